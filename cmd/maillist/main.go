@@ -1,33 +1,11 @@
 package main
 
 import (
-	"context"
-	"errors"
-	"fmt"
 	"os"
-	"os/signal"
-	"syscall"
 
-	"ai-over-email/pkg/email"
+	"ai-over-email/pkg/cli"
 )
 
 func main() {
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
-	defer stop()
-
-	lister, err := email.NewLister(email.Config{
-		EnvPath:    ".env",
-		ConfigPath: "config.json",
-		Output:     os.Stdout,
-		LogOutput:  os.Stderr,
-	})
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "maillist: %v\n", err)
-		os.Exit(1)
-	}
-
-	if err := lister.List(ctx); err != nil && !errors.Is(err, context.Canceled) {
-		fmt.Fprintf(os.Stderr, "maillist: %v\n", err)
-		os.Exit(1)
-	}
+	os.Exit(cli.Main(append([]string{"list"}, os.Args[1:]...)))
 }
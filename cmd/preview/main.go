@@ -0,0 +1,11 @@
+package main
+
+import (
+	"os"
+
+	"ai-over-email/pkg/cli"
+)
+
+func main() {
+	os.Exit(cli.Main(append([]string{"preview"}, os.Args[1:]...)))
+}
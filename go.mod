@@ -5,6 +5,7 @@ go 1.26.4
 require (
 	github.com/mark3labs/mcp-go v0.56.0
 	github.com/yuin/goldmark v1.8.2
+	golang.org/x/sys v0.44.0
 	modernc.org/sqlite v1.53.0
 )
 
@@ -18,7 +19,6 @@ require (
 	github.com/santhosh-tekuri/jsonschema/v6 v6.0.2 // indirect
 	github.com/spf13/cast v1.7.1 // indirect
 	github.com/yosida95/uritemplate/v3 v3.0.2 // indirect
-	golang.org/x/sys v0.44.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 	modernc.org/libc v1.73.4 // indirect
 	modernc.org/mathutil v1.7.1 // indirect
@@ -0,0 +1,234 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+const programName = "ai-over-email"
+
+const (
+	ExitOK       = 0
+	ExitFailure  = 1
+	ExitUsage    = 2
+	ExitConfig   = 3
+	ExitDataErr  = 65
+	ExitTempFail = 75
+)
+
+const usage = `usage: ai-over-email [--config path] [--env path] [--db path] <command> [flags]
+
+Commands:
+  watch       run the JMAP mailbox watcher and auto-responder
+  usenet      run the Usenet watcher
+  run-all     run the mailbox and Usenet watchers in one process
+  pipe        answer one RFC 822 message read from stdin, for MTA pipe delivery
+  lmtp        accept mail from an MTA over LMTP and answer it
+  list        list messages in the configured mailbox
+  mcp         serve the MCP tools over stdio
+  preview     preview the auto-reply for one message without sending it
+  replay      reprocess message IDs, or .eml fixtures, through the auto-reply pipeline
+  eval        score model, reasoning effort and prompt variants on a labeled corpus
+  db          read and edit the correspondent database
+  usage       report model tokens and cost by day, month, correspondent or model
+  policy      list and edit per-sender PGP, model tier, daily limit and block policies
+  keys        list or locate OpenPGP keys
+  doctor      check configuration, credentials, database and gpg
+
+Global flags may also follow the command name.
+Exit codes: 0 ok, 1 failure, 2 usage error, 3 configuration error,
+65 malformed input message, 75 temporary failure (the MTA should retry).`
+
+type globals struct {
+	ConfigPath   string
+	EnvPath      string
+	DatabasePath string
+}
+
+type env struct {
+	globals
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+type usageError struct {
+	message  string
+	reported bool
+}
+
+func (e usageError) Error() string {
+	return e.message
+}
+
+type configError struct {
+	err error
+}
+
+func (e configError) Error() string {
+	return e.err.Error()
+}
+
+func (e configError) Unwrap() error {
+	return e.err
+}
+
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e exitError) Unwrap() error {
+	return e.err
+}
+
+func usagef(format string, args ...any) error {
+	return usageError{message: fmt.Sprintf(format, args...)}
+}
+
+func configErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	return configError{err: err}
+}
+
+func Main(args []string) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return Run(ctx, args, os.Stdout, os.Stderr)
+}
+
+func Run(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) int {
+	return run(ctx, args, os.Stdin, stdout, stderr)
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	e := &env{
+		globals: globals{
+			ConfigPath:   "config.json",
+			EnvPath:      ".env",
+			DatabasePath: ".tmp/correspondents.sqlite3",
+		},
+		stdin:  stdin,
+		stdout: stdout,
+		stderr: stderr,
+	}
+	flags := e.flagSet(programName)
+	flags.Usage = func() { fmt.Fprintln(stderr, usage) }
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return ExitOK
+		}
+		return ExitUsage
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return ExitUsage
+	}
+
+	command, rest := flags.Arg(0), flags.Args()[1:]
+	var err error
+	switch command {
+	case "watch":
+		err = e.watch(ctx, rest)
+	case "usenet":
+		err = e.usenet(ctx, rest)
+	case "run-all":
+		err = e.runAll(ctx, rest)
+	case "pipe":
+		err = e.pipe(ctx, rest)
+	case "lmtp":
+		err = e.lmtp(ctx, rest)
+	case "list":
+		err = e.list(ctx, rest)
+	case "mcp":
+		err = e.mcp(ctx, rest)
+	case "preview":
+		err = e.preview(ctx, rest)
+	case "replay":
+		err = e.replay(ctx, rest)
+	case "eval":
+		err = e.eval(ctx, rest)
+	case "db":
+		err = e.db(ctx, rest)
+	case "usage":
+		err = e.tokenUsage(ctx, rest)
+	case "policy":
+		err = e.policy(ctx, rest)
+	case "keys":
+		err = e.keys(ctx, rest)
+	case "doctor":
+		err = e.doctor(ctx, rest)
+	case "help":
+		fmt.Fprintln(stdout, usage)
+		return ExitOK
+	default:
+		err = usagef("unknown command %q", command)
+	}
+	return e.exitCode(command, err)
+}
+
+func (e *env) exitCode(command string, err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return ExitOK
+	}
+	var usageErr usageError
+	if errors.As(err, &usageErr) {
+		if !usageErr.reported {
+			fmt.Fprintf(e.stderr, "%s %s: %v\n", programName, command, err)
+		}
+		return ExitUsage
+	}
+	fmt.Fprintf(e.stderr, "%s %s: %v\n", programName, command, err)
+	var exitErr exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	var cfgErr configError
+	if errors.As(err, &cfgErr) {
+		return ExitConfig
+	}
+	return ExitFailure
+}
+
+func (e *env) flagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(e.stderr)
+	flags.StringVar(&e.ConfigPath, "config", e.ConfigPath, "path to config.json")
+	flags.StringVar(&e.EnvPath, "env", e.EnvPath, "path to the credentials env file, or - to read only the process environment")
+	flags.StringVar(&e.DatabasePath, "db", e.DatabasePath, "path to the correspondent SQLite database")
+	return flags
+}
+
+func (e *env) command(name string, synopsis string) *flag.FlagSet {
+	flags := e.flagSet(name)
+	flags.Usage = func() {
+		fmt.Fprintf(e.stderr, "usage: %s %s\n", programName, synopsis)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+func (e *env) parse(flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return usageError{message: err.Error(), reported: true}
+	}
+	return nil
+}
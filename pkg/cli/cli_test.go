@@ -0,0 +1,167 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runCLI(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := Run(context.Background(), args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func runCLIInput(t *testing.T, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRunUsageErrors(t *testing.T) {
+	for _, args := range [][]string{
+		nil,
+		{"bogus"},
+		{"--no-such-flag", "watch"},
+		{"watch", "extra"},
+		{"preview"},
+		{"db"},
+		{"db", "get"},
+		{"db", "message", "abc"},
+		{"keys", "locate"},
+		{"eval"},
+		{"eval", "--effort", "high", "corpus.json"},
+	} {
+		code, _, stderr := runCLI(t, args...)
+		if code != ExitUsage {
+			t.Fatalf("Run(%q) = %d, want %d; stderr:\n%s", args, code, ExitUsage, stderr)
+		}
+	}
+}
+
+func TestRunConfigErrorExitCode(t *testing.T) {
+	dir := t.TempDir()
+	code, _, stderr := runCLI(t, "--config", filepath.Join(dir, "missing.json"), "--env", filepath.Join(dir, "missing.env"), "list")
+	if code != ExitConfig {
+		t.Fatalf("Run(list) = %d, want %d; stderr:\n%s", code, ExitConfig, stderr)
+	}
+	if !strings.Contains(stderr, "ai-over-email list:") {
+		t.Fatalf("stderr missing command prefix:\n%s", stderr)
+	}
+}
+
+func TestRunDBCommands(t *testing.T) {
+	db := filepath.Join(t.TempDir(), "correspondents.sqlite3")
+
+	code, stdout, stderr := runCLI(t, "--db", db, "db", "block", "Spammer@Example.com", "-reason", "abuse")
+	if code != ExitOK {
+		t.Fatalf("db block = %d; stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stdout, "sender blocked: Spammer@Example.com") {
+		t.Fatalf("db block stdout = %q", stdout)
+	}
+
+	code, stdout, stderr = runCLI(t, "db", "--db", db, "list", "-blocked")
+	if code != ExitOK {
+		t.Fatalf("db list = %d; stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stdout, "spammer@example.com") || !strings.Contains(stdout, "true") {
+		t.Fatalf("db list stdout = %q", stdout)
+	}
+
+	code, _, stderr = runCLI(t, "--db", db, "db", "set-profile", "nobody@example.com", "-zip", "10001")
+	if code != ExitFailure {
+		t.Fatalf("db set-profile on unknown sender = %d, want %d; stderr:\n%s", code, ExitFailure, stderr)
+	}
+
+	code, stdout, stderr = runCLI(t, "--db", db, "db", "search", "-email", "spammer@example.com", "boiling", "point")
+	if code != ExitOK || !strings.HasPrefix(stdout, "ID") {
+		t.Fatalf("db search = %d, stdout %q; stderr:\n%s", code, stdout, stderr)
+	}
+	code, _, stderr = runCLI(t, "--db", db, "db", "set-archive", "spammer@example.com", "-off")
+	if code != ExitOK {
+		t.Fatalf("db set-archive = %d; stderr:\n%s", code, stderr)
+	}
+
+	code, stdout, stderr = runCLI(t, "--db", db, "db", "migrate", "--status")
+	if code != ExitOK || !strings.Contains(stdout, "initial schema") || !strings.Contains(stdout, "applied") {
+		t.Fatalf("db migrate --status = %d, stdout %q; stderr:\n%s", code, stdout, stderr)
+	}
+
+	code, stdout, stderr = runCLI(t, "--db", db, "usage", "-by", "model")
+	if code != ExitOK || !strings.HasPrefix(stdout, "MODEL") || !strings.Contains(stdout, "total") {
+		t.Fatalf("usage = %d, stdout %q; stderr:\n%s", code, stdout, stderr)
+	}
+	if code, _, stderr = runCLI(t, "--db", db, "usage", "-by", "week"); code != ExitUsage {
+		t.Fatalf("usage -by week = %d, want %d; stderr:\n%s", code, ExitUsage, stderr)
+	}
+
+	code, _, stderr = runCLI(t, "--db", db, "policy", "set", "*@Example.com", "-pgp", "optional", "-daily-limit", "-1")
+	if code != ExitOK {
+		t.Fatalf("policy set = %d; stderr:\n%s", code, stderr)
+	}
+	code, stdout, stderr = runCLI(t, "--db", db, "policy", "set", "*@example.com", "-tier", "powerful")
+	if code != ExitOK || !strings.Contains(stdout, `"pgp": "optional"`) || !strings.Contains(stdout, `"modelTier": "powerful"`) {
+		t.Fatalf("policy set -tier = %d, stdout %q; stderr:\n%s", code, stdout, stderr)
+	}
+	code, stdout, stderr = runCLI(t, "--db", db, "policy", "list")
+	if code != ExitOK || !strings.Contains(stdout, "*@example.com") || !strings.Contains(stdout, "unlimited") {
+		t.Fatalf("policy list = %d, stdout %q; stderr:\n%s", code, stdout, stderr)
+	}
+	if code, _, stderr = runCLI(t, "--db", db, "policy", "set", "*@example.com", "-pgp", "never"); code != ExitFailure {
+		t.Fatalf("policy set -pgp never = %d, want %d; stderr:\n%s", code, ExitFailure, stderr)
+	}
+	code, stdout, stderr = runCLI(t, "--db", db, "policy", "delete", "*@example.com")
+	if code != ExitOK || !strings.Contains(stdout, "sender policy deleted") {
+		t.Fatalf("policy delete = %d, stdout %q; stderr:\n%s", code, stdout, stderr)
+	}
+}
+
+func TestRunDoctorReportsFailures(t *testing.T) {
+	dir := t.TempDir()
+	code, stdout, stderr := runCLI(t, "--config", filepath.Join(dir, "missing.json"), "--env", filepath.Join(dir, "missing.env"), "--db", filepath.Join(dir, "db.sqlite3"), "doctor")
+	if code != ExitFailure {
+		t.Fatalf("doctor = %d, want %d; stderr:\n%s", code, ExitFailure, stderr)
+	}
+	for _, want := range []string{"FAIL  config", "hint: copy config.example.json", "SKIP  jmap", "WARN  database", "SKIP  usenet"} {
+		if !strings.Contains(stdout, want) {
+			t.Fatalf("doctor output missing %q:\n%s", want, stdout)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "db.sqlite3")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("doctor created the database: %v", err)
+	}
+}
+
+func TestRunPipeExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	code, _, stderr := runCLIInput(t, "Subject: hi\r\n\r\nhello\r\n", "--config", filepath.Join(dir, "missing.json"), "--env", filepath.Join(dir, "missing.env"), "pipe")
+	if code != ExitConfig {
+		t.Fatalf("pipe with missing config = %d, want %d; stderr:\n%s", code, ExitConfig, stderr)
+	}
+
+	config := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(config, []byte(`{"intake":{"reply":"stdout"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if code, _, stderr = runCLIInput(t, "Subject: hi\r\n\r\nhello\r\n", "--config", config, "--env", filepath.Join(dir, "missing.env"), "pipe"); code != ExitConfig {
+		t.Fatalf("pipe without intake.from_address = %d, want %d; stderr:\n%s", code, ExitConfig, stderr)
+	}
+
+	if err := os.WriteFile(config, []byte(`{"intake":{"reply":"stdout","from_address":"assistant@mail.test"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	code, stdout, stderr := runCLIInput(t, "no headers here", "--config", config, "--env", filepath.Join(dir, "missing.env"), "--db", filepath.Join(dir, "db.sqlite3"), "pipe")
+	if code != ExitDataErr {
+		t.Fatalf("pipe with malformed message = %d, want %d; stderr:\n%s", code, ExitDataErr, stderr)
+	}
+	if stdout != "" {
+		t.Fatalf("pipe wrote a reply for a malformed message:\n%s", stdout)
+	}
+}
@@ -0,0 +1,325 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"ai-over-email/pkg/email"
+	"ai-over-email/pkg/mcpserver"
+	"ai-over-email/pkg/usenet"
+)
+
+func (e *env) emailConfig() email.Config {
+	return email.Config{
+		EnvPath:      e.EnvPath,
+		ConfigPath:   e.ConfigPath,
+		DatabasePath: e.DatabasePath,
+		Output:       e.stdout,
+		LogOutput:    e.stderr,
+	}
+}
+
+func (e *env) usenetConfig() usenet.Config {
+	return usenet.Config{
+		EnvPath:    e.EnvPath,
+		ConfigPath: e.ConfigPath,
+		Output:     e.stdout,
+		LogOutput:  e.stderr,
+	}
+}
+
+func (e *env) watch(ctx context.Context, args []string) error {
+	flags := e.command("watch", "watch")
+	if err := e.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return usagef("unexpected arguments %v", flags.Args())
+	}
+	watcher, err := email.NewWatcher(e.emailConfig())
+	if err != nil {
+		return configErr(err)
+	}
+	return e.stopped(watcher.Run(ctx))
+}
+
+func (e *env) usenet(ctx context.Context, args []string) error {
+	flags := e.command("usenet", "usenet")
+	if err := e.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return usagef("unexpected arguments %v", flags.Args())
+	}
+	watcher, err := usenet.NewWatcher(e.usenetConfig())
+	if err != nil {
+		return configErr(err)
+	}
+	return e.stopped(watcher.Run(ctx))
+}
+
+func (e *env) runAll(ctx context.Context, args []string) error {
+	flags := e.command("run-all", "run-all")
+	if err := e.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return usagef("unexpected arguments %v", flags.Args())
+	}
+	mailWatcher, err := email.NewWatcher(e.emailConfig())
+	if err != nil {
+		return configErr(fmt.Errorf("mail watcher: %w", err))
+	}
+	newsWatcher, err := usenet.NewWatcher(e.usenetConfig())
+	if err != nil {
+		return configErr(fmt.Errorf("usenet watcher: %w", err))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		name string
+		err  error
+	}
+	results := make(chan result, 2)
+	go func() { results <- result{"mail watcher", mailWatcher.Run(ctx)} }()
+	go func() { results <- result{"usenet watcher", newsWatcher.Run(ctx)} }()
+
+	var first error
+	for range 2 {
+		res := <-results
+		if first == nil && res.err != nil && !errors.Is(res.err, context.Canceled) {
+			first = fmt.Errorf("%s: %w", res.name, res.err)
+		}
+		if ctx.Err() == nil {
+			fmt.Fprintf(e.stderr, "%s stopped; shutting down the other watcher\n", res.name)
+		}
+		cancel()
+	}
+	if first != nil {
+		return first
+	}
+	return e.stopped(context.Canceled)
+}
+
+func (e *env) stopped(err error) error {
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(e.stdout, "Ciao!")
+		return nil
+	}
+	return err
+}
+
+func (e *env) list(ctx context.Context, args []string) error {
+	flags := e.command("list", "list")
+	if err := e.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return usagef("unexpected arguments %v", flags.Args())
+	}
+	lister, err := email.NewLister(e.emailConfig())
+	if err != nil {
+		return configErr(err)
+	}
+	if err := lister.List(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+func (e *env) mcp(ctx context.Context, args []string) error {
+	cfg := mcpserver.DefaultConfig()
+	flags := e.command("mcp", "mcp [--allow-writes] [--allow-usenet-post]")
+	flags.BoolVar(&cfg.AllowWrites, "allow-writes", cfg.AllowWrites, "register the correspondent admin tools (default from AI_OVER_EMAIL_MCP_ALLOW_WRITES)")
+	flags.BoolVar(&cfg.AllowUsenetPost, "allow-usenet-post", cfg.AllowUsenetPost, "register the post_followup tool (default from AI_OVER_EMAIL_MCP_ALLOW_USENET_POST)")
+	if err := e.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return usagef("unexpected arguments %v", flags.Args())
+	}
+	cfg.EnvPath = e.EnvPath
+	cfg.ConfigPath = e.ConfigPath
+	cfg.DatabasePath = e.DatabasePath
+	cfg.LogOutput = e.stderr
+	server, err := mcpserver.Open(cfg)
+	if err != nil {
+		return configErr(err)
+	}
+	defer server.Close()
+	return server.ServeStdio(ctx)
+}
+
+func (e *env) preview(ctx context.Context, args []string) error {
+	flags := e.command("preview", "preview [--json] <message-id>")
+	asJSON := flags.Bool("json", false, "print the preview as JSON")
+	if err := e.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usagef("expected exactly one message id")
+	}
+	watcher, err := email.NewWatcher(e.emailConfig())
+	if err != nil {
+		return configErr(err)
+	}
+	preview, err := watcher.PreviewReply(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return e.printJSON(preview)
+	}
+	e.printPreview(preview)
+	return nil
+}
+
+func (e *env) replay(ctx context.Context, args []string) error {
+	flags := e.command("replay", "replay [--dry-run] [--json] <message-id>...\n       ai-over-email replay --fixtures dir [--golden dir] [--cassettes dir] [--update] [--record [--stub]]")
+	dryRun := flags.Bool("dry-run", false, "preview the replies without sending, deleting, or counting anything")
+	asJSON := flags.Bool("json", false, "with --dry-run or --fixtures, print results as JSON")
+	fixtures := flags.String("fixtures", "", "replay the .eml files in this directory against recorded model traffic")
+	golden := flags.String("golden", "", "with --fixtures, golden file directory (default <fixtures>/golden)")
+	cassettes := flags.String("cassettes", "", "with --fixtures, cassette directory (default <fixtures>/cassettes)")
+	update := flags.Bool("update", false, "with --fixtures, rewrite golden files instead of comparing them")
+	record := flags.Bool("record", false, "with --fixtures, call the live APIs and rewrite cassettes and golden files")
+	stub := flags.Bool("stub", false, "with --record, answer from the existing cassettes instead of the live APIs, rewriting only the recorded requests and golden files")
+	openAIURL := flags.String("openai-url", "", "with --record, send model requests to this Responses endpoint, for example a local stub")
+	braveURL := flags.String("brave-url", "", "with --record, send search requests to this endpoint")
+	if err := e.parse(flags, args); err != nil {
+		return err
+	}
+	if *fixtures != "" {
+		if flags.NArg() > 0 {
+			return usagef("--fixtures does not take message ids")
+		}
+		if *stub && !*record {
+			return usagef("--stub requires --record")
+		}
+		config := e.emailConfig()
+		config.OpenAIURL = *openAIURL
+		config.BraveSearchURL = *braveURL
+		return e.replayFixtures(ctx, config, email.FixtureOptions{
+			Dir:         *fixtures,
+			GoldenDir:   *golden,
+			CassetteDir: *cassettes,
+			Update:      *update,
+			Record:      *record,
+			Stub:        *stub,
+		}, *asJSON)
+	}
+	if flags.NArg() == 0 {
+		return usagef("at least one message id is required")
+	}
+	watcher, err := email.NewWatcher(e.emailConfig())
+	if err != nil {
+		return configErr(err)
+	}
+
+	failed := 0
+	for _, id := range flags.Args() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if *dryRun {
+			preview, err := watcher.PreviewReply(ctx, id)
+			if err != nil {
+				fmt.Fprintf(e.stderr, "replay %s: %v\n", id, err)
+				failed++
+				continue
+			}
+			if *asJSON {
+				if err := e.printJSON(preview); err != nil {
+					return err
+				}
+				continue
+			}
+			e.printPreview(preview)
+			fmt.Fprintln(e.stdout)
+			continue
+		}
+		if err := watcher.Replay(ctx, id); err != nil {
+			fmt.Fprintf(e.stderr, "replay %s: %v\n", id, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(e.stdout, "REPLAYED\t%s\n", id)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d messages failed", failed, flags.NArg())
+	}
+	return nil
+}
+
+func (e *env) replayFixtures(ctx context.Context, config email.Config, options email.FixtureOptions, asJSON bool) error {
+	results, err := email.RunFixtures(ctx, config, options)
+	if err != nil {
+		if email.IsMalformedMessage(err) {
+			return exitError{code: ExitDataErr, err: err}
+		}
+		return configErr(err)
+	}
+	if asJSON {
+		if err := e.printJSON(results); err != nil {
+			return err
+		}
+	}
+	differing := 0
+	for _, result := range results {
+		status := "OK"
+		switch {
+		case result.Error != "":
+			status = "ERROR"
+		case len(result.Changed) > 0 && options.Update:
+			status = "UPDATED"
+		case len(result.Changed) > 0:
+			status = "CHANGED"
+		}
+		if status == "ERROR" || status == "CHANGED" {
+			differing++
+		}
+		if !asJSON {
+			fmt.Fprintf(e.stdout, "%s\t%s\t%s\n", status, result.Name, strings.Join(result.Changed, ","))
+		}
+	}
+	if differing > 0 {
+		return fmt.Errorf("%d of %d fixtures differ from their golden files or failed", differing, len(results))
+	}
+	return nil
+}
+
+func (e *env) printPreview(preview email.ReplyPreview) {
+	to := make([]string, 0, len(preview.To))
+	for _, address := range preview.To {
+		to = append(to, address.Email)
+	}
+	fmt.Fprintf(e.stdout, "Message: %s\n", preview.ID)
+	fmt.Fprintf(e.stdout, "Decision: %s\n", preview.Decision)
+	if preview.Reason != "" {
+		fmt.Fprintf(e.stdout, "Reason: %s\n", preview.Reason)
+	}
+	fmt.Fprintf(e.stdout, "To: %s\n", strings.Join(to, ", "))
+	fmt.Fprintf(e.stdout, "Subject: %s\n", preview.Subject)
+	if preview.Model != "" {
+		fmt.Fprintf(e.stdout, "Model: %s (reasoning effort %s)\n", preview.Model, preview.ReasoningEffort)
+		fmt.Fprintf(e.stdout, "Usage: input=%d cached_input=%d output=%d reasoning=%d total=%d\n", preview.Usage.InputTokens, preview.Usage.CachedInputTokens, preview.Usage.OutputTokens, preview.Usage.ReasoningTokens, preview.Usage.TotalTokens)
+	}
+	for _, attachment := range preview.Attachments {
+		fmt.Fprintf(e.stdout, "Attachment: %s (%s, %d bytes)\n", attachment.Name, attachment.Type, attachment.Size)
+	}
+	if preview.TextBody == "" {
+		return
+	}
+	fmt.Fprintf(e.stdout, "\n--- text/plain ---\n%s\n", preview.TextBody)
+	fmt.Fprintf(e.stdout, "\n--- text/html ---\n%s\n", preview.HTMLBody)
+}
+
+func (e *env) printJSON(value any) error {
+	encoder := json.NewEncoder(e.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
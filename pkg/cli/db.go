@@ -0,0 +1,287 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"ai-over-email/pkg/email"
+)
+
+const dbUsage = `db <command> [flags]
+
+Read commands:
+  list [-q text] [-blocked] [-limit n]
+  get <email>
+  usage [-days n]
+  search [-email addr] [-since YYYY-MM-DD] [-limit n] [-raw] [query]
+  message <archive-id>
+  migrate -status
+
+Admin commands:
+  migrate
+  set-profile <email> [-country CC] [-zip code] [-tz zone] [-context on|off]
+  reset-usage <email> [-day YYYY-MM-DD]
+  clear-notes <email>
+  set-archive <email> [-off] [-days n]
+  block <email> [-reason text]
+  unblock <email>`
+
+func (e *env) db(ctx context.Context, args []string) error {
+	flags := e.command("db", dbUsage)
+	if err := e.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return usageError{message: "missing db command", reported: true}
+	}
+	command, rest := flags.Arg(0), flags.Args()[1:]
+	sub := e.command("db "+command, dbUsage)
+
+	var run func(*email.CorrespondentStore) error
+	switch command {
+	case "list":
+		query := sub.String("q", "", "filter by email or display name")
+		blocked := sub.Bool("blocked", false, "only list blocked senders")
+		limit := sub.Int("limit", 100, "maximum number of rows")
+		if err := e.parseNoArgs(sub, rest); err != nil {
+			return err
+		}
+		run = func(store *email.CorrespondentStore) error {
+			correspondents, err := store.ListCorrespondents(ctx, email.CorrespondentListOptions{Query: *query, BlockedOnly: *blocked, Limit: *limit})
+			if err != nil {
+				return err
+			}
+			out := tabwriter.NewWriter(e.stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(out, "EMAIL\tNAME\tCOUNTRY\tPOSTAL CODE\tTIME ZONE\tTODAY\tBLOCKED\tLAST SEEN")
+			for _, c := range correspondents {
+				fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s\t%d\t%t\t%s\n", c.Email, c.DisplayName, c.Country, c.ZipCode, c.TimeZone, c.MessagesToday, c.Blocked, c.LastSeenAt)
+			}
+			return out.Flush()
+		}
+	case "get":
+		address, err := e.parseEmailArg(sub, rest)
+		if err != nil {
+			return err
+		}
+		run = func(store *email.CorrespondentStore) error {
+			detail, err := store.GetCorrespondent(ctx, address)
+			if err != nil {
+				return err
+			}
+			return e.printJSON(detail)
+		}
+	case "usage":
+		days := sub.Int("days", 7, "number of UTC days to include")
+		if err := e.parseNoArgs(sub, rest); err != nil {
+			return err
+		}
+		run = func(store *email.CorrespondentStore) error {
+			report, err := store.UsageReport(ctx, *days, time.Now())
+			if err != nil {
+				return err
+			}
+			return e.printJSON(report)
+		}
+	case "search":
+		address := sub.String("email", "", "only search exchanges with this correspondent")
+		since := sub.String("since", "", "only search exchanges archived on or after this UTC day")
+		limit := sub.Int("limit", 20, "maximum number of results")
+		raw := sub.Bool("raw", false, "pass the query to SQLite FTS5 as is instead of matching each word literally")
+		if err := e.parse(sub, rest); err != nil {
+			return err
+		}
+		query := strings.Join(sub.Args(), " ")
+		run = func(store *email.CorrespondentStore) error {
+			results, err := store.SearchArchive(ctx, email.ArchiveSearchOptions{Query: query, Raw: *raw, Email: *address, Since: *since, Limit: *limit})
+			if err != nil {
+				return err
+			}
+			out := tabwriter.NewWriter(e.stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(out, "ID\tARCHIVED\tEMAIL\tSUBJECT\tSNIPPET")
+			for _, r := range results {
+				fmt.Fprintf(out, "%d\t%s\t%s\t%s\t%s\n", r.ID, r.ArchivedAt, r.Email, r.Subject, strings.Join(strings.Fields(r.Snippet), " "))
+			}
+			return out.Flush()
+		}
+	case "message":
+		if err := e.parse(sub, rest); err != nil {
+			return err
+		}
+		if sub.NArg() != 1 {
+			return usagef("db message requires one archive id")
+		}
+		id, err := strconv.ParseInt(sub.Arg(0), 10, 64)
+		if err != nil {
+			return usagef("invalid archive id %q", sub.Arg(0))
+		}
+		run = func(store *email.CorrespondentStore) error {
+			message, err := store.GetArchivedMessage(ctx, id)
+			if err != nil {
+				return err
+			}
+			return e.printJSON(message)
+		}
+	case "migrate":
+		status := sub.Bool("status", false, "report applied and pending schema migrations without applying any")
+		if err := e.parseNoArgs(sub, rest); err != nil {
+			return err
+		}
+		if *status {
+			return e.printSchemaStatus(ctx)
+		}
+		run = func(store *email.CorrespondentStore) error {
+			return e.printSchemaStatus(ctx)
+		}
+	case "set-profile":
+		country := sub.String("country", "", "two-letter country code, for example US, CA or GB")
+		zip := sub.String("zip", "", "ZIP or postal code, validated for -country or the stored country (default US)")
+		zone := sub.String("tz", "", "IANA time zone or UTC offset")
+		senderContext := sub.String("context", "", "on or off: include the sender's name, local time and location in the model prompt")
+		address, err := e.parseEmailArg(sub, rest)
+		if err != nil {
+			return err
+		}
+		if *senderContext != "" && *senderContext != "on" && *senderContext != "off" {
+			return usagef("-context must be on or off")
+		}
+		run = func(store *email.CorrespondentStore) error {
+			if *country != "" || *zip != "" || *zone != "" || *senderContext == "" {
+				if err := store.SetProfile(ctx, address, email.CorrespondentProfileUpdate{Country: *country, ZipCode: *zip, TimeZone: *zone}); err != nil {
+					return err
+				}
+			}
+			if *senderContext != "" {
+				if err := store.SetSenderContext(ctx, address, *senderContext == "on"); err != nil {
+					return err
+				}
+			}
+			fmt.Fprintf(e.stdout, "profile updated: %s\n", address)
+			return nil
+		}
+	case "reset-usage":
+		day := sub.String("day", "", "UTC day as YYYY-MM-DD, defaults to today")
+		address, err := e.parseEmailArg(sub, rest)
+		if err != nil {
+			return err
+		}
+		run = func(store *email.CorrespondentStore) error {
+			removed, err := store.ResetDailyUsage(ctx, address, *day)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(e.stdout, "daily usage reset: %s rows_removed=%d\n", address, removed)
+			return nil
+		}
+	case "clear-notes":
+		address, err := e.parseEmailArg(sub, rest)
+		if err != nil {
+			return err
+		}
+		run = func(store *email.CorrespondentStore) error {
+			removed, err := store.ClearNotes(ctx, address)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(e.stdout, "notes cleared: %s notes_removed=%d\n", address, removed)
+			return nil
+		}
+	case "set-archive":
+		off := sub.Bool("off", false, "stop archiving this correspondent and delete what is kept at the next prune")
+		days := sub.Int("days", 0, "keep this correspondent's exchanges for n days instead of archive.retention_days (0 uses the config)")
+		address, err := e.parseEmailArg(sub, rest)
+		if err != nil {
+			return err
+		}
+		run = func(store *email.CorrespondentStore) error {
+			if err := store.SetArchivePolicy(ctx, address, !*off, *days); err != nil {
+				return err
+			}
+			fmt.Fprintf(e.stdout, "archive policy updated: %s archive=%t retention_days=%d\n", address, !*off, *days)
+			return nil
+		}
+	case "block":
+		reason := sub.String("reason", "", "operator note stored with the block")
+		address, err := e.parseEmailArg(sub, rest)
+		if err != nil {
+			return err
+		}
+		run = func(store *email.CorrespondentStore) error {
+			if err := store.BlockSender(ctx, address, true, *reason); err != nil {
+				return err
+			}
+			fmt.Fprintf(e.stdout, "sender blocked: %s\n", address)
+			return nil
+		}
+	case "unblock":
+		address, err := e.parseEmailArg(sub, rest)
+		if err != nil {
+			return err
+		}
+		run = func(store *email.CorrespondentStore) error {
+			if err := store.BlockSender(ctx, address, false, ""); err != nil {
+				return err
+			}
+			fmt.Fprintf(e.stdout, "sender unblocked: %s\n", address)
+			return nil
+		}
+	default:
+		return usagef("unknown db command %q", command)
+	}
+
+	store, err := email.OpenCorrespondentStore(e.DatabasePath)
+	if err != nil {
+		return configErr(err)
+	}
+	defer store.Close()
+	return run(store)
+}
+
+func (e *env) printSchemaStatus(ctx context.Context) error {
+	status, err := email.CorrespondentSchemaStatus(ctx, e.DatabasePath)
+	if err != nil {
+		return configErr(err)
+	}
+	fmt.Fprintf(e.stdout, "schema version %d, binary supports %d\n", status.Version, status.LatestVersion)
+	out := tabwriter.NewWriter(e.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(out, "VERSION\tNAME\tSTATE\tAPPLIED AT\tCHECKSUM")
+	problems := 0
+	for _, m := range status.Migrations {
+		fmt.Fprintf(out, "%d\t%s\t%s\t%s\t%.12s\n", m.Version, m.Name, m.State, m.AppliedAt, m.Checksum)
+		if m.State == email.MigrationChecksumMismatch || m.State == email.MigrationUnknown {
+			problems++
+		}
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	if problems > 0 {
+		return fmt.Errorf("%d migrations do not match this binary", problems)
+	}
+	return nil
+}
+
+func (e *env) parseNoArgs(flags *flag.FlagSet, args []string) error {
+	if err := e.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return usagef("unexpected arguments %v", flags.Args())
+	}
+	return nil
+}
+
+func (e *env) parseEmailArg(flags *flag.FlagSet, args []string) (string, error) {
+	if len(args) == 0 || args[0] == "" || args[0][0] == '-' {
+		return "", usagef("%s requires an email address", flags.Name())
+	}
+	if err := e.parseNoArgs(flags, args[1:]); err != nil {
+		return "", err
+	}
+	return args[0], nil
+}
@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"ai-over-email/pkg/diag"
+	"ai-over-email/pkg/email"
+	"ai-over-email/pkg/usenet"
+)
+
+func (e *env) doctor(ctx context.Context, args []string) error {
+	flags := e.command("doctor", "doctor [--json] [--timeout 1m]")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	timeout := flags.Duration("timeout", time.Minute, "overall time limit for network checks")
+	if err := e.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return usagef("unexpected arguments %v", flags.Args())
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	var report diag.Report
+	report = append(report, email.Diagnose(ctx, e.emailConfig())...)
+	report = append(report, usenet.Diagnose(ctx, e.usenetConfig())...)
+
+	if *asJSON {
+		if err := e.printJSON(report); err != nil {
+			return err
+		}
+	} else if err := report.Write(e.stdout); err != nil {
+		return err
+	}
+	if failed := report.Count(diag.Fail); failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	return nil
+}
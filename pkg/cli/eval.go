@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"ai-over-email/pkg/email"
+)
+
+func (e *env) eval(ctx context.Context, args []string) error {
+	flags := e.command("eval", "eval [--json] [--cases] [--no-grader] [--variants a,b] [--only ids] [--model m [--effort e] [--prompt name]] <corpus.json>")
+	asJSON := flags.Bool("json", false, "print the full report as JSON")
+	showCases := flags.Bool("cases", false, "also print one line per case and variant")
+	noGrader := flags.Bool("no-grader", false, "score with rule checks only, even when the corpus names a grader model")
+	variants := flags.String("variants", "", "comma-separated corpus variants to run (default all)")
+	only := flags.String("only", "", "comma-separated case ids to run (default all)")
+	model := flags.String("model", "", "add an ad hoc variant with this model")
+	effort := flags.String("effort", "", "with --model, reasoning effort for the ad hoc variant")
+	prompt := flags.String("prompt", "", "with --model, prompt version for the ad hoc variant (default builtin)")
+	openAIURL := flags.String("openai-url", "", "send model requests to this Responses endpoint, for example a local stub")
+	if err := e.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usagef("exactly one corpus file is required")
+	}
+	if *model == "" && (*effort != "" || *prompt != "") {
+		return usagef("--effort and --prompt need --model")
+	}
+
+	options := email.EvalOptions{
+		CorpusPath: flags.Arg(0),
+		Variants:   splitFlagList(*variants),
+		Cases:      splitFlagList(*only),
+		NoGrader:   *noGrader,
+	}
+	if *model != "" {
+		options.Extra = &email.EvalVariant{Model: *model, ReasoningEffort: *effort, Prompt: *prompt}
+	}
+	config := e.emailConfig()
+	config.OpenAIURL = *openAIURL
+	report, err := email.RunEval(ctx, config, options)
+	if err != nil {
+		return configErr(err)
+	}
+	if *asJSON {
+		return e.printJSON(report)
+	}
+
+	out := tabwriter.NewWriter(e.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(out, "VARIANT\tMODEL\tEFFORT\tPROMPT\tPASSED\tFACTS\tVIOLATIONS\tERRORS\tGRADER\tTOKENS IN/OUT\tCOST\tLATENCY")
+	for _, v := range report.Variants {
+		grader := "-"
+		if v.Graded > 0 {
+			grader = fmt.Sprintf("%.1f", v.GraderScore)
+		}
+		fmt.Fprintf(out, "%s\t%s\t%s\t%s@%s\t%d/%d\t%d/%d\t%d\t%d\t%s\t%d/%d\t%s\t%.1fs\n",
+			v.Name, v.Model, v.ReasoningEffort, v.Prompt, v.PromptHash[:7], v.Passed, v.Cases, v.FactsFound, v.FactsTotal,
+			v.Violations, v.Errors, grader, v.Usage.InputTokens, v.Usage.OutputTokens, formatEvalCost(v.Usage), float64(v.LatencyMS)/1000)
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	if report.Grader != "" {
+		fmt.Fprintf(e.stdout, "\nGrader %s: tokens %d/%d, cost %s\n", report.Grader, report.Usage.InputTokens, report.Usage.OutputTokens, formatEvalCost(report.Usage))
+	}
+	if !*showCases {
+		return nil
+	}
+
+	fmt.Fprintln(e.stdout)
+	out = tabwriter.NewWriter(e.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(out, "VARIANT\tCASE\tRESULT\tMISSING\tVIOLATED\tGRADER\tCOST")
+	for _, v := range report.Variants {
+		for _, c := range v.Results {
+			status := "PASS"
+			switch {
+			case c.Error != "":
+				status = "ERROR"
+			case !c.Passed:
+				status = "FAIL"
+			}
+			var missing, violated []string
+			for _, fact := range c.Facts {
+				if !fact.Hit {
+					missing = append(missing, fact.Text)
+				}
+			}
+			for _, forbidden := range c.Forbidden {
+				if forbidden.Hit {
+					violated = append(violated, forbidden.Text)
+				}
+			}
+			grader := "-"
+			if c.GraderScore != nil {
+				grader = fmt.Sprintf("%.1f", *c.GraderScore)
+			}
+			fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", v.Name, c.ID, status, strings.Join(missing, "; "), strings.Join(violated, "; "), grader, formatEvalCost(c.Usage))
+		}
+	}
+	return out.Flush()
+}
+
+func formatEvalCost(usage email.EvalUsage) string {
+	if usage.Unpriced {
+		return "unpriced"
+	}
+	return fmt.Sprintf("$%.4f", usage.CostUSD)
+}
+
+func splitFlagList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"ai-over-email/pkg/email"
+)
+
+const maxPipeMessageBytes = 25 << 20
+
+func (e *env) intakeConfig() email.Config {
+	cfg := e.emailConfig()
+	cfg.Output = e.stderr
+	return cfg
+}
+
+func (e *env) pipe(ctx context.Context, args []string) error {
+	flags := e.command("pipe", "pipe [--reply smtp|stdout] < message.eml")
+	reply := flags.String("reply", "", "reply delivery, smtp or stdout (default from intake.reply)")
+	if err := e.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return usagef("unexpected arguments %v", flags.Args())
+	}
+	intake, err := email.NewIntake(e.intakeConfig(), email.IntakeOptions{Reply: *reply, ReplyOutput: e.stdout})
+	if err != nil {
+		if email.IsConfigError(err) {
+			return configErr(err)
+		}
+		return exitError{code: ExitTempFail, err: err}
+	}
+	defer intake.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(e.stdin, maxPipeMessageBytes+1))
+	if err != nil {
+		return exitError{code: ExitTempFail, err: fmt.Errorf("read message from stdin: %w", err)}
+	}
+	if len(raw) > maxPipeMessageBytes {
+		return exitError{code: ExitDataErr, err: fmt.Errorf("message exceeds %d bytes", maxPipeMessageBytes)}
+	}
+	if err := intake.Deliver(ctx, raw, "pipe"); err != nil {
+		if email.IsMalformedMessage(err) {
+			return exitError{code: ExitDataErr, err: err}
+		}
+		return exitError{code: ExitTempFail, err: err}
+	}
+	return nil
+}
+
+func (e *env) lmtp(ctx context.Context, args []string) error {
+	flags := e.command("lmtp", "lmtp [--listen address|unix:path] [--reply smtp|stdout]")
+	listen := flags.String("listen", "", "LMTP listen address, host:port or unix:/path (default from intake.lmtp_listen)")
+	reply := flags.String("reply", "", "reply delivery, smtp or stdout (default from intake.reply)")
+	if err := e.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return usagef("unexpected arguments %v", flags.Args())
+	}
+	intake, err := email.NewIntake(e.intakeConfig(), email.IntakeOptions{Reply: *reply, ReplyOutput: e.stdout})
+	if err != nil {
+		return configErr(err)
+	}
+	defer intake.Close()
+	address := *listen
+	if address == "" {
+		address = intake.ListenAddress()
+	}
+	err = intake.ServeLMTP(ctx, address)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
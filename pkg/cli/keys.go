@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"ai-over-email/pkg/email"
+)
+
+const keysUsage = `keys <command> [email...]
+
+Commands:
+  list [email...]      list public keys in the local gpg keyring
+  locate <email>...    fetch sender keys through WKD and keys.openpgp.org`
+
+func (e *env) keys(ctx context.Context, args []string) error {
+	flags := e.command("keys", keysUsage)
+	if err := e.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return usageError{message: "missing keys command", reported: true}
+	}
+	command, emails := flags.Arg(0), flags.Args()[1:]
+	switch command {
+	case "list":
+		return email.ListPublicKeys(ctx, e.stdout, emails)
+	case "locate":
+		if len(emails) == 0 {
+			return usagef("keys locate requires at least one email address")
+		}
+		failed := 0
+		for _, address := range emails {
+			if err := email.LocateSigningKeys(ctx, []string{address}); err != nil {
+				fmt.Fprintf(e.stderr, "%v\n", err)
+				failed++
+				continue
+			}
+			fmt.Fprintf(e.stdout, "LOCATED\t%s\n", address)
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d keys could not be located", failed, len(emails))
+		}
+		return nil
+	default:
+		return usagef("unknown keys command %q", command)
+	}
+}
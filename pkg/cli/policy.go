@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"text/tabwriter"
+
+	"ai-over-email/pkg/email"
+)
+
+const policyUsage = `policy <command> [flags]
+
+Commands:
+  list [-json]
+  get <email>
+  set <pattern> [-pgp required|optional] [-tier default|powerful] [-daily-limit n] [-blocked] [-notes text]
+  delete <pattern>
+
+A pattern is an address, *@domain or *@*.domain. The most specific pattern
+matching a sender applies. set changes only the flags given; an empty -pgp or
+-tier and -daily-limit 0 fall back to the credentials allowlist and config.json,
+and -daily-limit -1 removes the limit. The watcher reads policies for every
+message, so changes apply without a restart.`
+
+func (e *env) policy(ctx context.Context, args []string) error {
+	flags := e.command("policy", policyUsage)
+	if err := e.parse(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return usageError{message: "missing policy command", reported: true}
+	}
+	command, rest := flags.Arg(0), flags.Args()[1:]
+	sub := e.command("policy "+command, policyUsage)
+
+	var run func(*email.CorrespondentStore) error
+	switch command {
+	case "list":
+		asJSON := sub.Bool("json", false, "print the policies as JSON")
+		if err := e.parseNoArgs(sub, rest); err != nil {
+			return err
+		}
+		run = func(store *email.CorrespondentStore) error {
+			policies, err := store.ListSenderPolicies(ctx)
+			if err != nil {
+				return err
+			}
+			if *asJSON {
+				return e.printJSON(policies)
+			}
+			out := tabwriter.NewWriter(e.stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(out, "PATTERN\tPGP\tTIER\tDAILY LIMIT\tBLOCKED\tNOTES")
+			for _, p := range policies {
+				fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%t\t%s\n", p.Pattern, p.PGP, p.ModelTier, dailyLimitText(p.DailyLimit), p.Blocked, p.Notes)
+			}
+			return out.Flush()
+		}
+	case "get":
+		address, err := e.parseEmailArg(sub, rest)
+		if err != nil {
+			return err
+		}
+		run = func(store *email.CorrespondentStore) error {
+			policy, ok, err := store.SenderPolicyFor(ctx, address)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("no sender policy matches %s", address)
+			}
+			return e.printJSON(policy)
+		}
+	case "set":
+		pgp := sub.String("pgp", "", "required or optional: whether mail must be OpenPGP encrypted and signed")
+		tier := sub.String("tier", "", "default or powerful: the model tier used for replies")
+		dailyLimit := sub.Int("daily-limit", 0, "inbound messages per day, -1 for no limit")
+		blocked := sub.Bool("blocked", false, "delete mail from matching senders without a reply")
+		notes := sub.String("notes", "", "operator note stored with the policy")
+		pattern, err := e.parseEmailArg(sub, rest)
+		if err != nil {
+			return err
+		}
+		var update email.SenderPolicyUpdate
+		sub.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "pgp":
+				update.PGP = pgp
+			case "tier":
+				update.ModelTier = tier
+			case "daily-limit":
+				update.DailyLimit = dailyLimit
+			case "blocked":
+				update.Blocked = blocked
+			case "notes":
+				update.Notes = notes
+			}
+		})
+		run = func(store *email.CorrespondentStore) error {
+			policy, err := store.SetSenderPolicy(ctx, pattern, update)
+			if err != nil {
+				return err
+			}
+			return e.printJSON(policy)
+		}
+	case "delete":
+		pattern, err := e.parseEmailArg(sub, rest)
+		if err != nil {
+			return err
+		}
+		run = func(store *email.CorrespondentStore) error {
+			if err := store.DeleteSenderPolicy(ctx, pattern); err != nil {
+				return err
+			}
+			fmt.Fprintf(e.stdout, "sender policy deleted: %s\n", pattern)
+			return nil
+		}
+	default:
+		return usagef("unknown policy command %q", command)
+	}
+
+	store, err := email.OpenCorrespondentStore(e.DatabasePath)
+	if err != nil {
+		return configErr(err)
+	}
+	defer store.Close()
+	return run(store)
+}
+
+func dailyLimitText(limit int) string {
+	switch {
+	case limit < 0:
+		return "unlimited"
+	case limit == 0:
+		return ""
+	}
+	return fmt.Sprint(limit)
+}
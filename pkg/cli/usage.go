@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"text/tabwriter"
+
+	"ai-over-email/pkg/email"
+)
+
+const tokenUsageUsage = `usage [-by day|month|correspondent|model|kind] [-since YYYY-MM-DD] [-until YYYY-MM-DD] [-email addr] [-json]
+
+Reports model calls, tokens and dollar cost recorded per message. Costs come
+from the openai.prices table in effect when each call was made; calls to
+models without a price are counted in the UNPRICED column.`
+
+func (e *env) tokenUsage(ctx context.Context, args []string) error {
+	flags := e.command("usage", tokenUsageUsage)
+	by := flags.String("by", "day", "group rows by day, month, correspondent, model or kind")
+	since := flags.String("since", "", "only include calls on or after this UTC day")
+	until := flags.String("until", "", "only include calls on or before this UTC day")
+	address := flags.String("email", "", "only include calls for this correspondent")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	if err := e.parseNoArgs(flags, args); err != nil {
+		return err
+	}
+
+	store, err := email.OpenCorrespondentStore(e.DatabasePath)
+	if err != nil {
+		return configErr(err)
+	}
+	defer store.Close()
+	report, err := store.TokenUsageReport(ctx, email.TokenUsageReportOptions{GroupBy: *by, Since: *since, Until: *until, Email: *address})
+	if err != nil {
+		return usagef("%v", err)
+	}
+	if *asJSON {
+		return e.printJSON(report)
+	}
+
+	out := tabwriter.NewWriter(e.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(out, "%s\tCALLS\tINPUT\tCACHED\tOUTPUT\tREASONING\tTOTAL\tTOOLS\tAVG MS\tCOST USD\tUNPRICED\n", map[string]string{
+		"day": "DAY", "month": "MONTH", "correspondent": "CORRESPONDENT", "model": "MODEL", "kind": "KIND",
+	}[report.GroupBy])
+	for _, row := range append(report.Rows, report.Total) {
+		fmt.Fprintf(out, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%.4f\t%d\n", row.Key, row.Calls, row.InputTokens, row.CachedInputTokens,
+			row.OutputTokens, row.ReasoningTokens, row.TotalTokens, row.ToolCalls, row.AvgLatencyMs, row.CostUSD, row.UnpricedCalls)
+	}
+	return out.Flush()
+}
@@ -8,30 +8,170 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"time"
 )
 
 const (
-	DefaultOpenAIModel           = "gpt-5-nano"
-	DefaultOpenAIReasoningEffort = "high"
+	DefaultOpenAIModel                     = "gpt-5-nano"
+	DefaultOpenAIReasoningEffort           = "high"
+	DefaultOpenAIExtractionReasoningEffort = "low"
+	DefaultMemoryMaxNotes                  = 20
+	DefaultMemoryMaxNoteChars              = 200
+	DefaultArchiveRetentionDays            = 365
+	DefaultRateLimitMessages               = 10
 )
 
 type ConfigStruct struct {
-	JMAP   JMAPConfig   `json:"jmap"`
-	OpenAI OpenAIConfig `json:"openai"`
-	Usenet UsenetConfig `json:"usenet"`
+	Transport  TransportConfig  `json:"transport"`
+	Intake     IntakeConfig     `json:"intake"`
+	JMAP       JMAPConfig       `json:"jmap"`
+	OpenAI     OpenAIConfig     `json:"openai"`
+	Memory     MemoryConfig     `json:"memory"`
+	Archive    ArchiveConfig    `json:"archive"`
+	Budgets    BudgetsConfig    `json:"budgets"`
+	RateLimits RateLimitsConfig `json:"rate_limits"`
+	Usenet     UsenetConfig     `json:"usenet"`
+}
+
+const (
+	ModelTierDefault  = "default"
+	ModelTierPowerful = "powerful"
+)
+
+const (
+	RateLimitFixed       = "fixed"
+	RateLimitSliding     = "sliding"
+	RateLimitTokenBucket = "token_bucket"
+)
+
+const (
+	TransportJMAP    = "jmap"
+	TransportMaildir = "maildir"
+	TransportIMAP    = "imap"
+)
+
+const (
+	IntakeReplySMTP   = "smtp"
+	IntakeReplyStdout = "stdout"
+)
+
+type TransportConfig struct {
+	Type    string        `json:"type"`
+	Maildir MaildirConfig `json:"maildir"`
+	IMAP    IMAPConfig    `json:"imap"`
+	SMTP    SMTPConfig    `json:"smtp"`
+}
+
+type MaildirConfig struct {
+	Path         string `json:"path"`
+	Outbox       string `json:"outbox"`
+	Sendmail     string `json:"sendmail"`
+	ArchivePath  string `json:"archive_path"`
+	PollInterval string `json:"poll_interval"`
+	FromName     string `json:"from_name"`
+	FromAddress  string `json:"from_address"`
+}
+
+type IMAPConfig struct {
+	Host           string `json:"host"`
+	Port           int    `json:"port"`
+	Security       string `json:"security"`
+	TLSServerName  string `json:"tls_server_name"`
+	Mailbox        string `json:"mailbox"`
+	ArchiveMailbox string `json:"archive_mailbox"`
+	PollInterval   string `json:"poll_interval"`
+	StatePath      string `json:"state_path"`
+}
+
+type SMTPConfig struct {
+	Host          string `json:"host"`
+	Port          int    `json:"port"`
+	Security      string `json:"security"`
+	TLSServerName string `json:"tls_server_name"`
+	FromName      string `json:"from_name"`
+	FromAddress   string `json:"from_address"`
+}
+
+type IntakeConfig struct {
+	LMTPListen  string     `json:"lmtp_listen"`
+	Reply       string     `json:"reply"`
+	FromName    string     `json:"from_name"`
+	FromAddress string     `json:"from_address"`
+	SMTP        SMTPConfig `json:"smtp"`
 }
 
 type JMAPConfig struct {
-	SessionEndpoint                string `json:"session_endpoint"`
-	LegacyBasicAuthSessionEndpoint string `json:"legacy_basic_auth_session_endpoint"`
+	SessionEndpoint                string          `json:"session_endpoint"`
+	LegacyBasicAuthSessionEndpoint string          `json:"legacy_basic_auth_session_endpoint"`
+	Autodiscover                   bool            `json:"autodiscover"`
+	OAuth                          JMAPOAuthConfig `json:"oauth"`
+}
+
+type JMAPOAuthConfig struct {
+	TokenURL string `json:"token_url"`
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope"`
 }
 
 type OpenAIConfig struct {
-	DefaultModel            string   `json:"default_model"`
-	DefaultReasoningEffort  string   `json:"default_reasoning_effort"`
-	PowerfulModel           string   `json:"powerful_model"`
-	PowerfulReasoningEffort string   `json:"powerful_reasoning_effort"`
-	PowerfulSenders         []string `json:"powerful_senders"`
+	DefaultModel              string                `json:"default_model"`
+	DefaultReasoningEffort    string                `json:"default_reasoning_effort"`
+	PowerfulModel             string                `json:"powerful_model"`
+	PowerfulReasoningEffort   string                `json:"powerful_reasoning_effort"`
+	PowerfulSenders           []string              `json:"powerful_senders"`
+	ExtractionModel           string                `json:"extraction_model"`
+	ExtractionReasoningEffort string                `json:"extraction_reasoning_effort"`
+	Prices                    map[string]ModelPrice `json:"prices"`
+}
+
+type ModelPrice struct {
+	InputPerMillion       float64 `json:"input_per_million"`
+	CachedInputPerMillion float64 `json:"cached_input_per_million"`
+	OutputPerMillion      float64 `json:"output_per_million"`
+}
+
+type MemoryConfig struct {
+	Disabled     bool `json:"disabled"`
+	MaxNotes     int  `json:"max_notes"`
+	MaxNoteChars int  `json:"max_note_chars"`
+}
+
+type ArchiveConfig struct {
+	Enabled          bool `json:"enabled"`
+	RetentionDays    int  `json:"retention_days"`
+	IncludeEncrypted bool `json:"include_encrypted"`
+}
+
+type BudgetsConfig struct {
+	CorrespondentMonthly BudgetLimit            `json:"correspondent_monthly"`
+	GlobalDaily          BudgetLimit            `json:"global_daily"`
+	TierMonthly          map[string]BudgetLimit `json:"tier_monthly"`
+}
+
+type BudgetLimit struct {
+	Tokens int64   `json:"tokens"`
+	USD    float64 `json:"usd"`
+}
+
+type RateLimitsConfig struct {
+	Default  RateLimit            `json:"default"`
+	LocalDay bool                 `json:"local_day"`
+	Tiers    []RateLimitTier      `json:"tiers"`
+	Domains  map[string]RateLimit `json:"domains"`
+	Senders  map[string]RateLimit `json:"senders"`
+}
+
+type RateLimitTier struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+	RateLimit
+}
+
+type RateLimit struct {
+	Messages  int    `json:"messages"`
+	Window    string `json:"window"`
+	Algorithm string `json:"algorithm"`
+	Unlimited bool   `json:"unlimited"`
 }
 
 type OpenAIModelSettings struct {
@@ -54,6 +194,31 @@ type UsenetConfig struct {
 }
 
 func Load(path string) (ConfigStruct, error) {
+	cfg, err := decode(path)
+	if err != nil {
+		return ConfigStruct{}, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return ConfigStruct{}, err
+	}
+	return cfg, nil
+}
+
+// LoadIntake loads a config for pipe and lmtp intake, which never talk to
+// the mail transport: the transport block is not validated and the intake
+// block is left for the caller to validate after applying overrides.
+func LoadIntake(path string) (ConfigStruct, error) {
+	cfg, err := decode(path)
+	if err != nil {
+		return ConfigStruct{}, err
+	}
+	if err := cfg.validateShared(); err != nil {
+		return ConfigStruct{}, err
+	}
+	return cfg, nil
+}
+
+func decode(path string) (ConfigStruct, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return ConfigStruct{}, fmt.Errorf("open config: %w", err)
@@ -74,31 +239,70 @@ func Load(path string) (ConfigStruct, error) {
 		}
 		return ConfigStruct{}, fmt.Errorf("decode config: multiple JSON values")
 	}
-	if err := cfg.Validate(); err != nil {
-		return ConfigStruct{}, err
-	}
-
 	return cfg, nil
 }
 
 func (cfg ConfigStruct) Validate() error {
-	if err := validateHTTPSURL("jmap.session_endpoint", cfg.JMAP.SessionEndpoint); err != nil {
-		return err
+	switch cfg.TransportType() {
+	case TransportJMAP:
+		if err := cfg.JMAP.validate(); err != nil {
+			return err
+		}
+	case TransportMaildir:
+		if err := cfg.Transport.Maildir.validate(); err != nil {
+			return err
+		}
+	case TransportIMAP:
+		if err := cfg.Transport.IMAP.validate(); err != nil {
+			return err
+		}
+		if err := cfg.Transport.SMTP.validate("transport.smtp"); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("config field transport.type must be jmap, maildir or imap")
 	}
-	if err := validateHTTPSURL("jmap.legacy_basic_auth_session_endpoint", cfg.JMAP.LegacyBasicAuthSessionEndpoint); err != nil {
-		return err
+	if cfg.Intake != (IntakeConfig{}) {
+		if err := cfg.Intake.Validate(); err != nil {
+			return err
+		}
 	}
+	return cfg.validateShared()
+}
+
+// validateShared checks the blocks every command uses, whatever the transport.
+func (cfg ConfigStruct) validateShared() error {
 	if err := validateReasoningEffort("openai.default_reasoning_effort", cfg.OpenAI.defaultReasoningEffort()); err != nil {
 		return err
 	}
 	if err := validateReasoningEffort("openai.powerful_reasoning_effort", cfg.OpenAI.powerfulReasoningEffort()); err != nil {
 		return err
 	}
+	if err := validateReasoningEffort("openai.extraction_reasoning_effort", cfg.OpenAI.extractionReasoningEffort()); err != nil {
+		return err
+	}
 	for _, sender := range cfg.OpenAI.PowerfulSenders {
 		if _, err := parseConfigEmail(sender); err != nil {
 			return fmt.Errorf("config field openai.powerful_senders contains invalid email %q: %w", sender, err)
 		}
 	}
+	if cfg.Memory.MaxNotes < 0 || cfg.Memory.MaxNoteChars < 0 {
+		return fmt.Errorf("config fields memory.max_notes and memory.max_note_chars must not be negative")
+	}
+	if cfg.Archive.RetentionDays < 0 {
+		return fmt.Errorf("config field archive.retention_days must not be negative")
+	}
+	if err := cfg.Budgets.validate(cfg.OpenAI); err != nil {
+		return err
+	}
+	if err := cfg.RateLimits.validate(); err != nil {
+		return err
+	}
+	for model, price := range cfg.OpenAI.Prices {
+		if price.InputPerMillion < 0 || price.CachedInputPerMillion < 0 || price.OutputPerMillion < 0 {
+			return fmt.Errorf("config field openai.prices.%s must not be negative", model)
+		}
+	}
 	if cfg.Usenet.Host != "" || cfg.Usenet.Group != "" {
 		if strings.TrimSpace(cfg.Usenet.Host) == "" {
 			return fmt.Errorf("config field usenet.host is required when usenet is configured")
@@ -133,6 +337,215 @@ func (cfg ConfigStruct) Validate() error {
 	return nil
 }
 
+func (cfg ConfigStruct) TransportType() string {
+	if value := strings.ToLower(strings.TrimSpace(cfg.Transport.Type)); value != "" {
+		return value
+	}
+	return TransportJMAP
+}
+
+func (cfg MaildirConfig) validate() error {
+	if strings.TrimSpace(cfg.Path) == "" {
+		return fmt.Errorf("config field transport.maildir.path is required for the maildir transport")
+	}
+	outbox := strings.TrimSpace(cfg.Outbox) != ""
+	sendmail := strings.TrimSpace(cfg.Sendmail) != ""
+	if outbox == sendmail {
+		return fmt.Errorf("config must set exactly one of transport.maildir.outbox or transport.maildir.sendmail")
+	}
+	if strings.TrimSpace(cfg.FromAddress) == "" {
+		return fmt.Errorf("config field transport.maildir.from_address is required for the maildir transport")
+	}
+	if _, err := parseConfigEmail(cfg.FromAddress); err != nil {
+		return fmt.Errorf("config field transport.maildir.from_address contains invalid email %q: %w", cfg.FromAddress, err)
+	}
+	if value := strings.TrimSpace(cfg.PollInterval); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("config field transport.maildir.poll_interval must be a positive duration such as 5s")
+		}
+	}
+	return nil
+}
+
+func (cfg MaildirConfig) Normalized() MaildirConfig {
+	cfg.Path = strings.TrimSpace(cfg.Path)
+	cfg.Outbox = strings.TrimSpace(cfg.Outbox)
+	cfg.Sendmail = strings.TrimSpace(cfg.Sendmail)
+	cfg.ArchivePath = strings.TrimSpace(cfg.ArchivePath)
+	cfg.PollInterval = strings.TrimSpace(cfg.PollInterval)
+	cfg.FromName = strings.TrimSpace(cfg.FromName)
+	cfg.FromAddress = strings.TrimSpace(cfg.FromAddress)
+	if cfg.PollInterval == "" {
+		cfg.PollInterval = "5s"
+	}
+	return cfg
+}
+
+func (cfg IMAPConfig) validate() error {
+	if strings.TrimSpace(cfg.Host) == "" {
+		return fmt.Errorf("config field transport.imap.host is required for the imap transport")
+	}
+	if cfg.Port < 0 || cfg.Port > 65535 {
+		return fmt.Errorf("config field transport.imap.port must be between 0 and 65535")
+	}
+	switch strings.ToLower(strings.TrimSpace(cfg.Security)) {
+	case "", "tls", "starttls", "none":
+	default:
+		return fmt.Errorf("config field transport.imap.security must be tls, starttls or none")
+	}
+	if value := strings.TrimSpace(cfg.PollInterval); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("config field transport.imap.poll_interval must be a positive duration such as 1m")
+		}
+	}
+	return nil
+}
+
+func (cfg IMAPConfig) Normalized() IMAPConfig {
+	cfg.Host = strings.TrimSpace(cfg.Host)
+	cfg.Security = strings.ToLower(strings.TrimSpace(cfg.Security))
+	cfg.TLSServerName = strings.TrimSpace(cfg.TLSServerName)
+	cfg.Mailbox = strings.TrimSpace(cfg.Mailbox)
+	cfg.ArchiveMailbox = strings.TrimSpace(cfg.ArchiveMailbox)
+	cfg.PollInterval = strings.TrimSpace(cfg.PollInterval)
+	cfg.StatePath = strings.TrimSpace(cfg.StatePath)
+	if cfg.Security == "" {
+		cfg.Security = "tls"
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 143
+		if cfg.Security == "tls" {
+			cfg.Port = 993
+		}
+	}
+	if cfg.Mailbox == "" {
+		cfg.Mailbox = "INBOX"
+	}
+	if cfg.PollInterval == "" {
+		cfg.PollInterval = "1m"
+	}
+	if cfg.StatePath == "" {
+		cfg.StatePath = ".tmp/imap-state.json"
+	}
+	return cfg
+}
+
+func (cfg SMTPConfig) validate(field string) error {
+	if strings.TrimSpace(cfg.Host) == "" {
+		return fmt.Errorf("config field %s.host is required", field)
+	}
+	if cfg.Port < 0 || cfg.Port > 65535 {
+		return fmt.Errorf("config field %s.port must be between 0 and 65535", field)
+	}
+	switch strings.ToLower(strings.TrimSpace(cfg.Security)) {
+	case "", "tls", "starttls", "none":
+	default:
+		return fmt.Errorf("config field %s.security must be tls, starttls or none", field)
+	}
+	if strings.TrimSpace(cfg.FromAddress) == "" {
+		return fmt.Errorf("config field %s.from_address is required", field)
+	}
+	if _, err := parseConfigEmail(cfg.FromAddress); err != nil {
+		return fmt.Errorf("config field %s.from_address contains invalid email %q: %w", field, cfg.FromAddress, err)
+	}
+	return nil
+}
+
+func (cfg SMTPConfig) Normalized() SMTPConfig {
+	cfg.Host = strings.TrimSpace(cfg.Host)
+	cfg.Security = strings.ToLower(strings.TrimSpace(cfg.Security))
+	cfg.TLSServerName = strings.TrimSpace(cfg.TLSServerName)
+	cfg.FromName = strings.TrimSpace(cfg.FromName)
+	cfg.FromAddress = strings.TrimSpace(cfg.FromAddress)
+	if cfg.Security == "" {
+		cfg.Security = "tls"
+	}
+	if cfg.Port == 0 {
+		switch cfg.Security {
+		case "tls":
+			cfg.Port = 465
+		case "starttls":
+			cfg.Port = 587
+		default:
+			cfg.Port = 25
+		}
+	}
+	return cfg
+}
+
+func (cfg JMAPConfig) validate() error {
+	if cfg.SessionEndpoint != "" || !cfg.Autodiscover {
+		if err := validateHTTPSURL("jmap.session_endpoint", cfg.SessionEndpoint); err != nil {
+			return fmt.Errorf("%w (or set jmap.autodiscover)", err)
+		}
+	}
+	if cfg.LegacyBasicAuthSessionEndpoint != "" {
+		if err := validateHTTPSURL("jmap.legacy_basic_auth_session_endpoint", cfg.LegacyBasicAuthSessionEndpoint); err != nil {
+			return err
+		}
+	}
+	if cfg.OAuth != (JMAPOAuthConfig{}) {
+		if err := validateHTTPSURL("jmap.oauth.token_url", cfg.OAuth.TokenURL); err != nil {
+			return err
+		}
+		if strings.TrimSpace(cfg.OAuth.ClientID) == "" {
+			return fmt.Errorf("config field jmap.oauth.client_id is required when jmap.oauth is configured")
+		}
+	}
+	return nil
+}
+
+func (cfg IntakeConfig) Validate() error {
+	if strings.TrimSpace(cfg.FromAddress) == "" {
+		return fmt.Errorf("config field intake.from_address is required when intake is configured")
+	}
+	if _, err := parseConfigEmail(cfg.FromAddress); err != nil {
+		return fmt.Errorf("config field intake.from_address contains invalid email %q: %w", cfg.FromAddress, err)
+	}
+	switch cfg.ReplyMode() {
+	case IntakeReplySMTP:
+		relay := cfg.SMTP
+		if strings.TrimSpace(relay.FromAddress) == "" {
+			relay.FromAddress = cfg.FromAddress
+		}
+		return relay.validate("intake.smtp")
+	case IntakeReplyStdout:
+		return nil
+	default:
+		return fmt.Errorf("config field intake.reply must be smtp or stdout")
+	}
+}
+
+func (cfg IntakeConfig) ReplyMode() string {
+	if value := strings.ToLower(strings.TrimSpace(cfg.Reply)); value != "" {
+		return value
+	}
+	if strings.TrimSpace(cfg.SMTP.Host) != "" {
+		return IntakeReplySMTP
+	}
+	return IntakeReplyStdout
+}
+
+func (cfg IntakeConfig) Normalized() IntakeConfig {
+	cfg.LMTPListen = strings.TrimSpace(cfg.LMTPListen)
+	cfg.Reply = cfg.ReplyMode()
+	cfg.FromName = strings.TrimSpace(cfg.FromName)
+	cfg.FromAddress = strings.TrimSpace(cfg.FromAddress)
+	if cfg.LMTPListen == "" {
+		cfg.LMTPListen = "127.0.0.1:2424"
+	}
+	if strings.TrimSpace(cfg.SMTP.FromAddress) == "" {
+		cfg.SMTP.FromAddress = cfg.FromAddress
+	}
+	if strings.TrimSpace(cfg.SMTP.FromName) == "" {
+		cfg.SMTP.FromName = cfg.FromName
+	}
+	cfg.SMTP = cfg.SMTP.Normalized()
+	return cfg
+}
+
 func (cfg UsenetConfig) Normalized() UsenetConfig {
 	cfg.Host = strings.TrimSpace(cfg.Host)
 	cfg.Security = strings.ToLower(strings.TrimSpace(cfg.Security))
@@ -175,15 +588,23 @@ func (cfg UsenetConfig) Normalized() UsenetConfig {
 	return cfg
 }
 
-func (cfg ConfigStruct) OpenAISettingsForSenders(senders []string) OpenAIModelSettings {
-	defaults := OpenAIModelSettings{
-		Model:           cfg.OpenAI.defaultModel(),
-		ReasoningEffort: cfg.OpenAI.defaultReasoningEffort(),
+func (cfg ConfigStruct) OpenAIExtractionSettings() OpenAIModelSettings {
+	return OpenAIModelSettings{
+		Model:           cfg.OpenAI.extractionModel(),
+		ReasoningEffort: cfg.OpenAI.extractionReasoningEffort(),
 	}
-	powerful := OpenAIModelSettings{
-		Model:           cfg.OpenAI.powerfulModel(),
-		ReasoningEffort: cfg.OpenAI.powerfulReasoningEffort(),
+}
+
+func (cfg ConfigStruct) OpenAITierSettings(tier string) OpenAIModelSettings {
+	if tier == ModelTierPowerful {
+		return OpenAIModelSettings{Model: cfg.OpenAI.powerfulModel(), ReasoningEffort: cfg.OpenAI.powerfulReasoningEffort()}
 	}
+	return OpenAIModelSettings{Model: cfg.OpenAI.defaultModel(), ReasoningEffort: cfg.OpenAI.defaultReasoningEffort()}
+}
+
+func (cfg ConfigStruct) OpenAISettingsForSenders(senders []string) OpenAIModelSettings {
+	defaults := cfg.OpenAITierSettings(ModelTierDefault)
+	powerful := cfg.OpenAITierSettings(ModelTierPowerful)
 	if len(cfg.OpenAI.PowerfulSenders) == 0 {
 		return defaults
 	}
@@ -208,6 +629,31 @@ func (cfg ConfigStruct) OpenAISettingsForSenders(senders []string) OpenAIModelSe
 	return defaults
 }
 
+func (cfg OpenAIConfig) Price(model string) (ModelPrice, bool) {
+	model = strings.TrimSpace(model)
+	if price, ok := cfg.Prices[model]; ok {
+		return price, true
+	}
+	best := ""
+	for name := range cfg.Prices {
+		if strings.HasPrefix(model, name+"-") && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return ModelPrice{}, false
+	}
+	return cfg.Prices[best], true
+}
+
+func (p ModelPrice) Cost(inputTokens, cachedInputTokens, outputTokens int) float64 {
+	cached := min(cachedInputTokens, inputTokens)
+	if p.CachedInputPerMillion == 0 {
+		cached = 0
+	}
+	return (float64(inputTokens-cached)*p.InputPerMillion + float64(cached)*p.CachedInputPerMillion + float64(outputTokens)*p.OutputPerMillion) / 1e6
+}
+
 func (cfg OpenAIConfig) defaultModel() string {
 	if model := strings.TrimSpace(cfg.DefaultModel); model != "" {
 		return model
@@ -236,6 +682,218 @@ func (cfg OpenAIConfig) powerfulReasoningEffort() string {
 	return cfg.defaultReasoningEffort()
 }
 
+func (cfg MemoryConfig) Limits() (int, int) {
+	maxNotes, maxNoteChars := cfg.MaxNotes, cfg.MaxNoteChars
+	if maxNotes == 0 {
+		maxNotes = DefaultMemoryMaxNotes
+	}
+	if maxNoteChars == 0 {
+		maxNoteChars = DefaultMemoryMaxNoteChars
+	}
+	return maxNotes, maxNoteChars
+}
+
+func (cfg ArchiveConfig) Retention() int {
+	if cfg.RetentionDays == 0 {
+		return DefaultArchiveRetentionDays
+	}
+	return cfg.RetentionDays
+}
+
+func (cfg BudgetsConfig) Enabled() bool {
+	if cfg.CorrespondentMonthly.Enabled() || cfg.GlobalDaily.Enabled() {
+		return true
+	}
+	for _, limit := range cfg.TierMonthly {
+		if limit.Enabled() {
+			return true
+		}
+	}
+	return false
+}
+
+// validate rejects usd limits that could never trip: spend is only costed
+// for models with an openai.prices entry, so every model the watcher may
+// call must have one.
+func (cfg BudgetsConfig) validate(openAI OpenAIConfig) error {
+	limits := map[string]BudgetLimit{
+		"budgets.correspondent_monthly": cfg.CorrespondentMonthly,
+		"budgets.global_daily":          cfg.GlobalDaily,
+	}
+	for tier, limit := range cfg.TierMonthly {
+		if tier != ModelTierDefault && tier != ModelTierPowerful {
+			return fmt.Errorf("config field budgets.tier_monthly has unknown tier %q: use default or powerful", tier)
+		}
+		limits["budgets.tier_monthly."+tier] = limit
+	}
+	usd := ""
+	for field, limit := range limits {
+		if limit.Tokens < 0 || limit.USD < 0 {
+			return fmt.Errorf("config field %s must not be negative", field)
+		}
+		if limit.USD > 0 && (usd == "" || field < usd) {
+			usd = field
+		}
+	}
+	if usd == "" {
+		return nil
+	}
+	for _, model := range []string{openAI.defaultModel(), openAI.powerfulModel(), openAI.extractionModel()} {
+		if _, ok := openAI.Price(model); !ok {
+			return fmt.Errorf("config field %s.usd needs an openai.prices entry for model %q; add one or use a tokens limit", usd, model)
+		}
+	}
+	return nil
+}
+
+func (limit BudgetLimit) Enabled() bool {
+	return limit.Tokens > 0 || limit.USD > 0
+}
+
+func (limit BudgetLimit) Exhausted(tokens int64, usd float64) bool {
+	return limit.Tokens > 0 && tokens >= limit.Tokens || limit.USD > 0 && usd >= limit.USD
+}
+
+// RateLimitFor picks the first of the sender's own limit, their domain's limit,
+// the first tier listing them, and the default. Unset fields fall back to the
+// default limit.
+func (cfg ConfigStruct) RateLimitFor(email string) (RateLimit, string) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	domain := email[strings.LastIndex(email, "@")+1:]
+	limits := cfg.RateLimits
+	limit, source := limits.Default, "default"
+	if matched, ok := findRateLimit(limits.Senders, email); ok {
+		limit, source = matched, "sender"
+	} else if matched, ok := findRateLimit(limits.Domains, domain); ok {
+		limit, source = matched, "domain"
+	} else {
+		for _, tier := range limits.Tiers {
+			if tier.matches(email, domain) || tier.Name == ModelTierPowerful && cfg.OpenAI.powerfulSender(email) {
+				limit, source = tier.RateLimit, "tier:"+tier.Name
+				break
+			}
+		}
+	}
+	return limit.withDefaults(limits.Default).withDefaults(RateLimit{Messages: DefaultRateLimitMessages, Window: "day", Algorithm: RateLimitFixed}), source
+}
+
+func (limit RateLimit) withDefaults(base RateLimit) RateLimit {
+	if limit.Messages == 0 {
+		limit.Messages = base.Messages
+	}
+	if strings.TrimSpace(limit.Window) == "" {
+		limit.Window = base.Window
+	}
+	if strings.TrimSpace(limit.Algorithm) == "" {
+		limit.Algorithm = base.Algorithm
+	}
+	return limit
+}
+
+// WindowDuration returns 24h for "day"; a fixed "day" window follows calendar
+// days rather than a rolling 24 hours.
+func (limit RateLimit) WindowDuration() time.Duration {
+	window := strings.TrimSpace(limit.Window)
+	if window == "" || window == "day" {
+		return 24 * time.Hour
+	}
+	duration, _ := time.ParseDuration(window)
+	return duration
+}
+
+func (limit RateLimit) CalendarDay() bool {
+	window := strings.TrimSpace(limit.Window)
+	return (window == "" || window == "day") && (limit.Algorithm == "" || limit.Algorithm == RateLimitFixed)
+}
+
+func (limit RateLimit) validate(field string) error {
+	if limit.Messages < 0 {
+		return fmt.Errorf("config field %s.messages must not be negative", field)
+	}
+	switch limit.Algorithm {
+	case "", RateLimitFixed, RateLimitSliding, RateLimitTokenBucket:
+	default:
+		return fmt.Errorf("config field %s.algorithm must be fixed, sliding or token_bucket", field)
+	}
+	if window := strings.TrimSpace(limit.Window); window != "" && window != "day" {
+		duration, err := time.ParseDuration(window)
+		if err != nil || duration < time.Minute || duration > 31*24*time.Hour {
+			return fmt.Errorf("config field %s.window must be day or a duration from 1m to 744h", field)
+		}
+	}
+	return nil
+}
+
+func (cfg RateLimitsConfig) validate() error {
+	if err := cfg.Default.validate("rate_limits.default"); err != nil {
+		return err
+	}
+	for i, tier := range cfg.Tiers {
+		if strings.TrimSpace(tier.Name) == "" {
+			return fmt.Errorf("config field rate_limits.tiers[%d].name is required", i)
+		}
+		if err := tier.validate("rate_limits.tiers." + tier.Name); err != nil {
+			return err
+		}
+	}
+	for domain, limit := range cfg.Domains {
+		if err := limit.validate("rate_limits.domains." + domain); err != nil {
+			return err
+		}
+	}
+	for sender, limit := range cfg.Senders {
+		if _, err := parseConfigEmail(sender); err != nil {
+			return fmt.Errorf("config field rate_limits.senders contains invalid email %q: %w", sender, err)
+		}
+		if err := limit.validate("rate_limits.senders." + sender); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tier RateLimitTier) matches(email string, domain string) bool {
+	for _, member := range tier.Members {
+		member = strings.ToLower(strings.TrimSpace(member))
+		if member == email || strings.TrimPrefix(member, "@") == domain {
+			return true
+		}
+	}
+	return false
+}
+
+func findRateLimit(limits map[string]RateLimit, key string) (RateLimit, bool) {
+	for name, limit := range limits {
+		if strings.EqualFold(strings.TrimSpace(name), key) {
+			return limit, true
+		}
+	}
+	return RateLimit{}, false
+}
+
+func (cfg OpenAIConfig) powerfulSender(email string) bool {
+	for _, sender := range cfg.PowerfulSenders {
+		if parsed, err := parseConfigEmail(sender); err == nil && parsed == email {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg OpenAIConfig) extractionModel() string {
+	if model := strings.TrimSpace(cfg.ExtractionModel); model != "" {
+		return model
+	}
+	return cfg.defaultModel()
+}
+
+func (cfg OpenAIConfig) extractionReasoningEffort() string {
+	if effort := strings.TrimSpace(cfg.ExtractionReasoningEffort); effort != "" {
+		return strings.ToLower(effort)
+	}
+	return DefaultOpenAIExtractionReasoningEffort
+}
+
 func validateHTTPSURL(field, value string) error {
 	if value == "" {
 		return fmt.Errorf("config field %s is required", field)
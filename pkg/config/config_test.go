@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoad(t *testing.T) {
@@ -67,6 +68,49 @@ func TestOpenAISettingsForSendersMatchesCaseInsensitivePowerfulSender(t *testing
 	}
 }
 
+func TestOpenAIExtractionSettings(t *testing.T) {
+	settings := ConfigStruct{OpenAI: OpenAIConfig{DefaultModel: "gpt-default"}}.OpenAIExtractionSettings()
+	if settings.Model != "gpt-default" || settings.ReasoningEffort != DefaultOpenAIExtractionReasoningEffort {
+		t.Fatalf("default extraction settings = %#v", settings)
+	}
+	settings = ConfigStruct{OpenAI: OpenAIConfig{ExtractionModel: "gpt-small", ExtractionReasoningEffort: "Minimal"}}.OpenAIExtractionSettings()
+	if settings.Model != "gpt-small" || settings.ReasoningEffort != "minimal" {
+		t.Fatalf("extraction settings = %#v", settings)
+	}
+}
+
+func TestMemoryLimits(t *testing.T) {
+	if notes, chars := (MemoryConfig{}).Limits(); notes != DefaultMemoryMaxNotes || chars != DefaultMemoryMaxNoteChars {
+		t.Fatalf("default limits = %d, %d", notes, chars)
+	}
+	if notes, chars := (MemoryConfig{MaxNotes: 5, MaxNoteChars: 80}).Limits(); notes != 5 || chars != 80 {
+		t.Fatalf("limits = %d, %d", notes, chars)
+	}
+	path := writeTempFile(t, `{
+  "jmap": {"session_endpoint": "https://api.example/session"},
+  "memory": {"max_notes": -1}
+}`)
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "memory.max_notes") {
+		t.Fatalf("Load error = %v, want memory validation error", err)
+	}
+}
+
+func TestArchiveRetention(t *testing.T) {
+	if days := (ArchiveConfig{}).Retention(); days != DefaultArchiveRetentionDays {
+		t.Fatalf("default retention = %d", days)
+	}
+	if days := (ArchiveConfig{RetentionDays: 30}).Retention(); days != 30 {
+		t.Fatalf("retention = %d", days)
+	}
+	path := writeTempFile(t, `{
+  "jmap": {"session_endpoint": "https://api.example/session"},
+  "archive": {"enabled": true, "retention_days": -1}
+}`)
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "archive.retention_days") {
+		t.Fatalf("Load error = %v, want archive validation error", err)
+	}
+}
+
 func TestLoadRejectsInvalidPowerfulSender(t *testing.T) {
 	path := writeTempFile(t, `{
   "jmap": {
@@ -231,3 +275,250 @@ func writeTempFile(t *testing.T, content string) string {
 	}
 	return path
 }
+
+func TestLoadAcceptsMaildirTransportWithoutJMAP(t *testing.T) {
+	path := writeTempFile(t, `{
+  "transport": {
+    "type": "maildir",
+    "maildir": {
+      "path": "/var/mail/pegasus",
+      "sendmail": "/usr/sbin/sendmail -t -i",
+      "from_address": "pegasus@example.com"
+    }
+  }
+}`)
+
+	config, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if config.TransportType() != TransportMaildir {
+		t.Fatalf("TransportType = %q", config.TransportType())
+	}
+	if got := config.Transport.Maildir.Normalized().PollInterval; got != "5s" {
+		t.Fatalf("PollInterval = %q, want 5s", got)
+	}
+}
+
+func TestLoadRejectsInvalidMaildirTransport(t *testing.T) {
+	for name, maildir := range map[string]string{
+		"missing path":        `{"outbox": "/tmp/out", "from_address": "pegasus@example.com"}`,
+		"outbox and sendmail": `{"path": "/tmp/in", "outbox": "/tmp/out", "sendmail": "sendmail -t", "from_address": "pegasus@example.com"}`,
+		"no submission":       `{"path": "/tmp/in", "from_address": "pegasus@example.com"}`,
+		"missing from":        `{"path": "/tmp/in", "outbox": "/tmp/out"}`,
+		"bad poll interval":   `{"path": "/tmp/in", "outbox": "/tmp/out", "from_address": "pegasus@example.com", "poll_interval": "soon"}`,
+	} {
+		path := writeTempFile(t, `{"transport": {"type": "maildir", "maildir": `+maildir+`}}`)
+		if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "transport.maildir") {
+			t.Fatalf("%s: Load error = %v, want transport.maildir error", name, err)
+		}
+	}
+
+	path := writeTempFile(t, `{"transport": {"type": "carrier-pigeon"}}`)
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "transport.type") {
+		t.Fatalf("unknown transport error = %v", err)
+	}
+}
+
+func TestLoadAcceptsIMAPTransport(t *testing.T) {
+	path := writeTempFile(t, `{
+  "transport": {
+    "type": "imap",
+    "imap": {"host": "imap.example.com", "archive_mailbox": "Archive"},
+    "smtp": {"host": "smtp.example.com", "security": "starttls", "from_address": "assistant@example.com"}
+  }
+}`)
+
+	config, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	imap := config.Transport.IMAP.Normalized()
+	if imap.Port != 993 || imap.Security != "tls" || imap.Mailbox != "INBOX" || imap.PollInterval != "1m" {
+		t.Fatalf("imap = %#v", imap)
+	}
+	if smtp := config.Transport.SMTP.Normalized(); smtp.Port != 587 {
+		t.Fatalf("smtp port = %d, want 587", smtp.Port)
+	}
+}
+
+func TestLoadRejectsInvalidIMAPTransport(t *testing.T) {
+	for name, transport := range map[string]string{
+		"missing imap host": `{"smtp": {"host": "smtp.example.com", "from_address": "a@example.com"}}`,
+		"bad imap security": `{"imap": {"host": "imap.example.com", "security": "ssl"}, "smtp": {"host": "smtp.example.com", "from_address": "a@example.com"}}`,
+		"missing smtp host": `{"imap": {"host": "imap.example.com"}, "smtp": {"from_address": "a@example.com"}}`,
+		"missing from":      `{"imap": {"host": "imap.example.com"}, "smtp": {"host": "smtp.example.com"}}`,
+		"bad poll interval": `{"imap": {"host": "imap.example.com", "poll_interval": "0s"}, "smtp": {"host": "smtp.example.com", "from_address": "a@example.com"}}`,
+	} {
+		path := writeTempFile(t, `{"transport": `+strings.Replace(transport, "{", `{"type": "imap", `, 1)+`}`)
+		if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "transport.") {
+			t.Fatalf("%s: Load error = %v, want transport error", name, err)
+		}
+	}
+}
+
+func TestLoadIntakeReplyModes(t *testing.T) {
+	path := writeTempFile(t, `{"transport": {"type": "maildir", "maildir": {"path": "/tmp/mail", "outbox": "/tmp/out", "from_address": "assistant@example.com"}}, "intake": {"from_address": "assistant@example.com", "smtp": {"host": "localhost", "security": "none"}}}`)
+	config, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	intake := config.Intake.Normalized()
+	if intake.Reply != IntakeReplySMTP || intake.SMTP.Port != 25 || intake.SMTP.FromAddress != "assistant@example.com" || intake.LMTPListen != "127.0.0.1:2424" {
+		t.Fatalf("intake = %#v", intake)
+	}
+
+	for name, intake := range map[string]string{
+		"missing from": `{"reply": "stdout"}`,
+		"bad reply":    `{"reply": "mbox", "from_address": "assistant@example.com"}`,
+		"smtp no host": `{"reply": "smtp", "from_address": "assistant@example.com"}`,
+	} {
+		path := writeTempFile(t, `{"transport": {"type": "maildir", "maildir": {"path": "/tmp/mail", "outbox": "/tmp/out", "from_address": "assistant@example.com"}}, "intake": `+intake+`}`)
+		if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "intake.") {
+			t.Fatalf("%s: Load error = %v, want intake error", name, err)
+		}
+	}
+}
+
+func TestLoadOpenAIPrices(t *testing.T) {
+	path := writeTempFile(t, `{
+  "jmap": {
+    "session_endpoint": "https://api.example/session"
+  },
+  "openai": {
+    "prices": {
+      "gpt-5": {"input_per_million": 1.25, "cached_input_per_million": 0.125, "output_per_million": 10},
+      "gpt-5-mini": {"input_per_million": 0.25, "output_per_million": 2}
+    }
+  }
+}`)
+
+	config, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	price, ok := config.OpenAI.Price("gpt-5-2025-08-07")
+	if !ok || price.InputPerMillion != 1.25 {
+		t.Fatalf("Price(snapshot) = %#v, %t", price, ok)
+	}
+	if cost := price.Cost(1_000_000, 200_000, 100_000); cost != 0.8*1.25+0.2*0.125+0.1*10 {
+		t.Fatalf("Cost = %v", cost)
+	}
+	mini, ok := config.OpenAI.Price("gpt-5-mini")
+	if !ok || mini.Cost(1_000_000, 500_000, 0) != 0.25 {
+		t.Fatalf("Price(gpt-5-mini) = %#v, %t", mini, ok)
+	}
+	if _, ok := config.OpenAI.Price("o3"); ok {
+		t.Fatal("Price(o3) found a price for an unlisted model")
+	}
+
+	path = writeTempFile(t, `{
+  "jmap": {"session_endpoint": "https://api.example/session"},
+  "openai": {"prices": {"gpt-5": {"output_per_million": -1}}}
+}`)
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "openai.prices.gpt-5") {
+		t.Fatalf("Load error = %v, want prices validation error", err)
+	}
+}
+
+func TestLoadBudgets(t *testing.T) {
+	path := writeTempFile(t, `{
+  "jmap": {"session_endpoint": "https://api.example/session"},
+  "openai": {"default_model": "gpt-5-nano", "powerful_model": "gpt-5", "prices": {"gpt-5": {"input_per_million": 1.25, "output_per_million": 10}}},
+  "budgets": {
+    "correspondent_monthly": {"usd": 2.5},
+    "global_daily": {"tokens": 1000000},
+    "tier_monthly": {"powerful": {"usd": 20}}
+  }
+}`)
+	config, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.Budgets.Enabled() || !config.Budgets.CorrespondentMonthly.Exhausted(0, 2.5) || config.Budgets.GlobalDaily.Exhausted(999999, 100) {
+		t.Fatalf("budgets = %+v", config.Budgets)
+	}
+	if (BudgetsConfig{}).Enabled() {
+		t.Fatal("empty budgets are enabled")
+	}
+
+	for _, budgets := range []string{
+		`{"global_daily": {"usd": -1}}`,
+		`{"tier_monthly": {"cheap": {"tokens": 10}}}`,
+		`{"global_daily": {"usd": 5}}`,
+	} {
+		path := writeTempFile(t, `{"jmap": {"session_endpoint": "https://api.example/session"}, "budgets": `+budgets+`}`)
+		if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "budgets.") {
+			t.Fatalf("Load(%s) error = %v, want budgets validation error", budgets, err)
+		}
+	}
+
+	path = writeTempFile(t, `{
+  "jmap": {"session_endpoint": "https://api.example/session"},
+  "openai": {"default_model": "gpt-5-nano", "powerful_model": "gpt-5", "extraction_model": "o4-mini", "prices": {"gpt-5": {"output_per_million": 10}}},
+  "budgets": {"tier_monthly": {"powerful": {"usd": 20}}}
+}`)
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), `"o4-mini"`) {
+		t.Fatalf("Load error = %v, want the unpriced extraction model reported", err)
+	}
+	path = writeTempFile(t, `{
+  "jmap": {"session_endpoint": "https://api.example/session"},
+  "budgets": {"global_daily": {"tokens": 1000}}
+}`)
+	if _, err := Load(path); err != nil {
+		t.Fatalf("Load with a tokens-only budget and no prices: %v", err)
+	}
+}
+
+func TestRateLimitFor(t *testing.T) {
+	path := writeTempFile(t, `{
+  "jmap": {"session_endpoint": "https://api.example/session"},
+  "openai": {"powerful_senders": ["boss@example.com"]},
+  "rate_limits": {
+    "default": {"messages": 5, "algorithm": "sliding", "window": "6h"},
+    "local_day": true,
+    "tiers": [
+      {"name": "trusted", "members": ["@partner.example", "friend@example.com"], "messages": 50},
+      {"name": "powerful", "unlimited": true}
+    ],
+    "domains": {"Example.org": {"messages": 2, "algorithm": "token_bucket", "window": "1h"}},
+    "senders": {"friend@example.com": {"messages": 100, "algorithm": "fixed", "window": "day"}}
+  }
+}`)
+	config, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		email  string
+		source string
+		want   RateLimit
+	}{
+		{"stranger@example.net", "default", RateLimit{Messages: 5, Window: "6h", Algorithm: RateLimitSliding}},
+		{"Friend@Example.com", "sender", RateLimit{Messages: 100, Window: "day", Algorithm: RateLimitFixed}},
+		{"someone@partner.example", "tier:trusted", RateLimit{Messages: 50, Window: "6h", Algorithm: RateLimitSliding}},
+		{"boss@example.com", "tier:powerful", RateLimit{Messages: 5, Window: "6h", Algorithm: RateLimitSliding, Unlimited: true}},
+		{"x@example.org", "domain", RateLimit{Messages: 2, Window: "1h", Algorithm: RateLimitTokenBucket}},
+	} {
+		got, source := config.RateLimitFor(tc.email)
+		if got != tc.want || source != tc.source {
+			t.Fatalf("RateLimitFor(%s) = %+v from %s, want %+v from %s", tc.email, got, source, tc.want, tc.source)
+		}
+	}
+	if got, _ := (ConfigStruct{}).RateLimitFor("a@example.com"); got != (RateLimit{Messages: DefaultRateLimitMessages, Window: "day", Algorithm: RateLimitFixed}) || !got.CalendarDay() || got.WindowDuration() != 24*time.Hour {
+		t.Fatalf("default rate limit = %+v", got)
+	}
+
+	for _, limits := range []string{
+		`{"default": {"algorithm": "leaky"}}`,
+		`{"default": {"window": "30s"}}`,
+		`{"tiers": [{"members": ["a@example.com"]}]}`,
+		`{"senders": {"not an email": {"messages": 1}}}`,
+		`{"domains": {"example.com": {"messages": -1}}}`,
+	} {
+		path := writeTempFile(t, `{"jmap": {"session_endpoint": "https://api.example/session"}, "rate_limits": `+limits+`}`)
+		if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "rate_limits") {
+			t.Fatalf("Load(%s) error = %v, want rate_limits validation error", limits, err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package diag
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+type Status string
+
+const (
+	Pass Status = "pass"
+	Warn Status = "warn"
+	Fail Status = "fail"
+	Skip Status = "skip"
+)
+
+type Check struct {
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Hint   string `json:"hint,omitempty"`
+}
+
+func Passed(name string, format string, args ...any) Check {
+	return Check{Name: name, Status: Pass, Detail: fmt.Sprintf(format, args...)}
+}
+
+func Warned(name string, detail string, hint string) Check {
+	return Check{Name: name, Status: Warn, Detail: detail, Hint: hint}
+}
+
+func Failed(name string, err error, hint string) Check {
+	return Check{Name: name, Status: Fail, Detail: err.Error(), Hint: hint}
+}
+
+func Skipped(name string, reason string) Check {
+	return Check{Name: name, Status: Skip, Detail: reason}
+}
+
+type Report []Check
+
+func (r Report) Count(status Status) int {
+	count := 0
+	for _, check := range r {
+		if check.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+func (r Report) Write(w io.Writer) error {
+	width := 0
+	for _, check := range r {
+		width = max(width, len(check.Name))
+	}
+	var out strings.Builder
+	for _, check := range r {
+		fmt.Fprintf(&out, "%-4s  %-*s  %s\n", strings.ToUpper(string(check.Status)), width, check.Name, check.Detail)
+		if check.Hint != "" && check.Status != Pass {
+			fmt.Fprintf(&out, "      %-*s  hint: %s\n", width, "", check.Hint)
+		}
+	}
+	fmt.Fprintf(&out, "\n%d passed, %d warnings, %d failed, %d skipped\n", r.Count(Pass), r.Count(Warn), r.Count(Fail), r.Count(Skip))
+	_, err := io.WriteString(w, out.String())
+	return err
+}
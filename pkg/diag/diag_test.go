@@ -0,0 +1,29 @@
+package diag
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReportWriteIncludesHintsForFailures(t *testing.T) {
+	report := Report{
+		Passed("config", "loaded %s", "config.json"),
+		Failed("gpg", errors.New("executable file not found"), "install gnupg"),
+		Skipped("usenet", "not configured"),
+	}
+
+	var out strings.Builder
+	if err := report.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	for _, want := range []string{"PASS  config  loaded config.json", "FAIL  gpg     executable file not found", "hint: install gnupg", "SKIP  usenet  not configured", "1 passed, 0 warnings, 1 failed, 1 skipped"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("report missing %q in:\n%s", want, got)
+		}
+	}
+	if report.Count(Fail) != 1 {
+		t.Fatalf("Count(Fail) = %d", report.Count(Fail))
+	}
+}
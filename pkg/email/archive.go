@@ -0,0 +1,226 @@
+package email
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const defaultArchiveSearchLimit = 20
+
+type ArchivedMessage struct {
+	ID           int64    `json:"id"`
+	Email        string   `json:"email"`
+	MessageID    string   `json:"messageId,omitempty"`
+	Subject      string   `json:"subject"`
+	InboundBody  string   `json:"inboundBody"`
+	ReplyBody    string   `json:"replyBody"`
+	Encrypted    bool     `json:"encrypted"`
+	Model        string   `json:"model,omitempty"`
+	ToolsUsed    []string `json:"toolsUsed"`
+	InputTokens  int      `json:"inputTokens"`
+	OutputTokens int      `json:"outputTokens"`
+	TotalTokens  int      `json:"totalTokens"`
+	ReceivedAt   string   `json:"receivedAt,omitempty"`
+	ArchivedAt   string   `json:"archivedAt"`
+}
+
+type ArchiveSearchOptions struct {
+	Query string
+	// Raw passes Query to FTS5 as is instead of matching each term literally.
+	Raw   bool
+	Email string
+	Since string
+	Limit int
+}
+
+type ArchiveSearchResult struct {
+	ID         int64  `json:"id"`
+	Email      string `json:"email"`
+	Subject    string `json:"subject"`
+	Snippet    string `json:"snippet"`
+	Model      string `json:"model,omitempty"`
+	ArchivedAt string `json:"archivedAt"`
+}
+
+func (s *correspondentStore) ArchiveMessage(ctx context.Context, message ArchivedMessage) (int64, error) {
+	email := strings.ToLower(strings.TrimSpace(message.Email))
+	if email == "" {
+		return 0, fmt.Errorf("correspondent email is empty")
+	}
+	if message.ArchivedAt == "" {
+		message.ArchivedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	result, err := s.db.ExecContext(ctx, `INSERT INTO archive_messages (
+			email, message_id, subject, inbound_body, reply_body, encrypted, model, tools_used,
+			input_tokens, output_tokens, total_tokens, received_at, archived_at
+		)
+		SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+		WHERE NOT EXISTS (SELECT 1 FROM correspondents WHERE email = ? AND archive = 0)`,
+		email, message.MessageID, message.Subject, message.InboundBody, message.ReplyBody, message.Encrypted, message.Model, strings.Join(message.ToolsUsed, ","),
+		message.InputTokens, message.OutputTokens, message.TotalTokens, message.ReceivedAt, message.ArchivedAt, email)
+	if err != nil {
+		return 0, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (s *correspondentStore) SearchArchive(ctx context.Context, opts ArchiveSearchOptions) ([]ArchiveSearchResult, error) {
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultArchiveSearchLimit
+	}
+	var query string
+	var args []any
+	if text := strings.TrimSpace(opts.Query); text != "" {
+		query = `SELECT a.id, a.email, a.subject, snippet(archive_search, -1, '[', ']', '…', 16), a.model, a.archived_at
+			FROM archive_search JOIN archive_messages a ON a.id = archive_search.rowid
+			WHERE archive_search MATCH ?`
+		if !opts.Raw {
+			text = quoteArchiveQuery(text)
+		}
+		args = append(args, text)
+	} else {
+		query = `SELECT a.id, a.email, a.subject, substr(a.inbound_body, 1, 120), a.model, a.archived_at
+			FROM archive_messages a
+			WHERE 1 = 1`
+	}
+	if email := strings.ToLower(strings.TrimSpace(opts.Email)); email != "" {
+		query += ` AND a.email = ?`
+		args = append(args, email)
+	}
+	if since := strings.TrimSpace(opts.Since); since != "" {
+		if _, err := time.Parse("2006-01-02", since); err != nil {
+			return nil, fmt.Errorf("since must be YYYY-MM-DD: %w", err)
+		}
+		query += ` AND a.archived_at >= ?`
+		args = append(args, since)
+	}
+	if strings.TrimSpace(opts.Query) != "" {
+		query += ` ORDER BY rank`
+	} else {
+		query += ` ORDER BY a.archived_at DESC`
+	}
+	query += ` LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("search archive: %w", err)
+	}
+	defer rows.Close()
+	results := []ArchiveSearchResult{}
+	for rows.Next() {
+		var result ArchiveSearchResult
+		if err := rows.Scan(&result.ID, &result.Email, &result.Subject, &result.Snippet, &result.Model, &result.ArchivedAt); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("search archive: %w", err)
+	}
+	return results, nil
+}
+
+// quoteArchiveQuery turns each whitespace-separated term into an FTS5 phrase,
+// so punctuation such as "foo-bar" or "what?" is searched for, not parsed.
+func quoteArchiveQuery(text string) string {
+	terms := strings.Fields(text)
+	for i, term := range terms {
+		terms[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	}
+	return strings.Join(terms, " ")
+}
+
+func (s *correspondentStore) GetArchivedMessage(ctx context.Context, id int64) (ArchivedMessage, error) {
+	var message ArchivedMessage
+	var tools string
+	err := s.db.QueryRowContext(ctx, `SELECT id, email, message_id, subject, inbound_body, reply_body, encrypted, model, tools_used,
+			input_tokens, output_tokens, total_tokens, received_at, archived_at
+		FROM archive_messages WHERE id = ?`, id).Scan(&message.ID, &message.Email, &message.MessageID, &message.Subject, &message.InboundBody,
+		&message.ReplyBody, &message.Encrypted, &message.Model, &tools, &message.InputTokens, &message.OutputTokens, &message.TotalTokens,
+		&message.ReceivedAt, &message.ArchivedAt)
+	if err == sql.ErrNoRows {
+		return ArchivedMessage{}, fmt.Errorf("archived message %d not found", id)
+	}
+	if err != nil {
+		return ArchivedMessage{}, err
+	}
+	message.ToolsUsed = []string{}
+	if tools != "" {
+		message.ToolsUsed = strings.Split(tools, ",")
+	}
+	return message, nil
+}
+
+func (s *correspondentStore) SetArchivePolicy(ctx context.Context, email string, enabled bool, days int) error {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return fmt.Errorf("correspondent email is empty")
+	}
+	if days < 0 {
+		return fmt.Errorf("archive retention days must not be negative")
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	result, err := s.db.ExecContext(ctx, `UPDATE correspondents SET archive = ?, archive_retention_days = ?, updated_at = ? WHERE email = ?`, enabled, days, now, email)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result, email)
+}
+
+func (s *correspondentStore) PruneArchive(ctx context.Context, defaultDays int, now time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM archive_messages WHERE id IN (
+			SELECT a.id FROM archive_messages a
+			LEFT JOIN correspondents c ON c.email = a.email
+			WHERE COALESCE(c.archive, 1) = 0
+				OR julianday(a.archived_at) < julianday(?) - COALESCE(NULLIF(c.archive_retention_days, 0), ?)
+		)`, now.UTC().Format(time.RFC3339Nano), defaultDays)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (w *Watcher) archiveExchange(ctx context.Context, msg emailMessage, body string, reply openAIAnswer) {
+	if w.store == nil || !w.appConfig.Archive.Enabled || len(msg.From) == 0 {
+		return
+	}
+	entry := ArchivedMessage{
+		Email:        msg.From[0].Email,
+		Subject:      msg.Subject,
+		InboundBody:  body,
+		ReplyBody:    reply.Text,
+		Model:        reply.Model,
+		ToolsUsed:    reply.ToolsUsed,
+		InputTokens:  reply.Usage.InputTokens,
+		OutputTokens: reply.Usage.OutputTokens,
+		TotalTokens:  reply.Usage.TotalTokens,
+		ReceivedAt:   msg.ReceivedAt,
+	}
+	if len(msg.MessageID) > 0 {
+		entry.MessageID = msg.MessageID[0]
+	}
+	if _, encrypted := extractPGPEncryptedPayload(msg.Raw, extractEmailBody(msg)); encrypted {
+		entry.Encrypted = true
+		if !w.appConfig.Archive.IncludeEncrypted {
+			entry.Subject, entry.InboundBody, entry.ReplyBody = "", "", ""
+		}
+	}
+	id, err := w.store.ArchiveMessage(ctx, entry)
+	if err != nil {
+		w.logf("exchange not archived: id=%s err=%v", msg.ID, err)
+		return
+	}
+	pruned, err := w.store.PruneArchive(ctx, w.appConfig.Archive.Retention(), time.Now())
+	if err != nil {
+		w.logf("archive prune failed: err=%v", err)
+	}
+	w.logf("exchange archived: id=%s archive_id=%d encrypted=%t content=%t pruned=%d", msg.ID, id, entry.Encrypted, entry.InboundBody != "", pruned)
+}
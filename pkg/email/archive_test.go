@@ -0,0 +1,157 @@
+package email
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	appconfig "ai-over-email/pkg/config"
+)
+
+func TestCorrespondentStoreArchiveSearch(t *testing.T) {
+	ctx := context.Background()
+	store := openTestCorrespondentStore(t)
+	alice, bob := testAddress("alice", "mail.test"), testAddress("bob", "mail.test")
+
+	first, err := store.ArchiveMessage(ctx, ArchivedMessage{Email: alice, Subject: "Altitude", InboundBody: "Why does water boil sooner in Denver?", ReplyBody: "Lower air pressure at altitude.", Model: "gpt-5-nano", ToolsUsed: []string{"web_search"}, TotalTokens: 30})
+	if err != nil || first == 0 {
+		t.Fatalf("ArchiveMessage = %d, %v", first, err)
+	}
+	if _, err := store.ArchiveMessage(ctx, ArchivedMessage{Email: bob, Subject: "Mortgage", InboundBody: "Should I refinance?", ReplyBody: "It depends on the rate and closing costs."}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := store.SearchArchive(ctx, ArchiveSearchOptions{Query: "pressure"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].ID != first || !strings.Contains(results[0].Snippet, "[pressure]") {
+		t.Fatalf("search results = %+v", results)
+	}
+	if results, err = store.SearchArchive(ctx, ArchiveSearchOptions{Query: "refinanc*", Email: alice, Raw: true}); err != nil || len(results) != 0 {
+		t.Fatalf("search scoped to alice = %+v, %v", results, err)
+	}
+	if results, err = store.SearchArchive(ctx, ArchiveSearchOptions{Query: "refinanc*", Raw: true}); err != nil || len(results) != 1 || results[0].Email != bob {
+		t.Fatalf("raw prefix search = %+v, %v", results, err)
+	}
+	if results, err = store.SearchArchive(ctx, ArchiveSearchOptions{}); err != nil || len(results) != 2 || results[0].Email != bob {
+		t.Fatalf("recent exchanges = %+v, %v", results, err)
+	}
+	if _, err := store.SearchArchive(ctx, ArchiveSearchOptions{Query: `"unterminated`, Raw: true}); err == nil {
+		t.Fatal("invalid raw FTS query was accepted")
+	}
+
+	message, err := store.GetArchivedMessage(ctx, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.ReplyBody != "Lower air pressure at altitude." || !slices.Equal(message.ToolsUsed, []string{"web_search"}) || message.TotalTokens != 30 {
+		t.Fatalf("archived message = %+v", message)
+	}
+	if _, err := store.GetArchivedMessage(ctx, 999); err == nil {
+		t.Fatal("missing archive id was found")
+	}
+}
+
+func TestCorrespondentStoreArchivePolicyAndPrune(t *testing.T) {
+	ctx := context.Background()
+	store := openTestCorrespondentStore(t)
+	alice, bob := testAddress("alice", "mail.test"), testAddress("bob", "mail.test")
+	for _, email := range []string{alice, bob} {
+		if _, err := store.Register(ctx, email, "", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	archive := func(email string, age time.Duration) {
+		t.Helper()
+		if _, err := store.ArchiveMessage(ctx, ArchivedMessage{Email: email, InboundBody: "hello", ArchivedAt: now.Add(-age).Format(time.RFC3339Nano)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archive(alice, 10*24*time.Hour)
+	archive(alice, 40*24*time.Hour)
+	archive(bob, 10*24*time.Hour)
+	archive(bob, 40*24*time.Hour)
+
+	if err := store.SetArchivePolicy(ctx, alice, true, 5); err != nil {
+		t.Fatal(err)
+	}
+	pruned, err := store.PruneArchive(ctx, 30, now)
+	if err != nil || pruned != 3 {
+		t.Fatalf("PruneArchive = %d, %v; want alice's two and bob's 40-day-old exchange", pruned, err)
+	}
+
+	if err := store.SetArchivePolicy(ctx, bob, false, 0); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := store.ArchiveMessage(ctx, ArchivedMessage{Email: bob, InboundBody: "hello"}); err != nil || id != 0 {
+		t.Fatalf("opted-out ArchiveMessage = %d, %v", id, err)
+	}
+	if pruned, err = store.PruneArchive(ctx, 30, now); err != nil || pruned != 1 {
+		t.Fatalf("PruneArchive after opt-out = %d, %v", pruned, err)
+	}
+	if err := store.SetArchivePolicy(ctx, bob, true, -1); err == nil {
+		t.Fatal("negative retention was accepted")
+	}
+	detail, err := store.GetCorrespondent(ctx, alice)
+	if err != nil || !detail.Archive || detail.ArchiveRetentionDays != 5 {
+		t.Fatalf("alice = %+v, %v", detail.Correspondent, err)
+	}
+}
+
+func TestWatcherArchivesAnsweredExchange(t *testing.T) {
+	ctx := context.Background()
+	w := &Watcher{store: openTestCorrespondentStore(t)}
+	msg := emailMessage{ID: "m1", Subject: "Bread", From: []emailAddress{{Email: testAddress("Sender", "mail.test")}}, MessageID: []string{"bread@mail.test"}}
+	reply := openAIAnswer{Text: "About 500 g of flour.", Model: "gpt-5-nano", Usage: openAIUsage{InputTokens: 20, OutputTokens: 5, TotalTokens: 25}}
+
+	w.archiveExchange(ctx, msg, "How much flour?", reply)
+	if results, err := w.store.SearchArchive(ctx, ArchiveSearchOptions{}); err != nil || len(results) != 0 {
+		t.Fatalf("archived while disabled: %+v, %v", results, err)
+	}
+
+	w.appConfig = appconfig.ConfigStruct{Archive: appconfig.ArchiveConfig{Enabled: true}}
+	w.archiveExchange(ctx, msg, "How much flour?", reply)
+	results, err := w.store.SearchArchive(ctx, ArchiveSearchOptions{Query: "flour", Email: msg.From[0].Email})
+	if err != nil || len(results) != 1 {
+		t.Fatalf("search = %+v, %v", results, err)
+	}
+	message, err := w.store.GetArchivedMessage(ctx, results[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.MessageID != "bread@mail.test" || message.InboundBody != "How much flour?" || message.Model != "gpt-5-nano" || message.InputTokens != 20 || message.Encrypted {
+		t.Fatalf("archived message = %+v", message)
+	}
+}
+
+func TestCorrespondentStoreArchiveSearchQuotesPunctuation(t *testing.T) {
+	ctx := context.Background()
+	store := openTestCorrespondentStore(t)
+	alice := testAddress("alice", "mail.test")
+	if _, err := store.ArchiveMessage(ctx, ArchivedMessage{Email: alice, Subject: "Hyphens", InboundBody: "What does foo-bar mean? Is it \"slang\"?", ReplyBody: "A placeholder name."}); err != nil {
+		t.Fatal(err)
+	}
+
+	for query, want := range map[string]int{
+		"foo-bar":       1,
+		"what?":         1,
+		`"slang`:        1,
+		`mean? "slang"`: 1,
+		"NOT":           0,
+		"subject:foo":   0,
+		"?":             0,
+		"foo-baz":       0,
+	} {
+		results, err := store.SearchArchive(ctx, ArchiveSearchOptions{Query: query})
+		if err != nil {
+			t.Fatalf("SearchArchive(%q): %v", query, err)
+		}
+		if len(results) != want {
+			t.Fatalf("SearchArchive(%q) = %d results, want %d", query, len(results), want)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package email
 
 import (
-	"context"
 	"encoding/base64"
 	"fmt"
 	"io"
@@ -13,32 +12,6 @@ import (
 
 const maxAttachmentBytes = 25 * 1024 * 1024
 
-func (w *Watcher) fetchAttachments(ctx context.Context, parts []emailBodyPart) ([]emailAttachment, error) {
-	attachments := make([]emailAttachment, 0, len(parts))
-	for _, part := range parts {
-		blobID := strings.TrimSpace(part.BlobID)
-		if blobID == "" {
-			continue
-		}
-		attachment := emailAttachment{
-			Name:   part.Name,
-			Type:   part.Type,
-			BlobID: blobID,
-			Size:   part.Size,
-		}
-		data, err := w.client.Download(ctx, w.accountID, blobID, attachmentName(attachment), attachmentType(attachment))
-		if err != nil {
-			return nil, err
-		}
-		attachment.Data = data
-		if attachment.Size == 0 {
-			attachment.Size = len(data)
-		}
-		attachments = append(attachments, attachment)
-	}
-	return attachments, nil
-}
-
 func extractDecryptedAttachments(plaintext string) []emailAttachment {
 	trimmed := strings.TrimSpace(plaintext)
 	if trimmed == "" {
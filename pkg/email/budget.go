@@ -0,0 +1,201 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	appconfig "ai-over-email/pkg/config"
+)
+
+const (
+	budgetGlobalDaily          = "global_daily"
+	budgetCorrespondentMonthly = "correspondent_monthly"
+	budgetDefaultTierMonthly   = "default_tier_monthly"
+)
+
+const budgetReplySubject = "Usage budget reached"
+
+type budgetSpend struct {
+	Tokens  int64
+	CostUSD float64
+}
+
+type budgetCheck struct {
+	Settings   appconfig.OpenAIModelSettings
+	Downgraded bool
+	Exhausted  string
+}
+
+// spendSince sums usage since the given time, optionally for one correspondent
+// or one tier. Rows recorded before the tier column existed count toward a tier
+// when their model is the tier's current model.
+func (s *correspondentStore) spendSince(ctx context.Context, since time.Time, email string, tier string, tierModel string) (budgetSpend, error) {
+	query := `SELECT COALESCE(SUM(total_tokens), 0), COALESCE(SUM(cost_usd), 0) FROM message_usage WHERE created_at >= ?`
+	// Without the zone suffix the bound sorts before every RFC3339Nano row in
+	// the same second, including fractional ones such as 00:00:00.5Z.
+	args := []any{since.UTC().Format("2006-01-02T15:04:05")}
+	if email != "" {
+		query += ` AND email = ?`
+		args = append(args, strings.ToLower(strings.TrimSpace(email)))
+	}
+	if tier != "" {
+		query += ` AND (tier = ? OR (tier = '' AND model = ?))`
+		args = append(args, tier, tierModel)
+	}
+	var spend budgetSpend
+	err := s.db.QueryRowContext(ctx, query, args...).Scan(&spend.Tokens, &spend.CostUSD)
+	return spend, err
+}
+
+func budgetPeriods(now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC), time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Global and per-correspondent budgets are hard limits. A spent powerful tier
+// falls back to the default tier, and only a spent default tier stops replies.
+func (w *Watcher) checkBudgets(ctx context.Context, msg emailMessage, settings appconfig.OpenAIModelSettings, now time.Time) (budgetCheck, error) {
+	check := budgetCheck{Settings: settings}
+	budgets := w.appConfig.Budgets
+	if w.store == nil || !budgets.Enabled() {
+		return check, nil
+	}
+	dayStart, monthStart := budgetPeriods(now)
+	if budgets.GlobalDaily.Enabled() {
+		spend, err := w.store.spendSince(ctx, dayStart, "", "", "")
+		if err != nil {
+			return budgetCheck{}, err
+		}
+		if budgets.GlobalDaily.Exhausted(spend.Tokens, spend.CostUSD) {
+			check.Exhausted = budgetGlobalDaily
+			return check, nil
+		}
+	}
+	if budgets.CorrespondentMonthly.Enabled() && len(msg.From) > 0 {
+		spend, err := w.store.spendSince(ctx, monthStart, msg.From[0].Email, "", "")
+		if err != nil {
+			return budgetCheck{}, err
+		}
+		if budgets.CorrespondentMonthly.Exhausted(spend.Tokens, spend.CostUSD) {
+			check.Exhausted = budgetCorrespondentMonthly
+			return check, nil
+		}
+	}
+
+	defaults := w.appConfig.OpenAITierSettings(appconfig.ModelTierDefault)
+	tiers := []string{appconfig.ModelTierDefault}
+	if settings != defaults && settings == w.appConfig.OpenAITierSettings(appconfig.ModelTierPowerful) {
+		tiers = []string{appconfig.ModelTierPowerful, appconfig.ModelTierDefault}
+	}
+	for _, tier := range tiers {
+		limit := budgets.TierMonthly[tier]
+		if !limit.Enabled() {
+			return check, nil
+		}
+		spend, err := w.store.spendSince(ctx, monthStart, "", tier, w.appConfig.OpenAITierSettings(tier).Model)
+		if err != nil {
+			return budgetCheck{}, err
+		}
+		if !limit.Exhausted(spend.Tokens, spend.CostUSD) {
+			return check, nil
+		}
+		if tier == appconfig.ModelTierPowerful {
+			check.Settings, check.Downgraded = defaults, true
+			continue
+		}
+		check.Exhausted = budgetDefaultTierMonthly
+	}
+	return check, nil
+}
+
+// settingsTier names the tier a call's settings belong to. Usage is recorded
+// under it so a tier keeps its monthly spend when its model is changed.
+// Extraction calls count toward the tier that uses the same model, if any.
+func (w *Watcher) settingsTier(settings appconfig.OpenAIModelSettings) string {
+	defaults := w.appConfig.OpenAITierSettings(appconfig.ModelTierDefault)
+	powerful := w.appConfig.OpenAITierSettings(appconfig.ModelTierPowerful)
+	switch {
+	case settings == defaults:
+		return appconfig.ModelTierDefault
+	case settings == powerful:
+		return appconfig.ModelTierPowerful
+	case settings.Model == defaults.Model:
+		return appconfig.ModelTierDefault
+	case settings.Model == powerful.Model:
+		return appconfig.ModelTierPowerful
+	}
+	return ""
+}
+
+// sideCallWithinBudget reports whether a profile or notes extraction may call
+// the model. Spent budgets skip these calls quietly; only the reply itself
+// answers with a budget notice.
+func (w *Watcher) sideCallWithinBudget(ctx context.Context, msg emailMessage, kind string) bool {
+	check, err := w.checkBudgets(ctx, msg, w.appConfig.OpenAIExtractionSettings(), time.Now())
+	if err != nil {
+		w.logf("model call skipped, budget check failed: id=%s kind=%s err=%v", msg.ID, kind, err)
+		return false
+	}
+	if check.Exhausted != "" {
+		w.logf("model call skipped by budget: id=%s kind=%s budget=%s", msg.ID, kind, check.Exhausted)
+		return false
+	}
+	return true
+}
+
+func (w *Watcher) budgetRemaining(ctx context.Context, msg emailMessage, now time.Time) string {
+	budgets := w.appConfig.Budgets
+	if w.store == nil {
+		return ""
+	}
+	dayStart, monthStart := budgetPeriods(now)
+	limit, since, email, period := budgets.CorrespondentMonthly, monthStart, "", "this month"
+	if len(msg.From) > 0 {
+		email = msg.From[0].Email
+	}
+	if !limit.Enabled() || email == "" {
+		limit, since, email, period = budgets.GlobalDaily, dayStart, "", "today"
+	}
+	if !limit.Enabled() {
+		return ""
+	}
+	spend, err := w.store.spendSince(ctx, since, email, "", "")
+	if err != nil {
+		w.logf("budget remaining not computed: id=%s err=%v", msg.ID, err)
+		return ""
+	}
+	var parts []string
+	if limit.USD > 0 {
+		parts = append(parts, fmt.Sprintf("$%.2f of $%.2f", max(limit.USD-spend.CostUSD, 0), limit.USD))
+	}
+	if limit.Tokens > 0 {
+		parts = append(parts, strconv.FormatInt(max(limit.Tokens-spend.Tokens, 0), 10)+" of "+strconv.FormatInt(limit.Tokens, 10)+" tokens")
+	}
+	return "Budget remaining " + period + ": " + strings.Join(parts, ", ")
+}
+
+func (w *Watcher) sendBudgetReply(ctx context.Context, to emailAddress, exhausted string, now time.Time, footer emailFooterStats) error {
+	body := budgetReplyBody(exhausted, now)
+	htmlBody, err := formatReplyHTMLBody(body, emailMessage{}, "")
+	if err != nil {
+		return err
+	}
+	return w.sendEmail(ctx, []emailAddress{to}, budgetReplySubject, body, htmlBody, nil, emailMessage{}, footer)
+}
+
+func budgetReplyBody(exhausted string, now time.Time) string {
+	dayStart, monthStart := budgetPeriods(now)
+	var reason string
+	switch exhausted {
+	case budgetGlobalDaily:
+		reason = fmt.Sprintf("This service has used its model budget for %s (UTC). Please try again after %s.", dayStart.Format("2006-01-02"), dayStart.AddDate(0, 0, 1).Format("2006-01-02"))
+	case budgetCorrespondentMonthly:
+		reason = fmt.Sprintf("You have used your model budget for %s. It resets on %s (UTC).", monthStart.Format("January 2006"), monthStart.AddDate(0, 1, 0).Format("2006-01-02"))
+	default:
+		reason = fmt.Sprintf("This service has used its model budget for %s. Please try again after %s (UTC).", monthStart.Format("January 2006"), monthStart.AddDate(0, 1, 0).Format("2006-01-02"))
+	}
+	return "Hello,\n\n" + reason + "\n\nThanks."
+}
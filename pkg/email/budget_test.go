@@ -0,0 +1,155 @@
+package email
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	appconfig "ai-over-email/pkg/config"
+)
+
+func TestWatcherCheckBudgets(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 5, 20, 15, 0, 0, 0, time.UTC)
+	alice, bob := testAddress("alice", "mail.test"), testAddress("bob", "mail.test")
+	w := &Watcher{store: openTestCorrespondentStore(t), appConfig: appconfig.ConfigStruct{OpenAI: appconfig.OpenAIConfig{
+		DefaultModel:    "small",
+		PowerfulModel:   "large",
+		PowerfulSenders: []string{alice},
+	}}}
+	msg := emailMessage{ID: "m1", From: []emailAddress{{Email: alice}}}
+	powerful := w.appConfig.OpenAISettingsForSenders([]string{alice})
+	for _, record := range []messageUsage{
+		{Email: alice, Model: "large", Usage: openAIUsage{TotalTokens: 600}, CostUSD: 3, CreatedAt: now.Add(-time.Hour)},
+		{Email: bob, Model: "small", Usage: openAIUsage{TotalTokens: 300}, CostUSD: 0.5, CreatedAt: now.AddDate(0, 0, -2)},
+		{Email: alice, Model: "large", Usage: openAIUsage{TotalTokens: 5000}, CostUSD: 50, CreatedAt: now.AddDate(0, -1, 0)},
+	} {
+		if err := w.store.RecordMessageUsage(ctx, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name       string
+		budgets    appconfig.BudgetsConfig
+		exhausted  string
+		downgraded bool
+	}{
+		{name: "none"},
+		{name: "under budget", budgets: appconfig.BudgetsConfig{CorrespondentMonthly: appconfig.BudgetLimit{USD: 3.5}, GlobalDaily: appconfig.BudgetLimit{Tokens: 1000}}},
+		{name: "global daily", budgets: appconfig.BudgetsConfig{GlobalDaily: appconfig.BudgetLimit{Tokens: 600}}, exhausted: budgetGlobalDaily},
+		{name: "correspondent monthly", budgets: appconfig.BudgetsConfig{CorrespondentMonthly: appconfig.BudgetLimit{USD: 3}}, exhausted: budgetCorrespondentMonthly},
+		{name: "powerful tier", budgets: appconfig.BudgetsConfig{TierMonthly: map[string]appconfig.BudgetLimit{"powerful": {USD: 2}}}, downgraded: true},
+		{name: "both tiers", budgets: appconfig.BudgetsConfig{TierMonthly: map[string]appconfig.BudgetLimit{"powerful": {USD: 2}, "default": {Tokens: 300}}}, downgraded: true, exhausted: budgetDefaultTierMonthly},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w.appConfig.Budgets = tc.budgets
+			check, err := w.checkBudgets(ctx, msg, powerful, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if check.Exhausted != tc.exhausted || check.Downgraded != tc.downgraded {
+				t.Fatalf("check = %+v", check)
+			}
+			if want := map[bool]string{false: "large", true: "small"}[tc.downgraded]; check.Settings.Model != want {
+				t.Fatalf("model = %q, want %q", check.Settings.Model, want)
+			}
+		})
+	}
+}
+
+func TestWatcherBudgetRemainingFooter(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 5, 20, 15, 0, 0, 0, time.UTC)
+	email := testAddress("alice", "mail.test")
+	w := &Watcher{store: openTestCorrespondentStore(t)}
+	msg := emailMessage{ID: "m1", From: []emailAddress{{Email: email}}}
+	if err := w.store.RecordMessageUsage(ctx, messageUsage{Email: email, Model: "small", Usage: openAIUsage{TotalTokens: 400}, CostUSD: 1.5, CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := w.budgetRemaining(ctx, msg, now); got != "" {
+		t.Fatalf("remaining without budgets = %q", got)
+	}
+	w.appConfig.Budgets.GlobalDaily = appconfig.BudgetLimit{Tokens: 1000}
+	if got := w.budgetRemaining(ctx, msg, now); got != "Budget remaining today: 600 of 1000 tokens" {
+		t.Fatalf("global remaining = %q", got)
+	}
+	w.appConfig.Budgets.CorrespondentMonthly = appconfig.BudgetLimit{USD: 1}
+	got := w.budgetRemaining(ctx, msg, now)
+	if got != "Budget remaining this month: $0.00 of $1.00" {
+		t.Fatalf("correspondent remaining = %q", got)
+	}
+	if footer := responseFooterText(emailFooterStats{BudgetRemaining: got}); !strings.HasSuffix(footer, " | "+got) {
+		t.Fatalf("footer = %q", footer)
+	}
+	if body := budgetReplyBody(budgetCorrespondentMonthly, now); !strings.Contains(body, "May 2026") || !strings.Contains(body, "2026-06-01") {
+		t.Fatalf("budget reply = %q", body)
+	}
+}
+
+func TestCorrespondentStoreSpendSinceIncludesFirstSecond(t *testing.T) {
+	ctx := context.Background()
+	store := openTestCorrespondentStore(t)
+	midnight := time.Date(2026, 5, 20, 0, 0, 0, 0, time.UTC)
+	for _, at := range []time.Time{midnight.Add(-time.Millisecond), midnight.Add(500 * time.Millisecond), midnight} {
+		if err := store.RecordMessageUsage(ctx, messageUsage{Model: "small", Usage: openAIUsage{TotalTokens: 10}, CreatedAt: at}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	spend, err := store.spendSince(ctx, midnight, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spend.Tokens != 20 {
+		t.Fatalf("tokens since midnight = %d, want 20", spend.Tokens)
+	}
+}
+
+func TestWatcherTierBudgetSurvivesModelChange(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 5, 20, 15, 0, 0, 0, time.UTC)
+	alice := testAddress("alice", "mail.test")
+	w := &Watcher{store: openTestCorrespondentStore(t), appConfig: appconfig.ConfigStruct{
+		OpenAI:  appconfig.OpenAIConfig{DefaultModel: "small", PowerfulModel: "large", PowerfulSenders: []string{alice}},
+		Budgets: appconfig.BudgetsConfig{TierMonthly: map[string]appconfig.BudgetLimit{"powerful": {USD: 2}}},
+	}}
+	msg := emailMessage{ID: "m1", From: []emailAddress{{Email: alice}}}
+	w.recordUsage(ctx, msg, usageKindReply, w.appConfig.OpenAITierSettings(appconfig.ModelTierPowerful), openAIUsage{TotalTokens: 100}, 0, time.Second)
+	if _, err := w.store.db.ExecContext(ctx, `UPDATE message_usage SET cost_usd = 3, created_at = ?`, now.Add(-time.Hour).Format(time.RFC3339Nano)); err != nil {
+		t.Fatal(err)
+	}
+
+	w.appConfig.OpenAI.PowerfulModel = "larger"
+	check, err := w.checkBudgets(ctx, msg, w.appConfig.OpenAITierSettings(appconfig.ModelTierPowerful), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !check.Downgraded || check.Settings.Model != "small" {
+		t.Fatalf("check after changing the powerful model = %+v, want the spent tier downgraded", check)
+	}
+}
+
+func TestWatcherSkipsSideCallsWhenBudgetSpent(t *testing.T) {
+	ctx := context.Background()
+	alice := testAddress("alice", "mail.test")
+	w := &Watcher{store: openTestCorrespondentStore(t), config: Config{LogOutput: io.Discard}, appConfig: appconfig.ConfigStruct{
+		Budgets: appconfig.BudgetsConfig{GlobalDaily: appconfig.BudgetLimit{Tokens: 100}},
+	}}
+	msg := emailMessage{ID: "m1", From: []emailAddress{{Email: alice}}}
+	if err := w.store.RecordMessageUsage(ctx, messageUsage{Email: alice, Model: "small", Usage: openAIUsage{TotalTokens: 100}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// w.openai is nil, so either extraction would panic if it reached the model.
+	if update := w.extractProfile(ctx, msg, "I'm in 80202."); update != (correspondentProfileUpdate{}) {
+		t.Fatalf("extractProfile = %+v, want no model update", update)
+	}
+	w.updateNotes(ctx, msg, "How much flour?", "About 500 g.")
+	notes, err := w.store.Notes(ctx, alice)
+	if err != nil || len(notes) != 0 {
+		t.Fatalf("notes = %+v, %v", notes, err)
+	}
+}
@@ -0,0 +1,281 @@
+package email
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type cassette struct {
+	BraveSearch  bool          `json:"brave_search"`
+	Interactions []interaction `json:"interactions"`
+}
+
+type interaction struct {
+	Method   string          `json:"method"`
+	Path     string          `json:"path"`
+	Query    string          `json:"query,omitempty"`
+	Request  json.RawMessage `json:"request,omitempty"`
+	Status   int             `json:"status"`
+	Response json.RawMessage `json:"response"`
+}
+
+type cassetteTransport struct {
+	base      http.RoundTripper
+	recording bool
+	// stub serves recorded responses without checking the request bodies. It
+	// stands in for the live APIs when re-recording a changed prompt.
+	stub bool
+
+	mu      sync.Mutex
+	tape    cassette
+	next    int
+	onEvent func(interaction)
+}
+
+func loadCassette(path string) (cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cassette{}, fmt.Errorf("read cassette: %w", err)
+	}
+	var tape cassette
+	if err := json.Unmarshal(data, &tape); err != nil {
+		return cassette{}, fmt.Errorf("decode cassette %s: %w", path, err)
+	}
+	return tape, nil
+}
+
+func saveCassette(path string, tape cassette) error {
+	data, err := json.MarshalIndent(tape, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode cassette: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("write cassette: %w", err)
+	}
+	return nil
+}
+
+func (t *cassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	current := interaction{
+		Method:  req.Method,
+		Path:    req.URL.Path,
+		Query:   req.URL.RawQuery,
+		Request: cassetteBody(body),
+	}
+	if t.recording {
+		return t.record(req, body, current)
+	}
+	return t.replay(req, current)
+}
+
+func (t *cassetteTransport) record(req *http.Request, body []byte, current interaction) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	forwarded := req.Clone(req.Context())
+	forwarded.Body = io.NopCloser(bytes.NewReader(body))
+	forwarded.ContentLength = int64(len(body))
+	resp, err := base.RoundTrip(forwarded)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	current.Status = resp.StatusCode
+	current.Response = cassetteBody(data)
+
+	t.mu.Lock()
+	t.tape.Interactions = append(t.tape.Interactions, current)
+	onEvent := t.onEvent
+	t.mu.Unlock()
+	if onEvent != nil {
+		onEvent(current)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	return resp, nil
+}
+
+func (t *cassetteTransport) replay(req *http.Request, current interaction) (*http.Response, error) {
+	t.mu.Lock()
+	if t.next >= len(t.tape.Interactions) {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("cassette exhausted: unexpected %s %s", current.Method, current.Path)
+	}
+	recorded := t.tape.Interactions[t.next]
+	t.next++
+	onEvent := t.onEvent
+	t.mu.Unlock()
+	if recorded.Method != current.Method || recorded.Path != current.Path {
+		return nil, fmt.Errorf("cassette mismatch at interaction %d: recorded %s %s, got %s %s", t.next, recorded.Method, recorded.Path, current.Method, current.Path)
+	}
+	if !t.stub {
+		if where := cassetteRequestDifference(recorded, current); where != "" {
+			return nil, fmt.Errorf("cassette request differs at interaction %d (%s %s) at %s; re-record needed: rerun with --record, or --record --stub to keep the recorded responses", t.next, current.Method, current.Path, where)
+		}
+	}
+	current.Status = recorded.Status
+	current.Response = recorded.Response
+	if onEvent != nil {
+		onEvent(current)
+	}
+
+	body := []byte(recorded.Response)
+	var text string
+	if json.Unmarshal(recorded.Response, &text) == nil {
+		body = []byte(text)
+	}
+	status := recorded.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return &http.Response{
+		Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func (t *cassetteTransport) remaining() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.tape.Interactions) - t.next
+}
+
+func cassetteBody(data []byte) json.RawMessage {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil
+	}
+	if json.Valid(trimmed) && !bytes.HasPrefix(trimmed, []byte(`"`)) {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, trimmed); err == nil {
+			return compact.Bytes()
+		}
+	}
+	quoted, _ := json.Marshal(string(data))
+	return quoted
+}
+
+// cassetteRequestDifference compares the query and JSON body of a replayed
+// request with the recorded one, ignoring key order and whitespace. It returns
+// the location of the first difference, or "" when they match.
+func cassetteRequestDifference(recorded, current interaction) string {
+	recordedQuery, _ := url.ParseQuery(recorded.Query)
+	currentQuery, _ := url.ParseQuery(current.Query)
+	if recordedQuery.Encode() != currentQuery.Encode() {
+		return "query"
+	}
+	return jsonDifference("body", decodeCassetteBody(recorded.Request), decodeCassetteBody(current.Request))
+}
+
+func decodeCassetteBody(body json.RawMessage) any {
+	var value any
+	if len(body) > 0 && json.Unmarshal(body, &value) != nil {
+		return string(body)
+	}
+	return value
+}
+
+func jsonDifference(path string, recorded, current any) string {
+	switch recorded := recorded.(type) {
+	case map[string]any:
+		current, ok := current.(map[string]any)
+		if !ok {
+			return path
+		}
+		keys := make([]string, 0, len(recorded)+len(current))
+		for key := range recorded {
+			keys = append(keys, key)
+		}
+		for key := range current {
+			if _, ok := recorded[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if where := jsonDifference(path+"."+key, recorded[key], current[key]); where != "" {
+				return where
+			}
+		}
+		return ""
+	case []any:
+		current, ok := current.([]any)
+		if !ok || len(current) != len(recorded) {
+			return path
+		}
+		for i := range recorded {
+			if where := jsonDifference(fmt.Sprintf("%s[%d]", path, i), recorded[i], current[i]); where != "" {
+				return where
+			}
+		}
+		return ""
+	default:
+		if recorded != current {
+			return path
+		}
+		return ""
+	}
+}
+
+var errCassetteUnused = errors.New("cassette has unused interactions")
+
+func describeInteraction(event interaction) string {
+	switch {
+	case strings.HasSuffix(event.Path, "/responses"):
+		var request struct {
+			Model              string `json:"model"`
+			PreviousResponseID string `json:"previous_response_id"`
+			Reasoning          struct {
+				Effort string `json:"effort"`
+			} `json:"reasoning"`
+			Tools []struct {
+				Type string `json:"type"`
+				Name string `json:"name"`
+			} `json:"tools"`
+		}
+		_ = json.Unmarshal(event.Request, &request)
+		tools := make([]string, 0, len(request.Tools))
+		for _, tool := range request.Tools {
+			tools = append(tools, strings.TrimSpace(tool.Type+" "+tool.Name))
+		}
+		return fmt.Sprintf("openai model=%s reasoning_effort=%s followup=%t tools=%q status=%d", request.Model, request.Reasoning.Effort, request.PreviousResponseID != "", tools, event.Status)
+	case strings.Contains(event.Path, "/web/search"):
+		query := event.Query
+		if values, err := url.ParseQuery(query); err == nil {
+			query = values.Get("q")
+		}
+		return fmt.Sprintf("brave_search query=%q status=%d", query, event.Status)
+	default:
+		return fmt.Sprintf("http %s %s status=%d", event.Method, event.Path, event.Status)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"os"
 	"strings"
+
+	appconfig "ai-over-email/pkg/config"
 )
 
 const defaultEnvPath = ".env"
@@ -19,9 +21,19 @@ type Credentials struct {
 	Mailbox             string
 	PublicEmail         string
 	PlaintextAllowlist  []string
+	IMAPUsername        string
+	IMAPPassword        string
+	SMTPUsername        string
+	SMTPPassword        string
+	OAuthRefreshToken   string
+	OAuthClientSecret   string
 }
 
 func LoadCredentials(envPath string) (Credentials, error) {
+	return loadCredentials(envPath, appconfig.TransportJMAP)
+}
+
+func loadCredentials(envPath string, transport string) (Credentials, error) {
 	values, err := loadEnvironment(envPath)
 	if err != nil {
 		return Credentials{}, err
@@ -29,17 +41,19 @@ func LoadCredentials(envPath string) (Credentials, error) {
 
 	creds := Credentials{
 		Username:            first(values, "AI_OVER_EMAIL_USERNAME"),
-		Password:            first(values, "AI_OVER_EMAIL_FASTMAIL_PASSWORD"),
-		Token:               first(values, "AI_OVER_EMAIL_FASTMAIL_TOKEN"),
+		Password:            first(values, "AI_OVER_EMAIL_JMAP_PASSWORD", "AI_OVER_EMAIL_FASTMAIL_PASSWORD"),
+		Token:               first(values, "AI_OVER_EMAIL_JMAP_TOKEN", "AI_OVER_EMAIL_FASTMAIL_TOKEN"),
 		OpenAIAPIToken:      first(values, "AI_OVER_EMAIL_OPENAI_API_KEY"),
 		BraveSearchAPIToken: first(values, "AI_OVER_EMAIL_BRAVE_API_KEY"),
 		Mailbox:             first(values, "AI_OVER_EMAIL_MAILBOX"),
 		PublicEmail:         first(values, "AI_OVER_EMAIL_PUBLIC_EMAIL"),
 		PlaintextAllowlist:  splitList(first(values, "AI_OVER_EMAIL_PLAINTEXT_ALLOWLIST")),
-	}
-	if creds.Token == "" && looksLikeFastmailAPIToken(creds.Password) {
-		creds.Token = creds.Password
-		creds.Password = ""
+		IMAPUsername:        first(values, "AI_OVER_EMAIL_IMAP_USERNAME", "AI_OVER_EMAIL_USERNAME"),
+		IMAPPassword:        first(values, "AI_OVER_EMAIL_IMAP_PASSWORD"),
+		SMTPUsername:        first(values, "AI_OVER_EMAIL_SMTP_USERNAME"),
+		SMTPPassword:        first(values, "AI_OVER_EMAIL_SMTP_PASSWORD"),
+		OAuthRefreshToken:   first(values, "AI_OVER_EMAIL_JMAP_OAUTH_REFRESH_TOKEN"),
+		OAuthClientSecret:   first(values, "AI_OVER_EMAIL_JMAP_OAUTH_CLIENT_SECRET"),
 	}
 	if creds.Mailbox == "" {
 		creds.Mailbox = "inbox"
@@ -47,11 +61,25 @@ func LoadCredentials(envPath string) (Credentials, error) {
 	if creds.PublicEmail == "" {
 		creds.PublicEmail = creds.Username
 	}
-	if creds.Token == "" && creds.Password == "" {
-		return Credentials{}, errors.New("credentials must include AI_OVER_EMAIL_FASTMAIL_TOKEN or AI_OVER_EMAIL_FASTMAIL_PASSWORD")
+	if creds.SMTPUsername == "" && creds.SMTPPassword == "" {
+		creds.SMTPUsername = creds.IMAPUsername
+		creds.SMTPPassword = creds.IMAPPassword
+	}
+	switch transport {
+	case appconfig.TransportJMAP:
+	case appconfig.TransportIMAP:
+		if creds.IMAPUsername == "" || creds.IMAPPassword == "" {
+			return Credentials{}, errors.New("credentials must include AI_OVER_EMAIL_IMAP_USERNAME and AI_OVER_EMAIL_IMAP_PASSWORD for the imap transport")
+		}
+		return creds, nil
+	default:
+		return creds, nil
+	}
+	if creds.Token == "" && creds.Password == "" && creds.OAuthRefreshToken == "" {
+		return Credentials{}, errors.New("credentials must include AI_OVER_EMAIL_JMAP_TOKEN (or AI_OVER_EMAIL_FASTMAIL_TOKEN), AI_OVER_EMAIL_JMAP_PASSWORD, or AI_OVER_EMAIL_JMAP_OAUTH_REFRESH_TOKEN")
 	}
-	if creds.Token == "" && creds.Username == "" {
-		return Credentials{}, errors.New("credentials must include AI_OVER_EMAIL_USERNAME when using AI_OVER_EMAIL_FASTMAIL_PASSWORD")
+	if creds.Token == "" && creds.OAuthRefreshToken == "" && creds.Username == "" && !looksLikeFastmailAPIToken(creds.Password) {
+		return Credentials{}, errors.New("credentials must include AI_OVER_EMAIL_USERNAME when using a JMAP password")
 	}
 
 	return creds, nil
@@ -109,6 +137,8 @@ func splitList(value string) []string {
 	return result
 }
 
+// looksLikeFastmailAPIToken reports whether a password is really a Fastmail
+// API token. Only Fastmail endpoints treat it as one; see isFastmailEndpoint.
 func looksLikeFastmailAPIToken(value string) bool {
 	return strings.HasPrefix(value, "fmu"+"1-") || strings.HasPrefix(value, "fmu"+"2-")
 }
@@ -5,6 +5,8 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+
+	appconfig "ai-over-email/pkg/config"
 )
 
 func TestLoadCredentialsUsernamePasswordFromEnvFile(t *testing.T) {
@@ -108,6 +110,48 @@ func TestLoadCredentialsWithoutEnvFile(t *testing.T) {
 	}
 }
 
+func TestLoadCredentialsGenericJMAPNames(t *testing.T) {
+	clearCredentialEnv(t)
+	path := writeTempFile(t, "AI_OVER_EMAIL_JMAP_TOKEN=generic-token\nAI_OVER_EMAIL_FASTMAIL_TOKEN=fastmail-token\n")
+
+	creds, err := LoadCredentials(path)
+	if err != nil {
+		t.Fatalf("LoadCredentials returned error: %v", err)
+	}
+	if creds.Token != "generic-token" {
+		t.Fatalf("Token = %q, want the generic name to win", creds.Token)
+	}
+
+	creds, err = LoadCredentials(writeTempFile(t, "AI_OVER_EMAIL_JMAP_OAUTH_REFRESH_TOKEN=refresh\nAI_OVER_EMAIL_JMAP_OAUTH_CLIENT_SECRET=secret\n"))
+	if err != nil {
+		t.Fatalf("LoadCredentials with OAuth refresh token returned error: %v", err)
+	}
+	if creds.OAuthRefreshToken != "refresh" || creds.OAuthClientSecret != "secret" {
+		t.Fatalf("OAuth credentials = %q/%q", creds.OAuthRefreshToken, creds.OAuthClientSecret)
+	}
+}
+
+func TestLoadCredentialsIMAPTransport(t *testing.T) {
+	clearCredentialEnv(t)
+	username := testAddress("user", "mail.test")
+	path := writeTempFile(t, "AI_OVER_EMAIL_USERNAME="+username+"\nAI_OVER_EMAIL_IMAP_PASSWORD=imap-pass\n")
+
+	creds, err := loadCredentials(path, appconfig.TransportIMAP)
+	if err != nil {
+		t.Fatalf("loadCredentials returned error: %v", err)
+	}
+	if creds.IMAPUsername != username || creds.IMAPPassword != "imap-pass" {
+		t.Fatalf("IMAP credentials = %q/%q", creds.IMAPUsername, creds.IMAPPassword)
+	}
+	if creds.SMTPUsername != username || creds.SMTPPassword != "imap-pass" {
+		t.Fatalf("SMTP credentials = %q/%q, want IMAP credentials", creds.SMTPUsername, creds.SMTPPassword)
+	}
+
+	if _, err := loadCredentials(writeTempFile(t, "AI_OVER_EMAIL_USERNAME="+username+"\n"), appconfig.TransportIMAP); err == nil {
+		t.Fatal("loadCredentials accepted imap transport without a password")
+	}
+}
+
 func clearCredentialEnv(t *testing.T) {
 	t.Helper()
 
@@ -115,11 +159,19 @@ func clearCredentialEnv(t *testing.T) {
 		"AI_OVER_EMAIL_USERNAME",
 		"AI_OVER_EMAIL_FASTMAIL_PASSWORD",
 		"AI_OVER_EMAIL_FASTMAIL_TOKEN",
+		"AI_OVER_EMAIL_JMAP_PASSWORD",
+		"AI_OVER_EMAIL_JMAP_TOKEN",
+		"AI_OVER_EMAIL_JMAP_OAUTH_REFRESH_TOKEN",
+		"AI_OVER_EMAIL_JMAP_OAUTH_CLIENT_SECRET",
 		"AI_OVER_EMAIL_OPENAI_API_KEY",
 		"AI_OVER_EMAIL_BRAVE_API_KEY",
 		"AI_OVER_EMAIL_MAILBOX",
 		"AI_OVER_EMAIL_PUBLIC_EMAIL",
 		"AI_OVER_EMAIL_PLAINTEXT_ALLOWLIST",
+		"AI_OVER_EMAIL_IMAP_USERNAME",
+		"AI_OVER_EMAIL_IMAP_PASSWORD",
+		"AI_OVER_EMAIL_SMTP_USERNAME",
+		"AI_OVER_EMAIL_SMTP_PASSWORD",
 	} {
 		t.Setenv(key, "")
 	}
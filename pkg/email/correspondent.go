@@ -2,6 +2,7 @@ package email
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"database/sql"
 	"fmt"
@@ -24,39 +25,34 @@ type correspondentRegistration struct {
 	ZipPresent           bool
 	TimezonePresent      bool
 	ProfileRequestNeeded bool
+	ProfilePending       bool
+	Country              string
+	ZipCode              string
+	TimeZone             string
+	TimeZoneSource       string
 }
 
 type correspondentProfileUpdate struct {
+	Country  string
 	ZipCode  string
 	TimeZone string
 }
 
-type correspondentDailyUsage struct {
-	Email   string
-	Day     string
-	Count   int
-	Allowed bool
-}
-
-func (u correspondentDailyUsage) remaining() int {
-	remaining := dailyMessageLimit - u.Count
-	if remaining < 0 {
-		return 0
-	}
-	return remaining
-}
-
 var (
 	zipCodePattern  = regexp.MustCompile(`\b\d{5}(?:-\d{4})?\b`)
 	utcZonePattern  = regexp.MustCompile(`(?i)\b(?:UTC|GMT)\s*([+-])\s*(\d{1,2})(?::?(\d{2}))?\b`)
 	ianaZonePattern = regexp.MustCompile(`\b(?:Africa|America|Antarctica|Arctic|Asia|Atlantic|Australia|Europe|Indian|Pacific)/[A-Za-z0-9_+\-]+(?:/[A-Za-z0-9_+\-]+)?\b`)
 )
 
-func openCorrespondentStore(path string) (*correspondentStore, error) {
-	path = strings.TrimSpace(path)
-	if path == "" {
-		path = filepath.Join(".tmp", "correspondents.sqlite3")
+func correspondentDBPath(path string) string {
+	if path = strings.TrimSpace(path); path == "" {
+		return filepath.Join(".tmp", "correspondents.sqlite3")
 	}
+	return path
+}
+
+func openCorrespondentStore(path string) (*correspondentStore, error) {
+	path = correspondentDBPath(path)
 	if dir := filepath.Dir(path); dir != "." && dir != "" {
 		if err := os.MkdirAll(dir, 0o700); err != nil {
 			return nil, fmt.Errorf("create correspondent db dir: %w", err)
@@ -75,47 +71,8 @@ func openCorrespondentStore(path string) (*correspondentStore, error) {
 	return store, nil
 }
 
-func (s *correspondentStore) migrate(ctx context.Context) error {
-	statements := []string{
-		`PRAGMA busy_timeout = 5000`,
-		`PRAGMA journal_mode = WAL`,
-		`CREATE TABLE IF NOT EXISTS correspondents (
-			email TEXT PRIMARY KEY,
-			display_name TEXT NOT NULL DEFAULT '',
-			zip_code TEXT NOT NULL DEFAULT '',
-			time_zone TEXT NOT NULL DEFAULT '',
-			time_zone_source TEXT NOT NULL DEFAULT '',
-			profile_request_sent_at TEXT NOT NULL DEFAULT '',
-			first_seen_at TEXT NOT NULL,
-			last_seen_at TEXT NOT NULL,
-			updated_at TEXT NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS correspondent_daily_usage (
-			email TEXT NOT NULL,
-			day TEXT NOT NULL,
-			message_count INTEGER NOT NULL DEFAULT 0,
-			first_message_at TEXT NOT NULL,
-			last_message_at TEXT NOT NULL,
-			updated_at TEXT NOT NULL,
-			PRIMARY KEY (email, day)
-		)`,
-		`CREATE TABLE IF NOT EXISTS outbound_email_totals (
-			id INTEGER PRIMARY KEY CHECK (id = 1),
-			total_sent INTEGER NOT NULL DEFAULT 0,
-			updated_at TEXT NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS account_token_totals (
-			id INTEGER PRIMARY KEY CHECK (id = 1),
-			total_tokens INTEGER NOT NULL DEFAULT 0,
-			updated_at TEXT NOT NULL
-		)`,
-	}
-	for _, statement := range statements {
-		if _, err := s.db.ExecContext(ctx, statement); err != nil {
-			return fmt.Errorf("migrate correspondent db: %w", err)
-		}
-	}
-	return nil
+func (s *correspondentStore) Close() error {
+	return s.db.Close()
 }
 
 func (s *correspondentStore) NextOutboundEmailTotal(ctx context.Context) (int64, error) {
@@ -179,53 +136,17 @@ func (s *correspondentStore) RecordAccountTokenUsage(ctx context.Context, tokens
 	return total, nil
 }
 
-func (s *correspondentStore) CountInboundMessage(ctx context.Context, email string, limit int, now time.Time) (correspondentDailyUsage, error) {
-	email = strings.ToLower(strings.TrimSpace(email))
-	if email == "" {
-		return correspondentDailyUsage{}, fmt.Errorf("correspondent email is empty")
+func (s *correspondentStore) Totals(ctx context.Context) (int64, int64, error) {
+	var totalTokens, totalSent int64
+	err := s.db.QueryRowContext(ctx, `SELECT total_tokens FROM account_token_totals WHERE id = 1`).Scan(&totalTokens)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, 0, err
 	}
-	if limit < 1 {
-		return correspondentDailyUsage{}, fmt.Errorf("daily message limit must be positive")
+	err = s.db.QueryRowContext(ctx, `SELECT total_sent FROM outbound_email_totals WHERE id = 1`).Scan(&totalSent)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, 0, err
 	}
-	now = now.UTC()
-	day := now.Format("2006-01-02")
-	nowText := now.Format(time.RFC3339Nano)
-
-	tx, err := s.db.BeginTx(ctx, nil)
-	if err != nil {
-		return correspondentDailyUsage{}, err
-	}
-	defer tx.Rollback()
-
-	var count int
-	err = tx.QueryRowContext(ctx, `SELECT message_count FROM correspondent_daily_usage WHERE email = ? AND day = ?`, email, day).Scan(&count)
-	switch {
-	case err == sql.ErrNoRows:
-		count = 1
-		if _, err := tx.ExecContext(ctx, `INSERT INTO correspondent_daily_usage (
-			email, day, message_count, first_message_at, last_message_at, updated_at
-		) VALUES (?, ?, 1, ?, ?, ?)`, email, day, nowText, nowText, nowText); err != nil {
-			return correspondentDailyUsage{}, err
-		}
-	case err != nil:
-		return correspondentDailyUsage{}, err
-	default:
-		count++
-		if _, err := tx.ExecContext(ctx, `UPDATE correspondent_daily_usage
-			SET message_count = ?, last_message_at = ?, updated_at = ?
-			WHERE email = ? AND day = ?`, count, nowText, nowText, email, day); err != nil {
-			return correspondentDailyUsage{}, err
-		}
-	}
-	if err := tx.Commit(); err != nil {
-		return correspondentDailyUsage{}, err
-	}
-	return correspondentDailyUsage{
-		Email:   email,
-		Day:     day,
-		Count:   count,
-		Allowed: count <= limit,
-	}, nil
+	return totalTokens, totalSent, nil
 }
 
 func (s *correspondentStore) Register(ctx context.Context, email string, displayName string, derivedTimeZone string) (correspondentRegistration, error) {
@@ -245,12 +166,14 @@ func (s *correspondentStore) Register(ctx context.Context, email string, display
 
 	var existing struct {
 		DisplayName          string
+		Country              string
 		ZipCode              string
 		TimeZone             string
+		TimeZoneSource       string
 		ProfileRequestSentAt string
 	}
-	err = tx.QueryRowContext(ctx, `SELECT display_name, zip_code, time_zone, profile_request_sent_at FROM correspondents WHERE email = ?`, email).
-		Scan(&existing.DisplayName, &existing.ZipCode, &existing.TimeZone, &existing.ProfileRequestSentAt)
+	err = tx.QueryRowContext(ctx, `SELECT display_name, country, zip_code, time_zone, time_zone_source, profile_request_sent_at FROM correspondents WHERE email = ?`, email).
+		Scan(&existing.DisplayName, &existing.Country, &existing.ZipCode, &existing.TimeZone, &existing.TimeZoneSource, &existing.ProfileRequestSentAt)
 	if err == sql.ErrNoRows {
 		timeZoneSource := ""
 		if derivedTimeZone != "" {
@@ -269,6 +192,8 @@ func (s *correspondentStore) Register(ctx context.Context, email string, display
 			ZipPresent:           false,
 			TimezonePresent:      derivedTimeZone != "",
 			ProfileRequestNeeded: true,
+			TimeZone:             derivedTimeZone,
+			TimeZoneSource:       timeZoneSource,
 		}, nil
 	}
 	if err != nil {
@@ -280,18 +205,28 @@ func (s *correspondentStore) Register(ctx context.Context, email string, display
 		nextDisplayName = displayName
 	}
 	nextTimeZone := existing.TimeZone
-	nextTimeZoneSource := ""
-	if nextTimeZone == "" && derivedTimeZone != "" {
+	nextTimeZoneSource := existing.TimeZoneSource
+	if zone := postalTimeZone(existing.Country, existing.ZipCode); zone != "" && !explicitTimeZoneSource(nextTimeZoneSource) {
+		nextTimeZone = zone
+		nextTimeZoneSource = "zip_lookup"
+	} else if nextTimeZoneSource == "zip_lookup" && zone == "" {
+		// The ZIP no longer resolves to a zone, for example because its
+		// prefix crosses a zone line, so drop the earlier guess.
+		nextTimeZone, nextTimeZoneSource = derivedTimeZone, ""
+		if derivedTimeZone != "" {
+			nextTimeZoneSource = "email_header"
+		}
+	} else if nextTimeZone == "" && derivedTimeZone != "" {
 		nextTimeZone = derivedTimeZone
 		nextTimeZoneSource = "email_header"
 	}
 	if _, err := tx.ExecContext(ctx, `UPDATE correspondents
 		SET display_name = ?,
-			time_zone = CASE WHEN time_zone = '' THEN ? ELSE time_zone END,
-			time_zone_source = CASE WHEN time_zone_source = '' AND ? != '' THEN ? ELSE time_zone_source END,
+			time_zone = ?,
+			time_zone_source = ?,
 			last_seen_at = ?,
 			updated_at = ?
-		WHERE email = ?`, nextDisplayName, nextTimeZone, nextTimeZoneSource, nextTimeZoneSource, now, now, email); err != nil {
+		WHERE email = ?`, nextDisplayName, nextTimeZone, nextTimeZoneSource, now, now, email); err != nil {
 		return correspondentRegistration{}, err
 	}
 	if err := tx.Commit(); err != nil {
@@ -304,10 +239,19 @@ func (s *correspondentStore) Register(ctx context.Context, email string, display
 		New:                  false,
 		ZipPresent:           zipPresent,
 		TimezonePresent:      timezonePresent,
-		ProfileRequestNeeded: (!zipPresent || !timezonePresent) && strings.TrimSpace(existing.ProfileRequestSentAt) == "",
+		ProfileRequestNeeded: (!zipPresent || !timezonePresent || !explicitTimeZoneSource(nextTimeZoneSource)) && strings.TrimSpace(existing.ProfileRequestSentAt) == "",
+		ProfilePending:       (!zipPresent || !timezonePresent || !explicitTimeZoneSource(nextTimeZoneSource)) && strings.TrimSpace(existing.ProfileRequestSentAt) != "",
+		Country:              existing.Country,
+		ZipCode:              existing.ZipCode,
+		TimeZone:             nextTimeZone,
+		TimeZoneSource:       nextTimeZoneSource,
 	}, nil
 }
 
+func explicitTimeZoneSource(source string) bool {
+	return source == "email_body" || source == "admin"
+}
+
 func (s *correspondentStore) MarkProfileRequestSent(ctx context.Context, email string) error {
 	email = strings.ToLower(strings.TrimSpace(email))
 	if email == "" {
@@ -323,24 +267,100 @@ func (s *correspondentStore) MarkProfileRequestSent(ctx context.Context, email s
 
 func (s *correspondentStore) UpdateProfile(ctx context.Context, email string, update correspondentProfileUpdate) error {
 	email = strings.ToLower(strings.TrimSpace(email))
-	update.ZipCode = strings.TrimSpace(update.ZipCode)
-	update.TimeZone = strings.TrimSpace(update.TimeZone)
 	if email == "" {
 		return fmt.Errorf("correspondent email is empty")
 	}
-	if update.ZipCode == "" && update.TimeZone == "" {
+	update, country, err := s.resolveProfileUpdate(ctx, email, update, false)
+	if err != nil {
+		return err
+	}
+	if update == (correspondentProfileUpdate{}) {
 		return nil
 	}
 	now := time.Now().UTC().Format(time.RFC3339Nano)
-	_, err := s.db.ExecContext(ctx, `UPDATE correspondents
-		SET zip_code = CASE WHEN ? != '' THEN ? ELSE zip_code END,
-			time_zone = CASE WHEN ? != '' THEN ? ELSE time_zone END,
-			time_zone_source = CASE WHEN ? != '' THEN 'email_body' ELSE time_zone_source END,
+	_, err = s.db.ExecContext(ctx, `UPDATE correspondents
+		SET `+profileAssignments("email_body")+`,
 			updated_at = ?
-		WHERE email = ?`, update.ZipCode, update.ZipCode, update.TimeZone, update.TimeZone, update.TimeZone, now, email)
+		WHERE email = ?`, update.profileArgs(country, now, email)...)
 	return err
 }
 
+// resolveProfileUpdate normalizes the country, validates the postal code
+// against it (the stored country when the update has none) and normalizes the
+// time zone. Invalid fields are errors when strict and are dropped otherwise.
+// It also returns the country the postal code belongs to.
+func (s *correspondentStore) resolveProfileUpdate(ctx context.Context, email string, update correspondentProfileUpdate, strict bool) (correspondentProfileUpdate, string, error) {
+	update.Country = strings.TrimSpace(update.Country)
+	update.ZipCode = strings.TrimSpace(update.ZipCode)
+	update.TimeZone = strings.TrimSpace(update.TimeZone)
+	if update.Country != "" {
+		country, ok := normalizeCountry(update.Country)
+		if !ok && strict {
+			return correspondentProfileUpdate{}, "", fmt.Errorf("invalid country %q: use a two-letter code such as US, CA or GB", update.Country)
+		}
+		if !ok {
+			country = ""
+		}
+		update.Country = country
+	}
+	country := update.Country
+	if update.ZipCode != "" {
+		if country == "" {
+			err := s.db.QueryRowContext(ctx, `SELECT country FROM correspondents WHERE email = ?`, email).Scan(&country)
+			if err != nil && err != sql.ErrNoRows {
+				return correspondentProfileUpdate{}, "", err
+			}
+		}
+		code, ok := normalizePostalCode(country, update.ZipCode)
+		if !ok && strict {
+			return correspondentProfileUpdate{}, "", fmt.Errorf("invalid postal code %q for %s", update.ZipCode, countryName(cmp.Or(country, "US")))
+		}
+		if !ok {
+			code = ""
+		}
+		update.ZipCode = code
+	}
+	if update.TimeZone != "" {
+		zone, ok := normalizeTimeZone(update.TimeZone)
+		if !ok && strict {
+			return correspondentProfileUpdate{}, "", fmt.Errorf("invalid time zone %q: use an IANA zone such as America/New_York or an offset such as UTC-05:00", update.TimeZone)
+		}
+		update.TimeZone = zone
+	}
+	return update, country, nil
+}
+
+// An explicit zone always wins. A US ZIP without one fills the zone from the
+// offline lookup unless the correspondent or an admin already set it. A new
+// country without a postal code clears the old one.
+func profileAssignments(source string) string {
+	return `country = CASE WHEN ? != '' THEN ? ELSE country END,
+			zip_code = CASE WHEN ? != '' THEN ? WHEN ? != '' AND ? != country THEN '' ELSE zip_code END,
+			time_zone = CASE WHEN ? != '' THEN ? WHEN ? != '' AND time_zone_source NOT IN ('email_body', 'admin') THEN ? ELSE time_zone END,
+			time_zone_source = CASE WHEN ? != '' THEN '` + source + `' WHEN ? != '' AND time_zone_source NOT IN ('email_body', 'admin') THEN 'zip_lookup' ELSE time_zone_source END`
+}
+
+func (u correspondentProfileUpdate) profileArgs(country string, now string, email string) []any {
+	lookupZone := ""
+	if u.TimeZone == "" {
+		lookupZone = postalTimeZone(country, u.ZipCode)
+	}
+	return []any{
+		u.Country, u.Country,
+		u.ZipCode, u.ZipCode, u.Country, u.Country,
+		u.TimeZone, u.TimeZone, lookupZone, lookupZone,
+		u.TimeZone, lookupZone,
+		now, email,
+	}
+}
+
+func postalTimeZone(country string, code string) string {
+	if country != "" && country != "US" {
+		return ""
+	}
+	return zipTimeZone(code)
+}
+
 func extractCorrespondentProfileUpdate(text string) correspondentProfileUpdate {
 	text = strings.TrimSpace(text)
 	if text == "" {
@@ -0,0 +1,365 @@
+package email
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const defaultCorrespondentListLimit = 100
+
+type CorrespondentStore = correspondentStore
+
+type CorrespondentProfileUpdate = correspondentProfileUpdate
+
+type Correspondent struct {
+	Email                string `json:"email"`
+	DisplayName          string `json:"displayName,omitempty"`
+	Country              string `json:"country,omitempty"`
+	ZipCode              string `json:"zipCode,omitempty"`
+	TimeZone             string `json:"timeZone,omitempty"`
+	TimeZoneSource       string `json:"timeZoneSource,omitempty"`
+	ProfileRequestSentAt string `json:"profileRequestSentAt,omitempty"`
+	FirstSeenAt          string `json:"firstSeenAt"`
+	LastSeenAt           string `json:"lastSeenAt"`
+	UpdatedAt            string `json:"updatedAt"`
+	Blocked              bool   `json:"blocked"`
+	BlockedAt            string `json:"blockedAt,omitempty"`
+	BlockedReason        string `json:"blockedReason,omitempty"`
+	SenderContext        bool   `json:"senderContext"`
+	Archive              bool   `json:"archive"`
+	ArchiveRetentionDays int    `json:"archiveRetentionDays,omitempty"`
+	MessagesToday        int    `json:"messagesToday"`
+}
+
+type CorrespondentDetail struct {
+	Correspondent
+	RecentUsage []CorrespondentDayUsage `json:"recentUsage"`
+	Notes       []CorrespondentNote     `json:"notes"`
+}
+
+type CorrespondentDayUsage struct {
+	Day            string `json:"day"`
+	MessageCount   int    `json:"messageCount"`
+	FirstMessageAt string `json:"firstMessageAt"`
+	LastMessageAt  string `json:"lastMessageAt"`
+}
+
+type CorrespondentListOptions struct {
+	Query       string
+	BlockedOnly bool
+	Limit       int
+}
+
+type UsageReport struct {
+	Since           string             `json:"since"`
+	Until           string             `json:"until"`
+	Days            []UsageReportDay   `json:"days"`
+	TopSenders      []UsageReportEntry `json:"topSenders"`
+	Correspondents  int                `json:"correspondents"`
+	Blocked         int                `json:"blocked"`
+	TotalTokensEver int64              `json:"totalTokensEver"`
+	TotalEmailsEver int64              `json:"totalEmailsEver"`
+}
+
+type UsageReportDay struct {
+	Day      string `json:"day"`
+	Messages int    `json:"messages"`
+	Senders  int    `json:"senders"`
+	Limited  int    `json:"limited"`
+}
+
+type UsageReportEntry struct {
+	Email    string `json:"email"`
+	Messages int    `json:"messages"`
+}
+
+func OpenCorrespondentStore(path string) (*CorrespondentStore, error) {
+	return openCorrespondentStore(path)
+}
+
+// likeEscaper escapes LIKE wildcards so a search for "a_b" or "100%" matches
+// those characters literally; queries using it declare ESCAPE '\'.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (s *correspondentStore) ListCorrespondents(ctx context.Context, opts CorrespondentListOptions) ([]Correspondent, error) {
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultCorrespondentListLimit
+	}
+	query := `SELECT ` + correspondentColumns + ` FROM correspondents c
+		LEFT JOIN correspondent_daily_usage u ON u.email = c.email AND u.day = ?
+		WHERE 1 = 1`
+	args := []any{time.Now().UTC().Format("2006-01-02")}
+	if text := strings.ToLower(strings.TrimSpace(opts.Query)); text != "" {
+		query += ` AND (c.email LIKE ? ESCAPE '\' OR lower(c.display_name) LIKE ? ESCAPE '\')`
+		pattern := "%" + likeEscaper.Replace(text) + "%"
+		args = append(args, pattern, pattern)
+	}
+	if opts.BlockedOnly {
+		query += ` AND c.blocked_at != ''`
+	}
+	query += ` ORDER BY c.last_seen_at DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []Correspondent
+	for rows.Next() {
+		correspondent, err := scanCorrespondent(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, correspondent)
+	}
+	return result, rows.Err()
+}
+
+func (s *correspondentStore) GetCorrespondent(ctx context.Context, email string) (CorrespondentDetail, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return CorrespondentDetail{}, fmt.Errorf("correspondent email is empty")
+	}
+	row := s.db.QueryRowContext(ctx, `SELECT `+correspondentColumns+` FROM correspondents c
+		LEFT JOIN correspondent_daily_usage u ON u.email = c.email AND u.day = ?
+		WHERE c.email = ?`, time.Now().UTC().Format("2006-01-02"), email)
+	correspondent, err := scanCorrespondent(row)
+	if err == sql.ErrNoRows {
+		return CorrespondentDetail{}, fmt.Errorf("correspondent %s not found", email)
+	}
+	if err != nil {
+		return CorrespondentDetail{}, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT day, message_count, first_message_at, last_message_at
+		FROM correspondent_daily_usage
+		WHERE email = ?
+		ORDER BY day DESC
+		LIMIT 30`, email)
+	if err != nil {
+		return CorrespondentDetail{}, err
+	}
+	defer rows.Close()
+	detail := CorrespondentDetail{Correspondent: correspondent, RecentUsage: []CorrespondentDayUsage{}}
+	for rows.Next() {
+		var usage CorrespondentDayUsage
+		if err := rows.Scan(&usage.Day, &usage.MessageCount, &usage.FirstMessageAt, &usage.LastMessageAt); err != nil {
+			return CorrespondentDetail{}, err
+		}
+		detail.RecentUsage = append(detail.RecentUsage, usage)
+	}
+	if err := rows.Err(); err != nil {
+		return CorrespondentDetail{}, err
+	}
+	rows.Close()
+	if detail.Notes, err = s.Notes(ctx, email); err != nil {
+		return CorrespondentDetail{}, err
+	}
+	return detail, nil
+}
+
+func (s *correspondentStore) UsageReport(ctx context.Context, days int, now time.Time) (UsageReport, error) {
+	if days <= 0 {
+		days = 7
+	}
+	now = now.UTC()
+	report := UsageReport{
+		Since:      now.AddDate(0, 0, -(days - 1)).Format("2006-01-02"),
+		Until:      now.Format("2006-01-02"),
+		Days:       []UsageReportDay{},
+		TopSenders: []UsageReportEntry{},
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT day, SUM(message_count), COUNT(*), SUM(CASE WHEN limited_count > 0 THEN 1 ELSE 0 END)
+		FROM correspondent_daily_usage
+		WHERE day >= ? AND day <= ?
+		GROUP BY day
+		ORDER BY day`, report.Since, report.Until)
+	if err != nil {
+		return UsageReport{}, err
+	}
+	for rows.Next() {
+		var day UsageReportDay
+		if err := rows.Scan(&day.Day, &day.Messages, &day.Senders, &day.Limited); err != nil {
+			rows.Close()
+			return UsageReport{}, err
+		}
+		report.Days = append(report.Days, day)
+	}
+	if err := rows.Close(); err != nil {
+		return UsageReport{}, err
+	}
+
+	rows, err = s.db.QueryContext(ctx, `SELECT email, SUM(message_count) AS total
+		FROM correspondent_daily_usage
+		WHERE day >= ? AND day <= ?
+		GROUP BY email
+		ORDER BY total DESC, email
+		LIMIT 10`, report.Since, report.Until)
+	if err != nil {
+		return UsageReport{}, err
+	}
+	for rows.Next() {
+		var entry UsageReportEntry
+		if err := rows.Scan(&entry.Email, &entry.Messages); err != nil {
+			rows.Close()
+			return UsageReport{}, err
+		}
+		report.TopSenders = append(report.TopSenders, entry)
+	}
+	if err := rows.Close(); err != nil {
+		return UsageReport{}, err
+	}
+
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(SUM(CASE WHEN blocked_at != '' THEN 1 ELSE 0 END), 0) FROM correspondents`).Scan(&report.Correspondents, &report.Blocked); err != nil {
+		return UsageReport{}, err
+	}
+	report.TotalTokensEver, report.TotalEmailsEver, err = s.Totals(ctx)
+	if err != nil {
+		return UsageReport{}, err
+	}
+	return report, nil
+}
+
+func (s *correspondentStore) SetProfile(ctx context.Context, email string, update correspondentProfileUpdate) error {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return fmt.Errorf("correspondent email is empty")
+	}
+	update, country, err := s.resolveProfileUpdate(ctx, email, update, true)
+	if err != nil {
+		return err
+	}
+	if update == (correspondentProfileUpdate{}) {
+		return fmt.Errorf("profile update must include a country, postal code or time zone")
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	result, err := s.db.ExecContext(ctx, `UPDATE correspondents
+		SET `+profileAssignments("admin")+`,
+			updated_at = ?
+		WHERE email = ?`, update.profileArgs(country, now, email)...)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result, email)
+}
+
+func (s *correspondentStore) ResetDailyUsage(ctx context.Context, email string, day string) (int64, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	day = strings.TrimSpace(day)
+	if email == "" {
+		return 0, fmt.Errorf("correspondent email is empty")
+	}
+	if day == "" {
+		day = time.Now().UTC().Format("2006-01-02")
+	}
+	if _, err := time.Parse("2006-01-02", day); err != nil {
+		return 0, fmt.Errorf("invalid day %q: use YYYY-MM-DD", day)
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	result, err := tx.ExecContext(ctx, `DELETE FROM correspondent_daily_usage WHERE email = ? AND day = ?`, email, day)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM inbound_messages WHERE email = ? AND substr(received_at, 1, 10) = ?`, email, day); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM rate_limit_state WHERE email = ?`, email); err != nil {
+		return 0, err
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return removed, tx.Commit()
+}
+
+func (s *correspondentStore) BlockSender(ctx context.Context, email string, blocked bool, reason string) error {
+	email = strings.ToLower(strings.TrimSpace(email))
+	reason = strings.TrimSpace(reason)
+	if email == "" {
+		return fmt.Errorf("correspondent email is empty")
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	if !blocked {
+		result, err := s.db.ExecContext(ctx, `UPDATE correspondents
+			SET blocked_at = '', blocked_reason = '', updated_at = ?
+			WHERE email = ?`, now, email)
+		if err != nil {
+			return err
+		}
+		return requireAffected(result, email)
+	}
+	_, err := s.db.ExecContext(ctx, `INSERT INTO correspondents (
+			email, first_seen_at, last_seen_at, updated_at, blocked_at, blocked_reason
+		) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(email) DO UPDATE SET
+			blocked_at = excluded.blocked_at,
+			blocked_reason = excluded.blocked_reason,
+			updated_at = excluded.updated_at`, email, now, now, now, now, reason)
+	return err
+}
+
+func (s *correspondentStore) IsBlocked(ctx context.Context, email string) (bool, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	var blockedAt string
+	err := s.db.QueryRowContext(ctx, `SELECT blocked_at FROM correspondents WHERE email = ?`, email).Scan(&blockedAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return blockedAt != "", nil
+}
+
+const correspondentColumns = `c.email, c.display_name, c.country, c.zip_code, c.time_zone, c.time_zone_source, c.profile_request_sent_at,
+	c.first_seen_at, c.last_seen_at, c.updated_at, c.blocked_at, c.blocked_reason, c.sender_context, c.archive, c.archive_retention_days, COALESCE(u.message_count, 0)`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanCorrespondent(row rowScanner) (Correspondent, error) {
+	var c Correspondent
+	if err := row.Scan(&c.Email, &c.DisplayName, &c.Country, &c.ZipCode, &c.TimeZone, &c.TimeZoneSource, &c.ProfileRequestSentAt,
+		&c.FirstSeenAt, &c.LastSeenAt, &c.UpdatedAt, &c.BlockedAt, &c.BlockedReason, &c.SenderContext, &c.Archive, &c.ArchiveRetentionDays, &c.MessagesToday); err != nil {
+		return Correspondent{}, err
+	}
+	c.Blocked = c.BlockedAt != ""
+	return c, nil
+}
+
+func requireAffected(result sql.Result, email string) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("correspondent %s not found", email)
+	}
+	return nil
+}
+
+func normalizeTimeZone(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if match := utcZonePattern.FindString(value); match != "" && match == value {
+		return extractCorrespondentProfileUpdate(value).TimeZone, true
+	}
+	if !strings.Contains(value, "/") {
+		return "", false
+	}
+	if _, err := time.LoadLocation(value); err != nil {
+		return "", false
+	}
+	return value, true
+}
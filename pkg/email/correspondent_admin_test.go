@@ -0,0 +1,134 @@
+package email
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appconfig "ai-over-email/pkg/config"
+)
+
+func TestCorrespondentStoreSetProfile(t *testing.T) {
+	ctx := context.Background()
+	store := openTestCorrespondentStore(t)
+	email := testAddress("sender", "mail.test")
+
+	if err := store.SetProfile(ctx, email, CorrespondentProfileUpdate{ZipCode: "10001"}); err == nil {
+		t.Fatalf("SetProfile on unknown sender returned nil error")
+	}
+	if _, err := store.Register(ctx, email, "Sender", ""); err != nil {
+		t.Fatalf("Register returned error: %v", err)
+	}
+	if err := store.SetProfile(ctx, email, CorrespondentProfileUpdate{TimeZone: "Mars/Olympus"}); err == nil {
+		t.Fatalf("SetProfile with invalid zone returned nil error")
+	}
+	if err := store.SetProfile(ctx, email, CorrespondentProfileUpdate{ZipCode: "1000"}); err == nil {
+		t.Fatalf("SetProfile with invalid ZIP returned nil error")
+	}
+	if err := store.SetProfile(ctx, email, CorrespondentProfileUpdate{ZipCode: "10001", TimeZone: "America/New_York"}); err != nil {
+		t.Fatalf("SetProfile returned error: %v", err)
+	}
+
+	detail, err := store.GetCorrespondent(ctx, email)
+	if err != nil {
+		t.Fatalf("GetCorrespondent returned error: %v", err)
+	}
+	if detail.ZipCode != "10001" || detail.TimeZone != "America/New_York" || detail.TimeZoneSource != "admin" {
+		t.Fatalf("profile = %#v", detail.Correspondent)
+	}
+}
+
+func TestCorrespondentStoreResetDailyUsage(t *testing.T) {
+	ctx := context.Background()
+	store := openTestCorrespondentStore(t)
+	email := testAddress("sender", "mail.test")
+	now := time.Now().UTC()
+
+	for i := 0; i < 3; i++ {
+		if _, err := store.CountInboundMessage(ctx, email, appconfig.RateLimit{Messages: 2}, false, now); err != nil {
+			t.Fatalf("CountInboundMessage returned error: %v", err)
+		}
+	}
+	if _, err := store.ResetDailyUsage(ctx, email, "07/04/2026"); err == nil {
+		t.Fatalf("ResetDailyUsage with invalid day returned nil error")
+	}
+	removed, err := store.ResetDailyUsage(ctx, email, "")
+	if err != nil {
+		t.Fatalf("ResetDailyUsage returned error: %v", err)
+	}
+	if removed != 1 {
+		t.Fatalf("rows removed = %d, want 1", removed)
+	}
+	usage, err := store.PeekInboundMessage(ctx, email, appconfig.RateLimit{Messages: 2}, false, now)
+	if err != nil {
+		t.Fatalf("PeekInboundMessage returned error: %v", err)
+	}
+	if !usage.Allowed || usage.Count != 1 {
+		t.Fatalf("usage after reset = %#v, want allowed count 1", usage)
+	}
+}
+
+func TestCorrespondentStoreBlockSender(t *testing.T) {
+	ctx := context.Background()
+	store := openTestCorrespondentStore(t)
+	blocked := testAddress("spammer", "mail.test")
+	other := testAddress("friend", "mail.test")
+
+	if _, err := store.Register(ctx, other, "Friend", ""); err != nil {
+		t.Fatalf("Register returned error: %v", err)
+	}
+	if err := store.BlockSender(ctx, blocked, true, "abuse"); err != nil {
+		t.Fatalf("BlockSender returned error: %v", err)
+	}
+	isBlocked, err := store.IsBlocked(ctx, blocked)
+	if err != nil {
+		t.Fatalf("IsBlocked returned error: %v", err)
+	}
+	if !isBlocked {
+		t.Fatalf("IsBlocked = false, want true")
+	}
+
+	list, err := store.ListCorrespondents(ctx, CorrespondentListOptions{BlockedOnly: true})
+	if err != nil {
+		t.Fatalf("ListCorrespondents returned error: %v", err)
+	}
+	if len(list) != 1 || list[0].Email != blocked || list[0].BlockedReason != "abuse" {
+		t.Fatalf("blocked list = %#v", list)
+	}
+	list, err = store.ListCorrespondents(ctx, CorrespondentListOptions{Query: "FRIEND"})
+	if err != nil {
+		t.Fatalf("ListCorrespondents query returned error: %v", err)
+	}
+	if len(list) != 1 || list[0].Email != other {
+		t.Fatalf("query list = %#v", list)
+	}
+	underscore := testAddress("first_last", "mail.test")
+	for _, address := range []string{underscore, testAddress("firstxlast", "mail.test")} {
+		if _, err := store.Register(ctx, address, "", ""); err != nil {
+			t.Fatalf("Register returned error: %v", err)
+		}
+	}
+	if _, err := store.Register(ctx, testAddress("percent", "mail.test"), `100% \ Sure`, ""); err != nil {
+		t.Fatalf("Register returned error: %v", err)
+	}
+	for query, want := range map[string]string{"t_l": underscore, "100%": testAddress("percent", "mail.test"), `% \ s`: testAddress("percent", "mail.test")} {
+		list, err = store.ListCorrespondents(ctx, CorrespondentListOptions{Query: query})
+		if err != nil {
+			t.Fatalf("ListCorrespondents(%q) returned error: %v", query, err)
+		}
+		if len(list) != 1 || list[0].Email != want {
+			t.Fatalf("ListCorrespondents(%q) = %#v, want only %s", query, list, want)
+		}
+	}
+
+	if err := store.BlockSender(ctx, blocked, false, ""); err != nil {
+		t.Fatalf("unblock returned error: %v", err)
+	}
+	isBlocked, err = store.IsBlocked(ctx, blocked)
+	if err != nil {
+		t.Fatalf("IsBlocked after unblock returned error: %v", err)
+	}
+	if isBlocked {
+		t.Fatalf("IsBlocked after unblock = true, want false")
+	}
+}
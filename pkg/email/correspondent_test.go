@@ -4,8 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	appconfig "ai-over-email/pkg/config"
 )
 
 func TestCorrespondentStoreRegistersNewSenderAndMarksProfileRequest(t *testing.T) {
@@ -94,10 +97,10 @@ func TestCorrespondentStoreCountsDailyUsage(t *testing.T) {
 	email := testAddress("sender", "mail.test")
 	now := time.Date(2026, 7, 4, 3, 0, 0, 0, time.UTC)
 
-	var usage correspondentDailyUsage
+	var usage inboundUsage
 	for i := 1; i <= 10; i++ {
 		var err error
-		usage, err = store.CountInboundMessage(ctx, email, 10, now)
+		usage, err = store.CountInboundMessage(ctx, email, appconfig.RateLimit{Messages: 10}, false, now)
 		if err != nil {
 			t.Fatalf("CountInboundMessage %d returned error: %v", i, err)
 		}
@@ -109,7 +112,7 @@ func TestCorrespondentStoreCountsDailyUsage(t *testing.T) {
 		}
 	}
 
-	usage, err := store.CountInboundMessage(ctx, email, 10, now)
+	usage, err := store.CountInboundMessage(ctx, email, appconfig.RateLimit{Messages: 10}, false, now)
 	if err != nil {
 		t.Fatalf("CountInboundMessage 11 returned error: %v", err)
 	}
@@ -121,7 +124,7 @@ func TestCorrespondentStoreCountsDailyUsage(t *testing.T) {
 	}
 
 	nextDay := now.Add(24 * time.Hour)
-	usage, err = store.CountInboundMessage(ctx, email, 10, nextDay)
+	usage, err = store.CountInboundMessage(ctx, email, appconfig.RateLimit{Messages: 10}, false, nextDay)
 	if err != nil {
 		t.Fatalf("next-day CountInboundMessage returned error: %v", err)
 	}
@@ -130,6 +133,66 @@ func TestCorrespondentStoreCountsDailyUsage(t *testing.T) {
 	}
 }
 
+func TestCorrespondentStorePeekDoesNotCountMessages(t *testing.T) {
+	ctx := context.Background()
+	store := openTestCorrespondentStore(t)
+	email := testAddress("sender", "mail.test")
+	now := time.Date(2026, 7, 4, 3, 0, 0, 0, time.UTC)
+
+	if _, err := store.CountInboundMessage(ctx, email, appconfig.RateLimit{Messages: 2}, false, now); err != nil {
+		t.Fatalf("CountInboundMessage returned error: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		usage, err := store.PeekInboundMessage(ctx, email, appconfig.RateLimit{Messages: 2}, false, now)
+		if err != nil {
+			t.Fatalf("PeekInboundMessage returned error: %v", err)
+		}
+		if usage.Count != 2 || !usage.Allowed {
+			t.Fatalf("peek usage = %#v, want allowed count 2", usage)
+		}
+	}
+	usage, err := store.CountInboundMessage(ctx, email, appconfig.RateLimit{Messages: 2}, false, now)
+	if err != nil {
+		t.Fatalf("second CountInboundMessage returned error: %v", err)
+	}
+	if usage.Count != 2 {
+		t.Fatalf("count after peeks = %d, want 2", usage.Count)
+	}
+	usage, err = store.PeekInboundMessage(ctx, email, appconfig.RateLimit{Messages: 2}, false, now)
+	if err != nil {
+		t.Fatalf("PeekInboundMessage at limit returned error: %v", err)
+	}
+	if usage.Allowed {
+		t.Fatalf("peek at limit allowed, want blocked: %#v", usage)
+	}
+}
+
+func TestCorrespondentStoreTotals(t *testing.T) {
+	ctx := context.Background()
+	store := openTestCorrespondentStore(t)
+
+	tokens, sent, err := store.Totals(ctx)
+	if err != nil {
+		t.Fatalf("Totals on empty db returned error: %v", err)
+	}
+	if tokens != 0 || sent != 0 {
+		t.Fatalf("empty totals = %d, %d; want 0, 0", tokens, sent)
+	}
+	if _, err := store.RecordAccountTokenUsage(ctx, 42); err != nil {
+		t.Fatalf("RecordAccountTokenUsage returned error: %v", err)
+	}
+	if _, err := store.NextOutboundEmailTotal(ctx); err != nil {
+		t.Fatalf("NextOutboundEmailTotal returned error: %v", err)
+	}
+	tokens, sent, err = store.Totals(ctx)
+	if err != nil {
+		t.Fatalf("Totals returned error: %v", err)
+	}
+	if tokens != 42 || sent != 1 {
+		t.Fatalf("totals = %d, %d; want 42, 1", tokens, sent)
+	}
+}
+
 func TestCorrespondentStoreCountsOutboundEmails(t *testing.T) {
 	ctx := context.Background()
 	store := openTestCorrespondentStore(t)
@@ -207,3 +270,52 @@ func openTestCorrespondentStore(t *testing.T) *correspondentStore {
 	})
 	return store
 }
+
+func TestCorrespondentStoreSenderContext(t *testing.T) {
+	ctx := context.Background()
+	store := openTestCorrespondentStore(t)
+	email := testAddress("sender", "mail.test")
+	if _, err := store.Register(ctx, email, "Ada Sender", "UTC-05:00"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetProfile(ctx, email, correspondentProfileUpdate{ZipCode: "80202", TimeZone: "America/Denver"}); err != nil {
+		t.Fatal(err)
+	}
+
+	sender, ok, err := store.SenderContext(ctx, email)
+	if err != nil || !ok {
+		t.Fatalf("SenderContext = %+v, %t, %v", sender, ok, err)
+	}
+	prompt := sender.prompt(time.Date(2026, time.January, 15, 3, 30, 0, 0, time.UTC))
+	for _, want := range []string{
+		"- Name: Ada Sender",
+		"- Local date and time: Wednesday, 14 January 2026, 20:30 (America/Denver, UTC-07:00)",
+		"- Location: US ZIP code 80202, approximately the Denver, CO area (ZIP prefix center near 39.74, -104.99)",
+	} {
+		if !strings.Contains(prompt, want) {
+			t.Fatalf("prompt missing %q:\n%s", want, prompt)
+		}
+	}
+
+	if err := store.SetSenderContext(ctx, email, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := store.SenderContext(ctx, email); err != nil || ok {
+		t.Fatalf("SenderContext after disabling = %t, %v", ok, err)
+	}
+	if err := store.SetSenderContext(ctx, testAddress("missing", "mail.test"), true); err == nil {
+		t.Fatal("SetSenderContext accepted an unknown correspondent")
+	}
+}
+
+func TestSenderContextPromptOffsetAndUnknownZone(t *testing.T) {
+	now := time.Date(2026, time.July, 1, 23, 15, 0, 0, time.UTC)
+	prompt := senderContext{TimeZone: "UTC+05:30"}.prompt(now)
+	if !strings.Contains(prompt, "- Local date and time: Thursday, 2 July 2026, 04:45 (UTC+05:30)") || strings.Contains(prompt, "Name:") || strings.Contains(prompt, "Location:") {
+		t.Fatalf("offset prompt:\n%s", prompt)
+	}
+	prompt = senderContext{TimeZone: "Mars/Olympus"}.prompt(now)
+	if !strings.Contains(prompt, "current UTC time is Wednesday, 1 July 2026, 23:15") {
+		t.Fatalf("unknown zone prompt:\n%s", prompt)
+	}
+}
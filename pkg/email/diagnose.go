@@ -0,0 +1,375 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	netsmtp "net/smtp"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	appconfig "ai-over-email/pkg/config"
+	"ai-over-email/pkg/diag"
+)
+
+var correspondentSchema = map[string][]string{
+	"correspondents":            {"email", "display_name", "zip_code", "time_zone", "time_zone_source", "profile_request_sent_at", "first_seen_at", "last_seen_at", "updated_at", "blocked_at", "blocked_reason", "sender_context", "country", "archive", "archive_retention_days"},
+	"correspondent_daily_usage": {"email", "day", "message_count", "first_message_at", "last_message_at", "updated_at", "limited_count"},
+	"inbound_messages":          {"id", "email", "received_at"},
+	"rate_limit_state":          {"email", "bucket_tokens", "bucket_updated_at", "notice_sent_at", "updated_at"},
+	"sender_policies":           {"pattern", "pgp", "model_tier", "daily_limit", "blocked", "notes", "created_at", "updated_at"},
+	"outbound_email_totals":     {"id", "total_sent", "updated_at"},
+	"account_token_totals":      {"id", "total_tokens", "updated_at"},
+	"schema_migrations":         {"version", "name", "checksum", "applied_at"},
+	"correspondent_notes":       {"email", "position", "note", "created_at", "updated_at"},
+	"message_usage":             {"id", "email", "message_id", "kind", "model", "input_tokens", "cached_input_tokens", "output_tokens", "reasoning_tokens", "total_tokens", "tool_calls", "latency_ms", "cost_usd", "priced", "created_at", "tier"},
+	"archive_messages":          {"id", "email", "message_id", "subject", "inbound_body", "reply_body", "encrypted", "model", "tools_used", "input_tokens", "output_tokens", "total_tokens", "received_at", "archived_at"},
+}
+
+func Diagnose(ctx context.Context, config Config) diag.Report {
+	config = normalizeConfig(config)
+	var report diag.Report
+
+	appConfig, err := appconfig.Load(config.ConfigPath)
+	if err != nil {
+		report = append(report, diag.Failed("config", err, "copy config.example.json to "+config.ConfigPath+" and fix the reported field"))
+	} else {
+		report = append(report, diag.Passed("config", "%s", config.ConfigPath))
+	}
+
+	transport := appconfig.TransportJMAP
+	if err == nil {
+		transport = appConfig.TransportType()
+	}
+	creds, credsErr := loadCredentials(config.EnvPath, transport)
+	if credsErr == nil {
+		creds = transportCredentials(creds, appConfig)
+	}
+	if credsErr != nil {
+		report = append(report, diag.Failed("email credentials", credsErr, "set the variables from .env.example in "+config.EnvPath+" or the process environment"))
+	} else {
+		report = append(report, diag.Passed("email credentials", "username=%s mailbox=%s token_present=%t", creds.Username, creds.Mailbox, creds.Token != ""))
+	}
+	if credsErr == nil && creds.OpenAIAPIToken == "" {
+		report = append(report, diag.Warned("openai credentials", "AI_OVER_EMAIL_OPENAI_API_KEY is not set", "the watcher cannot draft replies without an OpenAI API key"))
+	}
+
+	switch {
+	case transport == appconfig.TransportMaildir:
+		report = append(report, diagnoseMaildir(appConfig.Transport.Maildir.Normalized())...)
+	case err != nil || credsErr != nil:
+		report = append(report, diag.Skipped(transport, "needs a valid config and credentials"))
+	case transport == appconfig.TransportIMAP:
+		report = append(report, diagnoseIMAP(appConfig.Transport.IMAP.Normalized(), appConfig.Transport.SMTP.Normalized(), creds)...)
+	default:
+		report = append(report, diagnoseJMAP(ctx, appConfig, creds)...)
+	}
+
+	if credsErr != nil {
+		report = append(report, diagnoseGPG(ctx, "")...)
+	} else {
+		report = append(report, diagnoseGPG(ctx, creds.PublicEmail)...)
+	}
+	report = append(report, diagnoseDatabase(ctx, config.DatabasePath))
+	return report
+}
+
+func diagnoseJMAP(ctx context.Context, appConfig appconfig.ConfigStruct, creds Credentials) diag.Report {
+	var report diag.Report
+	client := newJMAPClient(creds, io.Discard)
+	if err := client.FetchSession(ctx, appConfig); err != nil {
+		return append(report, diag.Failed("jmap session", err, "create a JMAP API token with mail and submission scopes (Fastmail: Settings > Privacy & Security) and set AI_OVER_EMAIL_JMAP_TOKEN, or configure jmap.oauth with AI_OVER_EMAIL_JMAP_OAUTH_REFRESH_TOKEN"))
+	}
+	report = append(report, diag.Passed("jmap session", "%s auth=%s max_calls=%d max_get=%d", client.endpoint, client.auth.String(), client.limits.MaxCallsInRequest, client.limits.MaxObjectsInGet))
+
+	accountID, err := client.AccountID()
+	if err != nil {
+		return append(report, diag.Failed("jmap account", err, "the token must grant access to a mail account"))
+	}
+	report = append(report, diag.Passed("jmap account", "%s", accountID))
+
+	envelope, err := client.Call(ctx, []methodCall{
+		{"Mailbox/get", map[string]any{
+			"accountId":  accountID,
+			"properties": []string{"id", "name", "role"},
+		}, "mailboxes"},
+		{"Identity/get", map[string]any{
+			"accountId":  accountID,
+			"properties": []string{"id", "email", "name"},
+		}, "identities"},
+	})
+	if err != nil {
+		return append(report, diag.Failed("jmap mailboxes", err, "check network access to the JMAP API URL"))
+	}
+	var mailboxes mailboxGetResponse
+	var identities identityGetResponse
+	identitiesLoaded := false
+	for _, response := range envelope.MethodResponses {
+		name, args, err := decodeMethodResponse(response)
+		if err != nil {
+			return append(report, diag.Failed("jmap mailboxes", err, ""))
+		}
+		switch name {
+		case "Mailbox/get":
+			if err := json.Unmarshal(args, &mailboxes); err != nil {
+				return append(report, diag.Failed("jmap mailboxes", err, ""))
+			}
+		case "Identity/get":
+			if err := json.Unmarshal(args, &identities); err != nil {
+				return append(report, diag.Failed("jmap identity", err, ""))
+			}
+			identitiesLoaded = true
+		case "error":
+			report = append(report, diag.Failed("jmap identity", fmt.Errorf("JMAP error: %s", string(args)), "the token needs the submission scope to read identities"))
+		}
+	}
+
+	names := make([]string, 0, len(mailboxes.List))
+	for _, box := range mailboxes.List {
+		names = append(names, box.Name)
+	}
+	if id := selectMailboxID(mailboxes.List, creds.Mailbox); id != "" {
+		report = append(report, diag.Passed("mailbox", "%s -> %s", creds.Mailbox, id))
+	} else {
+		report = append(report, diag.Failed("mailbox", fmt.Errorf("mailbox %q not found", creds.Mailbox), "set AI_OVER_EMAIL_MAILBOX to one of: "+strings.Join(names, ", ")))
+	}
+	if id := selectMailboxID(mailboxes.List, "drafts"); id != "" {
+		report = append(report, diag.Passed("drafts mailbox", "%s", id))
+	} else {
+		report = append(report, diag.Failed("drafts mailbox", fmt.Errorf("no mailbox with the drafts role"), "create a Drafts folder or assign the drafts role in Fastmail settings"))
+	}
+
+	if !identitiesLoaded {
+		return report
+	}
+	report = append(report, diagnoseIdentity(identities.List, creds.Username))
+	return report
+}
+
+func diagnoseMaildir(config appconfig.MaildirConfig) diag.Report {
+	var report diag.Report
+	if err := newMaildirTransport(config, io.Discard).ConnectReadOnly(context.Background()); err != nil {
+		report = append(report, diag.Failed("maildir", err, "point transport.maildir.path at a Maildir with new/ and cur/ directories"))
+	} else {
+		report = append(report, diag.Passed("maildir", "%s", config.Path))
+	}
+	if config.ArchivePath != "" {
+		report = append(report, diagnoseMaildirDir("archive", config.ArchivePath))
+	}
+	if config.Sendmail == "" {
+		return append(report, diagnoseMaildirDir("outbox", config.Outbox))
+	}
+	command := strings.Fields(config.Sendmail)[0]
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return append(report, diag.Failed("sendmail", err, "install an MTA that provides "+command+" or set transport.maildir.outbox instead"))
+	}
+	return append(report, diag.Passed("sendmail", "%s", path))
+}
+
+// diagnoseMaildirDir reports a directory the watcher creates on start
+// without creating it here.
+func diagnoseMaildirDir(name string, path string) diag.Check {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return diag.Warned(name, path+" does not exist yet", "the watcher creates it on start")
+	} else if err != nil {
+		return diag.Failed(name, err, "make sure "+path+" is a readable directory")
+	}
+	return diag.Passed(name, "%s", path)
+}
+
+func diagnoseIMAP(imap appconfig.IMAPConfig, smtp appconfig.SMTPConfig, creds Credentials) diag.Report {
+	var report diag.Report
+	client, err := dialIMAP(imap, imapTimeout)
+	if err != nil {
+		report = append(report, diag.Failed("imap connect", err, "check transport.imap.host, port and security"))
+	} else {
+		defer client.Close()
+		report = append(report, diag.Passed("imap connect", "%s:%d %s", imap.Host, imap.Port, imap.Security))
+		if err := client.Login(creds.IMAPUsername, creds.IMAPPassword); err != nil {
+			report = append(report, diag.Failed("imap login", err, "use an app-specific password in AI_OVER_EMAIL_IMAP_PASSWORD"))
+		} else if status, err := client.Select(imap.Mailbox); err != nil {
+			report = append(report, diag.Failed("imap mailbox", err, "set transport.imap.mailbox to an existing folder"))
+		} else {
+			report = append(report, diag.Passed("imap mailbox", "%s uidvalidity=%d messages=%d", imap.Mailbox, status.UIDValidity, status.Exists))
+			if client.Has("IDLE") {
+				report = append(report, diag.Passed("imap idle", "server supports IDLE"))
+			} else {
+				report = append(report, diag.Warned("imap idle", "server does not support IDLE", "new mail is found by polling every transport.imap.poll_interval"))
+			}
+			if !client.Has("UIDPLUS") && imap.ArchiveMailbox == "" {
+				report = append(report, diag.Warned("imap expunge", "server does not support UIDPLUS", "answered messages are only flagged \\Deleted; set transport.imap.archive_mailbox or expunge them from your mail client"))
+			}
+		}
+	}
+
+	smtpClient, err := dialSMTP(smtp)
+	if err != nil {
+		return append(report, diag.Failed("smtp connect", err, "check transport.smtp.host, port and security"))
+	}
+	defer smtpClient.Close()
+	report = append(report, diag.Passed("smtp connect", "%s:%d %s", smtp.Host, smtp.Port, smtp.Security))
+	if creds.SMTPUsername == "" {
+		return append(report, diag.Skipped("smtp auth", "no SMTP credentials configured"))
+	}
+	if err := smtpClient.Auth(netsmtp.PlainAuth("", creds.SMTPUsername, creds.SMTPPassword, smtp.Host)); err != nil {
+		return append(report, diag.Failed("smtp auth", err, "set AI_OVER_EMAIL_SMTP_USERNAME and AI_OVER_EMAIL_SMTP_PASSWORD, or reuse the IMAP credentials"))
+	}
+	return append(report, diag.Passed("smtp auth", "%s", creds.SMTPUsername))
+}
+
+func diagnoseIdentity(identities []identity, username string) diag.Check {
+	if len(identities) == 0 {
+		return diag.Failed("identity", fmt.Errorf("account has no sending identities"), "add a sending identity in Fastmail settings")
+	}
+	id := selectIdentityID(identities, username)
+	for _, item := range identities {
+		if strings.EqualFold(item.Email, username) {
+			return diag.Passed("identity", "%s -> %s", item.Email, id)
+		}
+	}
+	emails := make([]string, 0, len(identities))
+	for _, item := range identities {
+		emails = append(emails, item.Email)
+	}
+	if strings.TrimSpace(username) == "" {
+		return diag.Warned("identity", fmt.Sprintf("AI_OVER_EMAIL_USERNAME is not set; replies will be sent as %s", identities[0].Email), "set AI_OVER_EMAIL_USERNAME to one of: "+strings.Join(emails, ", "))
+	}
+	return diag.Warned("identity", fmt.Sprintf("no identity matches %s; replies will be sent as %s", username, identities[0].Email), "set AI_OVER_EMAIL_USERNAME to one of: "+strings.Join(emails, ", "))
+}
+
+func diagnoseGPG(ctx context.Context, publicEmail string) diag.Report {
+	path, err := exec.LookPath("gpg")
+	if err != nil {
+		return diag.Report{
+			diag.Failed("gpg", err, "install GnuPG (for example apt install gnupg) so the watcher can decrypt and verify mail"),
+			diag.Skipped("decryption key", "needs gpg"),
+		}
+	}
+	report := diag.Report{diag.Passed("gpg", "%s", path)}
+	publicEmail = strings.TrimSpace(publicEmail)
+	if publicEmail == "" {
+		return append(report, diag.Skipped("decryption key", "AI_OVER_EMAIL_PUBLIC_EMAIL and AI_OVER_EMAIL_USERNAME are not set"))
+	}
+
+	gpgCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(gpgCtx, "gpg", "--batch", "--with-colons", "--list-secret-keys", "--", publicEmail)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	hint := "import the private key for " + publicEmail + " with gpg --import into the keyring of the user running the watcher"
+	if err := cmd.Run(); err != nil {
+		return append(report, diag.Failed("decryption key", fmt.Errorf("no secret key for %s: %s", publicEmail, strings.TrimSpace(stderr.String())), hint))
+	}
+	keyID, ok := usableDecryptionKey(stdout.String())
+	if !ok {
+		return append(report, diag.Failed("decryption key", fmt.Errorf("no usable encryption-capable secret key for %s", publicEmail), hint+"; check that the key or its encryption subkey is not expired or revoked"))
+	}
+	return append(report, diag.Passed("decryption key", "%s key %s", publicEmail, keyID))
+}
+
+func usableDecryptionKey(colons string) (string, bool) {
+	for _, line := range strings.Split(colons, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ":")
+		if len(fields) < 12 || (fields[0] != "sec" && fields[0] != "ssb") {
+			continue
+		}
+		switch fields[1] {
+		case "r", "e", "d", "i", "n":
+			continue
+		}
+		if !strings.Contains(fields[11], "e") {
+			continue
+		}
+		if len(fields) > 14 && fields[14] == "#" {
+			continue
+		}
+		return fields[4], true
+	}
+	return "", false
+}
+
+// diagnoseDatabase inspects the correspondent database read-only. Unlike
+// openCorrespondentStore it never creates the file or applies migrations, so
+// doctor reports the database as the watcher will find it.
+func diagnoseDatabase(ctx context.Context, path string) diag.Check {
+	path = correspondentDBPath(path)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return diag.Warned("database", path+" does not exist yet", "the watcher creates it on first start; make sure its directory is writable")
+	} else if err != nil {
+		return diag.Failed("database", err, "make sure "+path+" is readable")
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return diag.Failed("database", err, "make sure "+path+" is readable")
+	}
+	defer db.Close()
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return diag.Failed("database", err, "move the damaged database aside; the watcher recreates it on start")
+	}
+	if err := verifyMigrations(applied); errors.Is(err, errSchemaNewer) {
+		return diag.Failed("database", err, "upgrade ai-over-email; `ai-over-email db migrate -status` lists the applied migrations")
+	} else if err != nil {
+		return diag.Failed("database", err, "`ai-over-email db migrate -status` lists the applied migrations")
+	}
+	var pending []string
+	for _, migration := range schemaMigrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, fmt.Sprintf("%d %s", migration.Version, migration.Name))
+		}
+	}
+	if len(pending) > 0 {
+		return diag.Warned("database", fmt.Sprintf("%s has %d pending migrations: %s", path, len(pending), strings.Join(pending, ", ")), "run `ai-over-email db migrate`; the watcher also applies them on start")
+	}
+	store := &correspondentStore{db: db}
+	if err := store.CheckSchema(ctx); err != nil {
+		return diag.Failed("database", err, "move the damaged database aside; the watcher recreates it on start")
+	}
+	return diag.Passed("database", "%s schema version %d", path, latestSchemaVersion())
+}
+
+func (s *correspondentStore) CheckSchema(ctx context.Context) error {
+	var result string
+	if err := s.db.QueryRowContext(ctx, `PRAGMA quick_check`).Scan(&result); err != nil {
+		return fmt.Errorf("quick_check: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("quick_check: %s", result)
+	}
+	for table, columns := range correspondentSchema {
+		rows, err := s.db.QueryContext(ctx, `SELECT name FROM pragma_table_info(?)`, table)
+		if err != nil {
+			return err
+		}
+		present := map[string]bool{}
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				return err
+			}
+			present[strings.ToLower(name)] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(present) == 0 {
+			return fmt.Errorf("table %s is missing", table)
+		}
+		for _, column := range columns {
+			if !present[column] {
+				return fmt.Errorf("table %s is missing column %s", table, column)
+			}
+		}
+	}
+	return nil
+}
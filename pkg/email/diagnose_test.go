@@ -0,0 +1,123 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	appconfig "ai-over-email/pkg/config"
+	"ai-over-email/pkg/diag"
+)
+
+func TestUsableDecryptionKey(t *testing.T) {
+	colons := strings.Join([]string{
+		"sec:u:255:22:AAAAAAAAAAAAAAAA:1700000000:::u:::scESC:::+:::ed25519:::0:",
+		"uid:u::::1700000000::HASH::Pegasus <pegasus@example.com>::::::::::0:",
+		"ssb:e:255:18:BBBBBBBBBBBBBBBB:1700000000:1710000000:::::e:::+:::cv25519::",
+		"ssb:u:255:18:CCCCCCCCCCCCCCCC:1700000000::::::e:::+:::cv25519::",
+	}, "\n")
+	keyID, ok := usableDecryptionKey(colons)
+	if !ok || keyID != "CCCCCCCCCCCCCCCC" {
+		t.Fatalf("usableDecryptionKey = %q, %t; want CCCCCCCCCCCCCCCC, true", keyID, ok)
+	}
+
+	stubOnly := "sec:u:255:22:AAAAAAAAAAAAAAAA:1700000000:::u:::scESC:::#:::ed25519:::0:\nssb:u:255:18:CCCCCCCCCCCCCCCC:1700000000::::::e:::#:::cv25519::\n"
+	if _, ok := usableDecryptionKey(stubOnly); ok {
+		t.Fatal("usableDecryptionKey accepted a keyring with only secret key stubs")
+	}
+}
+
+func TestDiagnoseIdentity(t *testing.T) {
+	identities := []identity{
+		{ID: "id-1", Email: "other@example.com"},
+		{ID: "id-2", Email: "pegasus@example.com"},
+	}
+	if check := diagnoseIdentity(identities, "Pegasus@Example.com"); check.Status != diag.Pass {
+		t.Fatalf("matching identity = %#v", check)
+	}
+	check := diagnoseIdentity(identities, "missing@example.com")
+	if check.Status != diag.Warn || !strings.Contains(check.Detail, "other@example.com") || !strings.Contains(check.Hint, "pegasus@example.com") {
+		t.Fatalf("mismatched identity = %#v", check)
+	}
+	if check := diagnoseIdentity(nil, "pegasus@example.com"); check.Status != diag.Fail {
+		t.Fatalf("no identities = %#v", check)
+	}
+}
+
+func TestCorrespondentStoreCheckSchema(t *testing.T) {
+	ctx := context.Background()
+	store := openTestCorrespondentStore(t)
+
+	if err := store.CheckSchema(ctx); err != nil {
+		t.Fatalf("CheckSchema on migrated db returned error: %v", err)
+	}
+	if _, err := store.db.ExecContext(ctx, `DROP TABLE account_token_totals`); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CheckSchema(ctx); err == nil || !strings.Contains(err.Error(), "account_token_totals") {
+		t.Fatalf("CheckSchema after drop = %v, want missing table error", err)
+	}
+}
+
+func TestDiagnoseDatabaseIsReadOnly(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "correspondents.sqlite3")
+	if check := diagnoseDatabase(ctx, path); check.Status != diag.Warn {
+		t.Fatalf("missing database = %#v", check)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("doctor created the database: %v", err)
+	}
+
+	store, err := openCorrespondentStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := schemaMigrations[len(schemaMigrations)-1]
+	if _, err := store.db.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = ?`, latest.Version); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	check := diagnoseDatabase(ctx, path)
+	if check.Status != diag.Warn || !strings.Contains(check.Detail, latest.Name) {
+		t.Fatalf("database with a pending migration = %#v", check)
+	}
+	status, err := CorrespondentSchemaStatus(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Version == latest.Version {
+		t.Fatal("doctor applied the pending migration")
+	}
+
+	migrated := filepath.Join(t.TempDir(), "correspondents.sqlite3")
+	if store, err = openCorrespondentStore(migrated); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	if check := diagnoseDatabase(ctx, migrated); check.Status != diag.Pass {
+		t.Fatalf("migrated database = %#v", check)
+	}
+}
+
+func TestDiagnoseMaildirCreatesNothing(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, "Maildir", sub), 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := appconfig.MaildirConfig{Path: filepath.Join(dir, "Maildir"), Outbox: filepath.Join(dir, "outbox"), ArchivePath: filepath.Join(dir, "archive")}
+	report := diagnoseMaildir(config)
+	if report.Count(diag.Fail) != 0 || report.Count(diag.Warn) != 2 {
+		t.Fatalf("report = %#v", report)
+	}
+	for _, path := range []string{config.Outbox, config.ArchivePath} {
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("doctor created %s: %v", path, err)
+		}
+	}
+}
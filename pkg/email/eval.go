@@ -0,0 +1,516 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	appconfig "ai-over-email/pkg/config"
+)
+
+const builtinEvalPrompt = "builtin"
+
+type EvalOptions struct {
+	CorpusPath string
+	Variants   []string
+	Cases      []string
+	Extra      *EvalVariant
+	NoGrader   bool
+}
+
+type EvalVariant struct {
+	Name            string `json:"name"`
+	Model           string `json:"model"`
+	ReasoningEffort string `json:"reasoning_effort"`
+	Prompt          string `json:"prompt"`
+}
+
+type evalCorpus struct {
+	Prompts  map[string]string `json:"prompts"`
+	Variants []EvalVariant     `json:"variants"`
+	Grader   *struct {
+		Model           string `json:"model"`
+		ReasoningEffort string `json:"reasoning_effort"`
+	} `json:"grader"`
+	Cases []evalCase `json:"cases"`
+}
+
+type evalCase struct {
+	ID          string           `json:"id"`
+	Subject     string           `json:"subject"`
+	Body        string           `json:"body"`
+	Attachments []evalAttachment `json:"attachments"`
+	Facts       []evalCheck      `json:"facts"`
+	Forbidden   []evalCheck      `json:"forbidden"`
+}
+
+type evalAttachment struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+}
+
+type evalCheck struct {
+	Text  string `json:"text"`
+	Match string `json:"match"`
+}
+
+type EvalReport struct {
+	Corpus   string              `json:"corpus"`
+	Grader   string              `json:"grader,omitempty"`
+	Variants []EvalVariantResult `json:"variants"`
+	Usage    EvalUsage           `json:"graderUsage"`
+}
+
+type EvalVariantResult struct {
+	Name            string           `json:"name"`
+	Model           string           `json:"model"`
+	ReasoningEffort string           `json:"reasoningEffort"`
+	Prompt          string           `json:"prompt"`
+	PromptHash      string           `json:"promptHash"`
+	Cases           int              `json:"cases"`
+	Passed          int              `json:"passed"`
+	FactsFound      int              `json:"factsFound"`
+	FactsTotal      int              `json:"factsTotal"`
+	Violations      int              `json:"violations"`
+	Errors          int              `json:"errors"`
+	GraderScore     float64          `json:"graderScore"`
+	Graded          int              `json:"graded"`
+	Usage           EvalUsage        `json:"usage"`
+	LatencyMS       int64            `json:"latencyMs"`
+	Results         []EvalCaseResult `json:"results"`
+}
+
+type EvalCaseResult struct {
+	ID          string            `json:"id"`
+	Passed      bool              `json:"passed"`
+	Facts       []EvalCheckResult `json:"facts"`
+	Forbidden   []EvalCheckResult `json:"forbidden"`
+	GraderScore *float64          `json:"graderScore,omitempty"`
+	GraderNotes string            `json:"graderNotes,omitempty"`
+	Answer      string            `json:"answer,omitempty"`
+	Error       string            `json:"error,omitempty"`
+	Usage       EvalUsage         `json:"usage"`
+	LatencyMS   int64             `json:"latencyMs"`
+}
+
+type EvalCheckResult struct {
+	Text   string `json:"text"`
+	Rule   bool   `json:"rule"`
+	Grader *bool  `json:"grader,omitempty"`
+	Hit    bool   `json:"hit"`
+}
+
+type EvalUsage struct {
+	InputTokens       int     `json:"inputTokens"`
+	CachedInputTokens int     `json:"cachedInputTokens"`
+	OutputTokens      int     `json:"outputTokens"`
+	ReasoningTokens   int     `json:"reasoningTokens"`
+	CostUSD           float64 `json:"costUsd"`
+	Unpriced          bool    `json:"unpriced,omitempty"`
+}
+
+type evalGraderVerdict struct {
+	Facts     []bool  `json:"facts"`
+	Forbidden []bool  `json:"forbidden"`
+	Score     float64 `json:"score"`
+	Notes     string  `json:"notes"`
+}
+
+const evalGraderPrompt = `You grade email replies written by an assistant.
+
+You receive the original email, the reply, a numbered list of facts the reply should state, and a numbered list of content the reply must not contain.
+Judge meaning, not wording: a fact counts as present when the reply clearly conveys it, and forbidden content counts as present when the reply asserts or includes it.
+Score overall quality from 0 to 10 for correctness, completeness, and usefulness to the sender.
+
+Respond with only a JSON object of this shape and nothing else:
+{"facts": [true, false], "forbidden": [false], "score": 7.5, "notes": "one or two sentences"}
+The facts and forbidden arrays must have exactly one boolean per listed item, in order.`
+
+func RunEval(ctx context.Context, config Config, options EvalOptions) (EvalReport, error) {
+	config = normalizeConfig(config)
+	appConfig, err := appconfig.Load(config.ConfigPath)
+	if err != nil {
+		return EvalReport{}, err
+	}
+	corpus, err := loadEvalCorpus(options.CorpusPath)
+	if err != nil {
+		return EvalReport{}, err
+	}
+	variants, err := selectEvalVariants(corpus, appConfig, options)
+	if err != nil {
+		return EvalReport{}, err
+	}
+	cases, err := selectEvalCases(corpus, options.Cases)
+	if err != nil {
+		return EvalReport{}, err
+	}
+	prompts, err := loadEvalPrompts(options.CorpusPath, corpus.Prompts, variants)
+	if err != nil {
+		return EvalReport{}, err
+	}
+	secrets, err := loadEnvironment(config.EnvPath)
+	if err != nil {
+		return EvalReport{}, err
+	}
+	token := first(secrets, "AI_OVER_EMAIL_OPENAI_API_KEY")
+	if token == "" {
+		return EvalReport{}, fmt.Errorf("eval needs AI_OVER_EMAIL_OPENAI_API_KEY")
+	}
+	braveToken := first(secrets, "AI_OVER_EMAIL_BRAVE_API_KEY")
+
+	report := EvalReport{Corpus: options.CorpusPath}
+	var grader *openAIClient
+	var graderSettings appconfig.OpenAIModelSettings
+	if corpus.Grader != nil && !options.NoGrader {
+		graderSettings = normalizeOpenAIModelSettings(appconfig.OpenAIModelSettings{Model: corpus.Grader.Model, ReasoningEffort: corpus.Grader.ReasoningEffort})
+		grader = newOpenAIClient(token, "", "", config.LogOutput)
+		grader.UseEndpoints(config.OpenAIURL, "", config.HTTPTransport)
+		report.Grader = graderSettings.Model + "/" + graderSettings.ReasoningEffort
+	}
+
+	for _, variant := range variants {
+		client := newOpenAIClient(token, "", braveToken, config.LogOutput)
+		client.UseEndpoints(config.OpenAIURL, config.BraveSearchURL, config.HTTPTransport)
+		client.emailPrompt = prompts[variant.Prompt]
+		sum := sha256.Sum256([]byte(client.emailSystemPrompt()))
+		result := EvalVariantResult{
+			Name:            variant.Name,
+			Model:           variant.Model,
+			ReasoningEffort: variant.ReasoningEffort,
+			Prompt:          variant.Prompt,
+			PromptHash:      hex.EncodeToString(sum[:])[:12],
+		}
+		settings := appconfig.OpenAIModelSettings{Model: variant.Model, ReasoningEffort: variant.ReasoningEffort}
+		for _, c := range cases {
+			if err := ctx.Err(); err != nil {
+				return report, err
+			}
+			caseResult, graderUsage, err := runEvalCase(ctx, client, settings, grader, graderSettings, appConfig.OpenAI, options.CorpusPath, c)
+			if err != nil {
+				return report, fmt.Errorf("eval case %s: %w", c.ID, err)
+			}
+			report.Usage = report.Usage.add(graderUsage)
+			result.record(caseResult)
+		}
+		if result.Graded > 0 {
+			result.GraderScore /= float64(result.Graded)
+		}
+		report.Variants = append(report.Variants, result)
+	}
+	return report, nil
+}
+
+func loadEvalCorpus(path string) (evalCorpus, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return evalCorpus{}, fmt.Errorf("read eval corpus: %w", err)
+	}
+	var corpus evalCorpus
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&corpus); err != nil {
+		return evalCorpus{}, fmt.Errorf("decode eval corpus %s: %w", path, err)
+	}
+	if len(corpus.Cases) == 0 {
+		return evalCorpus{}, fmt.Errorf("eval corpus %s has no cases", path)
+	}
+	ids := map[string]bool{}
+	for i, c := range corpus.Cases {
+		if strings.TrimSpace(c.ID) == "" {
+			return evalCorpus{}, fmt.Errorf("eval case %d has no id", i+1)
+		}
+		if ids[c.ID] {
+			return evalCorpus{}, fmt.Errorf("eval case id %q is duplicated", c.ID)
+		}
+		ids[c.ID] = true
+		for _, check := range append(slices.Clone(c.Facts), c.Forbidden...) {
+			if strings.TrimSpace(check.Text) == "" && strings.TrimSpace(check.Match) == "" {
+				return evalCorpus{}, fmt.Errorf("eval case %s has a check without text or match", c.ID)
+			}
+			if _, err := check.pattern(); err != nil {
+				return evalCorpus{}, fmt.Errorf("eval case %s: invalid match %q: %w", c.ID, check.Match, err)
+			}
+		}
+	}
+	return corpus, nil
+}
+
+func selectEvalVariants(corpus evalCorpus, appConfig appconfig.ConfigStruct, options EvalOptions) ([]EvalVariant, error) {
+	variants := slices.Clone(corpus.Variants)
+	if len(options.Variants) > 0 {
+		variants = variants[:0]
+		for _, name := range options.Variants {
+			index := slices.IndexFunc(corpus.Variants, func(v EvalVariant) bool { return v.Name == name })
+			if index < 0 {
+				return nil, fmt.Errorf("eval variant %q is not defined in the corpus", name)
+			}
+			variants = append(variants, corpus.Variants[index])
+		}
+	}
+	if options.Extra != nil {
+		variants = append(variants, *options.Extra)
+	}
+	if len(variants) == 0 {
+		defaults := appConfig.OpenAISettingsForSenders(nil)
+		variants = append(variants, EvalVariant{Name: "default", Model: defaults.Model, ReasoningEffort: defaults.ReasoningEffort})
+	}
+	names := map[string]bool{}
+	for i := range variants {
+		settings := normalizeOpenAIModelSettings(appconfig.OpenAIModelSettings{Model: variants[i].Model, ReasoningEffort: variants[i].ReasoningEffort})
+		variants[i].Model = settings.Model
+		variants[i].ReasoningEffort = settings.ReasoningEffort
+		if variants[i].Prompt == "" {
+			variants[i].Prompt = builtinEvalPrompt
+		}
+		if variants[i].Name == "" {
+			variants[i].Name = variants[i].Model + "/" + variants[i].ReasoningEffort + "/" + variants[i].Prompt
+		}
+		if names[variants[i].Name] {
+			return nil, fmt.Errorf("eval variant %q is duplicated", variants[i].Name)
+		}
+		names[variants[i].Name] = true
+	}
+	return variants, nil
+}
+
+func selectEvalCases(corpus evalCorpus, ids []string) ([]evalCase, error) {
+	if len(ids) == 0 {
+		return corpus.Cases, nil
+	}
+	cases := make([]evalCase, 0, len(ids))
+	for _, id := range ids {
+		index := slices.IndexFunc(corpus.Cases, func(c evalCase) bool { return c.ID == id })
+		if index < 0 {
+			return nil, fmt.Errorf("eval case %q is not defined in the corpus", id)
+		}
+		cases = append(cases, corpus.Cases[index])
+	}
+	return cases, nil
+}
+
+func loadEvalPrompts(corpusPath string, paths map[string]string, variants []EvalVariant) (map[string]string, error) {
+	prompts := map[string]string{builtinEvalPrompt: ""}
+	for _, variant := range variants {
+		if _, ok := prompts[variant.Prompt]; ok {
+			continue
+		}
+		path, ok := paths[variant.Prompt]
+		if !ok {
+			return nil, fmt.Errorf("eval variant %s uses unknown prompt %q", variant.Name, variant.Prompt)
+		}
+		data, err := os.ReadFile(evalRelativePath(corpusPath, path))
+		if err != nil {
+			return nil, fmt.Errorf("read eval prompt %s: %w", variant.Prompt, err)
+		}
+		text := strings.TrimSpace(string(data))
+		if text == "" {
+			return nil, fmt.Errorf("eval prompt %s is empty", variant.Prompt)
+		}
+		prompts[variant.Prompt] = text
+	}
+	return prompts, nil
+}
+
+func evalRelativePath(corpusPath string, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(corpusPath), path)
+}
+
+func runEvalCase(ctx context.Context, client *openAIClient, settings appconfig.OpenAIModelSettings, grader *openAIClient, graderSettings appconfig.OpenAIModelSettings, prices appconfig.OpenAIConfig, corpusPath string, c evalCase) (EvalCaseResult, EvalUsage, error) {
+	result := EvalCaseResult{ID: c.ID}
+	attachments := make([]emailAttachment, 0, len(c.Attachments))
+	for _, attachment := range c.Attachments {
+		path := evalRelativePath(corpusPath, attachment.Path)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return result, EvalUsage{}, fmt.Errorf("read attachment: %w", err)
+		}
+		contentType := attachment.Type
+		if contentType == "" {
+			contentType = mime.TypeByExtension(filepath.Ext(path))
+		}
+		attachments = append(attachments, emailAttachment{Name: filepath.Base(path), Type: contentType, Data: data, Size: len(data)})
+	}
+
+	start := time.Now()
+	answer, err := client.AnswerEmail(ctx, c.Subject, c.Body, attachments, "", settings)
+	result.LatencyMS = time.Since(start).Milliseconds()
+	if err != nil {
+		if ctx.Err() != nil {
+			return result, EvalUsage{}, ctx.Err()
+		}
+		result.Error = err.Error()
+		return result, EvalUsage{}, nil
+	}
+	result.Answer = answer.Text
+	result.Usage = evalUsage(prices, answer.Model, answer.Usage)
+	for _, check := range c.Facts {
+		result.Facts = append(result.Facts, EvalCheckResult{Text: check.label(), Rule: check.matches(answer.Text)})
+	}
+	for _, check := range c.Forbidden {
+		result.Forbidden = append(result.Forbidden, EvalCheckResult{Text: check.label(), Rule: check.matches(answer.Text)})
+	}
+
+	var graderUsage EvalUsage
+	if grader != nil {
+		verdict, usage, err := gradeEvalAnswer(ctx, grader, graderSettings, c, answer.Text)
+		graderUsage = evalUsage(prices, graderSettings.Model, usage)
+		if err != nil {
+			if ctx.Err() != nil {
+				return result, graderUsage, ctx.Err()
+			}
+			result.GraderNotes = "grader failed: " + err.Error()
+		} else {
+			for i := range result.Facts {
+				found := verdict.Facts[i]
+				result.Facts[i].Grader = &found
+			}
+			for i := range result.Forbidden {
+				present := verdict.Forbidden[i]
+				result.Forbidden[i].Grader = &present
+			}
+			score := verdict.Score
+			result.GraderScore = &score
+			result.GraderNotes = verdict.Notes
+		}
+	}
+
+	result.Passed = true
+	for i := range result.Facts {
+		result.Facts[i].Hit = result.Facts[i].Rule || (result.Facts[i].Grader != nil && *result.Facts[i].Grader)
+		result.Passed = result.Passed && result.Facts[i].Hit
+	}
+	for i := range result.Forbidden {
+		result.Forbidden[i].Hit = result.Forbidden[i].Rule || (result.Forbidden[i].Grader != nil && *result.Forbidden[i].Grader)
+		result.Passed = result.Passed && !result.Forbidden[i].Hit
+	}
+	return result, graderUsage, nil
+}
+
+func gradeEvalAnswer(ctx context.Context, grader *openAIClient, settings appconfig.OpenAIModelSettings, c evalCase, answer string) (evalGraderVerdict, openAIUsage, error) {
+	var request strings.Builder
+	fmt.Fprintf(&request, "Original email subject: %s\n\nOriginal email body:\n%s\n\nReply to grade:\n%s\n\nFacts the reply should state:\n", c.Subject, c.Body, answer)
+	writeEvalChecks(&request, c.Facts)
+	request.WriteString("\nContent the reply must not contain:\n")
+	writeEvalChecks(&request, c.Forbidden)
+
+	response, err := grader.completeResponse(ctx, []map[string]any{
+		{"role": "system", "content": evalGraderPrompt},
+		{"role": "user", "content": request.String()},
+	}, settings)
+	if err != nil {
+		return evalGraderVerdict{}, openAIUsage{}, err
+	}
+	text := strings.TrimSpace(response.Text)
+	text = strings.TrimPrefix(text, "```json")
+	text = strings.TrimPrefix(text, "```")
+	text = strings.TrimSuffix(text, "```")
+	var verdict evalGraderVerdict
+	if err := json.Unmarshal([]byte(strings.TrimSpace(text)), &verdict); err != nil {
+		return evalGraderVerdict{}, response.Usage, fmt.Errorf("decode grader verdict: %w", err)
+	}
+	if len(verdict.Facts) != len(c.Facts) || len(verdict.Forbidden) != len(c.Forbidden) {
+		return evalGraderVerdict{}, response.Usage, fmt.Errorf("grader verdict has %d facts and %d forbidden, want %d and %d", len(verdict.Facts), len(verdict.Forbidden), len(c.Facts), len(c.Forbidden))
+	}
+	if verdict.Score < 0 || verdict.Score > 10 {
+		return evalGraderVerdict{}, response.Usage, fmt.Errorf("grader score %.1f is outside 0-10", verdict.Score)
+	}
+	return verdict, response.Usage, nil
+}
+
+func writeEvalChecks(b *strings.Builder, checks []evalCheck) {
+	if len(checks) == 0 {
+		b.WriteString("(none)\n")
+		return
+	}
+	for i, check := range checks {
+		fmt.Fprintf(b, "%d. %s\n", i+1, check.label())
+	}
+}
+
+func (c evalCheck) label() string {
+	if text := strings.TrimSpace(c.Text); text != "" {
+		return text
+	}
+	return c.Match
+}
+
+func (c evalCheck) pattern() (*regexp.Regexp, error) {
+	if strings.TrimSpace(c.Match) != "" {
+		return regexp.Compile("(?i)" + c.Match)
+	}
+	return regexp.Compile("(?i)" + regexp.QuoteMeta(strings.TrimSpace(c.Text)))
+}
+
+func (c evalCheck) matches(text string) bool {
+	pattern, err := c.pattern()
+	return err == nil && pattern.MatchString(text)
+}
+
+func evalUsage(prices appconfig.OpenAIConfig, model string, usage openAIUsage) EvalUsage {
+	result := EvalUsage{
+		InputTokens:       usage.InputTokens,
+		CachedInputTokens: usage.CachedInputTokens,
+		OutputTokens:      usage.OutputTokens,
+		ReasoningTokens:   usage.ReasoningTokens,
+	}
+	price, ok := prices.Price(model)
+	if !ok {
+		result.Unpriced = usage.InputTokens+usage.OutputTokens > 0
+		return result
+	}
+	result.CostUSD = price.Cost(usage.InputTokens, usage.CachedInputTokens, usage.OutputTokens)
+	return result
+}
+
+func (u EvalUsage) add(other EvalUsage) EvalUsage {
+	return EvalUsage{
+		InputTokens:       u.InputTokens + other.InputTokens,
+		CachedInputTokens: u.CachedInputTokens + other.CachedInputTokens,
+		OutputTokens:      u.OutputTokens + other.OutputTokens,
+		ReasoningTokens:   u.ReasoningTokens + other.ReasoningTokens,
+		CostUSD:           u.CostUSD + other.CostUSD,
+		Unpriced:          u.Unpriced || other.Unpriced,
+	}
+}
+
+func (r *EvalVariantResult) record(result EvalCaseResult) {
+	r.Cases++
+	r.Results = append(r.Results, result)
+	r.Usage = r.Usage.add(result.Usage)
+	r.LatencyMS += result.LatencyMS
+	if result.Error != "" {
+		r.Errors++
+		return
+	}
+	if result.Passed {
+		r.Passed++
+	}
+	for _, fact := range result.Facts {
+		r.FactsTotal++
+		if fact.Hit {
+			r.FactsFound++
+		}
+	}
+	for _, forbidden := range result.Forbidden {
+		if forbidden.Hit {
+			r.Violations++
+		}
+	}
+	if result.GraderScore != nil {
+		r.GraderScore += *result.GraderScore
+		r.Graded++
+	}
+}
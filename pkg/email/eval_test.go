@@ -0,0 +1,197 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRunEvalComparesVariants(t *testing.T) {
+	clearCredentialEnv(t)
+	t.Setenv("AI_OVER_EMAIL_OPENAI_API_KEY", "openai-test")
+	stub := newEvalStub(t)
+	config := Config{EnvPath: "-", ConfigPath: writeEvalConfig(t), Output: io.Discard, LogOutput: io.Discard, OpenAIURL: stub.URL + "/v1/responses"}
+
+	report, err := RunEval(context.Background(), config, EvalOptions{CorpusPath: "testdata/eval/corpus.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Grader != "gpt-5-mini/low" || len(report.Variants) != 3 {
+		t.Fatalf("report = %+v", report)
+	}
+
+	builtin := report.Variants[0]
+	if builtin.Name != "nano-low" || builtin.Prompt != "builtin" || builtin.Passed != 2 || builtin.FactsFound != 3 || builtin.FactsTotal != 3 || builtin.Violations != 0 {
+		t.Fatalf("builtin variant = %+v", builtin)
+	}
+	if builtin.Graded != 2 || builtin.GraderScore != 8 {
+		t.Fatalf("builtin grader = %d cases, mean %.1f", builtin.Graded, builtin.GraderScore)
+	}
+	if want := 2 * (800*0.05 + 200*0.005 + 500*0.4) / 1e6; math.Abs(builtin.Usage.CostUSD-want) > 1e-12 || builtin.Usage.InputTokens != 2000 {
+		t.Fatalf("builtin usage = %+v, want cost %f", builtin.Usage, want)
+	}
+
+	terse := report.Variants[1]
+	if terse.Prompt != "terse" || terse.PromptHash == builtin.PromptHash || terse.Passed != 0 || terse.FactsFound != 1 || terse.Violations != 1 {
+		t.Fatalf("terse variant = %+v", terse)
+	}
+	invoice := terse.Results[1]
+	if invoice.Passed || !invoice.Forbidden[0].Rule || invoice.Forbidden[0].Grader == nil || !*invoice.Forbidden[0].Grader {
+		t.Fatalf("terse invoice = %+v", invoice)
+	}
+
+	mini := report.Variants[2]
+	if mini.Errors != 1 || mini.Passed != 1 || !strings.Contains(mini.Results[1].Error, "500") || mini.Usage.Unpriced {
+		t.Fatalf("mini variant = %+v", mini)
+	}
+	if report.Usage.InputTokens != 5*100 || report.Usage.CostUSD == 0 {
+		t.Fatalf("grader usage = %+v", report.Usage)
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{`"graderUsage"`, `"reasoningEffort"`, `"promptHash"`, `"graderScore"`, `"latencyMs"`, `"inputTokens"`, `"costUsd"`} {
+		if !strings.Contains(string(data), key) {
+			t.Fatalf("eval JSON has no %s key:\n%s", key, data)
+		}
+	}
+	for _, key := range []string{`"cost_usd"`, `"latency_ms"`, `"input_tokens"`} {
+		if strings.Contains(string(data), key) {
+			t.Fatalf("eval JSON still has the snake_case key %s", key)
+		}
+	}
+}
+
+func TestRunEvalSelectsVariantsCasesAndAdHocModel(t *testing.T) {
+	clearCredentialEnv(t)
+	t.Setenv("AI_OVER_EMAIL_OPENAI_API_KEY", "openai-test")
+	stub := newEvalStub(t)
+	config := Config{EnvPath: "-", ConfigPath: writeEvalConfig(t), Output: io.Discard, LogOutput: io.Discard, OpenAIURL: stub.URL + "/v1/responses"}
+
+	report, err := RunEval(context.Background(), config, EvalOptions{
+		CorpusPath: "testdata/eval/corpus.json",
+		Variants:   []string{"nano-terse"},
+		Cases:      []string{"boiling-point"},
+		Extra:      &EvalVariant{Model: "gpt-9-experimental", ReasoningEffort: "minimal"},
+		NoGrader:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Variants) != 2 || report.Grader != "" || stub.graderCalls() != 0 {
+		t.Fatalf("report = %+v, grader calls = %d", report, stub.graderCalls())
+	}
+	if report.Variants[0].Name != "nano-terse" || report.Variants[0].Cases != 1 || report.Variants[0].Passed != 0 {
+		t.Fatalf("selected variant = %+v", report.Variants[0])
+	}
+	extra := report.Variants[1]
+	if extra.Name != "gpt-9-experimental/minimal/builtin" || extra.Passed != 1 || !extra.Usage.Unpriced || extra.Results[0].GraderScore != nil {
+		t.Fatalf("ad hoc variant = %+v", extra)
+	}
+
+	if _, err := RunEval(context.Background(), config, EvalOptions{CorpusPath: "testdata/eval/corpus.json", Cases: []string{"missing"}}); err == nil || !strings.Contains(err.Error(), `"missing"`) {
+		t.Fatalf("unknown case error = %v", err)
+	}
+	if _, err := RunEval(context.Background(), config, EvalOptions{CorpusPath: "testdata/eval/corpus.json", Extra: &EvalVariant{Model: "gpt-5-nano", Prompt: "verbose"}}); err == nil || !strings.Contains(err.Error(), `unknown prompt "verbose"`) {
+		t.Fatalf("unknown prompt error = %v", err)
+	}
+}
+
+type evalStub struct {
+	*httptest.Server
+	mu     sync.Mutex
+	grader int
+}
+
+func (s *evalStub) graderCalls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.grader
+}
+
+func newEvalStub(t *testing.T) *evalStub {
+	t.Helper()
+	stub := &evalStub{}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Model string           `json:"model"`
+			Input []map[string]any `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Input) != 2 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		system, _ := request.Input[0]["content"].(string)
+		user, _ := json.Marshal(request.Input[1]["content"])
+		denver := strings.Contains(string(user), "Denver")
+
+		var text string
+		usage := openAIUsage{InputTokens: 1000, CachedInputTokens: 200, OutputTokens: 500}
+		switch {
+		case strings.HasPrefix(system, "You grade"):
+			stub.mu.Lock()
+			stub.grader++
+			stub.mu.Unlock()
+			_, reply, _ := strings.Cut(string(user), "Reply to grade:")
+			reply, _, _ = strings.Cut(reply, "Facts the reply should state")
+			verdict := evalGraderVerdict{Score: 8, Forbidden: []bool{false}}
+			if denver {
+				verdict.Facts = []bool{true, strings.Contains(reply, "95")}
+			} else {
+				verdict.Facts = []bool{strings.Contains(reply, "42.50")}
+				verdict.Forbidden = []bool{strings.Contains(reply, "52.50")}
+			}
+			data, _ := json.Marshal(verdict)
+			text = "```json\n" + string(data) + "\n```"
+			usage = openAIUsage{InputTokens: 100, OutputTokens: 20}
+		case request.Model == "gpt-5-mini" && !denver:
+			http.Error(w, "overloaded", http.StatusInternalServerError)
+			return
+		case strings.Contains(system, "at most three short paragraphs") && denver:
+			text = "Denver sits higher, so the air pressure is lower and water boils sooner."
+		case strings.Contains(system, "at most three short paragraphs"):
+			text = "The total is 52.50 as listed."
+		case denver:
+			text = "Because of lower air pressure at altitude, water in Denver boils at about 95 °C."
+		default:
+			text = "The line items add up to 42.50, so the printed total is wrong."
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(openAIResponse{
+			ID:     fmt.Sprintf("resp_%s", request.Model),
+			Output: []openAIOutputItem{{Type: "message", Content: []openAIOutputContent{{Type: "output_text", Text: text}}}},
+			Usage:  usage,
+		})
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+func writeEvalConfig(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{
+  "jmap": {"session_endpoint": "https://api.example/session"},
+  "openai": {
+    "prices": {
+      "gpt-5-nano": {"input_per_million": 0.05, "cached_input_per_million": 0.005, "output_per_million": 0.4},
+      "gpt-5-mini": {"input_per_million": 0.25, "cached_input_per_million": 0.025, "output_per_million": 2}
+    }
+  }
+}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,387 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	appconfig "ai-over-email/pkg/config"
+)
+
+const (
+	fixtureEnvFile          = "fixtures.env"
+	fixtureAssistantAddress = "assistant@example.com"
+	fixtureReplayToken      = "replay"
+)
+
+// fixtureClock is the time every fixture is answered at, so prompts that
+// mention the sender's local time match their cassettes.
+var fixtureClock = time.Date(2026, time.October, 6, 15, 0, 0, 0, time.UTC)
+
+type FixtureOptions struct {
+	Dir         string
+	GoldenDir   string
+	CassetteDir string
+	Record      bool
+	// Stub re-records against the responses already in each cassette instead
+	// of the live APIs, so a prompt change only rewrites the recorded
+	// requests and the golden files.
+	Stub   bool
+	Update bool
+}
+
+type FixtureResult struct {
+	Name    string   `json:"name"`
+	Path    []string `json:"path"`
+	Changed []string `json:"changed,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+type fixtureTransport struct {
+	msg emailMessage
+
+	mu        sync.Mutex
+	path      []string
+	submitted []outgoingEmail
+}
+
+func RunFixtures(ctx context.Context, config Config, options FixtureOptions) ([]FixtureResult, error) {
+	config = normalizeConfig(config)
+	options = options.normalized()
+	appConfig, err := appconfig.Load(config.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := fixtureCredentials(config.EnvPath, options)
+	if err != nil {
+		return nil, err
+	}
+	names, err := filepath.Glob(filepath.Join(options.Dir, "*.eml"))
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no .eml fixtures in %s", options.Dir)
+	}
+	slices.Sort(names)
+	for _, dir := range []string{options.GoldenDir, options.CassetteDir} {
+		if options.Update || options.Record {
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return nil, fmt.Errorf("create fixture output directory: %w", err)
+			}
+		}
+	}
+
+	results := make([]FixtureResult, 0, len(names))
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		result, err := runFixture(ctx, config, appConfig, creds, options, name)
+		if err != nil {
+			return results, fmt.Errorf("fixture %s: %w", filepath.Base(name), err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (o FixtureOptions) normalized() FixtureOptions {
+	if o.Dir == "" {
+		o.Dir = "."
+	}
+	if o.GoldenDir == "" {
+		o.GoldenDir = filepath.Join(o.Dir, "golden")
+	}
+	if o.CassetteDir == "" {
+		o.CassetteDir = filepath.Join(o.Dir, "cassettes")
+	}
+	if o.Record {
+		o.Update = true
+	}
+	return o
+}
+
+func fixtureCredentials(envPath string, options FixtureOptions) (Credentials, error) {
+	values := map[string]string{}
+	fixtureValues, err := loadKeyValueFile(filepath.Join(options.Dir, fixtureEnvFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Credentials{}, err
+	}
+	for key, value := range fixtureValues {
+		values[key] = value
+	}
+	creds := Credentials{
+		Username:           first(values, "AI_OVER_EMAIL_USERNAME"),
+		PublicEmail:        first(values, "AI_OVER_EMAIL_PUBLIC_EMAIL"),
+		PlaintextAllowlist: splitList(first(values, "AI_OVER_EMAIL_PLAINTEXT_ALLOWLIST")),
+		Mailbox:            "inbox",
+	}
+	if creds.Username == "" {
+		creds.Username = fixtureAssistantAddress
+	}
+	if creds.PublicEmail == "" {
+		creds.PublicEmail = creds.Username
+	}
+	if !options.Record || options.Stub {
+		return creds, nil
+	}
+	secrets, err := loadEnvironment(envPath)
+	if err != nil {
+		return Credentials{}, err
+	}
+	creds.OpenAIAPIToken = first(secrets, "AI_OVER_EMAIL_OPENAI_API_KEY")
+	creds.BraveSearchAPIToken = first(secrets, "AI_OVER_EMAIL_BRAVE_API_KEY")
+	if creds.OpenAIAPIToken == "" {
+		return Credentials{}, fmt.Errorf("recording fixtures needs AI_OVER_EMAIL_OPENAI_API_KEY")
+	}
+	return creds, nil
+}
+
+// replayCredentials fills in placeholder keys so the pipeline takes the same
+// branches it took when the cassette was recorded.
+func replayCredentials(creds Credentials, recorded cassette) Credentials {
+	creds.OpenAIAPIToken = fixtureReplayToken
+	if recorded.BraveSearch {
+		creds.BraveSearchAPIToken = fixtureReplayToken
+	}
+	return creds
+}
+
+func runFixture(ctx context.Context, config Config, appConfig appconfig.ConfigStruct, creds Credentials, options FixtureOptions, emlPath string) (FixtureResult, error) {
+	base := strings.TrimSuffix(filepath.Base(emlPath), ".eml")
+	result := FixtureResult{Name: base}
+	raw, err := os.ReadFile(emlPath)
+	if err != nil {
+		return result, err
+	}
+	msg, err := parseRFC822Message(raw)
+	if err != nil {
+		return result, fmt.Errorf("%w: %v", errMalformedMessage, err)
+	}
+	msg.ID = base
+
+	cassettePath := filepath.Join(options.CassetteDir, base+".json")
+	transport := &fixtureTransport{msg: msg}
+	tape := &cassetteTransport{base: config.HTTPTransport, recording: options.Record, onEvent: func(event interaction) {
+		transport.step(describeInteraction(event))
+	}}
+	var stub *cassetteTransport
+	switch {
+	case options.Record && options.Stub:
+		recorded, err := loadCassette(cassettePath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return result, err
+		}
+		stub = &cassetteTransport{tape: recorded, stub: true}
+		tape.base = stub
+		tape.tape.BraveSearch = recorded.BraveSearch
+		creds = replayCredentials(creds, recorded)
+	case options.Record:
+		tape.tape.BraveSearch = creds.BraveSearchAPIToken != ""
+	default:
+		recorded, err := loadCassette(cassettePath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return result, err
+		}
+		tape.tape = recorded
+		creds = replayCredentials(creds, recorded)
+	}
+
+	dir, err := os.MkdirTemp("", "ai-over-email-fixture-")
+	if err != nil {
+		return result, err
+	}
+	defer os.RemoveAll(dir)
+	fixtureConfig := config
+	fixtureConfig.DatabasePath = filepath.Join(dir, "correspondents.sqlite3")
+	fixtureConfig.HTTPTransport = tape
+	fixtureConfig.LogOutput = &fixtureLogTap{transport: transport, next: config.LogOutput}
+	// Each fixture starts from an empty database, so memory notes would only
+	// add an extraction call to every cassette.
+	appConfig.Memory.Disabled = true
+	w, err := openWatcher(fixtureConfig, appConfig, creds, transport)
+	if err != nil {
+		return result, err
+	}
+	defer w.store.Close()
+	w.connected = true
+	w.now = func() time.Time { return fixtureClock }
+
+	if reason := w.skipAutoReplyReason(msg); reason != "" {
+		transport.step("skipped reason=" + reason)
+	} else if err := w.maybeAutoReply(ctx, msg); err != nil {
+		transport.step("error " + err.Error())
+		result.Error = err.Error()
+	}
+	unused := stub
+	if !options.Record {
+		unused = tape
+	}
+	if unused != nil && unused.remaining() > 0 {
+		transport.step(fmt.Sprintf("error %v: %d left", errCassetteUnused, unused.remaining()))
+		result.Error = errCassetteUnused.Error()
+	}
+	if options.Record {
+		if err := saveCassette(cassettePath, tape.tape); err != nil {
+			return result, err
+		}
+	}
+
+	result.Path = transport.steps()
+	goldens := map[string]string{base + ".path.txt": strings.Join(result.Path, "\n") + "\n"}
+	if reply, ok := transport.lastReply(); ok {
+		goldens[base+".reply.txt"] = reply.TextBody + "\n"
+		goldens[base+".reply.html"] = reply.HTMLBody + "\n"
+	} else {
+		goldens[base+".reply.txt"] = ""
+		goldens[base+".reply.html"] = ""
+	}
+	changed, err := compareGoldens(options.GoldenDir, goldens, options.Update)
+	if err != nil {
+		return result, err
+	}
+	result.Changed = changed
+	return result, nil
+}
+
+func compareGoldens(dir string, goldens map[string]string, update bool) ([]string, error) {
+	names := make([]string, 0, len(goldens))
+	for name := range goldens {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var changed []string
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		want := goldens[name]
+		got, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("read golden file: %w", err)
+		}
+		exists := err == nil
+		if string(got) == want && (exists || want == "") {
+			continue
+		}
+		changed = append(changed, name)
+		if !update {
+			continue
+		}
+		if want == "" {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("remove golden file: %w", err)
+			}
+			continue
+		}
+		if err := os.WriteFile(path, []byte(want), 0o644); err != nil {
+			return nil, fmt.Errorf("write golden file: %w", err)
+		}
+	}
+	return changed, nil
+}
+
+var fixtureDecisionEvents = []string{
+	"plaintext sender accepted by allowlist",
+	"plaintext sender accepted by sender policy",
+	"PGP decrypt accepted",
+	"auto-reply rejected by PGP policy",
+	"auto-reply skipped for blocked sender",
+	"correspondent rate limit reply sent",
+	"correspondent profile updated from email body",
+}
+
+type fixtureLogTap struct {
+	transport *fixtureTransport
+	next      io.Writer
+}
+
+func (t *fixtureLogTap) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimSpace(string(p)), "\n") {
+		_, message, ok := strings.Cut(line, " mailwatch: ")
+		if !ok {
+			continue
+		}
+		event, fields, _ := strings.Cut(message, ": ")
+		if !slices.Contains(fixtureDecisionEvents, event) {
+			continue
+		}
+		for _, field := range strings.Fields(fields) {
+			if strings.HasPrefix(field, "reason=") {
+				event += " " + field
+			}
+		}
+		t.transport.step(event)
+	}
+	if t.next == nil {
+		return len(p), nil
+	}
+	return t.next.Write(p)
+}
+
+func (t *fixtureTransport) step(line string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.path = append(t.path, line)
+}
+
+func (t *fixtureTransport) steps() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.path...)
+}
+
+func (t *fixtureTransport) lastReply() (outgoingEmail, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.submitted) == 0 {
+		return outgoingEmail{}, false
+	}
+	return t.submitted[len(t.submitted)-1], true
+}
+
+func (t *fixtureTransport) Connect(ctx context.Context) error {
+	return nil
+}
+
+func (t *fixtureTransport) Watch(ctx context.Context, deliver func(context.Context, []emailMessage)) error {
+	return fmt.Errorf("fixture transport replays files and cannot watch a mailbox")
+}
+
+func (t *fixtureTransport) Scan(ctx context.Context, limit int) ([]emailMessage, error) {
+	return nil, nil
+}
+
+func (t *fixtureTransport) Fetch(ctx context.Context, id string) (emailMessage, error) {
+	if id != t.msg.ID {
+		return emailMessage{}, fmt.Errorf("message %s not found", id)
+	}
+	return t.msg, nil
+}
+
+func (t *fixtureTransport) Attachments(ctx context.Context, msg emailMessage) ([]emailAttachment, error) {
+	return extractDecryptedAttachments(string(msg.Raw)), nil
+}
+
+func (t *fixtureTransport) Submit(ctx context.Context, msg outgoingEmail) error {
+	names := make([]string, 0, len(msg.Attachments))
+	for _, attachment := range msg.Attachments {
+		names = append(names, attachmentName(attachment))
+	}
+	t.step(fmt.Sprintf("submit to=%q subject=%q in_reply_to=%q attachments=%q", formatFrom(msg.To), msg.Subject, msg.InReplyTo, names))
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.submitted = append(t.submitted, msg)
+	return nil
+}
+
+func (t *fixtureTransport) Dispose(ctx context.Context, id string) error {
+	t.step("dispose id=" + id)
+	return nil
+}
@@ -0,0 +1,168 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReplayFixturesMatchGolden(t *testing.T) {
+	clearCredentialEnv(t)
+	results, err := RunFixtures(context.Background(), Config{
+		EnvPath:    "-",
+		ConfigPath: "testdata/replay/config.json",
+		Output:     io.Discard,
+		LogOutput:  io.Discard,
+	}, FixtureOptions{Dir: "testdata/replay"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("results = %d, want one per fixture", len(results))
+	}
+	for _, result := range results {
+		if result.Error != "" || len(result.Changed) > 0 {
+			t.Errorf("fixture %s: error=%q changed=%v; run `ai-over-email --config testdata/replay/config.json --env - replay --fixtures testdata/replay --update` in pkg/email and review the diff\npath:\n%s", result.Name, result.Error, result.Changed, strings.Join(result.Path, "\n"))
+		}
+	}
+}
+
+func TestRecordFixtureThenReplay(t *testing.T) {
+	clearCredentialEnv(t)
+	t.Setenv("AI_OVER_EMAIL_OPENAI_API_KEY", "openai-test")
+	calls := 0
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.Header.Get("Authorization") != "Bearer openai-test" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(openAIResponse{
+			ID:     "resp_stub",
+			Output: []openAIOutputItem{{Type: "message", Content: []openAIOutputContent{{Type: "output_text", Text: "Recorded answer."}}}},
+			Usage:  openAIUsage{TotalTokens: 11},
+		})
+	}))
+	defer stub.Close()
+
+	dir := t.TempDir()
+	for _, name := range []string{"question.eml", "fixtures.env"} {
+		data, err := os.ReadFile(filepath.Join("testdata/replay", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := Config{EnvPath: "-", ConfigPath: "testdata/replay/config.json", Output: io.Discard, LogOutput: io.Discard, OpenAIURL: stub.URL + "/v1/responses"}
+
+	results, err := RunFixtures(context.Background(), config, FixtureOptions{Dir: dir, Record: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Error != "" || calls != 1 {
+		t.Fatalf("record results = %+v, stub calls = %d", results, calls)
+	}
+	tape, err := loadCassette(filepath.Join(dir, "cassettes", "question.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tape.Interactions) != 1 || tape.BraveSearch || !strings.Contains(string(tape.Interactions[0].Request), "Why does water boil") {
+		t.Fatalf("cassette = %+v", tape)
+	}
+	cassetteData, _ := os.ReadFile(filepath.Join(dir, "cassettes", "question.json"))
+	if strings.Contains(string(cassetteData), "openai-test") {
+		t.Fatal("cassette stored the API key")
+	}
+	reply, err := os.ReadFile(filepath.Join(dir, "golden", "question.reply.txt"))
+	if err != nil || !strings.Contains(string(reply), "Recorded answer.") {
+		t.Fatalf("golden reply = %q, %v", reply, err)
+	}
+
+	t.Setenv("AI_OVER_EMAIL_OPENAI_API_KEY", "")
+	config.OpenAIURL = ""
+	results, err = RunFixtures(context.Background(), config, FixtureOptions{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Error != "" || len(results[0].Changed) > 0 || calls != 1 {
+		t.Fatalf("replay results = %+v, stub calls = %d", results, calls)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "cassettes", "question.json"), []byte(`{"interactions":[]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	results, err = RunFixtures(context.Background(), config, FixtureOptions{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(results[0].Error, "cassette exhausted") || len(results[0].Changed) == 0 {
+		t.Fatalf("replay with an empty cassette = %+v", results[0])
+	}
+}
+
+func TestReplayRejectsChangedRequestUntilStubReRecord(t *testing.T) {
+	clearCredentialEnv(t)
+	dir := t.TempDir()
+	for _, name := range []string{"question.eml", "fixtures.env", "cassettes/question.json", "golden/question.path.txt", "golden/question.reply.txt", "golden/question.reply.html"} {
+		data, err := os.ReadFile(filepath.Join("testdata/replay", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cassettePath := filepath.Join(dir, "cassettes", "question.json")
+	tape, err := loadCassette(cassettePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tape.Interactions[0].Request = json.RawMessage(strings.Replace(string(tape.Interactions[0].Request), "You are composing an email reply.", "An older prompt.", 1))
+	if err := saveCassette(cassettePath, tape); err != nil {
+		t.Fatal(err)
+	}
+	config := Config{EnvPath: "-", ConfigPath: "testdata/replay/config.json", Output: io.Discard, LogOutput: io.Discard}
+
+	results, err := RunFixtures(context.Background(), config, FixtureOptions{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(results[0].Error, "re-record needed") || !strings.Contains(results[0].Error, "body.input[0].content") {
+		t.Fatalf("replay with a changed prompt = %+v", results[0])
+	}
+
+	results, err = RunFixtures(context.Background(), config, FixtureOptions{Dir: dir, Record: true, Stub: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Error != "" || len(results[0].Changed) > 0 {
+		t.Fatalf("stub re-record = %+v", results[0])
+	}
+	rerecorded, err := loadCassette(cassettePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rerecorded.Interactions[0].Request), "You are composing an email reply.") || !strings.Contains(string(rerecorded.Interactions[0].Response), "resp_altitude") {
+		t.Fatalf("stub re-record did not keep the response and refresh the request: %s", rerecorded.Interactions[0].Request)
+	}
+
+	results, err = RunFixtures(context.Background(), config, FixtureOptions{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Error != "" || len(results[0].Changed) > 0 {
+		t.Fatalf("replay after stub re-record = %+v", results[0])
+	}
+}
@@ -0,0 +1,371 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	appconfig "ai-over-email/pkg/config"
+)
+
+const imapTimeout = 60 * time.Second
+
+var (
+	imapUIDPattern         = regexp.MustCompile(`\bUID (\d+)`)
+	imapUIDValidityPattern = regexp.MustCompile(`\[UIDVALIDITY (\d+)\]`)
+	imapUIDNextPattern     = regexp.MustCompile(`\[UIDNEXT (\d+)\]`)
+	imapLiteralPattern     = regexp.MustCompile(`\{(\d+)\}$`)
+)
+
+type imapClient struct {
+	conn         net.Conn
+	reader       *bufio.Reader
+	tag          int
+	capabilities map[string]bool
+}
+
+type imapResponse struct {
+	Line     string
+	Literals [][]byte
+}
+
+type imapMailboxStatus struct {
+	UIDValidity uint32
+	UIDNext     uint32
+	Exists      int
+}
+
+func dialIMAP(cfg appconfig.IMAPConfig, timeout time.Duration) (*imapClient, error) {
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	dialer := &net.Dialer{Timeout: timeout}
+	tlsConfig := &tls.Config{ServerName: cfg.TLSServerName, MinVersion: tls.VersionTLS12}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = cfg.Host
+	}
+
+	var conn net.Conn
+	var err error
+	if cfg.Security == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connect IMAP: %w", err)
+	}
+	client, err := newIMAPClient(conn)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Security == "starttls" {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.conn.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+func newIMAPClient(conn net.Conn) (*imapClient, error) {
+	client := &imapClient{conn: conn, reader: bufio.NewReader(conn)}
+	_ = conn.SetDeadline(time.Now().Add(imapTimeout))
+	greeting, err := client.readResponse()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("IMAP greeting: %w", err)
+	}
+	if !strings.HasPrefix(greeting.Line, "* OK") && !strings.HasPrefix(greeting.Line, "* PREAUTH") {
+		conn.Close()
+		return nil, fmt.Errorf("IMAP greeting: %s", greeting.Line)
+	}
+	return client, nil
+}
+
+func (c *imapClient) Close() error {
+	_, _ = c.execute("LOGOUT")
+	return c.conn.Close()
+}
+
+func (c *imapClient) StartTLS(tlsConfig *tls.Config) error {
+	if _, err := c.execute("STARTTLS"); err != nil {
+		return err
+	}
+	conn := tls.Client(c.conn, tlsConfig)
+	if err := conn.Handshake(); err != nil {
+		return fmt.Errorf("IMAP STARTTLS: %w", err)
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+	return nil
+}
+
+func (c *imapClient) Login(username, password string) error {
+	if _, err := c.execute("LOGIN %s %s", imapQuote(username), imapQuote(password)); err != nil {
+		return fmt.Errorf("IMAP LOGIN: %w", err)
+	}
+	return c.Capability()
+}
+
+func (c *imapClient) Capability() error {
+	responses, err := c.execute("CAPABILITY")
+	if err != nil {
+		return err
+	}
+	c.capabilities = map[string]bool{}
+	for _, response := range responses {
+		if rest, ok := strings.CutPrefix(response.Line, "* CAPABILITY "); ok {
+			for _, name := range strings.Fields(rest) {
+				c.capabilities[strings.ToUpper(name)] = true
+			}
+		}
+	}
+	return nil
+}
+
+func (c *imapClient) Has(capability string) bool {
+	return c.capabilities[strings.ToUpper(capability)]
+}
+
+func (c *imapClient) Select(mailbox string) (imapMailboxStatus, error) {
+	responses, err := c.execute("SELECT %s", imapQuote(mailbox))
+	if err != nil {
+		return imapMailboxStatus{}, fmt.Errorf("IMAP SELECT %s: %w", mailbox, err)
+	}
+	var status imapMailboxStatus
+	for _, response := range responses {
+		if match := imapUIDValidityPattern.FindStringSubmatch(response.Line); match != nil {
+			status.UIDValidity = parseUID(match[1])
+		}
+		if match := imapUIDNextPattern.FindStringSubmatch(response.Line); match != nil {
+			status.UIDNext = parseUID(match[1])
+		}
+		fields := strings.Fields(response.Line)
+		if len(fields) == 3 && fields[0] == "*" && strings.EqualFold(fields[2], "EXISTS") {
+			status.Exists, _ = strconv.Atoi(fields[1])
+		}
+	}
+	if status.UIDValidity == 0 {
+		return imapMailboxStatus{}, fmt.Errorf("IMAP SELECT %s: server did not report UIDVALIDITY", mailbox)
+	}
+	return status, nil
+}
+
+func (c *imapClient) SearchUIDs(criteria string) ([]uint32, error) {
+	responses, err := c.execute("UID SEARCH %s", criteria)
+	if err != nil {
+		return nil, fmt.Errorf("IMAP SEARCH: %w", err)
+	}
+	var uids []uint32
+	for _, response := range responses {
+		rest, ok := strings.CutPrefix(response.Line, "* SEARCH")
+		if !ok {
+			continue
+		}
+		for _, field := range strings.Fields(rest) {
+			if uid := parseUID(field); uid > 0 {
+				uids = append(uids, uid)
+			}
+		}
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids, nil
+}
+
+func (c *imapClient) FetchUIDs(uids []uint32, item string) (map[uint32][]byte, error) {
+	if len(uids) == 0 {
+		return map[uint32][]byte{}, nil
+	}
+	responses, err := c.execute("UID FETCH %s (UID %s)", imapUIDSet(uids), item)
+	if err != nil {
+		return nil, fmt.Errorf("IMAP FETCH: %w", err)
+	}
+	messages := make(map[uint32][]byte, len(uids))
+	for _, response := range responses {
+		if !strings.Contains(strings.ToUpper(response.Line), " FETCH ") || len(response.Literals) == 0 {
+			continue
+		}
+		match := imapUIDPattern.FindStringSubmatch(response.Line)
+		if match == nil {
+			continue
+		}
+		messages[parseUID(match[1])] = response.Literals[0]
+	}
+	return messages, nil
+}
+
+func (c *imapClient) Move(uid uint32, mailbox string) error {
+	if c.Has("MOVE") {
+		if _, err := c.execute("UID MOVE %d %s", uid, imapQuote(mailbox)); err != nil {
+			return fmt.Errorf("IMAP MOVE: %w", err)
+		}
+		return nil
+	}
+	if _, err := c.execute("UID COPY %d %s", uid, imapQuote(mailbox)); err != nil {
+		return fmt.Errorf("IMAP COPY: %w", err)
+	}
+	_, err := c.Delete(uid)
+	return err
+}
+
+// Delete flags uid \Deleted and, when the server supports UIDPLUS, expunges
+// only that message. Without UIDPLUS the message stays flagged for the
+// mailbox owner to expunge: a bare EXPUNGE would also remove every other
+// message a user or client flagged, so it is never sent. The result reports
+// whether the message was expunged.
+func (c *imapClient) Delete(uid uint32) (bool, error) {
+	if _, err := c.execute(`UID STORE %d +FLAGS.SILENT (\Deleted)`, uid); err != nil {
+		return false, fmt.Errorf("IMAP STORE: %w", err)
+	}
+	if !c.Has("UIDPLUS") {
+		return false, nil
+	}
+	if _, err := c.execute("UID EXPUNGE %d", uid); err != nil {
+		return false, fmt.Errorf("IMAP EXPUNGE: %w", err)
+	}
+	return true, nil
+}
+
+func (c *imapClient) Idle(ctx context.Context, timeout time.Duration) (bool, error) {
+	tag := c.nextTag()
+	if err := c.send(tag + " IDLE"); err != nil {
+		return false, err
+	}
+	_ = c.conn.SetDeadline(time.Now().Add(imapTimeout))
+	response, err := c.readResponse()
+	if err != nil {
+		return false, err
+	}
+	if !strings.HasPrefix(response.Line, "+") {
+		return false, fmt.Errorf("IMAP IDLE: %s", response.Line)
+	}
+
+	stop := context.AfterFunc(ctx, func() { _ = c.conn.SetReadDeadline(time.Now()) })
+	defer stop()
+	_ = c.conn.SetReadDeadline(time.Now().Add(timeout))
+	changed := false
+	for {
+		response, err := c.readResponse()
+		if err != nil {
+			var netErr net.Error
+			if !errors.As(err, &netErr) || !netErr.Timeout() {
+				return false, err
+			}
+			break
+		}
+		fields := strings.Fields(response.Line)
+		if len(fields) >= 3 && fields[0] == "*" && strings.EqualFold(fields[2], "EXISTS") {
+			changed = true
+			break
+		}
+	}
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+
+	_ = c.conn.SetDeadline(time.Now().Add(imapTimeout))
+	if err := c.send("DONE"); err != nil {
+		return false, err
+	}
+	if _, err := c.readUntilTagged(tag); err != nil {
+		return false, fmt.Errorf("IMAP IDLE: %w", err)
+	}
+	return changed, nil
+}
+
+func (c *imapClient) execute(format string, args ...any) ([]imapResponse, error) {
+	_ = c.conn.SetDeadline(time.Now().Add(imapTimeout))
+	tag := c.nextTag()
+	if err := c.send(tag + " " + fmt.Sprintf(format, args...)); err != nil {
+		return nil, err
+	}
+	return c.readUntilTagged(tag)
+}
+
+func (c *imapClient) readUntilTagged(tag string) ([]imapResponse, error) {
+	var responses []imapResponse
+	for {
+		response, err := c.readResponse()
+		if err != nil {
+			return nil, err
+		}
+		rest, ok := strings.CutPrefix(response.Line, tag+" ")
+		if !ok {
+			responses = append(responses, response)
+			continue
+		}
+		status, text, _ := strings.Cut(rest, " ")
+		if !strings.EqualFold(status, "OK") {
+			return responses, fmt.Errorf("%s %s", status, text)
+		}
+		return responses, nil
+	}
+}
+
+func (c *imapClient) readResponse() (imapResponse, error) {
+	var response imapResponse
+	var line strings.Builder
+	for {
+		part, err := c.reader.ReadString('\n')
+		if err != nil {
+			return imapResponse{}, err
+		}
+		part = strings.TrimRight(part, "\r\n")
+		line.WriteString(part)
+		match := imapLiteralPattern.FindStringSubmatch(part)
+		if match == nil {
+			response.Line = line.String()
+			return response, nil
+		}
+		size, err := strconv.Atoi(match[1])
+		if err != nil || size > maxAttachmentBytes*2 {
+			return imapResponse{}, fmt.Errorf("IMAP literal too large: %s", match[1])
+		}
+		literal := make([]byte, size)
+		if _, err := io.ReadFull(c.reader, literal); err != nil {
+			return imapResponse{}, err
+		}
+		response.Literals = append(response.Literals, literal)
+	}
+}
+
+func (c *imapClient) send(line string) error {
+	if _, err := io.WriteString(c.conn, line+"\r\n"); err != nil {
+		return fmt.Errorf("IMAP write: %w", err)
+	}
+	return nil
+}
+
+func (c *imapClient) nextTag() string {
+	c.tag++
+	return fmt.Sprintf("A%04d", c.tag)
+}
+
+func imapQuote(value string) string {
+	value = strings.NewReplacer("\r", "", "\n", "").Replace(value)
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+func imapUIDSet(uids []uint32) string {
+	parts := make([]string, 0, len(uids))
+	for _, uid := range uids {
+		parts = append(parts, strconv.FormatUint(uint64(uid), 10))
+	}
+	return strings.Join(parts, ",")
+}
+
+func parseUID(value string) uint32 {
+	uid, err := strconv.ParseUint(strings.TrimSpace(value), 10, 32)
+	if err != nil {
+		return 0
+	}
+	return uint32(uid)
+}
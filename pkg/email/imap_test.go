@@ -0,0 +1,436 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	appconfig "ai-over-email/pkg/config"
+)
+
+type fakeIMAPServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	messages map[uint32]string
+	moved    map[uint32]string
+	deleted  map[uint32]bool
+	commands []string
+	caps     string
+	nextUID  uint32
+	validity uint32
+	notify   chan struct{}
+	idling   chan struct{}
+}
+
+func newFakeIMAPServer(t *testing.T, messages map[uint32]string, nextUID uint32) *fakeIMAPServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeIMAPServer{listener: listener, messages: messages, moved: map[uint32]string{}, deleted: map[uint32]bool{}, caps: "IMAP4rev1 IDLE MOVE UIDPLUS", nextUID: nextUID, validity: 7, notify: make(chan struct{}, 1), idling: make(chan struct{}, 1)}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *fakeIMAPServer) config(t *testing.T) appconfig.IMAPConfig {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	return appconfig.IMAPConfig{
+		Host:           host,
+		Port:           portNumber,
+		Security:       "none",
+		Mailbox:        "INBOX",
+		ArchiveMailbox: "Archive",
+		PollInterval:   "1m",
+		StatePath:      filepath.Join(t.TempDir(), "imap-state.json"),
+	}
+}
+
+func (s *fakeIMAPServer) deliver(raw string) {
+	s.mu.Lock()
+	s.messages[s.nextUID] = raw
+	s.nextUID++
+	s.mu.Unlock()
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// recreate replaces the mailbox with an empty one under a new UIDVALIDITY.
+func (s *fakeIMAPServer) recreate(validity uint32, nextUID uint32) {
+	s.mu.Lock()
+	s.messages, s.validity, s.nextUID = map[uint32]string{}, validity, nextUID
+	s.mu.Unlock()
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *fakeIMAPServer) waitForIdle(t *testing.T) {
+	t.Helper()
+	select {
+	case <-s.idling:
+	case <-time.After(3 * time.Second):
+		t.Fatal("watcher did not start IDLE")
+	}
+}
+
+func (s *fakeIMAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	write := func(format string, args ...any) { fmt.Fprintf(conn, format+"\r\n", args...) }
+	write("* OK fake IMAP ready")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		tag, command, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		upper := strings.ToUpper(command)
+		s.mu.Lock()
+		s.commands = append(s.commands, upper)
+		switch {
+		case strings.HasPrefix(upper, "LOGIN "):
+			write("%s OK logged in", tag)
+		case upper == "CAPABILITY":
+			write("* CAPABILITY %s", s.caps)
+			write("%s OK done", tag)
+		case strings.HasPrefix(upper, "SELECT "):
+			write("* %d EXISTS", len(s.messages))
+			write("* OK [UIDVALIDITY %d] ok", s.validity)
+			write("* OK [UIDNEXT %d] ok", s.nextUID)
+			write("%s OK [READ-WRITE] selected", tag)
+		case strings.HasPrefix(upper, "UID SEARCH "):
+			var from uint32
+			if rest, ok := strings.CutPrefix(upper, "UID SEARCH UID "); ok {
+				from = parseUID(strings.TrimSuffix(rest, ":*"))
+			}
+			var uids []string
+			for _, uid := range s.sortedUIDs() {
+				if uid >= from {
+					uids = append(uids, strconv.Itoa(int(uid)))
+				}
+			}
+			write("* SEARCH %s", strings.Join(uids, " "))
+			write("%s OK searched", tag)
+		case strings.HasPrefix(upper, "UID FETCH "):
+			fields := strings.Fields(command)
+			for index, value := range strings.Split(fields[2], ",") {
+				uid := parseUID(value)
+				raw, ok := s.messages[uid]
+				if !ok {
+					continue
+				}
+				item := "BODY[]"
+				if strings.Contains(upper, "[HEADER]") {
+					item = "BODY[HEADER]"
+					raw = raw[:strings.Index(raw, "\r\n\r\n")+4]
+				}
+				fmt.Fprintf(conn, "* %d FETCH (UID %d %s {%d}\r\n%s)\r\n", index+1, uid, item, len(raw), raw)
+			}
+			write("%s OK fetched", tag)
+		case strings.HasPrefix(upper, "UID MOVE "):
+			fields := strings.Fields(command)
+			uid := parseUID(fields[2])
+			s.moved[uid] = strings.Trim(fields[3], `"`)
+			delete(s.messages, uid)
+			write("%s OK moved", tag)
+		case strings.HasPrefix(upper, "UID COPY "):
+			fields := strings.Fields(command)
+			s.moved[parseUID(fields[2])] = strings.Trim(fields[3], `"`)
+			write("%s OK copied", tag)
+		case strings.HasPrefix(upper, "UID STORE "):
+			s.deleted[parseUID(strings.Fields(command)[2])] = true
+			write("%s OK stored", tag)
+		case strings.HasPrefix(upper, "UID EXPUNGE "):
+			uid := parseUID(strings.Fields(command)[2])
+			if s.deleted[uid] {
+				delete(s.messages, uid)
+			}
+			write("%s OK expunged", tag)
+		case upper == "EXPUNGE":
+			for uid := range s.deleted {
+				delete(s.messages, uid)
+			}
+			write("%s OK expunged", tag)
+		case upper == "IDLE":
+			s.mu.Unlock()
+			write("+ idling")
+			select {
+			case s.idling <- struct{}{}:
+			default:
+			}
+			select {
+			case <-s.notify:
+				s.mu.Lock()
+				write("* %d EXISTS", len(s.messages))
+				s.mu.Unlock()
+			case <-time.After(5 * time.Second):
+			}
+			if _, err := reader.ReadString('\n'); err != nil {
+				return
+			}
+			s.mu.Lock()
+			write("%s OK idle done", tag)
+		case upper == "LOGOUT":
+			write("* BYE")
+			write("%s OK bye", tag)
+			s.mu.Unlock()
+			return
+		default:
+			write("%s BAD unsupported", tag)
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *fakeIMAPServer) sortedUIDs() []uint32 {
+	uids := make([]uint32, 0, len(s.messages))
+	for uid := range s.messages {
+		uids = append(uids, uid)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids
+}
+
+func testIMAPMessage(subject string) string {
+	return strings.ReplaceAll("From: Sender <sender@mail.test>\nTo: assistant@mail.test\nSubject: "+subject+"\nMessage-ID: <"+strings.ReplaceAll(subject, " ", "-")+"@mail.test>\n\nBody for "+subject+"\n", "\n", "\r\n")
+}
+
+func TestIMAPTransportWatchFetchAndDispose(t *testing.T) {
+	server := newFakeIMAPServer(t, map[uint32]string{3: testIMAPMessage("old message")}, 4)
+	transport := newIMAPTransport(server.config(t), appconfig.SMTPConfig{}, Credentials{IMAPUsername: "assistant", IMAPPassword: "secret"}, nil)
+	if err := transport.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if transport.state.UIDValidity != 7 || transport.state.LastUID != 3 {
+		t.Fatalf("state = %#v, want baseline at uidvalidity 7 last uid 3", transport.state)
+	}
+
+	scanned, err := transport.Scan(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scanned) != 1 || scanned[0].ID != "7.3" || scanned[0].Subject != "old message" {
+		t.Fatalf("Scan = %#v", scanned)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	delivered := make(chan []emailMessage, 1)
+	go transport.Watch(ctx, func(_ context.Context, messages []emailMessage) { delivered <- messages })
+	server.waitForIdle(t)
+	server.deliver(testIMAPMessage("new message"))
+
+	var messages []emailMessage
+	select {
+	case messages = <-delivered:
+	case <-time.After(3 * time.Second):
+		t.Fatal("IDLE did not deliver the new message")
+	}
+	if len(messages) != 1 || messages[0].ID != "7.4" || messages[0].Subject != "new message" {
+		t.Fatalf("delivered = %#v", messages)
+	}
+
+	full, err := transport.Fetch(context.Background(), "7.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(extractEmailBody(full)); got != "Body for new message" {
+		t.Fatalf("body = %q", got)
+	}
+	if _, err := transport.Fetch(context.Background(), "6.4"); err == nil || !strings.Contains(err.Error(), "UIDVALIDITY") {
+		t.Fatalf("Fetch with stale uidvalidity error = %v", err)
+	}
+
+	if err := transport.Dispose(context.Background(), "7.4"); err != nil {
+		t.Fatal(err)
+	}
+	server.mu.Lock()
+	moved := server.moved[4]
+	server.mu.Unlock()
+	if moved != "Archive" {
+		t.Fatalf("message 4 moved to %q, want Archive", moved)
+	}
+
+	saved, err := loadIMAPState(transport.config.StatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.LastUID != 4 {
+		t.Fatalf("saved last uid = %d, want 4", saved.LastUID)
+	}
+}
+
+func TestIMAPReadResponseHandlesLiterals(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		fmt.Fprint(server, "* 1 FETCH (UID 9 BODY[] {5}\r\nhello FLAGS (\\Seen))\r\n")
+		server.Close()
+	}()
+
+	imap := &imapClient{conn: client, reader: bufio.NewReader(client)}
+	response, err := imap.readResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Literals) != 1 || string(response.Literals[0]) != "hello" {
+		t.Fatalf("literals = %q", response.Literals)
+	}
+	if !strings.Contains(response.Line, "FLAGS (\\Seen))") {
+		t.Fatalf("line = %q", response.Line)
+	}
+}
+
+func TestIMAPQuoteEscapes(t *testing.T) {
+	if got := imapQuote(`pa"ss\word`); got != `"pa\"ss\\word"` {
+		t.Fatalf("imapQuote = %s", got)
+	}
+}
+
+func TestIMAPTransportConnectReadOnlyKeepsState(t *testing.T) {
+	server := newFakeIMAPServer(t, map[uint32]string{3: testIMAPMessage("old message")}, 4)
+	config := server.config(t)
+	if err := saveIMAPState(config.StatePath, imapState{UIDValidity: 5, LastUID: 40}); err != nil {
+		t.Fatal(err)
+	}
+	transport := newIMAPTransport(config, appconfig.SMTPConfig{}, Credentials{IMAPUsername: "assistant", IMAPPassword: "secret"}, nil)
+	if err := transport.ConnectReadOnly(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transport.Fetch(context.Background(), "7.3"); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := loadIMAPState(config.StatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved != (imapState{UIDValidity: 5, LastUID: 40}) {
+		t.Fatalf("saved state = %#v, want it untouched by a read-only connect", saved)
+	}
+}
+
+func TestIMAPTransportWatchResetsOnUIDValidityChange(t *testing.T) {
+	server := newFakeIMAPServer(t, map[uint32]string{3: testIMAPMessage("old message")}, 4)
+	transport := newIMAPTransport(server.config(t), appconfig.SMTPConfig{}, Credentials{IMAPUsername: "assistant", IMAPPassword: "secret"}, nil)
+	if err := transport.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	delivered := make(chan []emailMessage, 1)
+	go transport.Watch(ctx, func(_ context.Context, messages []emailMessage) { delivered <- messages })
+	server.waitForIdle(t)
+	server.recreate(9, 2)
+	server.waitForIdle(t)
+
+	transport.mu.Lock()
+	state := transport.state
+	transport.mu.Unlock()
+	if state != (imapState{UIDValidity: 9, LastUID: 1}) {
+		t.Fatalf("state after recreate = %#v, want uidvalidity 9 last uid 1", state)
+	}
+	saved, err := loadIMAPState(transport.config.StatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved != state {
+		t.Fatalf("saved state = %#v, want %#v", saved, state)
+	}
+
+	server.deliver(testIMAPMessage("after recreate"))
+	select {
+	case messages := <-delivered:
+		if len(messages) != 1 || messages[0].ID != "9.2" || messages[0].Subject != "after recreate" {
+			t.Fatalf("delivered = %#v", messages)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("IDLE did not deliver the message in the recreated mailbox")
+	}
+	if _, err := transport.Fetch(context.Background(), "9.2"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIMAPTransportDisposeWithoutUIDPLUSNeverExpungesMailbox(t *testing.T) {
+	server := newFakeIMAPServer(t, map[uint32]string{
+		3: testIMAPMessage("kept by user"),
+		4: testIMAPMessage("answered"),
+		5: testIMAPMessage("archived"),
+	}, 6)
+	server.caps = "IMAP4rev1 IDLE"
+	server.deleted[3] = true
+	config := server.config(t)
+	config.ArchiveMailbox = ""
+	transport := newIMAPTransport(config, appconfig.SMTPConfig{}, Credentials{IMAPUsername: "assistant", IMAPPassword: "secret"}, nil)
+	if err := transport.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Dispose(context.Background(), "7.4"); err != nil {
+		t.Fatal(err)
+	}
+	transport.config.ArchiveMailbox = "Archive"
+	if err := transport.Dispose(context.Background(), "7.5"); err != nil {
+		t.Fatal(err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for _, command := range server.commands {
+		if strings.Contains(command, "EXPUNGE") {
+			t.Fatalf("server without UIDPLUS received %q", command)
+		}
+	}
+	if len(server.messages) != 3 {
+		t.Fatalf("messages = %d, want all three left in the mailbox", len(server.messages))
+	}
+	if !server.deleted[4] || !server.deleted[5] || server.moved[5] != "Archive" {
+		t.Fatalf("deleted = %v moved = %v, want 4 and 5 flagged and 5 copied to Archive", server.deleted, server.moved)
+	}
+}
+
+func TestIMAPTransportDisposeWithUIDPLUSExpungesOnlyThatMessage(t *testing.T) {
+	server := newFakeIMAPServer(t, map[uint32]string{3: testIMAPMessage("kept by user"), 4: testIMAPMessage("answered")}, 5)
+	server.caps = "IMAP4rev1 UIDPLUS"
+	server.deleted[3] = true
+	config := server.config(t)
+	config.ArchiveMailbox = ""
+	transport := newIMAPTransport(config, appconfig.SMTPConfig{}, Credentials{IMAPUsername: "assistant", IMAPPassword: "secret"}, nil)
+	if err := transport.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Dispose(context.Background(), "7.4"); err != nil {
+		t.Fatal(err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if _, ok := server.messages[3]; !ok {
+		t.Fatal("message flagged by the user was expunged")
+	}
+	if _, ok := server.messages[4]; ok {
+		t.Fatal("answered message was not expunged")
+	}
+}
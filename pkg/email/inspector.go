@@ -91,11 +91,14 @@ func NewInspector(config Config) (*Inspector, error) {
 		return nil, err
 	}
 
+	client := newJMAPClient(creds, config.LogOutput)
+	client.useTransport(config.HTTPTransport)
+
 	return &Inspector{
 		config:    config,
 		creds:     creds,
 		appConfig: appConfig,
-		client:    newJMAPClient(creds, config.LogOutput),
+		client:    client,
 	}, nil
 }
 
@@ -130,7 +133,7 @@ func (i *Inspector) SearchMessages(ctx context.Context, opts SearchOptions) ([]M
 			"accountId": i.accountID,
 			"filter":    filter,
 			"sort":      []map[string]any{{"property": "receivedAt", "isAscending": false}},
-			"limit":     limit,
+			"limit":     i.client.clampGetLimit(limit),
 		}, "query"},
 		{"Email/get", map[string]any{
 			"accountId":           i.accountID,
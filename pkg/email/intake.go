@@ -0,0 +1,215 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	appconfig "ai-over-email/pkg/config"
+	"ai-over-email/pkg/mimebuild"
+)
+
+var errMalformedMessage = errors.New("malformed message")
+
+// intakeConfigError marks a permanent config or credentials problem, so pipe
+// can report it instead of asking the MTA to retry.
+type intakeConfigError struct {
+	err error
+}
+
+func (e intakeConfigError) Error() string {
+	return e.err.Error()
+}
+
+func (e intakeConfigError) Unwrap() error {
+	return e.err
+}
+
+type IntakeOptions struct {
+	Reply       string
+	ReplyOutput io.Writer
+}
+
+type Intake struct {
+	watcher   *Watcher
+	transport *intakeTransport
+	counter   atomic.Uint64
+}
+
+type intakeTransport struct {
+	config    appconfig.IntakeConfig
+	creds     Credentials
+	output    io.Writer
+	logOutput io.Writer
+
+	mu       sync.Mutex
+	messages map[string]emailMessage
+}
+
+func NewIntake(config Config, options IntakeOptions) (*Intake, error) {
+	config = normalizeConfig(config)
+
+	logf(config.LogOutput, "loading application config from %s", config.ConfigPath)
+	appConfig, err := appconfig.LoadIntake(config.ConfigPath)
+	if err != nil {
+		return nil, intakeConfigError{err}
+	}
+	intakeConfig := appConfig.Intake
+	if options.Reply != "" {
+		intakeConfig.Reply = options.Reply
+	}
+	if err := intakeConfig.Validate(); err != nil {
+		return nil, intakeConfigError{err}
+	}
+	intakeConfig = intakeConfig.Normalized()
+	logf(config.LogOutput, "intake config loaded: reply=%s from=%s smtp_host=%s", intakeConfig.Reply, intakeConfig.FromAddress, intakeConfig.SMTP.Host)
+
+	logf(config.LogOutput, "loading credentials from environment with optional env file %s", config.EnvPath)
+	creds, err := loadCredentials(config.EnvPath, "intake")
+	if err != nil {
+		return nil, intakeConfigError{err}
+	}
+	creds = credentialsFrom(creds, intakeConfig.FromAddress)
+	logf(config.LogOutput, "credentials loaded: username_present=%t smtp_username_present=%t openai_token_present=%t brave_search_token_present=%t", creds.Username != "", creds.SMTPUsername != "", creds.OpenAIAPIToken != "", creds.BraveSearchAPIToken != "")
+
+	output := options.ReplyOutput
+	if output == nil {
+		output = os.Stdout
+	}
+	transport := &intakeTransport{
+		config:    intakeConfig,
+		creds:     creds,
+		output:    output,
+		logOutput: config.LogOutput,
+		messages:  make(map[string]emailMessage),
+	}
+	watcher, err := openWatcher(config, appConfig, creds, transport)
+	if err != nil {
+		return nil, err
+	}
+	watcher.connected = true
+	return &Intake{watcher: watcher, transport: transport}, nil
+}
+
+func (in *Intake) Deliver(ctx context.Context, raw []byte, source string) error {
+	msg, err := parseRFC822Message(raw)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errMalformedMessage, err)
+	}
+	msg.ID = fmt.Sprintf("%s-%d-%d", source, time.Now().Unix(), in.counter.Add(1))
+
+	w := in.watcher
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	in.transport.store(msg)
+	defer in.transport.forget(msg.ID)
+	if reason := w.skipAutoReplyReason(msg); reason != "" {
+		w.handleAutoReplyGuard(ctx, msg, reason, source)
+		return nil
+	}
+	w.logf("%s accepted message: id=%s from=%q subject=%q bytes=%d", source, msg.ID, formatFrom(msg.From), msg.Subject, len(raw))
+	fmt.Fprintf(w.config.Output, "FROM: %s\tSUBJECT: %s\n", formatFrom(msg.From), msg.Subject)
+	return w.maybeAutoReply(ctx, msg)
+}
+
+func (in *Intake) Close() error {
+	if in.watcher.store == nil {
+		return nil
+	}
+	return in.watcher.store.Close()
+}
+
+func (in *Intake) ListenAddress() string {
+	return in.transport.config.LMTPListen
+}
+
+func IsMalformedMessage(err error) bool {
+	return errors.Is(err, errMalformedMessage)
+}
+
+// IsConfigError reports whether NewIntake failed on the config file or the
+// credentials rather than on something worth retrying.
+func IsConfigError(err error) bool {
+	var configErr intakeConfigError
+	return errors.As(err, &configErr)
+}
+
+func (t *intakeTransport) store(msg emailMessage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.messages[msg.ID] = msg
+}
+
+func (t *intakeTransport) forget(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.messages, id)
+}
+
+func (t *intakeTransport) Connect(ctx context.Context) error {
+	return nil
+}
+
+func (t *intakeTransport) Watch(ctx context.Context, deliver func(context.Context, []emailMessage)) error {
+	return fmt.Errorf("intake transport receives mail from an MTA and cannot watch a mailbox")
+}
+
+func (t *intakeTransport) Scan(ctx context.Context, limit int) ([]emailMessage, error) {
+	return nil, nil
+}
+
+func (t *intakeTransport) Fetch(ctx context.Context, id string) (emailMessage, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	msg, ok := t.messages[id]
+	if !ok {
+		return emailMessage{}, fmt.Errorf("message %s not found", id)
+	}
+	return msg, nil
+}
+
+func (t *intakeTransport) Attachments(ctx context.Context, msg emailMessage) ([]emailAttachment, error) {
+	return extractDecryptedAttachments(string(msg.Raw)), nil
+}
+
+func (t *intakeTransport) Submit(ctx context.Context, msg outgoingEmail) error {
+	from := emailAddress{Name: t.config.FromName, Email: t.config.FromAddress}
+	raw, err := buildRFC822(from, msg, time.Now(), mimebuild.NewMessageID(t.config.FromAddress))
+	if err != nil {
+		return err
+	}
+	if t.config.Reply == appconfig.IntakeReplyStdout {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if _, err := t.output.Write(raw); err != nil {
+			return fmt.Errorf("write reply: %w", err)
+		}
+		t.logf("reply written to stdout: recipients=%d bytes=%d", len(msg.To), len(raw))
+		return nil
+	}
+
+	recipients := make([]string, 0, len(msg.To))
+	for _, address := range msg.To {
+		recipients = append(recipients, address.Email)
+	}
+	if err := sendSMTP(t.config.SMTP, t.creds.SMTPUsername, t.creds.SMTPPassword, recipients, raw); err != nil {
+		return err
+	}
+	t.logf("reply submitted over SMTP: host=%s recipients=%d bytes=%d", t.config.SMTP.Host, len(recipients), len(raw))
+	return nil
+}
+
+func (t *intakeTransport) Dispose(ctx context.Context, id string) error {
+	t.forget(id)
+	return nil
+}
+
+func (t *intakeTransport) logf(format string, args ...any) {
+	logf(t.logOutput, format, args...)
+}
@@ -5,11 +5,13 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	appconfig "ai-over-email/pkg/config"
@@ -19,6 +21,7 @@ const (
 	capCore       = "urn:ietf:params:jmap:core"
 	capMail       = "urn:ietf:params:jmap:mail"
 	capSubmission = "urn:ietf:params:jmap:submission"
+	capWebSocket  = "urn:ietf:params:jmap:websocket"
 )
 
 type authMode int
@@ -26,6 +29,7 @@ type authMode int
 const (
 	authBasic authMode = iota
 	authBearer
+	authOAuth
 )
 
 type jmapClient struct {
@@ -34,16 +38,34 @@ type jmapClient struct {
 	creds       Credentials
 	auth        authMode
 	session     Session
+	limits      coreCapability
+	oauth       *oauthTokenSource
+	endpoint    string
 	logOutput   io.Writer
+	uploadSlots chan struct{}
+
+	socketMu sync.Mutex
+	socket   *jmapSocket
 }
 
 type Session struct {
-	Accounts        map[string]Account `json:"accounts"`
-	PrimaryAccounts map[string]string  `json:"primaryAccounts"`
-	APIURL          string             `json:"apiUrl"`
-	EventSourceURL  string             `json:"eventSourceUrl"`
-	DownloadURL     string             `json:"downloadUrl"`
-	UploadURL       string             `json:"uploadUrl"`
+	Capabilities    map[string]json.RawMessage `json:"capabilities"`
+	Accounts        map[string]Account         `json:"accounts"`
+	PrimaryAccounts map[string]string          `json:"primaryAccounts"`
+	APIURL          string                     `json:"apiUrl"`
+	EventSourceURL  string                     `json:"eventSourceUrl"`
+	DownloadURL     string                     `json:"downloadUrl"`
+	UploadURL       string                     `json:"uploadUrl"`
+}
+
+type coreCapability struct {
+	MaxSizeUpload         int64 `json:"maxSizeUpload"`
+	MaxConcurrentUpload   int   `json:"maxConcurrentUpload"`
+	MaxSizeRequest        int64 `json:"maxSizeRequest"`
+	MaxConcurrentRequests int   `json:"maxConcurrentRequests"`
+	MaxCallsInRequest     int   `json:"maxCallsInRequest"`
+	MaxObjectsInGet       int   `json:"maxObjectsInGet"`
+	MaxObjectsInSet       int   `json:"maxObjectsInSet"`
 }
 
 type Account struct {
@@ -89,34 +111,81 @@ func newJMAPClient(creds Credentials, logOutput io.Writer) *jmapClient {
 	}
 }
 
+func (c *jmapClient) useTransport(transport http.RoundTripper) {
+	if transport == nil {
+		return
+	}
+	c.httpClient.Transport = transport
+	c.eventClient.Transport = transport
+}
+
 func (c *jmapClient) FetchSession(ctx context.Context, config appconfig.ConfigStruct) error {
 	var attempts []string
 
+	endpoint := config.JMAP.SessionEndpoint
+	if endpoint == "" && config.JMAP.Autodiscover {
+		discovered, err := c.discoverSessionEndpoint(ctx)
+		if err != nil {
+			return err
+		}
+		endpoint = discovered
+	}
+	basicEndpoint := config.JMAP.LegacyBasicAuthSessionEndpoint
+	if basicEndpoint == "" {
+		basicEndpoint = endpoint
+	}
+	if c.creds.Token == "" && isFastmailEndpoint(endpoint) && looksLikeFastmailAPIToken(c.creds.Password) {
+		c.creds.Token = c.creds.Password
+		c.creds.Password = ""
+	}
+
+	if config.JMAP.OAuth.TokenURL != "" && c.creds.OAuthRefreshToken != "" {
+		c.auth = authOAuth
+		c.oauth = newOAuthTokenSource(c.httpClient, config.JMAP.OAuth, c.creds, c.logOutput)
+		c.logf("attempting JMAP session with OAuth: endpoint=%s token_url=%s", endpoint, config.JMAP.OAuth.TokenURL)
+		if err := c.fetchSessionAt(ctx, endpoint); err == nil {
+			c.logSession(endpoint)
+			return nil
+		} else {
+			c.logf("OAuth JMAP session attempt failed: endpoint=%s err=%v", endpoint, err)
+			attempts = append(attempts, err.Error())
+		}
+	}
+
 	if c.creds.Token != "" {
 		c.auth = authBearer
-		c.logf("attempting JMAP session with bearer token: endpoint=%s", config.JMAP.SessionEndpoint)
-		if err := c.fetchSessionAt(ctx, config.JMAP.SessionEndpoint); err == nil {
-			c.logSession(config.JMAP.SessionEndpoint)
+		c.logf("attempting JMAP session with bearer token: endpoint=%s", endpoint)
+		if err := c.fetchSessionAt(ctx, endpoint); err == nil {
+			c.logSession(endpoint)
 			return nil
 		} else {
-			c.logf("bearer JMAP session attempt failed: endpoint=%s err=%v", config.JMAP.SessionEndpoint, err)
+			c.logf("bearer JMAP session attempt failed: endpoint=%s err=%v", endpoint, err)
 			attempts = append(attempts, err.Error())
 		}
 	}
 
 	if c.creds.Username != "" && c.creds.Password != "" {
 		c.auth = authBasic
-		c.logf("attempting JMAP session with Basic auth: endpoint=%s username_present=%t", config.JMAP.LegacyBasicAuthSessionEndpoint, c.creds.Username != "")
-		if err := c.fetchSessionAt(ctx, config.JMAP.LegacyBasicAuthSessionEndpoint); err == nil {
-			c.logSession(config.JMAP.LegacyBasicAuthSessionEndpoint)
+		c.logf("attempting JMAP session with Basic auth: endpoint=%s username_present=%t", basicEndpoint, c.creds.Username != "")
+		if err := c.fetchSessionAt(ctx, basicEndpoint); err == nil {
+			c.logSession(basicEndpoint)
 			return nil
 		} else {
-			c.logf("Basic auth JMAP session attempt failed: endpoint=%s err=%v", config.JMAP.LegacyBasicAuthSessionEndpoint, err)
+			c.logf("Basic auth JMAP session attempt failed: endpoint=%s err=%v", basicEndpoint, err)
 			attempts = append(attempts, err.Error())
 		}
 	}
 
-	return fmt.Errorf("could not open JMAP session; Fastmail's current JMAP API requires a JMAP API token in AI_OVER_EMAIL_FASTMAIL_TOKEN; attempts: %s", strings.Join(attempts, " | "))
+	return fmt.Errorf("could not open JMAP session; Fastmail's current JMAP API requires a JMAP API token in AI_OVER_EMAIL_FASTMAIL_TOKEN, other servers accept AI_OVER_EMAIL_JMAP_TOKEN, a password, or an OAuth refresh token; attempts: %s", strings.Join(attempts, " | "))
+}
+
+func isFastmailEndpoint(endpoint string) bool {
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	return host == "fastmail.com" || strings.HasSuffix(host, ".fastmail.com")
 }
 
 func (c *jmapClient) fetchSessionAt(ctx context.Context, endpoint string) error {
@@ -124,11 +193,10 @@ func (c *jmapClient) fetchSessionAt(ctx context.Context, endpoint string) error
 	if err != nil {
 		return err
 	}
-	c.authorize(req)
 
 	start := time.Now()
 	c.logf("JMAP session request: method=%s url=%s auth=%s", req.Method, req.URL.Redacted(), c.auth.String())
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.send(c.httpClient, req)
 	if err != nil {
 		return fmt.Errorf("get JMAP session: %w", err)
 	}
@@ -149,8 +217,22 @@ func (c *jmapClient) fetchSessionAt(ctx context.Context, endpoint string) error
 	if c.session.APIURL == "" {
 		return fmt.Errorf("JMAP session from %s did not include apiUrl", endpoint)
 	}
-	if c.session.EventSourceURL == "" {
-		return fmt.Errorf("JMAP session from %s did not include eventSourceUrl", endpoint)
+	if _, ok := c.session.Capabilities[capMail]; !ok {
+		return fmt.Errorf("JMAP session from %s does not advertise %s", endpoint, capMail)
+	}
+	c.limits = coreCapability{}
+	if raw, ok := c.session.Capabilities[capCore]; ok {
+		if err := json.Unmarshal(raw, &c.limits); err != nil {
+			return fmt.Errorf("decode JMAP core capability: %w", err)
+		}
+	}
+	c.uploadSlots = nil
+	if c.limits.MaxConcurrentUpload > 0 {
+		c.uploadSlots = make(chan struct{}, c.limits.MaxConcurrentUpload)
+	}
+	c.endpoint = endpoint
+	if _, ok := c.WebSocketCapability(); !ok && c.session.EventSourceURL == "" {
+		return fmt.Errorf("JMAP session from %s did not include eventSourceUrl or a WebSocket capability", endpoint)
 	}
 
 	return nil
@@ -167,13 +249,44 @@ func (c *jmapClient) AccountID() (string, error) {
 }
 
 func (c *jmapClient) Call(ctx context.Context, calls []methodCall) (responseEnvelope, error) {
+	batches, err := splitMethodCalls(calls, c.limits.MaxCallsInRequest)
+	if err != nil {
+		return responseEnvelope{}, err
+	}
+	if len(batches) > 1 {
+		c.logf("JMAP request split for server limit: calls=%d batches=%d max_calls_in_request=%d", len(calls), len(batches), c.limits.MaxCallsInRequest)
+	}
+	var envelope responseEnvelope
+	for _, batch := range batches {
+		response, err := c.callBatch(ctx, batch)
+		if err != nil {
+			return responseEnvelope{}, err
+		}
+		envelope.MethodResponses = append(envelope.MethodResponses, response.MethodResponses...)
+	}
+	return envelope, nil
+}
+
+func (c *jmapClient) callBatch(ctx context.Context, calls []methodCall) (responseEnvelope, error) {
+	using := c.using()
 	body, err := json.Marshal(requestEnvelope{
-		Using:       []string{capCore, capMail, capSubmission},
+		Using:       using,
 		MethodCalls: calls,
 	})
 	if err != nil {
 		return responseEnvelope{}, err
 	}
+	if c.limits.MaxSizeRequest > 0 && int64(len(body)) > c.limits.MaxSizeRequest {
+		return responseEnvelope{}, fmt.Errorf("JMAP request of %d bytes exceeds server maxSizeRequest %d: methods=%s", len(body), c.limits.MaxSizeRequest, methodCallNames(calls))
+	}
+
+	if socket := c.activeSocket(); socket != nil {
+		envelope, err := socket.Call(ctx, using, calls)
+		if !errors.Is(err, errJMAPSocketClosed) {
+			return envelope, err
+		}
+		c.logf("JMAP WebSocket unavailable for call; using HTTP: err=%v", err)
+	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.session.APIURL, bytes.NewReader(body))
 	if err != nil {
@@ -181,11 +294,10 @@ func (c *jmapClient) Call(ctx context.Context, calls []methodCall) (responseEnve
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
-	c.authorize(req)
 
 	start := time.Now()
 	c.logf("JMAP API request: url=%s methods=%s bytes=%d", req.URL.Redacted(), methodCallNames(calls), len(body))
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.send(c.httpClient, req)
 	if err != nil {
 		return responseEnvelope{}, fmt.Errorf("JMAP API call: %w", err)
 	}
@@ -205,6 +317,146 @@ func (c *jmapClient) Call(ctx context.Context, calls []methodCall) (responseEnve
 	return envelope, nil
 }
 
+func (c *jmapClient) using() []string {
+	using := []string{capCore, capMail}
+	if _, ok := c.session.Capabilities[capSubmission]; ok || len(c.session.Capabilities) == 0 {
+		using = append(using, capSubmission)
+	}
+	return using
+}
+
+func (c *jmapClient) clampGetLimit(limit int) int {
+	if c.limits.MaxObjectsInGet > 0 && limit > c.limits.MaxObjectsInGet {
+		return c.limits.MaxObjectsInGet
+	}
+	return limit
+}
+
+func splitMethodCalls(calls []methodCall, maxCalls int) ([][]methodCall, error) {
+	if maxCalls <= 0 || len(calls) <= maxCalls {
+		return [][]methodCall{calls}, nil
+	}
+
+	// A batch may only end where no later call refers back across the cut,
+	// either to an earlier call's result or to an object it creates.
+	callIndex := make(map[string]int, len(calls))
+	creationIndex := make(map[string]int)
+	segmentStart := make([]int, len(calls))
+	for i, call := range calls {
+		segmentStart[i] = i
+		if len(call) == 3 {
+			if id, ok := call[2].(string); ok {
+				callIndex[id] = i
+			}
+		}
+		if len(call) < 2 {
+			continue
+		}
+		args, err := methodCallArguments(call[1])
+		if err != nil {
+			return nil, err
+		}
+		var refs []int
+		for key, value := range args {
+			if ref, ok := resultReferenceOf(value); ok && strings.HasPrefix(key, "#") {
+				if j, ok := callIndex[ref]; ok {
+					refs = append(refs, j)
+				}
+				continue
+			}
+			for _, id := range creationReferences(value) {
+				if j, ok := creationIndex[id]; ok {
+					refs = append(refs, j)
+				}
+			}
+		}
+		for _, j := range refs {
+			for k := j + 1; k <= i; k++ {
+				segmentStart[k] = min(segmentStart[k], segmentStart[j])
+			}
+		}
+		if create, ok := args["create"].(map[string]any); ok {
+			for id := range create {
+				creationIndex[id] = i
+			}
+		}
+	}
+
+	var segments [][]methodCall
+	for i := 0; i < len(calls); {
+		end := i + 1
+		for end < len(calls) && segmentStart[end] < end {
+			end++
+		}
+		segments = append(segments, calls[i:end])
+		i = end
+	}
+
+	var batches [][]methodCall
+	var batch []methodCall
+	for _, segment := range segments {
+		if len(segment) > maxCalls {
+			return nil, fmt.Errorf("JMAP server allows %d calls per request, but %s must run together", maxCalls, methodCallNames(segment))
+		}
+		if len(batch)+len(segment) > maxCalls {
+			batches = append(batches, batch)
+			batch = nil
+		}
+		batch = append(batch, segment...)
+	}
+	return append(batches, batch), nil
+}
+
+// methodCallArguments returns a call's arguments as decoded JSON, so that
+// nested references can be found whatever Go types built them.
+func methodCallArguments(value any) (map[string]any, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("encode JMAP method arguments: %w", err)
+	}
+	var args map[string]any
+	if err := json.Unmarshal(data, &args); err != nil {
+		return nil, fmt.Errorf("decode JMAP method arguments: %w", err)
+	}
+	return args, nil
+}
+
+// creationReferences lists the creation ids referred to as "#id", either as a
+// string value (an emailId) or as an object key (onSuccessUpdateEmail).
+func creationReferences(value any) []string {
+	var ids []string
+	switch value := value.(type) {
+	case string:
+		if id, ok := strings.CutPrefix(value, "#"); ok && id != "" {
+			ids = append(ids, id)
+		}
+	case []any:
+		for _, item := range value {
+			ids = append(ids, creationReferences(item)...)
+		}
+	case map[string]any:
+		for key, item := range value {
+			if id, ok := strings.CutPrefix(key, "#"); ok && id != "" {
+				ids = append(ids, id)
+			}
+			ids = append(ids, creationReferences(item)...)
+		}
+	}
+	return ids
+}
+
+func resultReferenceOf(value any) (string, bool) {
+	switch ref := value.(type) {
+	case map[string]string:
+		return ref["resultOf"], ref["resultOf"] != ""
+	case map[string]any:
+		id, ok := ref["resultOf"].(string)
+		return id, ok
+	default:
+		return "", false
+	}
+}
+
 func (c *jmapClient) Download(ctx context.Context, accountID string, blobID string, name string, contentType string) ([]byte, error) {
 	if c.session.DownloadURL == "" {
 		return nil, fmt.Errorf("JMAP session did not include downloadUrl")
@@ -220,11 +472,10 @@ func (c *jmapClient) Download(ctx context.Context, accountID string, blobID stri
 		return nil, err
 	}
 	req.Header.Set("Accept", contentType)
-	c.authorize(req)
 
 	start := time.Now()
 	c.logf("JMAP download request: url=%s blob_id=%s", req.URL.Redacted(), blobID)
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.send(c.httpClient, req)
 	if err != nil {
 		return nil, fmt.Errorf("JMAP download: %w", err)
 	}
@@ -246,6 +497,17 @@ func (c *jmapClient) Upload(ctx context.Context, accountID string, name string,
 	if c.session.UploadURL == "" {
 		return uploadResponse{}, fmt.Errorf("JMAP session did not include uploadUrl")
 	}
+	if c.limits.MaxSizeUpload > 0 && int64(len(data)) > c.limits.MaxSizeUpload {
+		return uploadResponse{}, fmt.Errorf("JMAP upload %q of %d bytes exceeds server maxSizeUpload %d", name, len(data), c.limits.MaxSizeUpload)
+	}
+	if c.uploadSlots != nil {
+		select {
+		case c.uploadSlots <- struct{}{}:
+			defer func() { <-c.uploadSlots }()
+		case <-ctx.Done():
+			return uploadResponse{}, ctx.Err()
+		}
+	}
 	uploadURL := expandURLTemplate(c.session.UploadURL, map[string]string{
 		"accountId": accountID,
 	})
@@ -258,11 +520,10 @@ func (c *jmapClient) Upload(ctx context.Context, accountID string, name string,
 	if name != "" {
 		req.Header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
 	}
-	c.authorize(req)
 
 	start := time.Now()
 	c.logf("JMAP upload request: url=%s name=%q type=%s bytes=%d", req.URL.Redacted(), name, contentType, len(data))
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.send(c.httpClient, req)
 	if err != nil {
 		return uploadResponse{}, fmt.Errorf("JMAP upload: %w", err)
 	}
@@ -295,22 +556,55 @@ func (c *jmapClient) NewEventSourceRequest(ctx context.Context, lastEventID stri
 	if lastEventID != "" {
 		req.Header.Set("Last-Event-ID", lastEventID)
 	}
-	c.authorize(req)
 	return req, nil
 }
 
 func (c *jmapClient) Do(req *http.Request) (*http.Response, error) {
 	c.logf("HTTP request: method=%s url=%s accept=%s", req.Method, req.URL.Redacted(), req.Header.Get("Accept"))
-	return c.eventClient.Do(req)
+	return c.send(c.eventClient, req)
+}
+
+func (c *jmapClient) send(client *http.Client, req *http.Request) (*http.Response, error) {
+	if err := c.authorize(req); err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || c.auth != authOAuth {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	c.logf("JMAP request unauthorized; refreshing OAuth access token: url=%s", req.URL.Redacted())
+	c.oauth.Invalidate(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+	if err := c.authorize(retry); err != nil {
+		return nil, err
+	}
+	return client.Do(retry)
 }
 
-func (c *jmapClient) authorize(req *http.Request) {
-	if c.auth == authBearer {
+func (c *jmapClient) authorize(req *http.Request) error {
+	switch c.auth {
+	case authOAuth:
+		token, err := c.oauth.Token(req.Context())
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case authBearer:
 		req.Header.Set("Authorization", "Bearer "+c.creds.Token)
-		return
+	default:
+		raw := c.creds.Username + ":" + c.creds.Password
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(raw)))
 	}
-	raw := c.creds.Username + ":" + c.creds.Password
-	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(raw)))
+	return nil
 }
 
 func expandEventSourceURL(tmpl string, values map[string]string) string {
@@ -353,7 +647,9 @@ func expandQueryTemplate(tmpl string, values map[string]string, prefix string, s
 }
 
 func (c *jmapClient) logSession(endpoint string) {
-	c.logf("JMAP session established: endpoint=%s api_url=%s event_source_template=%s accounts=%d primary_mail_account=%s", endpoint, c.session.APIURL, c.session.EventSourceURL, len(c.session.Accounts), c.session.PrimaryAccounts[capMail])
+	webSocket, _ := c.WebSocketCapability()
+	c.logf("JMAP session established: endpoint=%s api_url=%s event_source_template=%s websocket_url=%s websocket_push=%t accounts=%d primary_mail_account=%s", endpoint, c.session.APIURL, c.session.EventSourceURL, webSocket.URL, webSocket.SupportsPush, len(c.session.Accounts), c.session.PrimaryAccounts[capMail])
+	c.logf("JMAP server limits: max_calls_in_request=%d max_objects_in_get=%d max_objects_in_set=%d max_size_request=%d max_size_upload=%d max_concurrent_upload=%d", c.limits.MaxCallsInRequest, c.limits.MaxObjectsInGet, c.limits.MaxObjectsInSet, c.limits.MaxSizeRequest, c.limits.MaxSizeUpload, c.limits.MaxConcurrentUpload)
 }
 
 func (c *jmapClient) logf(format string, args ...any) {
@@ -395,6 +691,8 @@ func (a authMode) String() string {
 		return "bearer"
 	case authBasic:
 		return "basic"
+	case authOAuth:
+		return "oauth"
 	default:
 		return "unknown"
 	}
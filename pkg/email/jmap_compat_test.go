@@ -0,0 +1,204 @@
+package email
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	appconfig "ai-over-email/pkg/config"
+	"ai-over-email/pkg/jmaptest"
+)
+
+// newGenericJMAPServer stands in for a non-Fastmail JMAP server: tight core
+// limits, no submission capability, and an OAuth refresh endpoint.
+func newGenericJMAPServer(t *testing.T) *jmaptest.Server {
+	server := jmaptest.NewServer()
+	t.Cleanup(server.Close)
+	server.Limits = jmaptest.Limits{
+		MaxSizeUpload:         16,
+		MaxConcurrentUpload:   1,
+		MaxSizeRequest:        4096,
+		MaxConcurrentRequests: 1,
+		MaxCallsInRequest:     2,
+		MaxObjectsInGet:       3,
+		MaxObjectsInSet:       3,
+	}
+	server.Capabilities = []string{jmaptest.CapabilityCore, jmaptest.CapabilityMail}
+	server.OAuthClientID = "ai-over-email"
+	return server
+}
+
+func genericJMAPTransport(server *jmaptest.Server, jmap appconfig.JMAPConfig, creds Credentials) *jmapTransport {
+	creds.Mailbox = "inbox"
+	transport := newJMAPTransport(appconfig.ConfigStruct{JMAP: jmap}, creds, nil)
+	transport.client.httpClient = server.Client()
+	transport.client.eventClient = server.Client()
+	return transport
+}
+
+func TestJMAPGenericServerLimitsWithBasicAuth(t *testing.T) {
+	server := newGenericJMAPServer(t)
+	transport := genericJMAPTransport(server,
+		appconfig.JMAPConfig{SessionEndpoint: server.SessionURL()},
+		Credentials{Username: server.Username, Password: server.Password},
+	)
+	ctx := context.Background()
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	if transport.client.auth != authBasic || transport.identityID != "identity-1" || transport.emailState != server.State() {
+		t.Fatalf("connected with auth=%s identity=%q state=%q", transport.client.auth, transport.identityID, transport.emailState)
+	}
+	if _, err := transport.Scan(ctx, 25); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if err := transport.syncEmailChanges(ctx, func(context.Context, []emailMessage) {}); err != nil {
+		t.Fatalf("syncEmailChanges: %v", err)
+	}
+	if _, err := transport.client.Upload(ctx, server.AccountID, "big.bin", "application/octet-stream", make([]byte, 17)); err == nil || !strings.Contains(err.Error(), "maxSizeUpload") {
+		t.Fatalf("oversize upload error = %v", err)
+	}
+
+	requests := server.Requests()
+	if len(requests) < 3 {
+		t.Fatalf("initialization was not split: %d requests", len(requests))
+	}
+	var queryLimits, changesLimits []any
+	for _, request := range requests {
+		if len(request.Calls) > 2 {
+			t.Fatalf("request with %d calls, want at most maxCallsInRequest", len(request.Calls))
+		}
+		if slices.Contains(request.Using, jmaptest.CapabilitySubmission) {
+			t.Fatal("request used the submission capability the session did not advertise")
+		}
+		for _, call := range request.Calls {
+			switch call.Name {
+			case "Email/query":
+				queryLimits = append(queryLimits, call.Args["limit"])
+			case "Email/changes":
+				changesLimits = append(changesLimits, call.Args["maxChanges"])
+			}
+		}
+	}
+	if len(queryLimits) == 0 || queryLimits[len(queryLimits)-1] != float64(3) {
+		t.Fatalf("Scan query limits = %v, want maxObjectsInGet", queryLimits)
+	}
+	if len(changesLimits) != 1 || changesLimits[0] != float64(3) {
+		t.Fatalf("maxChanges = %v, want maxObjectsInGet", changesLimits)
+	}
+}
+
+func TestJMAPOAuthRefreshesAfterUnauthorized(t *testing.T) {
+	server := newGenericJMAPServer(t)
+	transport := genericJMAPTransport(server,
+		appconfig.JMAPConfig{
+			SessionEndpoint: server.SessionURL(),
+			OAuth:           appconfig.JMAPOAuthConfig{TokenURL: server.URL + "/oauth/token", ClientID: "ai-over-email"},
+		},
+		Credentials{OAuthRefreshToken: "refresh-0"},
+	)
+	ctx := context.Background()
+	if err := transport.Connect(ctx); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	server.RevokeToken()
+	if _, err := transport.Scan(ctx, 1); err != nil {
+		t.Fatalf("Scan after token revocation: %v", err)
+	}
+
+	if refreshes := server.Refreshes(); !slices.Equal(refreshes, []string{"refresh-0", "refresh-1"}) {
+		t.Fatalf("refresh tokens used = %q, want the rotated token on the second refresh", refreshes)
+	}
+	if transport.client.auth != authOAuth {
+		t.Fatalf("auth = %s", transport.client.auth)
+	}
+}
+
+func TestJMAPAutodiscoveryFollowsWellKnownRedirect(t *testing.T) {
+	server := newGenericJMAPServer(t)
+	host := strings.TrimPrefix(server.URL, "https://")
+	transport := genericJMAPTransport(server,
+		appconfig.JMAPConfig{Autodiscover: true},
+		Credentials{Username: "user@" + host, Token: server.Token},
+	)
+	if err := transport.client.FetchSession(context.Background(), transport.appConfig); err != nil {
+		t.Fatalf("FetchSession: %v", err)
+	}
+	if want := server.SessionURL(); transport.client.endpoint != want {
+		t.Fatalf("endpoint = %q, want %q", transport.client.endpoint, want)
+	}
+}
+
+func TestSplitMethodCallsKeepsBackReferencesTogether(t *testing.T) {
+	calls := []methodCall{
+		{"Mailbox/get", map[string]any{}, "a"},
+		{"Email/query", map[string]any{}, "b"},
+		{"Email/get", map[string]any{"#ids": map[string]string{"resultOf": "b"}}, "c"},
+	}
+	batches, err := splitMethodCalls(calls, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 2 || len(batches[0]) != 1 || len(batches[1]) != 2 {
+		t.Fatalf("batches = %v", batches)
+	}
+	if _, err := splitMethodCalls(calls, 1); err == nil {
+		t.Fatal("splitMethodCalls split a back-reference across requests")
+	}
+}
+
+func TestSplitMethodCallsKeepsCreationReferencesTogether(t *testing.T) {
+	calls := []methodCall{
+		{"Mailbox/get", map[string]any{}, "a"},
+		{"Email/set", map[string]any{"create": map[string]any{"reply": map[string]any{"subject": "#not-a-reference"}}}, "emailSet"},
+		{"EmailSubmission/set", map[string]any{
+			"onSuccessUpdateEmail": map[string]any{"#submission": map[string]any{"keywords/$draft": nil}},
+			"create":               map[string]any{"submission": map[string]any{"emailId": "#reply"}},
+		}, "submissionSet"},
+	}
+	batches, err := splitMethodCalls(calls, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 2 || len(batches[0]) != 1 || len(batches[1]) != 2 {
+		t.Fatalf("batches = %v", batches)
+	}
+	if _, err := splitMethodCalls(calls, 1); err == nil {
+		t.Fatal("splitMethodCalls separated an email from the submission that sends it")
+	}
+
+	independent := []methodCall{
+		{"Email/set", map[string]any{"create": map[string]any{"draft": map[string]any{}}}, "a"},
+		{"Email/set", map[string]any{"create": map[string]any{"other": map[string]any{"subject": "#reply"}}}, "b"},
+	}
+	if batches, err := splitMethodCalls(independent, 1); err != nil || len(batches) != 2 {
+		t.Fatalf("batches = %v, %v; want calls without shared creation ids split", batches, err)
+	}
+}
+
+func TestJMAPPasswordShapedLikeFastmailTokenUsesBasicAuthElsewhere(t *testing.T) {
+	server := newGenericJMAPServer(t)
+	server.Password = "fmu1-generic-server-password"
+	transport := genericJMAPTransport(server,
+		appconfig.JMAPConfig{SessionEndpoint: server.SessionURL()},
+		Credentials{Username: server.Username, Password: server.Password},
+	)
+	if err := transport.client.FetchSession(context.Background(), transport.appConfig); err != nil {
+		t.Fatalf("FetchSession: %v", err)
+	}
+	if transport.client.auth != authBasic {
+		t.Fatalf("auth = %s, want Basic auth on a non-Fastmail server", transport.client.auth)
+	}
+
+	for endpoint, want := range map[string]bool{
+		"https://api.fastmail.com/jmap/session": true,
+		"https://FASTMAIL.com/.well-known/jmap": true,
+		"https://jmap.example.com/session":      false,
+		"https://fastmail.com.example.net/jmap": false,
+	} {
+		if got := isFastmailEndpoint(endpoint); got != want {
+			t.Fatalf("isFastmailEndpoint(%q) = %t, want %t", endpoint, got, want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var lookupSRV = net.DefaultResolver.LookupSRV
+
+func (c *jmapClient) discoverSessionEndpoint(ctx context.Context) (string, error) {
+	domain := addressDomain(c.creds.Username)
+	if domain == "" {
+		domain = addressDomain(c.creds.PublicEmail)
+	}
+	if domain == "" {
+		return "", fmt.Errorf("JMAP autodiscovery needs a mailbox address in AI_OVER_EMAIL_USERNAME")
+	}
+
+	host := domain
+	if _, _, err := net.SplitHostPort(domain); err != nil {
+		if _, records, err := lookupSRV(ctx, "jmap", "tcp", domain); err == nil && len(records) > 0 && records[0].Target != "." {
+			host = net.JoinHostPort(strings.TrimSuffix(records[0].Target, "."), strconv.Itoa(int(records[0].Port)))
+			c.logf("JMAP SRV record found: domain=%s target=%s", domain, host)
+		} else {
+			c.logf("JMAP SRV record not found; using domain: domain=%s err=%v", domain, err)
+		}
+	}
+
+	wellKnown := "https://" + host + "/.well-known/jmap"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return "", fmt.Errorf("JMAP autodiscovery: %w", err)
+	}
+	client := &http.Client{
+		Timeout:   20 * time.Second,
+		Transport: c.httpClient.Transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	c.logf("JMAP autodiscovery request: url=%s", wellKnown)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("JMAP autodiscovery at %s: %w", wellKnown, err)
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 300 && resp.StatusCode < 400:
+		location, err := resp.Location()
+		if err != nil {
+			return "", fmt.Errorf("JMAP autodiscovery at %s: %s without a usable Location: %w", wellKnown, resp.Status, err)
+		}
+		if location.Scheme != "https" {
+			return "", fmt.Errorf("JMAP autodiscovery at %s redirected to non-HTTPS %s", wellKnown, location.Redacted())
+		}
+		c.logf("JMAP autodiscovery redirected: session_endpoint=%s", location.Redacted())
+		return location.String(), nil
+	case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusUnauthorized:
+		c.logf("JMAP autodiscovery serves the session directly: session_endpoint=%s", wellKnown)
+		return wellKnown, nil
+	default:
+		return "", fmt.Errorf("JMAP autodiscovery at %s: %s", wellKnown, resp.Status)
+	}
+}
+
+func addressDomain(address string) string {
+	_, domain, ok := strings.Cut(strings.TrimSpace(address), "@")
+	if !ok {
+		return ""
+	}
+	return strings.ToLower(domain)
+}
@@ -0,0 +1,120 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	appconfig "ai-over-email/pkg/config"
+)
+
+const oauthExpiryMargin = time.Minute
+
+type oauthTokenSource struct {
+	httpClient   *http.Client
+	config       appconfig.JMAPOAuthConfig
+	clientSecret string
+	logOutput    io.Writer
+
+	mu           sync.Mutex
+	refreshToken string
+	accessToken  string
+	expiry       time.Time
+}
+
+type oauthTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func newOAuthTokenSource(httpClient *http.Client, config appconfig.JMAPOAuthConfig, creds Credentials, logOutput io.Writer) *oauthTokenSource {
+	return &oauthTokenSource{
+		httpClient:   httpClient,
+		config:       config,
+		clientSecret: creds.OAuthClientSecret,
+		refreshToken: creds.OAuthRefreshToken,
+		logOutput:    logOutput,
+	}
+}
+
+func (s *oauthTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.accessToken != "" && (s.expiry.IsZero() || time.Now().Add(oauthExpiryMargin).Before(s.expiry)) {
+		return s.accessToken, nil
+	}
+	return s.refresh(ctx)
+}
+
+func (s *oauthTokenSource) Invalidate(stale string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.accessToken == stale {
+		s.accessToken = ""
+	}
+}
+
+func (s *oauthTokenSource) refresh(ctx context.Context) (string, error) {
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {s.refreshToken},
+		"client_id":     {s.config.ClientID},
+	}
+	if s.config.Scope != "" {
+		form.Set("scope", s.config.Scope)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if s.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(s.config.ClientID), url.QueryEscape(s.clientSecret))
+	}
+
+	start := time.Now()
+	logf(s.logOutput, "OAuth token refresh request: url=%s client_id=%s", req.URL.Redacted(), s.config.ClientID)
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("refresh OAuth access token: %w", err)
+	}
+	defer resp.Body.Close()
+	logf(s.logOutput, "OAuth token refresh response: status=%s duration=%s", resp.Status, time.Since(start).Round(time.Millisecond))
+
+	var token oauthTokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil && resp.StatusCode < 300 {
+		return "", fmt.Errorf("decode OAuth token response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 || token.Error != "" {
+		return "", fmt.Errorf("refresh OAuth access token: %s: %s %s", resp.Status, token.Error, token.ErrorDescription)
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("refresh OAuth access token: response did not include access_token")
+	}
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return "", fmt.Errorf("refresh OAuth access token: unsupported token_type %q", token.TokenType)
+	}
+
+	s.accessToken = token.AccessToken
+	s.expiry = time.Time{}
+	if token.ExpiresIn > 0 {
+		s.expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	if token.RefreshToken != "" && token.RefreshToken != s.refreshToken {
+		s.refreshToken = token.RefreshToken
+		logf(s.logOutput, "OAuth refresh token rotated by server; keeping the new token for this process")
+	}
+	logf(s.logOutput, "OAuth access token refreshed: expires_in=%ds", token.ExpiresIn)
+	return s.accessToken, nil
+}
@@ -0,0 +1,284 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	jmapSocketCallTimeout  = 20 * time.Second
+	jmapSocketPingInterval = 30 * time.Second
+	jmapSocketReadTimeout  = 90 * time.Second
+)
+
+var errJMAPSocketClosed = errors.New("JMAP WebSocket closed")
+
+type webSocketCapability struct {
+	URL          string `json:"url"`
+	SupportsPush bool   `json:"supportsPush"`
+}
+
+type jmapSocket struct {
+	conn      *wsConn
+	logOutput io.Writer
+	pushes    chan stateChange
+	done      chan struct{}
+
+	mu      sync.Mutex
+	nextID  int
+	pending map[string]chan socketResult
+	err     error
+}
+
+type socketResult struct {
+	envelope responseEnvelope
+	err      error
+}
+
+type socketRequest struct {
+	Type        string       `json:"@type"`
+	ID          string       `json:"id"`
+	Using       []string     `json:"using"`
+	MethodCalls []methodCall `json:"methodCalls"`
+}
+
+type socketPushEnable struct {
+	Type      string   `json:"@type"`
+	DataTypes []string `json:"dataTypes"`
+	PushState string   `json:"pushState,omitempty"`
+}
+
+type socketMessage struct {
+	Type            string                       `json:"@type"`
+	RequestID       string                       `json:"requestId"`
+	MethodResponses []methodResponse             `json:"methodResponses"`
+	Changed         map[string]map[string]string `json:"changed"`
+	PushState       string                       `json:"pushState"`
+	ErrorType       string                       `json:"type"`
+	Status          int                          `json:"status"`
+	Detail          string                       `json:"detail"`
+}
+
+func (c *jmapClient) WebSocketCapability() (webSocketCapability, bool) {
+	raw, ok := c.session.Capabilities[capWebSocket]
+	if !ok {
+		return webSocketCapability{}, false
+	}
+	var capability webSocketCapability
+	if err := json.Unmarshal(raw, &capability); err != nil || capability.URL == "" {
+		return webSocketCapability{}, false
+	}
+	return capability, true
+}
+
+func (c *jmapClient) OpenSocket(ctx context.Context, url string) (*jmapSocket, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.session.APIURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.authorize(req); err != nil {
+		return nil, err
+	}
+	c.logf("connecting to JMAP WebSocket: url=%s auth=%s", url, c.auth.String())
+	conn, err := dialWebSocket(ctx, c.eventClient.Transport, url, req.Header, "jmap")
+	if err != nil {
+		return nil, err
+	}
+	socket := &jmapSocket{
+		conn:      conn,
+		logOutput: c.logOutput,
+		pushes:    make(chan stateChange, 16),
+		done:      make(chan struct{}),
+		pending:   make(map[string]chan socketResult),
+	}
+	go socket.readLoop()
+	go socket.pingLoop()
+
+	c.socketMu.Lock()
+	c.socket = socket
+	c.socketMu.Unlock()
+	return socket, nil
+}
+
+func (c *jmapClient) CloseSocket(socket *jmapSocket) {
+	c.socketMu.Lock()
+	if c.socket == socket {
+		c.socket = nil
+	}
+	c.socketMu.Unlock()
+	socket.Close()
+}
+
+func (c *jmapClient) activeSocket() *jmapSocket {
+	c.socketMu.Lock()
+	defer c.socketMu.Unlock()
+	if c.socket == nil || c.socket.Err() != nil {
+		return nil
+	}
+	return c.socket
+}
+
+func (s *jmapSocket) Call(ctx context.Context, using []string, calls []methodCall) (responseEnvelope, error) {
+	ch := make(chan socketResult, 1)
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return responseEnvelope{}, fmt.Errorf("%w: %v", errJMAPSocketClosed, s.err)
+	}
+	s.nextID++
+	id := "r" + strconv.Itoa(s.nextID)
+	s.pending[id] = ch
+	s.mu.Unlock()
+
+	body, err := json.Marshal(socketRequest{Type: "Request", ID: id, Using: using, MethodCalls: calls})
+	if err != nil {
+		s.forget(id)
+		return responseEnvelope{}, err
+	}
+	start := time.Now()
+	logf(s.logOutput, "JMAP WebSocket request: id=%s methods=%s bytes=%d", id, methodCallNames(calls), len(body))
+	if err := s.conn.WriteMessage(body); err != nil {
+		s.forget(id)
+		s.fail(err)
+		return responseEnvelope{}, fmt.Errorf("%w: %v", errJMAPSocketClosed, err)
+	}
+
+	timer := time.NewTimer(jmapSocketCallTimeout)
+	defer timer.Stop()
+	select {
+	case result := <-ch:
+		if result.err == nil {
+			logf(s.logOutput, "JMAP WebSocket response: id=%s methods=%s duration=%s", id, methodResponseNames(result.envelope.MethodResponses), time.Since(start).Round(time.Millisecond))
+		}
+		return result.envelope, result.err
+	case <-ctx.Done():
+		s.forget(id)
+		return responseEnvelope{}, ctx.Err()
+	case <-timer.C:
+		s.forget(id)
+		return responseEnvelope{}, fmt.Errorf("JMAP WebSocket call timed out after %s", jmapSocketCallTimeout)
+	case <-s.done:
+		return responseEnvelope{}, fmt.Errorf("JMAP WebSocket call: %w", s.Err())
+	}
+}
+
+func (s *jmapSocket) EnablePush(pushState string) error {
+	body, err := json.Marshal(socketPushEnable{Type: "WebSocketPushEnable", DataTypes: []string{"Email"}, PushState: pushState})
+	if err != nil {
+		return err
+	}
+	if err := s.conn.WriteMessage(body); err != nil {
+		s.fail(err)
+		return err
+	}
+	return nil
+}
+
+func (s *jmapSocket) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *jmapSocket) Close() {
+	s.fail(errJMAPSocketClosed)
+}
+
+func (s *jmapSocket) readLoop() {
+	for {
+		_ = s.conn.SetReadDeadline(time.Now().Add(jmapSocketReadTimeout))
+		data, err := s.conn.ReadMessage()
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		var message socketMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			logf(s.logOutput, "JMAP WebSocket message ignored: err=%v bytes=%d", err, len(data))
+			continue
+		}
+		switch message.Type {
+		case "Response":
+			s.resolve(message.RequestID, socketResult{envelope: responseEnvelope{MethodResponses: message.MethodResponses}})
+		case "RequestError":
+			s.resolve(message.RequestID, socketResult{err: fmt.Errorf("JMAP WebSocket request error: type=%s status=%d detail=%s", message.ErrorType, message.Status, message.Detail)})
+		case "StateChange":
+			select {
+			case s.pushes <- stateChange{Type: message.Type, Changed: message.Changed, PushState: message.PushState}:
+			default:
+				logf(s.logOutput, "JMAP WebSocket push dropped: queue full push_state=%s", message.PushState)
+			}
+		default:
+			logf(s.logOutput, "JMAP WebSocket message ignored: type=%q", message.Type)
+		}
+	}
+}
+
+func (s *jmapSocket) pingLoop() {
+	ticker := time.NewTicker(jmapSocketPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if err := s.conn.Ping(); err != nil {
+				s.fail(err)
+				return
+			}
+		}
+	}
+}
+
+func (s *jmapSocket) resolve(id string, result socketResult) {
+	s.mu.Lock()
+	ch, ok := s.pending[id]
+	delete(s.pending, id)
+	s.mu.Unlock()
+	if !ok {
+		logf(s.logOutput, "JMAP WebSocket response ignored: unknown request_id=%q", id)
+		return
+	}
+	ch <- result
+}
+
+func (s *jmapSocket) forget(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, id)
+}
+
+func (s *jmapSocket) fail(err error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.err = err
+	s.pending = map[string]chan socketResult{}
+	s.mu.Unlock()
+	close(s.done)
+	s.conn.Close()
+}
+
+type reconnectBackoff struct {
+	min     time.Duration
+	max     time.Duration
+	current time.Duration
+}
+
+func (b *reconnectBackoff) next(connected time.Duration) time.Duration {
+	if b.current == 0 || connected >= b.max {
+		b.current = b.min
+		return b.current
+	}
+	b.current = min(b.current*2, b.max)
+	return b.current
+}
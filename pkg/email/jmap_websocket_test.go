@@ -0,0 +1,270 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	appconfig "ai-over-email/pkg/config"
+)
+
+type fakeJMAPSocketServer struct {
+	t          *testing.T
+	mu         sync.Mutex
+	connects   int
+	pushStates []string
+	httpCalls  int
+}
+
+func (s *fakeJMAPSocketServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api" {
+		s.mu.Lock()
+		s.httpCalls++
+		s.mu.Unlock()
+		http.Error(w, "calls must use the WebSocket", http.StatusTeapot)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer test-token" || r.Header.Get("Sec-WebSocket-Protocol") != "jmap" {
+		http.Error(w, "bad handshake", http.StatusBadRequest)
+		return
+	}
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		s.t.Error(err)
+		return
+	}
+	defer conn.Close()
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Protocol: jmap\r\nSec-WebSocket-Accept: " + webSocketAccept(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+	rw.Flush()
+
+	s.mu.Lock()
+	s.connects++
+	connection := s.connects
+	s.mu.Unlock()
+
+	send := func(value any) {
+		data, _ := json.Marshal(value)
+		if err := writeWebSocketFrame(conn, wsOpText, data, false); err != nil {
+			s.t.Error(err)
+		}
+	}
+	requests := 0
+	reader := bufio.NewReader(conn)
+	for {
+		_, opcode, payload, err := readWebSocketFrame(reader)
+		if err != nil || opcode == wsOpClose {
+			return
+		}
+		var message map[string]any
+		if err := json.Unmarshal(payload, &message); err != nil {
+			s.t.Errorf("client sent invalid JSON: %s", payload)
+			return
+		}
+		switch message["@type"] {
+		case "WebSocketPushEnable":
+			pushState, _ := message["pushState"].(string)
+			s.mu.Lock()
+			s.pushStates = append(s.pushStates, pushState)
+			s.mu.Unlock()
+		case "Request":
+			requests++
+			changed := connection == 1 && requests == 2
+			var responses []any
+			for _, call := range message["methodCalls"].([]any) {
+				switch call.([]any)[0] {
+				case "Email/changes":
+					changes := map[string]any{"oldState": "s1", "newState": "s1", "created": []string{}}
+					if changed {
+						changes["newState"], changes["created"] = "s2", []string{"m1"}
+					}
+					responses = append(responses, []any{"Email/changes", changes, "changes"})
+				case "Email/get":
+					list := []any{}
+					if changed {
+						list = append(list, map[string]any{"id": "m1", "subject": "pushed", "mailboxIds": map[string]bool{"inbox": true}})
+					}
+					responses = append(responses, []any{"Email/get", map[string]any{"list": list}, "created"})
+				}
+			}
+			send(map[string]any{"@type": "Response", "requestId": message["id"], "methodResponses": responses})
+			if connection == 1 && requests == 1 {
+				send(map[string]any{"@type": "StateChange", "changed": map[string]any{"account": map[string]string{"Email": "s2"}}, "pushState": "push-1"})
+			}
+			if changed {
+				return
+			}
+		}
+	}
+}
+
+func TestJMAPTransportWatchesOverWebSocket(t *testing.T) {
+	server := &fakeJMAPSocketServer{t: t}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	transport := newJMAPTransport(appconfig.ConfigStruct{}, Credentials{Token: "test-token"}, nil)
+	transport.client.auth = authBearer
+	transport.client.session = Session{
+		Capabilities: map[string]json.RawMessage{
+			capWebSocket: json.RawMessage(`{"url":"` + strings.Replace(httpServer.URL, "http://", "ws://", 1) + `/jmap/ws","supportsPush":true}`),
+		},
+		APIURL: httpServer.URL + "/api",
+	}
+	transport.accountID = "account"
+	transport.inboxID = "inbox"
+	transport.emailState = "s1"
+	transport.backoff = reconnectBackoff{min: 10 * time.Millisecond, max: time.Second}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	delivered := make(chan []emailMessage, 4)
+	done := make(chan error, 1)
+	go func() {
+		done <- transport.Watch(ctx, func(_ context.Context, messages []emailMessage) {
+			if len(messages) > 0 {
+				delivered <- messages
+			}
+		})
+	}()
+
+	select {
+	case messages := <-delivered:
+		if len(messages) != 1 || messages[0].ID != "m1" {
+			t.Fatalf("delivered = %#v", messages)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("push over WebSocket did not deliver the new message")
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		server.mu.Lock()
+		states := append([]string(nil), server.pushStates...)
+		server.mu.Unlock()
+		if len(states) >= 2 {
+			if states[0] != "" || states[1] != "push-1" {
+				t.Fatalf("push states = %q, want resumption from push-1", states)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("client did not reconnect; push states = %q", states)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("Watch returned %v", err)
+	}
+	if transport.emailState != "s2" {
+		t.Fatalf("email state = %q, want s2", transport.emailState)
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.httpCalls != 0 {
+		t.Fatalf("method calls used HTTP %d times", server.httpCalls)
+	}
+}
+
+func TestJMAPWebSocketRejectionIsDetected(t *testing.T) {
+	httpServer := httptest.NewServer(http.NotFoundHandler())
+	defer httpServer.Close()
+	_, err := dialWebSocket(context.Background(), nil, strings.Replace(httpServer.URL, "http://", "ws://", 1), nil, "jmap")
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("dialWebSocket error = %v", err)
+	}
+	if !errors.Is(err, errWebSocketRejected) {
+		t.Fatalf("rejection not classified: %v", err)
+	}
+}
+
+func TestDialWebSocketUsesTransportTLSAndProxy(t *testing.T) {
+	server := &fakeJMAPSocketServer{t: t}
+	httpServer := httptest.NewTLSServer(server)
+	defer httpServer.Close()
+
+	var connects []string
+	var mu sync.Mutex
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		mu.Lock()
+		connects = append(connects, r.Host)
+		mu.Unlock()
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer upstream.Close()
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 200 Connection established\r\n\r\n")
+		rw.Flush()
+		go io.Copy(upstream, rw)
+		io.Copy(conn, upstream)
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport := httpServer.Client().Transport.(*http.Transport).Clone()
+	header := http.Header{"Authorization": {"Bearer test-token"}}
+	wsURL := strings.Replace(httpServer.URL, "https://", "wss://", 1) + "/jmap/ws"
+	if _, err := dialWebSocket(context.Background(), nil, wsURL, header, "jmap"); err == nil {
+		t.Fatal("dialWebSocket trusted the test certificate without the transport's roots")
+	}
+	conn, err := dialWebSocket(context.Background(), transport, wsURL, header, "jmap")
+	if err != nil {
+		t.Fatalf("dialWebSocket with the transport's TLS config: %v", err)
+	}
+	conn.Close()
+
+	transport.Proxy = http.ProxyURL(proxyURL)
+	conn, err = dialWebSocket(context.Background(), transport, wsURL, header, "jmap")
+	if err != nil {
+		t.Fatalf("dialWebSocket through the proxy: %v", err)
+	}
+	conn.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	if want := strings.TrimPrefix(httpServer.URL, "https://"); len(connects) != 1 || connects[0] != want {
+		t.Fatalf("proxy CONNECTs = %q, want one to %s", connects, want)
+	}
+}
+
+func TestReconnectBackoffDoublesAndResets(t *testing.T) {
+	backoff := reconnectBackoff{min: time.Second, max: 8 * time.Second}
+	var got []time.Duration
+	for range 5 {
+		got = append(got, backoff.next(0))
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("backoff = %v, want %v", got, want)
+		}
+	}
+	if delay := backoff.next(time.Minute); delay != time.Second {
+		t.Fatalf("backoff after a healthy connection = %s, want reset", delay)
+	}
+}
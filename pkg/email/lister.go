@@ -46,13 +46,16 @@ func NewLister(config Config) (*Lister, error) {
 	if err != nil {
 		return nil, err
 	}
-	logf(config.LogOutput, "application config loaded: jmap_session_endpoint=%s legacy_basic_endpoint=%s", appConfig.JMAP.SessionEndpoint, appConfig.JMAP.LegacyBasicAuthSessionEndpoint)
+	logf(config.LogOutput, "application config loaded: jmap_session_endpoint=%s legacy_basic_endpoint=%s autodiscover=%t oauth=%t", appConfig.JMAP.SessionEndpoint, appConfig.JMAP.LegacyBasicAuthSessionEndpoint, appConfig.JMAP.Autodiscover, appConfig.JMAP.OAuth.TokenURL != "")
+
+	client := newJMAPClient(creds, config.LogOutput)
+	client.useTransport(config.HTTPTransport)
 
 	return &Lister{
 		config:    config,
 		creds:     creds,
 		appConfig: appConfig,
-		client:    newJMAPClient(creds, config.LogOutput),
+		client:    client,
 	}, nil
 }
 
@@ -83,7 +86,7 @@ func (l *Lister) List(ctx context.Context) error {
 			fmt.Fprintf(l.config.Output, "%d\t%s\t%s\t%s\n", printed, cleanListField(msg.ReceivedAt), cleanListField(formatFrom(msg.From)), cleanListField(msg.Subject))
 		}
 
-		if len(query.IDs) < listPageSize {
+		if len(query.IDs) < l.client.clampGetLimit(listPageSize) {
 			l.logf("mailbox listing complete: printed=%d", printed)
 			return nil
 		}
@@ -98,7 +101,7 @@ func (l *Lister) fetchPage(ctx context.Context, position int) (emailQueryRespons
 			"filter":         map[string]any{},
 			"sort":           []map[string]any{{"property": "receivedAt", "isAscending": false}},
 			"position":       position,
-			"limit":          listPageSize,
+			"limit":          l.client.clampGetLimit(listPageSize),
 			"calculateTotal": true,
 		}, "query"},
 		{"Email/get", map[string]any{
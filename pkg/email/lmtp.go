@@ -0,0 +1,195 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	lmtpMaxMessageBytes = 25 << 20
+	lmtpMaxRecipients   = 100
+	lmtpIdleTimeout     = 5 * time.Minute
+)
+
+func (in *Intake) ServeLMTP(ctx context.Context, address string) error {
+	network, addr := "tcp", address
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		network, addr = "unix", path
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove stale LMTP socket: %w", err)
+		}
+	}
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return fmt.Errorf("listen LMTP: %w", err)
+	}
+	return in.serveLMTP(ctx, listener)
+}
+
+func (in *Intake) serveLMTP(ctx context.Context, listener net.Listener) error {
+	in.watcher.logf("LMTP listener ready: network=%s address=%s", listener.Addr().Network(), listener.Addr())
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	var sessions sync.WaitGroup
+	defer sessions.Wait()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				in.watcher.logf("LMTP listener stopped")
+				return ctx.Err()
+			}
+			return fmt.Errorf("accept LMTP connection: %w", err)
+		}
+		sessions.Add(1)
+		go func() {
+			defer sessions.Done()
+			defer conn.Close()
+			in.lmtpSession(ctx, conn)
+		}()
+	}
+}
+
+func (in *Intake) lmtpSession(ctx context.Context, conn net.Conn) {
+	reader := textproto.NewReader(bufio.NewReader(conn))
+	reply := func(format string, args ...any) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "localhost"
+	}
+
+	var from string
+	var recipients []string
+	greeted := false
+	reset := func() {
+		from = ""
+		recipients = nil
+	}
+
+	reply("220 %s LMTP ai-over-email ready", hostname)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(lmtpIdleTimeout))
+		line, err := reader.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "LHLO":
+			greeted = true
+			reset()
+			reply("250-%s", hostname)
+			reply("250-PIPELINING")
+			reply("250-ENHANCEDSTATUSCODES")
+			reply("250-8BITMIME")
+			reply("250 SIZE %d", lmtpMaxMessageBytes)
+		case "MAIL":
+			path, ok := lmtpPath(arg, "FROM:")
+			switch {
+			case !greeted:
+				reply("503 5.5.1 send LHLO first")
+			case from != "":
+				reply("503 5.5.1 nested MAIL command")
+			case !ok:
+				reply("501 5.5.4 syntax: MAIL FROM:<address>")
+			default:
+				from = path
+				if from == "" {
+					from = "<>"
+				}
+				reply("250 2.1.0 sender ok")
+			}
+		case "RCPT":
+			path, ok := lmtpPath(arg, "TO:")
+			switch {
+			case from == "":
+				reply("503 5.5.1 need MAIL before RCPT")
+			case !ok || path == "":
+				reply("501 5.5.4 syntax: RCPT TO:<address>")
+			case len(recipients) >= lmtpMaxRecipients:
+				reply("452 4.5.3 too many recipients")
+			default:
+				recipients = append(recipients, path)
+				reply("250 2.1.5 recipient ok")
+			}
+		case "DATA":
+			if len(recipients) == 0 {
+				reply("503 5.5.1 need RCPT before DATA")
+				continue
+			}
+			reply("354 2.0.0 end data with <CR><LF>.<CR><LF>")
+			data := reader.DotReader()
+			raw, err := io.ReadAll(io.LimitReader(data, lmtpMaxMessageBytes+1))
+			if err == nil {
+				_, err = io.Copy(io.Discard, data)
+			}
+			if err != nil {
+				return
+			}
+			status := in.lmtpDeliver(ctx, raw, from)
+			for range recipients {
+				reply("%s", status)
+			}
+			reset()
+		case "RSET":
+			reset()
+			reply("250 2.0.0 ok")
+		case "NOOP":
+			reply("250 2.0.0 ok")
+		case "VRFY":
+			reply("252 2.5.0 cannot verify")
+		case "QUIT":
+			reply("221 2.0.0 bye")
+			return
+		default:
+			reply("500 5.5.2 command not recognized")
+		}
+	}
+}
+
+func (in *Intake) lmtpDeliver(ctx context.Context, raw []byte, from string) string {
+	if len(raw) > lmtpMaxMessageBytes {
+		in.watcher.logf("LMTP message rejected: from=%s reason=too_large bytes=%d", from, len(raw))
+		return "552 5.3.4 message too large"
+	}
+	err := in.Deliver(ctx, raw, "lmtp")
+	switch {
+	case err == nil:
+		return "250 2.0.0 message accepted"
+	case IsMalformedMessage(err):
+		in.watcher.logf("LMTP message rejected: from=%s err=%v", from, err)
+		return "554 5.6.0 malformed message"
+	default:
+		in.watcher.logf("LMTP message deferred: from=%s err=%v", from, err)
+		return "451 4.3.0 processing failed; try again later"
+	}
+}
+
+func lmtpPath(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	rest := strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(rest, "<") {
+		return "", false
+	}
+	end := strings.Index(rest, ">")
+	if end < 0 {
+		return "", false
+	}
+	return rest[1:end], true
+}
@@ -0,0 +1,151 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestIntake(t *testing.T, replies io.Writer) *Intake {
+	t.Helper()
+	clearCredentialEnv(t)
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.json")
+	// An intake-only config: pipe and lmtp must not need a transport block.
+	if err := os.WriteFile(configPath, []byte(`{
+  "intake": {
+    "reply": "stdout",
+    "from_name": "Assistant",
+    "from_address": "`+testAddress("assistant", "mail.test")+`"
+  }
+}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	envPath := writeTempFile(t, "AI_OVER_EMAIL_OPENAI_API_KEY=test-key\n")
+
+	intake, err := NewIntake(Config{
+		EnvPath:      envPath,
+		ConfigPath:   configPath,
+		DatabasePath: filepath.Join(dir, "correspondents.sqlite3"),
+		Output:       io.Discard,
+		LogOutput:    io.Discard,
+	}, IntakeOptions{ReplyOutput: replies})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { intake.Close() })
+	return intake
+}
+
+func TestIntakeDeliverWritesRFC822Replies(t *testing.T) {
+	var replies bytes.Buffer
+	intake := newTestIntake(t, &replies)
+	raw := strings.ReplaceAll(`From: Sender <`+testAddress("sender", "mail.test")+`>
+To: `+testAddress("assistant", "mail.test")+`
+Subject: Hello there
+Message-ID: <question@mail.test>
+
+Plain text question
+`, "\n", "\r\n")
+
+	if err := intake.Deliver(context.Background(), []byte(raw), "pipe"); err != nil {
+		t.Fatal(err)
+	}
+	got := replies.String()
+	for _, want := range []string{
+		"Subject: A quick setup question",
+		"Subject: Re: Hello there",
+		"In-Reply-To: <question@mail.test>",
+		"Auto-Submitted: auto-replied",
+		"Content-Type: multipart/alternative",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("reply output missing %q:\n%s", want, got)
+		}
+	}
+
+	if err := intake.Deliver(context.Background(), []byte("not a message"), "pipe"); !IsMalformedMessage(err) {
+		t.Fatalf("Deliver(garbage) error = %v, want malformed message", err)
+	}
+}
+
+func TestLMTPSessionReportsStatusPerRecipient(t *testing.T) {
+	intake := newTestIntake(t, io.Discard)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- intake.serveLMTP(ctx, listener) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	expect := func(prefix string) {
+		t.Helper()
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("read LMTP reply: %v", err)
+			}
+			if !strings.HasPrefix(line, prefix) {
+				t.Fatalf("LMTP reply = %q, want prefix %q", line, prefix)
+			}
+			if len(line) < 4 || line[3] != '-' {
+				return
+			}
+		}
+	}
+	send := func(line string) { fmt.Fprint(conn, line+"\r\n") }
+
+	expect("220 ")
+	send("MAIL FROM:<" + testAddress("sender", "mail.test") + ">")
+	expect("503 ")
+	send("LHLO mta.test")
+	expect("250")
+	send("MAIL FROM:<" + testAddress("assistant", "mail.test") + ">")
+	expect("250 ")
+	send("RCPT TO:<" + testAddress("assistant", "mail.test") + ">")
+	expect("250 ")
+	send("RCPT TO:<" + testAddress("alias", "mail.test") + ">")
+	expect("250 ")
+	send("DATA")
+	expect("354 ")
+	send("From: " + testAddress("assistant", "mail.test"))
+	send("Subject: loop")
+	send("")
+	send("..leading dot")
+	send(".")
+	expect("250 2.0.0")
+	expect("250 2.0.0")
+
+	send("MAIL FROM:<>")
+	expect("250 ")
+	send("RCPT TO:<" + testAddress("assistant", "mail.test") + ">")
+	expect("250 ")
+	send("DATA")
+	expect("354 ")
+	send("garbage without headers")
+	send(".")
+	expect("554 5.6.0")
+
+	send("QUIT")
+	expect("221 ")
+}
@@ -0,0 +1,310 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	appconfig "ai-over-email/pkg/config"
+)
+
+type CorrespondentNote struct {
+	Note      string `json:"note"`
+	CreatedAt string `json:"createdAt"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+const (
+	memoryShowSubject   = "memory"
+	memoryForgetSubject = "forget memory"
+	memoryReplySubject  = "Your notes with this address"
+)
+
+func (s *correspondentStore) Notes(ctx context.Context, email string) ([]CorrespondentNote, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	rows, err := s.db.QueryContext(ctx, `SELECT note, created_at, updated_at FROM correspondent_notes WHERE email = ? ORDER BY position`, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	notes := []CorrespondentNote{}
+	for rows.Next() {
+		var note CorrespondentNote
+		if err := rows.Scan(&note.Note, &note.CreatedAt, &note.UpdatedAt); err != nil {
+			return nil, err
+		}
+		notes = append(notes, note)
+	}
+	return notes, rows.Err()
+}
+
+func (s *correspondentStore) ReplaceNotes(ctx context.Context, email string, notes []string, maxNotes int, maxNoteChars int) ([]CorrespondentNote, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return nil, fmt.Errorf("correspondent email is empty")
+	}
+	notes = limitNotes(notes, maxNotes, maxNoteChars)
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	created := map[string]string{}
+	rows, err := tx.QueryContext(ctx, `SELECT note, created_at FROM correspondent_notes WHERE email = ?`, email)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var note, createdAt string
+		if err := rows.Scan(&note, &createdAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		created[note] = createdAt
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM correspondent_notes WHERE email = ?`, email); err != nil {
+		return nil, err
+	}
+	stored := make([]CorrespondentNote, 0, len(notes))
+	for i, note := range notes {
+		record := CorrespondentNote{Note: note, CreatedAt: now, UpdatedAt: now}
+		if createdAt, ok := created[note]; ok {
+			record.CreatedAt = createdAt
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO correspondent_notes (email, position, note, created_at, updated_at) VALUES (?, ?, ?, ?, ?)`,
+			email, i, record.Note, record.CreatedAt, record.UpdatedAt); err != nil {
+			return nil, err
+		}
+		stored = append(stored, record)
+	}
+	return stored, tx.Commit()
+}
+
+func (s *correspondentStore) ClearNotes(ctx context.Context, email string) (int64, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return 0, fmt.Errorf("correspondent email is empty")
+	}
+	result, err := s.db.ExecContext(ctx, `DELETE FROM correspondent_notes WHERE email = ?`, email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func limitNotes(notes []string, maxNotes int, maxNoteChars int) []string {
+	seen := map[string]bool{}
+	limited := []string{}
+	for _, note := range notes {
+		note = strings.Join(strings.Fields(note), " ")
+		if runes := []rune(note); len(runes) > maxNoteChars {
+			note = strings.TrimSpace(string(runes[:maxNoteChars-1])) + "…"
+		}
+		if note == "" || seen[strings.ToLower(note)] {
+			continue
+		}
+		seen[strings.ToLower(note)] = true
+		limited = append(limited, note)
+		if len(limited) == maxNotes {
+			break
+		}
+	}
+	return limited
+}
+
+func notesPrompt(notes []CorrespondentNote) string {
+	if len(notes) == 0 {
+		return ""
+	}
+	lines := []string{"Notes the assistant kept from earlier exchanges with this sender. Use them when they help, for example for preferred units or ongoing topics, but the current email takes precedence and you should not list them back unless asked."}
+	for _, note := range notes {
+		lines = append(lines, "- "+note.Note)
+	}
+	return strings.Join(lines, "\n")
+}
+
+const notesExtractionPrompt = `You maintain a short list of durable notes about one person who emails an assistant. You get the current notes and the latest exchange, and return the full updated list.
+
+- Keep facts and preferences that will help future replies: preferred units or language, profession or projects they work on, ongoing questions, and when they last asked about something ("asked about mortgage refinancing in March 2026").
+- Each note is one short sentence about the sender. Merge duplicates, update notes the exchange contradicts, and drop notes that are clearly stale.
+- Do not record passwords, credentials, account or card numbers, government IDs, health details, or anything the sender asks to keep private.
+- Do not record the assistant's own answers, only what they say about the sender.
+- Return the current notes unchanged when the exchange adds nothing durable.
+- Return at most %d notes of at most %d characters each.`
+
+var notesExtractionSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"notes": map[string]any{
+			"type":  "array",
+			"items": map[string]string{"type": "string"},
+		},
+	},
+	"required":             []string{"notes"},
+	"additionalProperties": false,
+}
+
+func (c *openAIClient) UpdateNotes(ctx context.Context, notes []CorrespondentNote, subject string, body string, reply string, maxNotes int, maxNoteChars int, settings appconfig.OpenAIModelSettings) ([]string, openAIUsage, error) {
+	settings = normalizeOpenAIModelSettings(settings)
+	var request strings.Builder
+	request.WriteString("Current notes:\n")
+	if len(notes) == 0 {
+		request.WriteString("(none)\n")
+	}
+	for _, note := range notes {
+		fmt.Fprintf(&request, "- %s\n", note.Note)
+	}
+	fmt.Fprintf(&request, "\nToday's date: %s\n\nLatest email from the sender, subject %q:\n%s\n\nAssistant reply:\n%s", time.Now().UTC().Format("2 January 2006"), subject, body, reply)
+
+	decoded, err := c.sendOpenAIRequest(ctx, map[string]any{
+		"model": settings.Model,
+		"input": []map[string]any{
+			{"role": "system", "content": fmt.Sprintf(notesExtractionPrompt, maxNotes, maxNoteChars)},
+			{"role": "user", "content": request.String()},
+		},
+		"reasoning": map[string]string{
+			"effort": settings.ReasoningEffort,
+		},
+		"text": map[string]any{
+			"format": map[string]any{
+				"type":   "json_schema",
+				"name":   "correspondent_notes",
+				"strict": true,
+				"schema": notesExtractionSchema,
+			},
+		},
+		"_search_mode": "none",
+	})
+	if err != nil {
+		return nil, openAIUsage{}, err
+	}
+	var extracted struct {
+		Notes []string `json:"notes"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(decoded.outputText())), &extracted); err != nil {
+		return nil, decoded.Usage, fmt.Errorf("decode notes extraction: %w", err)
+	}
+	return extracted.Notes, decoded.Usage, nil
+}
+
+func (w *Watcher) memoryEnabled() bool {
+	return w.store != nil && !w.appConfig.Memory.Disabled
+}
+
+func (w *Watcher) notesPrompt(ctx context.Context, msg emailMessage) (string, error) {
+	if !w.memoryEnabled() || len(msg.From) == 0 {
+		return "", nil
+	}
+	notes, err := w.store.Notes(ctx, msg.From[0].Email)
+	if err != nil {
+		return "", err
+	}
+	return notesPrompt(notes), nil
+}
+
+func (w *Watcher) updateNotes(ctx context.Context, msg emailMessage, body string, reply string) {
+	if !w.memoryEnabled() || len(msg.From) == 0 {
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(msg.From[0].Email))
+	notes, err := w.store.Notes(ctx, email)
+	if err != nil {
+		w.logf("correspondent notes not updated: id=%s email=%s err=%v", msg.ID, email, err)
+		return
+	}
+	if !w.sideCallWithinBudget(ctx, msg, usageKindNotes) {
+		return
+	}
+	maxNotes, maxNoteChars := w.appConfig.Memory.Limits()
+	settings := w.appConfig.OpenAIExtractionSettings()
+	started := time.Now()
+	updated, usage, err := w.openai.UpdateNotes(ctx, notes, msg.Subject, body, reply, maxNotes, maxNoteChars, settings)
+	w.recordUsage(ctx, msg, usageKindNotes, settings, usage, 0, time.Since(started))
+	if usage.TotalTokens > 0 {
+		if _, err := w.store.RecordAccountTokenUsage(ctx, usage.TotalTokens); err != nil {
+			w.logf("correspondent notes usage not recorded: id=%s err=%v", msg.ID, err)
+		}
+	}
+	if err != nil {
+		w.logf("correspondent notes extraction failed: id=%s email=%s err=%v", msg.ID, email, err)
+		return
+	}
+	stored, err := w.store.ReplaceNotes(ctx, email, updated, maxNotes, maxNoteChars)
+	if err != nil {
+		w.logf("correspondent notes not updated: id=%s email=%s err=%v", msg.ID, email, err)
+		return
+	}
+	w.logf("correspondent notes updated: id=%s email=%s before=%d after=%d", msg.ID, email, len(notes), len(stored))
+}
+
+func memoryCommand(subject string) string {
+	subject = strings.ToLower(strings.Join(strings.Fields(subject), " "))
+	for {
+		trimmed := strings.TrimSpace(strings.TrimPrefix(subject, "re:"))
+		if trimmed == subject {
+			break
+		}
+		subject = trimmed
+	}
+	switch subject {
+	case memoryShowSubject, memoryForgetSubject:
+		return subject
+	}
+	return ""
+}
+
+func (w *Watcher) handleMemoryCommand(ctx context.Context, msg emailMessage, command string, footer emailFooterStats) error {
+	email := strings.ToLower(strings.TrimSpace(msg.From[0].Email))
+	var notes []CorrespondentNote
+	if w.memoryEnabled() {
+		var err error
+		if notes, err = w.store.Notes(ctx, email); err != nil {
+			return err
+		}
+		if command == memoryForgetSubject {
+			removed, err := w.store.ClearNotes(ctx, email)
+			if err != nil {
+				return err
+			}
+			w.logf("correspondent notes cleared by email command: id=%s email=%s removed=%d", msg.ID, email, removed)
+		}
+	}
+	body := memoryCommandBody(command, notes, w.memoryEnabled())
+	htmlBody, err := formatReplyHTMLBody(body, emailMessage{}, "")
+	if err != nil {
+		return err
+	}
+	return w.sendEmail(ctx, msg.From[:1], memoryReplySubject, body, htmlBody, nil, msg, footer)
+}
+
+func memoryCommandBody(command string, notes []CorrespondentNote, enabled bool) string {
+	var b strings.Builder
+	b.WriteString("Hello,\n\n")
+	switch {
+	case !enabled:
+		b.WriteString("This address does not keep notes about correspondents, so there is nothing to show or delete.\n\n")
+	case command == memoryForgetSubject && len(notes) == 0:
+		b.WriteString("I did not keep any notes about you, so there was nothing to delete.\n\n")
+	case command == memoryForgetSubject:
+		b.WriteString("I deleted the notes I kept about you. New notes will only come from future messages.\n\n")
+		fmt.Fprintf(&b, "To see what I keep, send a message with the subject \"%s\".\n\n", memoryShowSubject)
+	case len(notes) == 0:
+		b.WriteString("I do not keep any notes about you yet.\n\n")
+	default:
+		b.WriteString("These are the notes I keep from earlier exchanges and use when replying to you:\n\n")
+		for _, note := range notes {
+			fmt.Fprintf(&b, "- %s\n", note.Note)
+		}
+		fmt.Fprintf(&b, "\nTo delete them all, send a message with the subject \"%s\".\n\n", memoryForgetSubject)
+	}
+	b.WriteString("Thanks.")
+	return b.String()
+}
@@ -0,0 +1,168 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	appconfig "ai-over-email/pkg/config"
+	"ai-over-email/pkg/jmaptest"
+)
+
+func TestCorrespondentStoreReplaceNotes(t *testing.T) {
+	ctx := context.Background()
+	store := openTestCorrespondentStore(t)
+	email := testAddress("sender", "mail.test")
+	if _, err := store.Register(ctx, email, "Sender", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := store.ReplaceNotes(ctx, email, []string{"Prefers metric units.", "  prefers   METRIC units. ", "", "Works on a\nboat restoration.", "Third note."}, 2, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 2 || first[0].Note != "Prefers metric units." || first[1].Note != "Works on a boat restoration." {
+		t.Fatalf("first notes = %+v", first)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	second, err := store.ReplaceNotes(ctx, email, []string{"Asked about mortgage refinancing in March 2026.", "Prefers metric units."}, 20, 21)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second[0].Note != "Asked about mortgage…" || second[0].CreatedAt == first[0].CreatedAt {
+		t.Fatalf("truncated note = %+v", second[0])
+	}
+	if second[1].CreatedAt != first[0].CreatedAt || second[1].UpdatedAt == first[0].UpdatedAt {
+		t.Fatalf("unchanged note lost its creation time: %+v, first %+v", second[1], first[0])
+	}
+
+	detail, err := store.GetCorrespondent(ctx, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(detail.Notes) != 2 || detail.Notes[0].Note != second[0].Note {
+		t.Fatalf("detail notes = %+v", detail.Notes)
+	}
+
+	removed, err := store.ClearNotes(ctx, strings.ToUpper(email))
+	if err != nil || removed != 2 {
+		t.Fatalf("ClearNotes = %d, %v", removed, err)
+	}
+	if notes, err := store.Notes(ctx, email); err != nil || len(notes) != 0 {
+		t.Fatalf("notes after clear = %+v, %v", notes, err)
+	}
+}
+
+func TestMemoryCommand(t *testing.T) {
+	for subject, want := range map[string]string{
+		"memory":                    memoryShowSubject,
+		"Memory":                    memoryShowSubject,
+		"Re: RE: memory":            memoryShowSubject,
+		"Forget  Memory":            memoryForgetSubject,
+		"memory of the trip":        "",
+		"Re: Your notes with this…": "",
+	} {
+		if got := memoryCommand(subject); got != want {
+			t.Errorf("memoryCommand(%q) = %q, want %q", subject, got, want)
+		}
+	}
+}
+
+func TestWatcherUpdatesNotesAfterReply(t *testing.T) {
+	ctx := context.Background()
+	var lastInput string
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Input []struct {
+				Content string `json:"content"`
+			} `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Input) != 2 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		lastInput = request.Input[1].Content
+		_ = json.NewEncoder(w).Encode(openAIResponse{
+			Output: []openAIOutputItem{{Type: "message", Content: []openAIOutputContent{{Type: "output_text", Text: `{"notes":["Prefers metric units.","Asked about bread recipes."]}`}}}},
+			Usage:  openAIUsage{InputTokens: 40, OutputTokens: 8, TotalTokens: 48},
+		})
+	}))
+	t.Cleanup(stub.Close)
+
+	w := &Watcher{store: openTestCorrespondentStore(t), openai: newOpenAIClient("openai-test", "", "", nil)}
+	w.openai.UseEndpoints(stub.URL, "", nil)
+	email := testAddress("sender", "mail.test")
+	msg := emailMessage{ID: "m1", Subject: "Bread", From: []emailAddress{{Email: email}}}
+	if _, err := w.store.ReplaceNotes(ctx, email, []string{"Prefers metric units."}, 20, 200); err != nil {
+		t.Fatal(err)
+	}
+
+	w.updateNotes(ctx, msg, "How much flour for one loaf?", "About 500 g.")
+	if !strings.Contains(lastInput, "- Prefers metric units.") || !strings.Contains(lastInput, "How much flour for one loaf?") || !strings.Contains(lastInput, "About 500 g.") {
+		t.Fatalf("extraction input:\n%s", lastInput)
+	}
+	prompt, err := w.notesPrompt(ctx, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(prompt, "- Prefers metric units.\n- Asked about bread recipes.") {
+		t.Fatalf("notes prompt:\n%s", prompt)
+	}
+	if tokens, _, err := w.store.Totals(ctx); err != nil || tokens != 48 {
+		t.Fatalf("account tokens = %d, %v", tokens, err)
+	}
+
+	w.appConfig = appconfig.ConfigStruct{Memory: appconfig.MemoryConfig{Disabled: true}}
+	lastInput = ""
+	w.updateNotes(ctx, msg, "Another question", "Another answer")
+	if prompt, err := w.notesPrompt(ctx, msg); err != nil || prompt != "" || lastInput != "" {
+		t.Fatalf("disabled memory: prompt=%q input=%q err=%v", prompt, lastInput, err)
+	}
+}
+
+func TestWatcherAnswersMemoryCommands(t *testing.T) {
+	sender := testAddress("sender", "mail.test")
+	f := startFakeJMAPWatcher(t, sender)
+
+	deliver := func(subject string) {
+		t.Helper()
+		if _, err := f.server.Deliver(jmaptest.Message{
+			From:    []jmaptest.Address{{Email: sender}},
+			To:      []jmaptest.Address{{Email: f.server.Username}},
+			Subject: subject,
+			Text:    "Hello",
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deliver("Question")
+	if _, err := f.server.WaitForSubmissions(2, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	deliver("memory")
+	submissions, err := f.server.WaitForSubmissions(3, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shown := submissions[2].Email
+	if shown.Subject != memoryReplySubject || !strings.Contains(shown.TextBody, "- Asked what the answer is.") {
+		t.Fatalf("memory reply %q:\n%s", shown.Subject, shown.TextBody)
+	}
+
+	deliver("Re: forget memory")
+	if submissions, err = f.server.WaitForSubmissions(4, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if forgot := submissions[3].Email; !strings.Contains(forgot.TextBody, "I deleted the notes I kept about you.") {
+		t.Fatalf("forget reply:\n%s", forgot.TextBody)
+	}
+	if calls := f.openAICalls.Load(); calls != 1 {
+		t.Fatalf("OpenAI calls = %d, want only the first question answered", calls)
+	}
+}
@@ -0,0 +1,409 @@
+package email
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+var errSchemaNewer = errors.New("correspondent db schema is newer than this binary")
+
+type schemaMigration struct {
+	Version    int
+	Name       string
+	Statements []string
+}
+
+// Applied migrations are checksummed, so never edit one that has shipped;
+// append a new version instead.
+var schemaMigrations = []schemaMigration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS correspondents (
+				email TEXT PRIMARY KEY,
+				display_name TEXT NOT NULL DEFAULT '',
+				zip_code TEXT NOT NULL DEFAULT '',
+				time_zone TEXT NOT NULL DEFAULT '',
+				time_zone_source TEXT NOT NULL DEFAULT '',
+				profile_request_sent_at TEXT NOT NULL DEFAULT '',
+				first_seen_at TEXT NOT NULL,
+				last_seen_at TEXT NOT NULL,
+				updated_at TEXT NOT NULL,
+				blocked_at TEXT NOT NULL DEFAULT '',
+				blocked_reason TEXT NOT NULL DEFAULT '',
+				sender_context INTEGER NOT NULL DEFAULT 1,
+				country TEXT NOT NULL DEFAULT '',
+				archive INTEGER NOT NULL DEFAULT 1,
+				archive_retention_days INTEGER NOT NULL DEFAULT 0
+			)`,
+			`CREATE TABLE IF NOT EXISTS correspondent_daily_usage (
+				email TEXT NOT NULL,
+				day TEXT NOT NULL,
+				message_count INTEGER NOT NULL DEFAULT 0,
+				first_message_at TEXT NOT NULL,
+				last_message_at TEXT NOT NULL,
+				updated_at TEXT NOT NULL,
+				PRIMARY KEY (email, day)
+			)`,
+			`CREATE TABLE IF NOT EXISTS outbound_email_totals (
+				id INTEGER PRIMARY KEY CHECK (id = 1),
+				total_sent INTEGER NOT NULL DEFAULT 0,
+				updated_at TEXT NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS account_token_totals (
+				id INTEGER PRIMARY KEY CHECK (id = 1),
+				total_tokens INTEGER NOT NULL DEFAULT 0,
+				updated_at TEXT NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS correspondent_notes (
+				email TEXT NOT NULL,
+				position INTEGER NOT NULL,
+				note TEXT NOT NULL,
+				created_at TEXT NOT NULL,
+				updated_at TEXT NOT NULL,
+				PRIMARY KEY (email, position)
+			)`,
+			`CREATE TABLE IF NOT EXISTS archive_messages (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				email TEXT NOT NULL,
+				message_id TEXT NOT NULL DEFAULT '',
+				subject TEXT NOT NULL DEFAULT '',
+				inbound_body TEXT NOT NULL DEFAULT '',
+				reply_body TEXT NOT NULL DEFAULT '',
+				encrypted INTEGER NOT NULL DEFAULT 0,
+				model TEXT NOT NULL DEFAULT '',
+				tools_used TEXT NOT NULL DEFAULT '',
+				input_tokens INTEGER NOT NULL DEFAULT 0,
+				output_tokens INTEGER NOT NULL DEFAULT 0,
+				total_tokens INTEGER NOT NULL DEFAULT 0,
+				received_at TEXT NOT NULL DEFAULT '',
+				archived_at TEXT NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS archive_messages_email ON archive_messages (email, archived_at)`,
+			`CREATE VIRTUAL TABLE IF NOT EXISTS archive_search USING fts5(
+				subject, inbound_body, reply_body,
+				content = 'archive_messages', content_rowid = 'id'
+			)`,
+			`CREATE TRIGGER IF NOT EXISTS archive_messages_insert AFTER INSERT ON archive_messages BEGIN
+				INSERT INTO archive_search (rowid, subject, inbound_body, reply_body) VALUES (new.id, new.subject, new.inbound_body, new.reply_body);
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS archive_messages_delete AFTER DELETE ON archive_messages BEGIN
+				INSERT INTO archive_search (archive_search, rowid, subject, inbound_body, reply_body) VALUES ('delete', old.id, old.subject, old.inbound_body, old.reply_body);
+			END`,
+		},
+	},
+	{
+		Version: 2,
+		Name:    "message usage",
+		Statements: []string{
+			`CREATE TABLE message_usage (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				email TEXT NOT NULL DEFAULT '',
+				message_id TEXT NOT NULL DEFAULT '',
+				kind TEXT NOT NULL,
+				model TEXT NOT NULL DEFAULT '',
+				input_tokens INTEGER NOT NULL DEFAULT 0,
+				cached_input_tokens INTEGER NOT NULL DEFAULT 0,
+				output_tokens INTEGER NOT NULL DEFAULT 0,
+				reasoning_tokens INTEGER NOT NULL DEFAULT 0,
+				total_tokens INTEGER NOT NULL DEFAULT 0,
+				tool_calls INTEGER NOT NULL DEFAULT 0,
+				latency_ms INTEGER NOT NULL DEFAULT 0,
+				cost_usd REAL NOT NULL DEFAULT 0,
+				priced INTEGER NOT NULL DEFAULT 0,
+				created_at TEXT NOT NULL
+			)`,
+			`CREATE INDEX message_usage_created ON message_usage (created_at)`,
+			`CREATE INDEX message_usage_email ON message_usage (email, created_at)`,
+		},
+	},
+	{
+		Version: 3,
+		Name:    "rate limit windows",
+		Statements: []string{
+			`ALTER TABLE correspondent_daily_usage ADD COLUMN limited_count INTEGER NOT NULL DEFAULT 0`,
+			`CREATE TABLE inbound_messages (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				email TEXT NOT NULL,
+				received_at TEXT NOT NULL
+			)`,
+			`CREATE INDEX inbound_messages_email ON inbound_messages (email, received_at)`,
+			`CREATE TABLE rate_limit_state (
+				email TEXT PRIMARY KEY,
+				bucket_tokens REAL NOT NULL DEFAULT 0,
+				bucket_updated_at TEXT NOT NULL DEFAULT '',
+				notice_sent_at TEXT NOT NULL DEFAULT '',
+				updated_at TEXT NOT NULL
+			)`,
+		},
+	},
+	{
+		Version: 4,
+		Name:    "sender policies",
+		Statements: []string{
+			`CREATE TABLE sender_policies (
+				pattern TEXT PRIMARY KEY,
+				pgp TEXT NOT NULL DEFAULT '',
+				model_tier TEXT NOT NULL DEFAULT '',
+				daily_limit INTEGER NOT NULL DEFAULT 0,
+				blocked INTEGER NOT NULL DEFAULT 0,
+				notes TEXT NOT NULL DEFAULT '',
+				created_at TEXT NOT NULL,
+				updated_at TEXT NOT NULL
+			)`,
+		},
+	},
+	{
+		Version: 5,
+		Name:    "message usage tier",
+		Statements: []string{
+			`ALTER TABLE message_usage ADD COLUMN tier TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+}
+
+// Databases created before schema_migrations existed got their columns one
+// ALTER TABLE at a time. They are brought up to the initial schema before
+// version 1 is recorded.
+var legacyColumns = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"correspondents", "blocked_at", "TEXT NOT NULL DEFAULT ''"},
+	{"correspondents", "blocked_reason", "TEXT NOT NULL DEFAULT ''"},
+	{"correspondents", "sender_context", "INTEGER NOT NULL DEFAULT 1"},
+	{"correspondents", "country", "TEXT NOT NULL DEFAULT ''"},
+	{"correspondents", "archive", "INTEGER NOT NULL DEFAULT 1"},
+	{"correspondents", "archive_retention_days", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+const (
+	MigrationApplied          = "applied"
+	MigrationPending          = "pending"
+	MigrationChecksumMismatch = "checksum_mismatch"
+	MigrationUnknown          = "unknown"
+)
+
+type SchemaStatus struct {
+	Version       int                     `json:"version"`
+	LatestVersion int                     `json:"latestVersion"`
+	Migrations    []SchemaMigrationStatus `json:"migrations"`
+}
+
+type SchemaMigrationStatus struct {
+	Version   int    `json:"version"`
+	Name      string `json:"name"`
+	State     string `json:"state"`
+	Checksum  string `json:"checksum"`
+	AppliedAt string `json:"appliedAt,omitempty"`
+}
+
+type appliedMigration struct {
+	Name      string
+	Checksum  string
+	AppliedAt string
+}
+
+func (m schemaMigration) checksum() string {
+	sum := sha256.New()
+	for _, statement := range m.Statements {
+		sum.Write([]byte(strings.Join(strings.Fields(statement), " ")))
+		sum.Write([]byte{0})
+	}
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+func latestSchemaVersion() int {
+	return schemaMigrations[len(schemaMigrations)-1].Version
+}
+
+func (s *correspondentStore) migrate(ctx context.Context) error {
+	for _, pragma := range []string{`PRAGMA busy_timeout = 5000`, `PRAGMA journal_mode = WAL`} {
+		if _, err := s.db.ExecContext(ctx, pragma); err != nil {
+			return fmt.Errorf("migrate correspondent db: %w", err)
+		}
+	}
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("migrate correspondent db: %w", err)
+	}
+	applied, err := appliedMigrations(ctx, s.db)
+	if err != nil {
+		return fmt.Errorf("migrate correspondent db: %w", err)
+	}
+	if err := verifyMigrations(applied); err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		if err := s.adoptLegacySchema(ctx); err != nil {
+			return fmt.Errorf("migrate correspondent db: %w", err)
+		}
+	}
+	for _, migration := range schemaMigrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		if err := s.applyMigration(ctx, migration); err != nil {
+			return fmt.Errorf("migrate correspondent db to version %d (%s): %w", migration.Version, migration.Name, err)
+		}
+	}
+	return nil
+}
+
+func (s *correspondentStore) applyMigration(ctx context.Context, migration schemaMigration) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var exists int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM schema_migrations WHERE version = ?`, migration.Version).Scan(&exists); err != nil {
+		return err
+	}
+	if exists > 0 {
+		return nil
+	}
+	for _, statement := range migration.Statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)`,
+		migration.Version, migration.Name, migration.checksum(), time.Now().UTC().Format(time.RFC3339Nano)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *correspondentStore) adoptLegacySchema(ctx context.Context) error {
+	var tables int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'correspondents'`).Scan(&tables); err != nil {
+		return err
+	}
+	if tables == 0 {
+		return nil
+	}
+	for _, column := range legacyColumns {
+		if err := s.ensureColumn(ctx, column.table, column.column, column.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *correspondentStore) ensureColumn(ctx context.Context, table string, column string, definition string) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if strings.EqualFold(name, column) {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition))
+	return err
+}
+
+func appliedMigrations(ctx context.Context, db *sql.DB) (map[int]appliedMigration, error) {
+	applied := map[int]appliedMigration{}
+	var tables int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`).Scan(&tables); err != nil {
+		return nil, err
+	}
+	if tables == 0 {
+		return applied, nil
+	}
+	rows, err := db.QueryContext(ctx, `SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var migration appliedMigration
+		if err := rows.Scan(&version, &migration.Name, &migration.Checksum, &migration.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = migration
+	}
+	return applied, rows.Err()
+}
+
+func verifyMigrations(applied map[int]appliedMigration) error {
+	latest := latestSchemaVersion()
+	for version := range applied {
+		if version > latest {
+			return fmt.Errorf("%w: version %d, this binary supports %d", errSchemaNewer, version, latest)
+		}
+	}
+	for _, migration := range schemaMigrations {
+		record, ok := applied[migration.Version]
+		if ok && record.Checksum != migration.checksum() {
+			return fmt.Errorf("correspondent db migration %d (%s) checksum mismatch: database has %s, binary has %s", migration.Version, migration.Name, record.Checksum, migration.checksum())
+		}
+	}
+	return nil
+}
+
+// CorrespondentSchemaStatus reports the migration state without applying
+// anything, so it also works on a database newer than the binary.
+func CorrespondentSchemaStatus(ctx context.Context, path string) (SchemaStatus, error) {
+	path = correspondentDBPath(path)
+	applied := map[int]appliedMigration{}
+	if _, err := os.Stat(path); err == nil {
+		db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+		if err != nil {
+			return SchemaStatus{}, fmt.Errorf("open correspondent db: %w", err)
+		}
+		defer db.Close()
+		if applied, err = appliedMigrations(ctx, db); err != nil {
+			return SchemaStatus{}, fmt.Errorf("read schema_migrations: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return SchemaStatus{}, fmt.Errorf("open correspondent db: %w", err)
+	}
+	status := SchemaStatus{LatestVersion: latestSchemaVersion(), Migrations: []SchemaMigrationStatus{}}
+	known := map[int]bool{}
+	for _, migration := range schemaMigrations {
+		known[migration.Version] = true
+		entry := SchemaMigrationStatus{Version: migration.Version, Name: migration.Name, State: MigrationPending, Checksum: migration.checksum()}
+		if record, ok := applied[migration.Version]; ok {
+			entry.State, entry.AppliedAt = MigrationApplied, record.AppliedAt
+			if record.Checksum != entry.Checksum {
+				entry.State, entry.Checksum = MigrationChecksumMismatch, record.Checksum
+			}
+		}
+		status.Migrations = append(status.Migrations, entry)
+	}
+	for version, record := range applied {
+		if !known[version] {
+			status.Migrations = append(status.Migrations, SchemaMigrationStatus{Version: version, Name: record.Name, State: MigrationUnknown, Checksum: record.Checksum, AppliedAt: record.AppliedAt})
+		}
+		status.Version = max(status.Version, version)
+	}
+	slices.SortFunc(status.Migrations, func(a, b SchemaMigrationStatus) int { return a.Version - b.Version })
+	return status, nil
+}
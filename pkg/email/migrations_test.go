@@ -0,0 +1,161 @@
+package email
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCorrespondentSchemaStatus(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "correspondents.sqlite3")
+
+	status, err := CorrespondentSchemaStatus(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Version != 0 || status.LatestVersion != latestSchemaVersion() || status.Migrations[0].State != MigrationPending {
+		t.Fatalf("status before open = %+v", status)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("status created the database: %v", err)
+	}
+
+	for range 2 {
+		store, err := openCorrespondentStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.Close()
+	}
+	if status, err = CorrespondentSchemaStatus(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+	if status.Version != latestSchemaVersion() || len(status.Migrations) != len(schemaMigrations) {
+		t.Fatalf("status after open = %+v", status)
+	}
+	for _, migration := range status.Migrations {
+		if migration.State != MigrationApplied || migration.AppliedAt == "" {
+			t.Fatalf("migration %d = %+v", migration.Version, migration)
+		}
+	}
+}
+
+func TestCorrespondentStoreAdoptsLegacySchema(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "correspondents.sqlite3")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, `CREATE TABLE correspondents (
+		email TEXT PRIMARY KEY,
+		display_name TEXT NOT NULL DEFAULT '',
+		zip_code TEXT NOT NULL DEFAULT '',
+		time_zone TEXT NOT NULL DEFAULT '',
+		time_zone_source TEXT NOT NULL DEFAULT '',
+		profile_request_sent_at TEXT NOT NULL DEFAULT '',
+		first_seen_at TEXT NOT NULL,
+		last_seen_at TEXT NOT NULL,
+		updated_at TEXT NOT NULL,
+		blocked_at TEXT NOT NULL DEFAULT ''
+	)`); err != nil {
+		t.Fatal(err)
+	}
+	email := testAddress("legacy", "mail.test")
+	if _, err := db.ExecContext(ctx, `INSERT INTO correspondents (email, zip_code, first_seen_at, last_seen_at, updated_at) VALUES (?, '80202', 'x', 'x', 'x')`, email); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	store, err := openCorrespondentStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.CheckSchema(ctx); err != nil {
+		t.Fatal(err)
+	}
+	detail, err := store.GetCorrespondent(ctx, email)
+	if err != nil || detail.ZipCode != "80202" || !detail.SenderContext || !detail.Archive {
+		t.Fatalf("legacy correspondent = %+v, %v", detail.Correspondent, err)
+	}
+}
+
+func TestCorrespondentStoreRefusesIncompatibleSchema(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name   string
+		change string
+		state  string
+	}{
+		{"newer", `INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (9999, 'from the future', 'abc', 'x')`, MigrationUnknown},
+		{"edited", `UPDATE schema_migrations SET checksum = 'abc' WHERE version = 1`, MigrationChecksumMismatch},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "correspondents.sqlite3")
+			store, err := openCorrespondentStore(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.db.ExecContext(ctx, tc.change); err != nil {
+				t.Fatal(err)
+			}
+			store.Close()
+
+			if store, err := openCorrespondentStore(path); err == nil {
+				store.Close()
+				t.Fatal("opened an incompatible database")
+			} else if tc.name == "newer" && !errors.Is(err, errSchemaNewer) {
+				t.Fatalf("open error = %v, want errSchemaNewer", err)
+			}
+			status, err := CorrespondentSchemaStatus(ctx, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := false
+			for _, migration := range status.Migrations {
+				found = found || migration.State == tc.state
+			}
+			if !found {
+				t.Fatalf("status = %+v, want a %s migration", status, tc.state)
+			}
+		})
+	}
+}
+
+func TestCorrespondentStoreMigrationIsTransactional(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "correspondents.sqlite3")
+	store, err := openCorrespondentStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	original := schemaMigrations
+	t.Cleanup(func() { schemaMigrations = original })
+	schemaMigrations = append(original[:len(original):len(original)], schemaMigration{
+		Version:    latestSchemaVersion() + 1,
+		Name:       "broken",
+		Statements: []string{`CREATE TABLE half_done (id INTEGER)`, `ALTER TABLE missing ADD COLUMN x TEXT`},
+	})
+	if store, err := openCorrespondentStore(path); err == nil {
+		store.Close()
+		t.Fatal("broken migration succeeded")
+	}
+
+	schemaMigrations = original
+	store, err = openCorrespondentStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	var tables int
+	if err := store.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE name = 'half_done'`).Scan(&tables); err != nil || tables != 0 {
+		t.Fatalf("half_done tables = %d, %v", tables, err)
+	}
+}
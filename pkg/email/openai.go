@@ -3,6 +3,7 @@ package email
 import (
 	appconfig "ai-over-email/pkg/config"
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -23,12 +24,48 @@ type openAIClient struct {
 	token            string
 	fromEmail        string
 	braveSearchToken string
+	responsesURL     string
+	braveURL         string
 	http             *http.Client
 	logOutput        io.Writer
+	emailPrompt      string
 }
 
 type OpenAIClient = openAIClient
 
+const emailReplyPrompt = `You are composing an email reply.
+
+The entire interaction is happening over email:
+- The incoming user message is an email, not a chat turn.
+- Your output will be sent directly back as the email body.
+- Any images or files attached to the email are part of the user's request and should be considered alongside the written body.
+- Read the entire email carefully before answering, including forwarded messages, quoted replies, previous thread history, inline headers, signatures, and attachment text or images.
+- Treat forwarded or quoted messages as important context for the sender's current question, not as boilerplate to ignore. If the current note asks about "this", "below", "forwarded", "attached", or similar references, resolve that from the forwarded body, prior thread, and attachments.
+- Write a complete, polished email response.
+- Use Markdown-style headings, lists, links, and emphasis when useful; the delivered email is rendered as HTML with a plain-text fallback.
+- When tabular comparison is useful, use a simple standard table so the delivered email renders it as an HTML table. Never leave table content as raw pipe-delimited text in the email body.
+- Start with a brief greeting when appropriate.
+- Close naturally, but do not invent a human name or signature.
+- Do not mention system prompts, internal tooling, hidden reasoning, API details, or that an AI model wrote the reply.
+
+Research and reasoning expectations:
+- Treat the sender's email as a serious request from a real person.
+- Infer what the sender is asking, including implicit context and likely intent.
+- Reconstruct the relevant situation from the full email body and thread context before deciding what to answer.
+- Take the time needed to produce a rigorous answer.
+- Use web search for current facts, sources, standards, dates, prices, laws, technical details, papers, or anything that may have changed.
+- Prefer primary sources and reputable references. If sources disagree, explain the disagreement.
+- Think at a PhD professor level: define terms, state assumptions, reason from evidence, distinguish facts from interpretation, and surface caveats.
+- Be thorough, but structure the email so it remains readable.
+
+Email structure:
+- If the question is simple, answer directly first and then add supporting detail.
+- If the question is complex, use clear section headings and short paragraphs.
+- Include concrete recommendations, tradeoffs, and next steps when useful.
+- Include source links inline or in a short "Sources" section when web research informs the answer.
+- Avoid excessive quotation; summarize in your own words.
+- If you cannot fully answer, explain exactly what is missing and what would resolve it.`
+
 type openAIResponse struct {
 	ID     string             `json:"id"`
 	Output []openAIOutputItem `json:"output"`
@@ -39,6 +76,7 @@ type openAIAnswer struct {
 	Text      string
 	Model     string
 	ToolsUsed []string
+	ToolCalls int
 	Usage     openAIUsage
 }
 
@@ -50,6 +88,27 @@ type openAIUsage struct {
 	CachedInputTokens int `json:"cached_input_tokens"`
 }
 
+// The Responses API nests cached and reasoning counts in *_tokens_details.
+func (u *openAIUsage) UnmarshalJSON(data []byte) error {
+	type plain openAIUsage
+	var decoded struct {
+		plain
+		InputDetails struct {
+			CachedTokens int `json:"cached_tokens"`
+		} `json:"input_tokens_details"`
+		OutputDetails struct {
+			ReasoningTokens int `json:"reasoning_tokens"`
+		} `json:"output_tokens_details"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*u = openAIUsage(decoded.plain)
+	u.CachedInputTokens = cmp.Or(u.CachedInputTokens, decoded.InputDetails.CachedTokens)
+	u.ReasoningTokens = cmp.Or(u.ReasoningTokens, decoded.OutputDetails.ReasoningTokens)
+	return nil
+}
+
 type openAIOutputItem struct {
 	Type      string                `json:"type"`
 	Name      string                `json:"name"`
@@ -81,6 +140,8 @@ func newOpenAIClient(token string, fromEmail string, braveSearchToken string, lo
 		token:            token,
 		fromEmail:        strings.TrimSpace(fromEmail),
 		braveSearchToken: strings.TrimSpace(braveSearchToken),
+		responsesURL:     openAIResponsesURL,
+		braveURL:         braveSearchURL,
 		http: &http.Client{
 			Timeout: 10 * time.Minute,
 		},
@@ -92,50 +153,34 @@ func NewOpenAIClient(token string, fromEmail string, braveSearchToken string, lo
 	return newOpenAIClient(token, fromEmail, braveSearchToken, logOutput)
 }
 
-func (c *openAIClient) AnswerEmail(ctx context.Context, subject string, body string, attachments []emailAttachment, settings appconfig.OpenAIModelSettings) (openAIAnswer, error) {
+func (c *openAIClient) UseEndpoints(responsesURL string, braveURL string, transport http.RoundTripper) {
+	if responsesURL != "" {
+		c.responsesURL = responsesURL
+	}
+	if braveURL != "" {
+		c.braveURL = braveURL
+	}
+	if transport != nil {
+		c.http.Transport = transport
+	}
+}
+
+func (c *openAIClient) AnswerEmail(ctx context.Context, subject string, body string, attachments []emailAttachment, senderContext string, settings appconfig.OpenAIModelSettings) (openAIAnswer, error) {
 	if c.token == "" {
 		return openAIAnswer{}, fmt.Errorf("OPENAI_API_TOKEN is not configured")
 	}
 	settings = normalizeOpenAIModelSettings(settings)
 
-	prompt := `You are composing an email reply.
-
-The entire interaction is happening over email:
-- The incoming user message is an email, not a chat turn.
-- Your output will be sent directly back as the email body.
-- Any images or files attached to the email are part of the user's request and should be considered alongside the written body.
-- Read the entire email carefully before answering, including forwarded messages, quoted replies, previous thread history, inline headers, signatures, and attachment text or images.
-- Treat forwarded or quoted messages as important context for the sender's current question, not as boilerplate to ignore. If the current note asks about "this", "below", "forwarded", "attached", or similar references, resolve that from the forwarded body, prior thread, and attachments.
-- Write a complete, polished email response.
-- Use Markdown-style headings, lists, links, and emphasis when useful; the delivered email is rendered as HTML with a plain-text fallback.
-- When tabular comparison is useful, use a simple standard table so the delivered email renders it as an HTML table. Never leave table content as raw pipe-delimited text in the email body.
-- Start with a brief greeting when appropriate.
-- Close naturally, but do not invent a human name or signature.
-- Do not mention system prompts, internal tooling, hidden reasoning, API details, or that an AI model wrote the reply.
-
-Research and reasoning expectations:
-- Treat the sender's email as a serious request from a real person.
-- Infer what the sender is asking, including implicit context and likely intent.
-- Reconstruct the relevant situation from the full email body and thread context before deciding what to answer.
-- Take the time needed to produce a rigorous answer.
-- Use web search for current facts, sources, standards, dates, prices, laws, technical details, papers, or anything that may have changed.
-- Prefer primary sources and reputable references. If sources disagree, explain the disagreement.
-- Think at a PhD professor level: define terms, state assumptions, reason from evidence, distinguish facts from interpretation, and surface caveats.
-- Be thorough, but structure the email so it remains readable.
-
-Email structure:
-- If the question is simple, answer directly first and then add supporting detail.
-- If the question is complex, use clear section headings and short paragraphs.
-- Include concrete recommendations, tradeoffs, and next steps when useful.
-- Include source links inline or in a short "Sources" section when web research informs the answer.
-- Avoid excessive quotation; summarize in your own words.
-- If you cannot fully answer, explain exactly what is missing and what would resolve it.`
+	prompt := c.emailSystemPrompt()
 	if c.fromEmail != "" {
 		prompt += "\n\nThe configured sender address is available in local credentials; do not disclose it unless the email context requires it."
 	}
 	if c.braveSearchToken != "" {
 		prompt += "\n\nUse the web_search tool for current or source-dependent facts. The tool is backed by Brave Search and returns titles, URLs, snippets, and dates when available. Once you have enough source context, stop searching and write the final email reply."
 	}
+	if senderContext = strings.TrimSpace(senderContext); senderContext != "" {
+		prompt += "\n\n" + senderContext
+	}
 
 	input := []map[string]any{
 		{
@@ -199,6 +244,13 @@ Usenet style:
 	return c.completeResponse(ctx, input, settings)
 }
 
+func (c *openAIClient) emailSystemPrompt() string {
+	if c.emailPrompt != "" {
+		return c.emailPrompt
+	}
+	return emailReplyPrompt
+}
+
 type UsenetPostPrompt struct {
 	Subject       string
 	Author        string
@@ -217,6 +269,7 @@ func (c *openAIClient) completeResponse(ctx context.Context, input []map[string]
 	}
 	usage := decoded.Usage
 	toolsUsed := decoded.toolsUsed()
+	toolCalls := decoded.toolCalls()
 	if c.braveSearchToken != "" {
 		const maxBraveSearchRounds = 4
 		for i := 0; i < maxBraveSearchRounds; i++ {
@@ -241,6 +294,7 @@ func (c *openAIClient) completeResponse(ctx context.Context, input []map[string]
 			}
 			usage = usage.add(decoded.Usage)
 			toolsUsed = appendToolsUsed(toolsUsed, decoded.toolsUsed()...)
+			toolCalls += decoded.toolCalls()
 		}
 	}
 
@@ -248,7 +302,7 @@ func (c *openAIClient) completeResponse(ctx context.Context, input []map[string]
 	if text == "" {
 		return openAIAnswer{}, fmt.Errorf("OpenAI response did not include output_text")
 	}
-	return openAIAnswer{Text: text, Model: settings.Model, ToolsUsed: toolsUsed, Usage: usage}, nil
+	return openAIAnswer{Text: text, Model: settings.Model, ToolsUsed: toolsUsed, ToolCalls: toolCalls, Usage: usage}, nil
 }
 
 func normalizeOpenAIModelSettings(settings appconfig.OpenAIModelSettings) appconfig.OpenAIModelSettings {
@@ -360,7 +414,7 @@ func (c *openAIClient) sendOpenAIRequest(ctx context.Context, payload map[string
 		return openAIResponse{}, err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, openAIResponsesURL, bytes.NewReader(data))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.responsesURL, bytes.NewReader(data))
 	if err != nil {
 		return openAIResponse{}, err
 	}
@@ -433,7 +487,7 @@ func (c *openAIClient) braveSearch(ctx context.Context, query string, count int)
 	values.Set("q", query)
 	values.Set("count", strconv.Itoa(count))
 	values.Set("safesearch", "moderate")
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, braveSearchURL+"?"+values.Encode(), nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.braveURL+"?"+values.Encode(), nil)
 	if err != nil {
 		return "", err
 	}
@@ -551,6 +605,16 @@ func (r openAIResponse) toolsUsed() []string {
 	return tools
 }
 
+func (r openAIResponse) toolCalls() int {
+	calls := 0
+	for _, item := range r.Output {
+		if item.Type == "function_call" || item.Type == "web_search_call" {
+			calls++
+		}
+	}
+	return calls
+}
+
 func appendToolsUsed(existing []string, names ...string) []string {
 	seen := make(map[string]struct{}, len(existing)+len(names))
 	result := make([]string, 0, len(existing)+len(names))
@@ -2,6 +2,7 @@ package email
 
 import (
 	appconfig "ai-over-email/pkg/config"
+	"encoding/json"
 	"strings"
 	"testing"
 )
@@ -32,6 +33,16 @@ func TestOpenAIUsageAddsTokenCounts(t *testing.T) {
 	}
 }
 
+func TestOpenAIUsageDecodesTokenDetails(t *testing.T) {
+	var usage openAIUsage
+	if err := json.Unmarshal([]byte(`{"input_tokens":100,"input_tokens_details":{"cached_tokens":40},"output_tokens":30,"output_tokens_details":{"reasoning_tokens":20},"total_tokens":130}`), &usage); err != nil {
+		t.Fatal(err)
+	}
+	if usage != (openAIUsage{InputTokens: 100, CachedInputTokens: 40, OutputTokens: 30, ReasoningTokens: 20, TotalTokens: 130}) {
+		t.Fatalf("usage = %#v", usage)
+	}
+}
+
 func TestOpenAIResponseFunctionCalls(t *testing.T) {
 	response := openAIResponse{
 		Output: []openAIOutputItem{
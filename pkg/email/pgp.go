@@ -21,26 +21,27 @@ const (
 )
 
 func (w *Watcher) decryptVerifiedEmail(ctx context.Context, msg emailMessage) (string, string, []emailAttachment, string, error) {
-	raw := []byte(nil)
-	if len(msg.Raw) > 0 {
-		raw = msg.Raw
-	} else if msg.BlobID != "" {
-		var err error
-		raw, err = w.client.Download(ctx, w.accountID, msg.BlobID, "message.eml", "message/rfc822")
+	payload, ok := extractPGPEncryptedPayload(msg.Raw, extractEmailBody(msg))
+	if !ok {
+		allowed, pattern, err := w.plaintextAllowed(ctx, msg.From)
 		if err != nil {
 			return "", "", nil, "", err
 		}
-	}
-
-	payload, ok := extractPGPEncryptedPayload(raw, extractEmailBody(msg))
-	if !ok {
-		if plaintextSenderAllowed(msg.From, w.creds.PlaintextAllowlist) {
+		if allowed {
 			body := extractEmailBody(msg)
-			attachments, err := w.fetchAttachments(ctx, msg.Attachments)
-			if err != nil {
-				return "", "", nil, "", err
+			var attachments []emailAttachment
+			if len(msg.Attachments) > 0 {
+				var err error
+				attachments, err = w.transport.Attachments(ctx, msg)
+				if err != nil {
+					return "", "", nil, "", err
+				}
+			}
+			if pattern != "" {
+				w.logf("plaintext sender accepted by sender policy: pattern=%s from=%q body_bytes=%d attachments=%d", pattern, formatFrom(msg.From), len(body), len(attachments))
+			} else {
+				w.logf("plaintext sender accepted by allowlist: from=%q body_bytes=%d attachments=%d", formatFrom(msg.From), len(body), len(attachments))
 			}
-			w.logf("plaintext sender accepted by allowlist: from=%q body_bytes=%d attachments=%d", formatFrom(msg.From), len(body), len(attachments))
 			return body, "", attachments, "", nil
 		}
 		return "", "", nil, "not_encrypted", nil
@@ -283,6 +284,10 @@ func extractTextMIMEPart(header mail.Header, body io.Reader) (string, bool) {
 			if err != nil {
 				return "", false
 			}
+			if disposition, _, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition")); strings.EqualFold(disposition, "attachment") {
+				_ = part.Close()
+				continue
+			}
 			text, ok := extractTextMIMEPart(mail.Header(part.Header), part)
 			_ = part.Close()
 			if !ok {
@@ -351,6 +356,22 @@ func stripHTML(text string) string {
 	return strings.Join(strings.Fields(out.String()), " ")
 }
 
+func LocateSigningKeys(ctx context.Context, emails []string) error {
+	return locateSigningKeys(ctx, emails)
+}
+
+func ListPublicKeys(ctx context.Context, out io.Writer, emails []string) error {
+	args := append([]string{"--batch", "--list-keys", "--keyid-format", "long", "--"}, emails...)
+	cmd := exec.CommandContext(ctx, "gpg", args...)
+	var stderr bytes.Buffer
+	cmd.Stdout = out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("list keys: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
 func signatureNeedsPublicKey(status string) bool {
 	return strings.Contains(status, "NO_PUBKEY") || strings.Contains(status, "ERRSIG")
 }
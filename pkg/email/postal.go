@@ -0,0 +1,90 @@
+package email
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type postalFormat struct {
+	Name      string
+	Pattern   *regexp.Regexp
+	Separator string
+	Tail      int
+}
+
+var postalFormats = map[string]postalFormat{
+	"AU": {Name: "Australia", Pattern: regexp.MustCompile(`^\d{4}$`)},
+	"BR": {Name: "Brazil", Pattern: regexp.MustCompile(`^\d{8}$`), Separator: "-", Tail: 3},
+	"CA": {Name: "Canada", Pattern: regexp.MustCompile(`^[ABCEGHJ-NPRSTVXY]\d[ABCEGHJ-NPRSTV-Z]\d[ABCEGHJ-NPRSTV-Z]\d$`), Separator: " ", Tail: 3},
+	"DE": {Name: "Germany", Pattern: regexp.MustCompile(`^\d{5}$`)},
+	"ES": {Name: "Spain", Pattern: regexp.MustCompile(`^(?:0[1-9]|[1-4]\d|5[0-2])\d{3}$`)},
+	"FR": {Name: "France", Pattern: regexp.MustCompile(`^\d{5}$`)},
+	"GB": {Name: "United Kingdom", Pattern: regexp.MustCompile(`^(?:[A-Z]{1,2}\d[A-Z\d]?|GIR)\d[A-Z]{2}$`), Separator: " ", Tail: 3},
+	"IE": {Name: "Ireland", Pattern: regexp.MustCompile(`^(?:[AC-FHKNPRTV-Y]\d{2}|D6W)[0-9AC-FHKNPRTV-Y]{4}$`), Separator: " ", Tail: 4},
+	"IN": {Name: "India", Pattern: regexp.MustCompile(`^[1-9]\d{5}$`)},
+	"IT": {Name: "Italy", Pattern: regexp.MustCompile(`^\d{5}$`)},
+	"JP": {Name: "Japan", Pattern: regexp.MustCompile(`^\d{7}$`), Separator: "-", Tail: 4},
+	"MX": {Name: "Mexico", Pattern: regexp.MustCompile(`^\d{5}$`)},
+	"NL": {Name: "Netherlands", Pattern: regexp.MustCompile(`^[1-9]\d{3}[A-Z]{2}$`), Separator: " ", Tail: 2},
+	"NZ": {Name: "New Zealand", Pattern: regexp.MustCompile(`^\d{4}$`)},
+	"US": {Name: "United States", Pattern: regexp.MustCompile(`^\d{5}(?:\d{4})?$`)},
+}
+
+var (
+	countryAliases         = map[string]string{"UK": "GB", "USA": "US", "UNITED STATES OF AMERICA": "US", "GREAT BRITAIN": "GB", "ENGLAND": "GB", "SCOTLAND": "GB", "WALES": "GB"}
+	countryCodePattern     = regexp.MustCompile(`^[A-Z]{2}$`)
+	genericPostalPattern   = regexp.MustCompile(`^[A-Z0-9]{2,10}$`)
+	postalSeparatorPattern = regexp.MustCompile(`[\s-]+`)
+)
+
+func normalizeCountry(value string) (string, bool) {
+	value = strings.ToUpper(strings.Join(strings.Fields(value), " "))
+	if value == "" {
+		return "", false
+	}
+	if code, ok := countryAliases[value]; ok {
+		return code, true
+	}
+	for code, format := range postalFormats {
+		if value == strings.ToUpper(format.Name) {
+			return code, true
+		}
+	}
+	return value, countryCodePattern.MatchString(value)
+}
+
+func normalizePostalCode(country string, code string) (string, bool) {
+	if country == "" {
+		country = "US"
+	}
+	compact := postalSeparatorPattern.ReplaceAllString(strings.ToUpper(strings.TrimSpace(code)), "")
+	format, ok := postalFormats[country]
+	if !ok {
+		return compact, genericPostalPattern.MatchString(compact)
+	}
+	if !format.Pattern.MatchString(compact) {
+		return "", false
+	}
+	if country == "US" && len(compact) == 9 {
+		return compact[:5] + "-" + compact[5:], true
+	}
+	if format.Tail > 0 {
+		return compact[:len(compact)-format.Tail] + format.Separator + compact[len(compact)-format.Tail:], true
+	}
+	return compact, true
+}
+
+func countryName(code string) string {
+	if format, ok := postalFormats[code]; ok {
+		return format.Name
+	}
+	return code
+}
+
+func postalCodeLocation(country string, code string) string {
+	if country == "" || country == "US" {
+		return zipCodeLocation(code)
+	}
+	return fmt.Sprintf("postal code %s, %s", code, countryName(country))
+}
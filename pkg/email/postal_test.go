@@ -0,0 +1,174 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNormalizePostalCode(t *testing.T) {
+	for _, tc := range []struct {
+		country, code, want string
+		ok                  bool
+	}{
+		{"", "10001", "10001", true},
+		{"US", "100011234", "10001-1234", true},
+		{"US", "1000", "", false},
+		{"CA", "m5v2t6", "M5V 2T6", true},
+		{"CA", "D5V 2T6", "", false},
+		{"GB", "sw1a1aa", "SW1A 1AA", true},
+		{"GB", "EC1A 1BB", "EC1A 1BB", true},
+		{"IN", "110 001", "110001", true},
+		{"IN", "011001", "", false},
+		{"NL", "1012ab", "1012 AB", true},
+		{"JP", "100-0001", "100-0001", true},
+		{"IE", "D02 X285", "D02 X285", true},
+		{"SE", "114 55", "11455", true},
+		{"SE", "!", "", false},
+	} {
+		got, ok := normalizePostalCode(tc.country, tc.code)
+		if ok != tc.ok || (ok && got != tc.want) {
+			t.Errorf("normalizePostalCode(%q, %q) = %q, %t; want %q, %t", tc.country, tc.code, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestNormalizeCountry(t *testing.T) {
+	for value, want := range map[string]string{"ca": "CA", "UK": "GB", "United  Kingdom": "GB", "india": "IN", "se": "SE"} {
+		if got, ok := normalizeCountry(value); !ok || got != want {
+			t.Errorf("normalizeCountry(%q) = %q, %t; want %q", value, got, ok, want)
+		}
+	}
+	for _, value := range []string{"", "Canadia", "C4"} {
+		if got, ok := normalizeCountry(value); ok {
+			t.Errorf("normalizeCountry(%q) = %q, want invalid", value, got)
+		}
+	}
+}
+
+func TestCorrespondentStoreInternationalProfile(t *testing.T) {
+	ctx := context.Background()
+	store := openTestCorrespondentStore(t)
+	email := testAddress("sender", "mail.test")
+	if _, err := store.Register(ctx, email, "", "UTC-05:00"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.SetProfile(ctx, email, CorrespondentProfileUpdate{Country: "CA", ZipCode: "10001"}); err == nil || !strings.Contains(err.Error(), "Canada") {
+		t.Fatalf("SetProfile with a US ZIP for Canada = %v", err)
+	}
+	if err := store.SetProfile(ctx, email, CorrespondentProfileUpdate{Country: "Canadia"}); err == nil {
+		t.Fatal("SetProfile accepted an unknown country")
+	}
+	if err := store.UpdateProfile(ctx, email, correspondentProfileUpdate{ZipCode: "80202"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UpdateProfile(ctx, email, correspondentProfileUpdate{Country: "ca", TimeZone: "America/Toronto"}); err != nil {
+		t.Fatal(err)
+	}
+	detail, err := store.GetCorrespondent(ctx, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detail.Country != "CA" || detail.ZipCode != "" || detail.TimeZone != "America/Toronto" || detail.TimeZoneSource != "email_body" {
+		t.Fatalf("after moving to Canada = %+v", detail.Correspondent)
+	}
+
+	if err := store.UpdateProfile(ctx, email, correspondentProfileUpdate{ZipCode: "12345"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetProfile(ctx, email, CorrespondentProfileUpdate{ZipCode: "m5v 2t6"}); err != nil {
+		t.Fatal(err)
+	}
+	sender, _, err := store.SenderContext(ctx, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prompt := sender.prompt(time.Now()); !strings.Contains(prompt, "- Location: postal code M5V 2T6, Canada") {
+		t.Fatalf("prompt:\n%s", prompt)
+	}
+}
+
+func TestWatcherExtractsProfileWithModelWhenRequestPending(t *testing.T) {
+	ctx := context.Background()
+	var calls atomic.Int32
+	reply := `{"country": "CA", "postal_code": "", "time_zone": "America/Toronto"}`
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var request struct {
+			Text struct {
+				Format struct {
+					Type string `json:"type"`
+				} `json:"format"`
+			} `json:"text"`
+			Tools []any `json:"tools"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Text.Format.Type != "json_schema" || len(request.Tools) != 0 {
+			http.Error(w, "want a structured-output request without tools", http.StatusBadRequest)
+			return
+		}
+		if reply == "" {
+			http.Error(w, "overloaded", http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(openAIResponse{
+			Output: []openAIOutputItem{{Type: "message", Content: []openAIOutputContent{{Type: "output_text", Text: reply}}}},
+			Usage:  openAIUsage{InputTokens: 50, OutputTokens: 10, TotalTokens: 60},
+		})
+	}))
+	t.Cleanup(stub.Close)
+
+	w := &Watcher{store: openTestCorrespondentStore(t), openai: newOpenAIClient("openai-test", "", "", nil)}
+	w.openai.UseEndpoints(stub.URL, "", nil)
+	email := testAddress("sender", "mail.test")
+	msg := emailMessage{ID: "m1", From: []emailAddress{{Email: email}}}
+
+	pending, err := profilePending(ctx, w.store, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.updateCorrespondentProfiles(ctx, msg, "Hi, I'm in Toronto, Eastern time.", map[string]bool{email: pending}); err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 0 {
+		t.Fatalf("model called before the profile request was answered")
+	}
+
+	if err := w.store.MarkProfileRequestSent(ctx, email); err != nil {
+		t.Fatal(err)
+	}
+	if pending, err = profilePending(ctx, w.store, email); err != nil || !pending {
+		t.Fatalf("pending = %t, %v", pending, err)
+	}
+	if err := w.updateCorrespondentProfiles(ctx, msg, "Hi, I'm in Toronto, Eastern time.", map[string]bool{email: pending}); err != nil {
+		t.Fatal(err)
+	}
+	detail, err := w.store.GetCorrespondent(ctx, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 1 || detail.Country != "CA" || detail.TimeZone != "America/Toronto" {
+		t.Fatalf("after model extraction: calls=%d profile=%+v", calls.Load(), detail.Correspondent)
+	}
+	if tokens, _, err := w.store.Totals(ctx); err != nil || tokens != 60 {
+		t.Fatalf("account tokens = %d, %v", tokens, err)
+	}
+
+	reply = ""
+	if err := w.updateCorrespondentProfiles(ctx, msg, "Actually I moved, ZIP 80202.", map[string]bool{email: true}); err != nil {
+		t.Fatal(err)
+	}
+	if detail, _ = w.store.GetCorrespondent(ctx, email); detail.ZipCode != "" {
+		t.Fatalf("pattern fallback stored a US ZIP for a Canadian profile: %+v", detail.Correspondent)
+	}
+}
+
+func profilePending(ctx context.Context, store *correspondentStore, email string) (bool, error) {
+	registered, err := store.Register(ctx, email, "", "")
+	return registered.ProfilePending, err
+}
@@ -0,0 +1,185 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type ReplyPreview struct {
+	ID              string          `json:"id"`
+	Decision        string          `json:"decision"`
+	Reason          string          `json:"reason,omitempty"`
+	To              []emailAddress  `json:"to,omitempty"`
+	Subject         string          `json:"subject,omitempty"`
+	Model           string          `json:"model,omitempty"`
+	ReasoningEffort string          `json:"reasoningEffort,omitempty"`
+	ToolsUsed       []string        `json:"toolsUsed,omitempty"`
+	TextBody        string          `json:"textBody,omitempty"`
+	HTMLBody        string          `json:"htmlBody,omitempty"`
+	Attachments     []AttachmentRef `json:"attachments,omitempty"`
+	Usage           openAIUsage     `json:"usage"`
+}
+
+func (w *Watcher) PreviewReply(ctx context.Context, id string) (ReplyPreview, error) {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return ReplyPreview{}, fmt.Errorf("message id is required")
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.connected && !w.connectedReadOnly {
+		if err := w.connectReadOnly(ctx); err != nil {
+			return ReplyPreview{}, err
+		}
+	}
+
+	full, err := w.transport.Fetch(ctx, id)
+	if err != nil {
+		return ReplyPreview{}, err
+	}
+	preview := ReplyPreview{ID: full.ID, To: full.From}
+	if reason := w.skipAutoReplyReason(full); reason != "" {
+		preview.Decision = "skipped"
+		preview.Reason = reason
+		return preview, nil
+	}
+
+	blocked, err := w.senderBlocked(ctx, full)
+	if err != nil {
+		return ReplyPreview{}, err
+	}
+	if blocked {
+		preview.Decision = "skipped"
+		preview.Reason = "blocked_sender"
+		return preview, nil
+	}
+
+	usage, err := w.peekInboundUsage(ctx, full)
+	if err != nil {
+		return ReplyPreview{}, err
+	}
+	footer := usage.footer()
+	if !usage.Allowed {
+		preview.Decision = "rate_limited"
+		preview.To = full.From[:1]
+		preview.Subject = rateLimitReplySubject(usage)
+		body := rateLimitReplyBody(usage)
+		return w.finishPreview(ctx, preview, body, emailMessage{}, "", footer)
+	}
+
+	body, protectedSubject, attachments, rejectReason, err := w.decryptVerifiedEmail(ctx, full)
+	if err != nil {
+		return ReplyPreview{}, err
+	}
+	if protectedSubject != "" {
+		full.Subject = protectedSubject
+	}
+	preview.Subject = replySubject(full.Subject)
+	if rejectReason != "" {
+		preview.Decision = "pgp_rejected"
+		preview.Reason = rejectReason
+		return w.finishPreview(ctx, preview, pgpRequiredReply(rejectReason, w.creds.PublicEmail), full, "", footer)
+	}
+	if command := memoryCommand(full.Subject); command != "" {
+		var notes []CorrespondentNote
+		if w.memoryEnabled() {
+			if notes, err = w.store.Notes(ctx, full.From[0].Email); err != nil {
+				return ReplyPreview{}, err
+			}
+		}
+		preview.Decision = "memory_command"
+		preview.To = full.From[:1]
+		preview.Subject = memoryReplySubject
+		return w.finishPreview(ctx, preview, memoryCommandBody(command, notes, w.memoryEnabled()), emailMessage{}, "", footer)
+	}
+	if w.creds.OpenAIAPIToken == "" {
+		return ReplyPreview{}, fmt.Errorf("OPENAI_API_TOKEN is missing from credentials")
+	}
+
+	senderContext, err := w.senderContextPrompt(ctx, full)
+	if err != nil {
+		return ReplyPreview{}, err
+	}
+	notes, err := w.notesPrompt(ctx, full)
+	if err != nil {
+		return ReplyPreview{}, err
+	}
+	modelSettings, err := w.modelSettings(ctx, full)
+	if err != nil {
+		return ReplyPreview{}, err
+	}
+	budget, err := w.checkBudgets(ctx, full, modelSettings, time.Now())
+	if err != nil {
+		return ReplyPreview{}, err
+	}
+	footer.BudgetRemaining = w.budgetRemaining(ctx, full, time.Now())
+	if budget.Exhausted != "" {
+		preview.Decision = "budget_exhausted"
+		preview.Reason = budget.Exhausted
+		preview.To = full.From[:1]
+		preview.Subject = budgetReplySubject
+		return w.finishPreview(ctx, preview, budgetReplyBody(budget.Exhausted, time.Now()), emailMessage{}, "", footer)
+	}
+	modelSettings = budget.Settings
+	w.logf("reply preview calling OpenAI: id=%s model=%s reasoning_effort=%s body_bytes=%d attachments=%d sender_context=%t notes=%t", full.ID, modelSettings.Model, modelSettings.ReasoningEffort, len(body), len(attachments), senderContext != "", notes != "")
+	reply, err := w.openai.AnswerEmail(ctx, full.Subject, body, attachments, joinPromptSections(senderContext, notes), modelSettings)
+	if err != nil {
+		return ReplyPreview{}, err
+	}
+	preview.Decision = "answered"
+	preview.Model = reply.Model
+	preview.ReasoningEffort = modelSettings.ReasoningEffort
+	preview.ToolsUsed = reply.ToolsUsed
+	preview.Usage = reply.Usage
+	for _, attachment := range attachments {
+		preview.Attachments = append(preview.Attachments, AttachmentRef{
+			Name:   attachmentName(attachment),
+			Type:   attachmentType(attachment),
+			Size:   len(attachment.Data),
+			BlobID: attachment.BlobID,
+		})
+	}
+	footer.TokensUsed = reply.Usage.TotalTokens
+	footer.Model = reply.Model
+	footer.ToolsUsed = reply.ToolsUsed
+	return w.finishPreview(ctx, preview, reply.Text, full, body, footer)
+}
+
+func (w *Watcher) finishPreview(ctx context.Context, preview ReplyPreview, reply string, original emailMessage, originalBody string, footer emailFooterStats) (ReplyPreview, error) {
+	htmlBody, err := formatReplyHTMLBody(reply, original, originalBody)
+	if err != nil {
+		return ReplyPreview{}, err
+	}
+	if w.store != nil {
+		totalTokens, totalSent, err := w.store.Totals(ctx)
+		if err != nil {
+			return ReplyPreview{}, err
+		}
+		footer.TotalTokensEver = totalTokens + int64(footer.TokensUsed)
+		footer.TotalEmailsEver = totalSent + 1
+	}
+	preview.TextBody, preview.HTMLBody = appendResponseFooter(formatReplyBody(reply, original, originalBody), htmlBody, footer)
+	w.logf("reply preview complete: id=%s decision=%s reason=%s text_bytes=%d html_bytes=%d", preview.ID, preview.Decision, preview.Reason, len(preview.TextBody), len(preview.HTMLBody))
+	return preview, nil
+}
+
+func (w *Watcher) peekInboundUsage(ctx context.Context, msg emailMessage) (inboundUsage, error) {
+	for _, from := range msg.From {
+		email := strings.ToLower(strings.TrimSpace(from.Email))
+		if email == "" {
+			continue
+		}
+		if w.store == nil {
+			return w.untrackedUsage(email), nil
+		}
+		limit, _, err := w.rateLimitFor(ctx, email)
+		if err != nil {
+			return inboundUsage{}, err
+		}
+		return w.store.PeekInboundMessage(ctx, email, limit, w.appConfig.RateLimits.LocalDay, time.Now())
+	}
+	return w.untrackedUsage(""), nil
+}
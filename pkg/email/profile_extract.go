@@ -0,0 +1,64 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	appconfig "ai-over-email/pkg/config"
+)
+
+const profileExtractionPrompt = `You read an email a person sent in reply to a request for their location and time zone, and extract only what they say about themselves.
+
+- country: two-letter ISO 3166 code of the country they say they live in, or implied by a city, region or postal code they give. Empty if unknown.
+- postal_code: the ZIP or postal code they give for themselves, exactly as written. Empty if they give none. Never invent one from a city.
+- time_zone: IANA time zone name such as America/Toronto or Asia/Kolkata. Derive it from what they say, for example "Toronto, Eastern time" is America/Toronto and "I'm in Mumbai" is Asia/Kolkata. Use a UTC offset such as UTC+05:30 only when they give nothing more specific. Empty if unknown.
+
+Ignore quoted earlier messages, signatures of other people, and addresses that are not theirs, such as a shipping address they ask about.`
+
+var profileExtractionSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"country":     map[string]string{"type": "string"},
+		"postal_code": map[string]string{"type": "string"},
+		"time_zone":   map[string]string{"type": "string"},
+	},
+	"required":             []string{"country", "postal_code", "time_zone"},
+	"additionalProperties": false,
+}
+
+func (c *openAIClient) ExtractProfile(ctx context.Context, text string, settings appconfig.OpenAIModelSettings) (correspondentProfileUpdate, openAIUsage, error) {
+	settings = normalizeOpenAIModelSettings(settings)
+	decoded, err := c.sendOpenAIRequest(ctx, map[string]any{
+		"model": settings.Model,
+		"input": []map[string]any{
+			{"role": "system", "content": profileExtractionPrompt},
+			{"role": "user", "content": text},
+		},
+		"reasoning": map[string]string{
+			"effort": settings.ReasoningEffort,
+		},
+		"text": map[string]any{
+			"format": map[string]any{
+				"type":   "json_schema",
+				"name":   "correspondent_profile",
+				"strict": true,
+				"schema": profileExtractionSchema,
+			},
+		},
+		"_search_mode": "none",
+	})
+	if err != nil {
+		return correspondentProfileUpdate{}, openAIUsage{}, err
+	}
+	var extracted struct {
+		Country    string `json:"country"`
+		PostalCode string `json:"postal_code"`
+		TimeZone   string `json:"time_zone"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(decoded.outputText())), &extracted); err != nil {
+		return correspondentProfileUpdate{}, decoded.Usage, fmt.Errorf("decode profile extraction: %w", err)
+	}
+	return correspondentProfileUpdate{Country: extracted.Country, ZipCode: extracted.PostalCode, TimeZone: extracted.TimeZone}, decoded.Usage, nil
+}
@@ -0,0 +1,225 @@
+package email
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	appconfig "ai-over-email/pkg/config"
+)
+
+// Fixed width so that received_at sorts as text in time order.
+const inboundTimeLayout = "2006-01-02T15:04:05.000000Z07:00"
+
+const inboundMessageRetention = 32 * 24 * time.Hour
+
+type inboundUsage struct {
+	Email     string
+	Day       string
+	Count     int
+	Limit     appconfig.RateLimit
+	Remaining int
+	Allowed   bool
+	Zone      string
+	ResetAt   time.Time
+	NoticeDue bool
+}
+
+func (u inboundUsage) footer() emailFooterStats {
+	if u.Limit.Unlimited {
+		return emailFooterStats{MessageLimit: -1}
+	}
+	return emailFooterStats{MessagesRemaining: u.Remaining, MessageLimit: u.Limit.Messages, MessagePeriod: u.period()}
+}
+
+func (u inboundUsage) period() string {
+	switch {
+	case u.Limit.Algorithm == appconfig.RateLimitTokenBucket:
+		return "now"
+	case u.Limit.Algorithm == appconfig.RateLimitSliding:
+		return "in the last " + windowText(u.Limit.WindowDuration())
+	case u.Limit.CalendarDay():
+		return "today"
+	default:
+		return "until " + u.ResetAt.UTC().Format("15:04 UTC")
+	}
+}
+
+func windowText(window time.Duration) string {
+	if window%time.Hour != 0 {
+		return fmt.Sprintf("%d minutes", int(window/time.Minute))
+	}
+	if window == time.Hour {
+		return "hour"
+	}
+	return fmt.Sprintf("%d hours", int(window/time.Hour))
+}
+
+func (s *correspondentStore) CountInboundMessage(ctx context.Context, email string, limit appconfig.RateLimit, localDay bool, now time.Time) (inboundUsage, error) {
+	return s.checkInboundMessage(ctx, email, limit, localDay, now, true)
+}
+
+// PeekInboundMessage reports the usage the next message would have without
+// counting it.
+func (s *correspondentStore) PeekInboundMessage(ctx context.Context, email string, limit appconfig.RateLimit, localDay bool, now time.Time) (inboundUsage, error) {
+	return s.checkInboundMessage(ctx, email, limit, localDay, now, false)
+}
+
+func (s *correspondentStore) checkInboundMessage(ctx context.Context, email string, limit appconfig.RateLimit, localDay bool, now time.Time, record bool) (inboundUsage, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return inboundUsage{}, fmt.Errorf("correspondent email is empty")
+	}
+	if limit.Messages < 1 && !limit.Unlimited {
+		return inboundUsage{}, fmt.Errorf("message limit must be positive")
+	}
+	now = now.UTC()
+	day := now.Format("2006-01-02")
+	nowText := now.Format(inboundTimeLayout)
+	usage := inboundUsage{Email: email, Day: day, Limit: limit, Zone: "UTC"}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return inboundUsage{}, err
+	}
+	defer tx.Rollback()
+
+	if record {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO correspondent_daily_usage (
+			email, day, message_count, first_message_at, last_message_at, updated_at
+		) VALUES (?, ?, 1, ?, ?, ?)
+		ON CONFLICT (email, day) DO UPDATE SET
+			message_count = message_count + 1,
+			last_message_at = excluded.last_message_at,
+			updated_at = excluded.updated_at`, email, day, now.Format(time.RFC3339Nano), now.Format(time.RFC3339Nano), now.Format(time.RFC3339Nano)); err != nil {
+			return inboundUsage{}, err
+		}
+	}
+	if limit.Unlimited {
+		usage.Allowed = true
+		return usage, commitIf(tx, record)
+	}
+
+	var bucketTokens float64
+	var bucketUpdatedAt, noticeSentAt string
+	err = tx.QueryRowContext(ctx, `SELECT bucket_tokens, bucket_updated_at, notice_sent_at FROM rate_limit_state WHERE email = ?`, email).Scan(&bucketTokens, &bucketUpdatedAt, &noticeSentAt)
+	if err != nil && err != sql.ErrNoRows {
+		return inboundUsage{}, err
+	}
+
+	window := limit.WindowDuration()
+	windowStart := now.Add(-window)
+	switch {
+	case limit.Algorithm == appconfig.RateLimitTokenBucket:
+		capacity := float64(limit.Messages)
+		tokens := capacity
+		if updated, err := time.Parse(inboundTimeLayout, bucketUpdatedAt); err == nil {
+			tokens = min(capacity, bucketTokens+now.Sub(updated).Seconds()/window.Seconds()*capacity)
+		}
+		usage.Allowed = tokens >= 1
+		if usage.Allowed {
+			tokens--
+		}
+		usage.Remaining = int(tokens)
+		usage.Count = limit.Messages - usage.Remaining
+		usage.ResetAt = now
+		if tokens < 1 {
+			usage.ResetAt = now.Add(time.Duration((1 - tokens) / capacity * float64(window)))
+		}
+		if record {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO rate_limit_state (email, bucket_tokens, bucket_updated_at, updated_at)
+				VALUES (?, ?, ?, ?)
+				ON CONFLICT (email) DO UPDATE SET
+					bucket_tokens = excluded.bucket_tokens,
+					bucket_updated_at = excluded.bucket_updated_at,
+					updated_at = excluded.updated_at`, email, tokens, nowText, nowText); err != nil {
+				return inboundUsage{}, err
+			}
+		}
+	default:
+		end := now
+		if limit.Algorithm != appconfig.RateLimitSliding {
+			if limit.CalendarDay() {
+				location := time.UTC
+				if localDay {
+					var zone string
+					if err := tx.QueryRowContext(ctx, `SELECT time_zone FROM correspondents WHERE email = ?`, email).Scan(&zone); err != nil && err != sql.ErrNoRows {
+						return inboundUsage{}, err
+					}
+					if loc, ok := correspondentLocation(zone); ok {
+						location, usage.Zone = loc, zone
+					}
+				}
+				local := now.In(location)
+				midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location)
+				windowStart, end = midnight.UTC(), midnight.AddDate(0, 0, 1).UTC()
+				usage.Day = midnight.Format("2006-01-02")
+			} else {
+				windowStart = now.Truncate(window)
+				end = windowStart.Add(window)
+			}
+		}
+		// Only accepted messages are stored, so a sender who keeps writing
+		// while limited does not push the window further out.
+		inWindow := `received_at >= ?`
+		if limit.Algorithm == appconfig.RateLimitSliding {
+			inWindow = `received_at > ?`
+		}
+		var accepted int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM inbound_messages WHERE email = ? AND `+inWindow, email, windowStart.Format(inboundTimeLayout)).Scan(&accepted); err != nil {
+			return inboundUsage{}, err
+		}
+		usage.Count = accepted + 1
+		usage.Allowed = usage.Count <= limit.Messages
+		usage.Remaining = max(limit.Messages-usage.Count, 0)
+		usage.ResetAt = end
+		if limit.Algorithm == appconfig.RateLimitSliding && !usage.Allowed {
+			var oldest string
+			err := tx.QueryRowContext(ctx, `SELECT received_at FROM inbound_messages WHERE email = ? AND `+inWindow+` ORDER BY received_at LIMIT 1 OFFSET ?`,
+				email, windowStart.Format(inboundTimeLayout), accepted-limit.Messages).Scan(&oldest)
+			if err != nil && err != sql.ErrNoRows {
+				return inboundUsage{}, err
+			}
+			if expires, err := time.Parse(inboundTimeLayout, oldest); err == nil {
+				usage.ResetAt = expires.Add(window)
+			}
+		}
+		if record && usage.Allowed {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO inbound_messages (email, received_at) VALUES (?, ?)`, email, nowText); err != nil {
+				return inboundUsage{}, err
+			}
+			if _, err := tx.ExecContext(ctx, `DELETE FROM inbound_messages WHERE email = ? AND received_at < ?`, email, now.Add(-inboundMessageRetention).Format(inboundTimeLayout)); err != nil {
+				return inboundUsage{}, err
+			}
+		}
+	}
+
+	if !usage.Allowed {
+		sent, err := time.Parse(inboundTimeLayout, noticeSentAt)
+		usage.NoticeDue = err != nil || sent.Before(windowStart)
+		if record {
+			if _, err := tx.ExecContext(ctx, `UPDATE correspondent_daily_usage SET limited_count = limited_count + 1 WHERE email = ? AND day = ?`, email, day); err != nil {
+				return inboundUsage{}, err
+			}
+		}
+	}
+	return usage, commitIf(tx, record)
+}
+
+func commitIf(tx *sql.Tx, commit bool) error {
+	if !commit {
+		return nil
+	}
+	return tx.Commit()
+}
+
+func (s *correspondentStore) MarkRateLimitNotice(ctx context.Context, email string, now time.Time) error {
+	email = strings.ToLower(strings.TrimSpace(email))
+	nowText := now.UTC().Format(inboundTimeLayout)
+	_, err := s.db.ExecContext(ctx, `INSERT INTO rate_limit_state (email, notice_sent_at, updated_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT (email) DO UPDATE SET notice_sent_at = excluded.notice_sent_at, updated_at = excluded.updated_at`, email, nowText, nowText)
+	return err
+}
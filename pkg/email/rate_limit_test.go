@@ -0,0 +1,160 @@
+package email
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	appconfig "ai-over-email/pkg/config"
+	"ai-over-email/pkg/jmaptest"
+)
+
+func TestCorrespondentStoreSlidingWindow(t *testing.T) {
+	ctx := context.Background()
+	store := openTestCorrespondentStore(t)
+	email := testAddress("sender", "mail.test")
+	limit := appconfig.RateLimit{Messages: 2, Window: "1h", Algorithm: appconfig.RateLimitSliding}
+	start := time.Date(2026, 7, 4, 12, 0, 0, 0, time.UTC)
+
+	count := func(after time.Duration) inboundUsage {
+		t.Helper()
+		usage, err := store.CountInboundMessage(ctx, email, limit, false, start.Add(after))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return usage
+	}
+	for i, after := range []time.Duration{0, 10 * time.Minute} {
+		if usage := count(after); !usage.Allowed || usage.Remaining != 1-i {
+			t.Fatalf("message %d = %+v", i+1, usage)
+		}
+	}
+	blocked := count(20 * time.Minute)
+	if blocked.Allowed || !blocked.NoticeDue || !blocked.ResetAt.Equal(start.Add(time.Hour)) {
+		t.Fatalf("over limit = %+v", blocked)
+	}
+	if err := store.MarkRateLimitNotice(ctx, email, start.Add(20*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if usage := count(30 * time.Minute); usage.Allowed || usage.NoticeDue {
+		t.Fatalf("second over limit = %+v, want blocked without a notice", usage)
+	}
+	if usage := count(time.Hour); !usage.Allowed {
+		t.Fatalf("after the first message aged out = %+v", usage)
+	}
+	if usage := count(time.Hour + 5*time.Minute); usage.Allowed || usage.NoticeDue {
+		t.Fatalf("over limit within an hour of the notice = %+v", usage)
+	}
+	if usage := count(time.Hour + 25*time.Minute); !usage.Allowed {
+		t.Fatalf("after the second message aged out = %+v", usage)
+	}
+	if usage := count(time.Hour + 30*time.Minute); usage.Allowed || !usage.NoticeDue {
+		t.Fatalf("over limit in a later window = %+v, want a new notice", usage)
+	}
+}
+
+func TestCorrespondentStoreTokenBucket(t *testing.T) {
+	ctx := context.Background()
+	store := openTestCorrespondentStore(t)
+	email := testAddress("sender", "mail.test")
+	limit := appconfig.RateLimit{Messages: 3, Window: "3h", Algorithm: appconfig.RateLimitTokenBucket}
+	start := time.Date(2026, 7, 4, 12, 0, 0, 0, time.UTC)
+
+	for i := range 3 {
+		usage, err := store.CountInboundMessage(ctx, email, limit, false, start)
+		if err != nil || !usage.Allowed || usage.Remaining != 2-i {
+			t.Fatalf("message %d = %+v, %v", i+1, usage, err)
+		}
+	}
+	usage, err := store.CountInboundMessage(ctx, email, limit, false, start.Add(30*time.Minute))
+	if err != nil || usage.Allowed || !usage.ResetAt.Equal(start.Add(time.Hour)) {
+		t.Fatalf("empty bucket = %+v, %v", usage, err)
+	}
+	if usage, err = store.PeekInboundMessage(ctx, email, limit, false, start.Add(time.Hour)); err != nil || !usage.Allowed {
+		t.Fatalf("peek after refill = %+v, %v", usage, err)
+	}
+	if usage, err = store.CountInboundMessage(ctx, email, limit, false, start.Add(time.Hour)); err != nil || !usage.Allowed || usage.Remaining != 0 {
+		t.Fatalf("after refill = %+v, %v", usage, err)
+	}
+	if usage.footer().MessagePeriod != "now" {
+		t.Fatalf("footer = %+v", usage.footer())
+	}
+}
+
+func TestCorrespondentStoreLocalDayBoundary(t *testing.T) {
+	ctx := context.Background()
+	store := openTestCorrespondentStore(t)
+	email := testAddress("sender", "mail.test")
+	if _, err := store.Register(ctx, email, "Sender", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.db.ExecContext(ctx, `UPDATE correspondents SET time_zone = 'America/New_York' WHERE email = ?`, email); err != nil {
+		t.Fatal(err)
+	}
+	limit := appconfig.RateLimit{Messages: 1}
+	evening := time.Date(2026, 7, 4, 3, 0, 0, 0, time.UTC)
+
+	usage, err := store.CountInboundMessage(ctx, email, limit, true, evening)
+	if err != nil || !usage.Allowed || usage.Day != "2026-07-03" {
+		t.Fatalf("local evening = %+v, %v", usage, err)
+	}
+	usage, err = store.CountInboundMessage(ctx, email, limit, true, evening.Add(30*time.Minute))
+	if err != nil || usage.Allowed || usage.Zone != "America/New_York" {
+		t.Fatalf("same local day = %+v, %v", usage, err)
+	}
+	if body := rateLimitReplyBody(usage); !strings.Contains(body, "per day in your time zone (America/New_York)") || !strings.Contains(body, "for 2026-07-03") {
+		t.Fatalf("notice body:\n%s", body)
+	}
+	if usage, err = store.CountInboundMessage(ctx, email, limit, true, evening.Add(2*time.Hour)); err != nil || !usage.Allowed {
+		t.Fatalf("after local midnight = %+v, %v", usage, err)
+	}
+	if usage, err = store.PeekInboundMessage(ctx, email, limit, false, evening.Add(2*time.Hour)); err != nil || usage.Allowed {
+		t.Fatalf("same UTC day = %+v, %v", usage, err)
+	}
+}
+
+func TestWatcherSendsOneRateLimitNoticePerWindow(t *testing.T) {
+	sender := testAddress("sender", "mail.test")
+	f := startFakeJMAPWatcherWithConfig(t, sender, `"rate_limits": {"default": {"messages": 1}}`)
+
+	deliver := func(subject string) string {
+		t.Helper()
+		id, err := f.server.Deliver(jmaptest.Message{
+			From:    []jmaptest.Address{{Email: sender}},
+			To:      []jmaptest.Address{{Email: f.server.Username}},
+			Subject: subject,
+			Text:    "Hello",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	deliver("First")
+	if _, err := f.server.WaitForSubmissions(2, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	deliver("Second")
+	submissions, err := f.server.WaitForSubmissions(3, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notice := submissions[2].Email
+	if notice.Subject != "Daily message limit reached" || !strings.Contains(notice.TextBody, "up to 1 messages per sender per UTC day") {
+		t.Fatalf("notice %q:\n%s", notice.Subject, notice.TextBody)
+	}
+
+	third := deliver("Third")
+	f.waitFor(t, "the third message to be deleted", func() bool {
+		_, ok := f.server.Email(third)
+		return !ok
+	})
+	if got := len(f.server.Submissions()); got != 3 {
+		t.Fatalf("submissions = %d, want no second notice", got)
+	}
+	if calls := f.openAICalls.Load(); calls != 1 {
+		t.Fatalf("OpenAI calls = %d, want 1", calls)
+	}
+}
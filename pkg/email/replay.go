@@ -0,0 +1,34 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+func (w *Watcher) Replay(ctx context.Context, id string) error {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return fmt.Errorf("message id is required")
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.connected {
+		if err := w.connect(ctx); err != nil {
+			return err
+		}
+	}
+
+	msg, err := w.transport.Fetch(ctx, id)
+	if err != nil {
+		return err
+	}
+	w.seen[msg.ID] = struct{}{}
+	if reason := w.skipAutoReplyReason(msg); reason != "" {
+		w.handleAutoReplyGuard(ctx, msg, reason, "replay")
+		return nil
+	}
+	w.logf("replaying message through auto-reply pipeline: id=%s from=%q subject=%q", msg.ID, formatFrom(msg.From), msg.Subject)
+	return w.maybeAutoReply(ctx, msg)
+}
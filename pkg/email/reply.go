@@ -2,12 +2,14 @@ package email
 
 import (
 	"bytes"
+	"cmp"
 	"fmt"
 	"html"
 	"strconv"
 	"strings"
 	"time"
 
+	appconfig "ai-over-email/pkg/config"
 	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark/extension"
 )
@@ -18,8 +20,10 @@ type emailFooterStats struct {
 	TotalEmailsEver   int64
 	Model             string
 	ToolsUsed         []string
-	RemainingToday    int
-	DailyMessageLimit int
+	MessagesRemaining int
+	MessageLimit      int
+	MessagePeriod     string
+	BudgetRemaining   string
 }
 
 func extractEmailBody(msg emailMessage) string {
@@ -144,6 +148,13 @@ func htmlEmailText(body string) string {
 	return strings.ReplaceAll(escaped, "\n", "<br>\n")
 }
 
+func appendResponseFooter(textBody string, htmlBody string, stats emailFooterStats) (string, string) {
+	if stats.MessageLimit == 0 {
+		stats.MessageLimit = appconfig.DefaultRateLimitMessages
+	}
+	return appendResponseFooterText(textBody, stats), appendResponseFooterHTML(htmlBody, stats)
+}
+
 func appendResponseFooterText(body string, stats emailFooterStats) string {
 	body = strings.TrimRight(strings.TrimSpace(body), "\r\n")
 	return body + "\n\n---\n" + responseFooterText(stats)
@@ -160,14 +171,12 @@ func appendResponseFooterHTML(body string, stats emailFooterStats) string {
 }
 
 func responseFooterText(stats emailFooterStats) string {
-	remaining := stats.RemainingToday
-	if remaining < 0 {
-		remaining = 0
-	}
-	limit := stats.DailyMessageLimit
-	if limit <= 0 {
-		limit = dailyMessageLimit
+	remaining := max(stats.MessagesRemaining, 0)
+	limit := stats.MessageLimit
+	if limit == 0 {
+		limit = appconfig.DefaultRateLimitMessages
 	}
+	period := cmp.Or(stats.MessagePeriod, "today")
 	model := strings.TrimSpace(stats.Model)
 	if model == "" {
 		model = "unknown"
@@ -182,7 +191,12 @@ func responseFooterText(stats emailFooterStats) string {
 		"Tokens used for this email: " + strconv.Itoa(stats.TokensUsed),
 		"Total tokens used by this email account: " + strconv.FormatInt(stats.TotalTokensEver, 10),
 		"Total emails sent by this service: " + strconv.FormatInt(stats.TotalEmailsEver, 10),
-		"Messages remaining today: " + strconv.Itoa(remaining) + " of " + strconv.Itoa(limit),
+	}
+	if limit > 0 {
+		parts = append(parts, "Messages remaining "+period+": "+strconv.Itoa(remaining)+" of "+strconv.Itoa(limit))
+	}
+	if stats.BudgetRemaining != "" {
+		parts = append(parts, stats.BudgetRemaining)
 	}
 	return strings.Join(parts, " | ")
 }
@@ -0,0 +1,37 @@
+package email
+
+import (
+	"time"
+
+	"ai-over-email/pkg/mimebuild"
+)
+
+func buildRFC822(from emailAddress, msg outgoingEmail, date time.Time, messageID string) ([]byte, error) {
+	message := mimebuild.Message{
+		From:       mimeAddress(from),
+		Subject:    msg.Subject,
+		Date:       date,
+		MessageID:  messageID,
+		InReplyTo:  msg.InReplyTo,
+		References: msg.References,
+		Headers:    []mimebuild.Header{{Name: "Auto-Submitted", Value: "auto-replied"}},
+		Text:       msg.TextBody,
+		HTML:       msg.HTMLBody,
+		Flowed:     true,
+	}
+	for _, address := range msg.To {
+		message.To = append(message.To, mimeAddress(address))
+	}
+	for _, attachment := range msg.Attachments {
+		message.Attachments = append(message.Attachments, mimebuild.Part{
+			Name:        attachmentName(attachment),
+			ContentType: attachmentType(attachment),
+			Data:        attachment.Data,
+		})
+	}
+	return mimebuild.Build(message)
+}
+
+func mimeAddress(address emailAddress) mimebuild.Address {
+	return mimebuild.Address{Name: address.Name, Email: address.Email}
+}
@@ -0,0 +1,142 @@
+package email
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type senderContext struct {
+	Email       string
+	DisplayName string
+	Country     string
+	ZipCode     string
+	TimeZone    string
+}
+
+func (s *correspondentStore) SenderContext(ctx context.Context, email string) (senderContext, bool, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	result := senderContext{Email: email}
+	var enabled bool
+	err := s.db.QueryRowContext(ctx, `SELECT display_name, country, zip_code, time_zone, sender_context FROM correspondents WHERE email = ?`, email).
+		Scan(&result.DisplayName, &result.Country, &result.ZipCode, &result.TimeZone, &enabled)
+	if err == sql.ErrNoRows || (err == nil && !enabled) {
+		return senderContext{}, false, nil
+	}
+	if err != nil {
+		return senderContext{}, false, err
+	}
+	return result, true, nil
+}
+
+func (s *correspondentStore) SetSenderContext(ctx context.Context, email string, enabled bool) error {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return fmt.Errorf("correspondent email is empty")
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	result, err := s.db.ExecContext(ctx, `UPDATE correspondents SET sender_context = ?, updated_at = ? WHERE email = ?`, enabled, now, email)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result, email)
+}
+
+func (w *Watcher) senderContextPrompt(ctx context.Context, msg emailMessage) (string, error) {
+	if w.store == nil || len(msg.From) == 0 {
+		return "", nil
+	}
+	sender, ok, err := w.store.SenderContext(ctx, msg.From[0].Email)
+	if err != nil || !ok {
+		return "", err
+	}
+	if sender.DisplayName == "" {
+		sender.DisplayName = strings.TrimSpace(msg.From[0].Name)
+	}
+	now := time.Now()
+	if w.now != nil {
+		now = w.now()
+	}
+	return sender.prompt(now), nil
+}
+
+func (c senderContext) prompt(now time.Time) string {
+	lines := []string{"Sender context from the assistant's correspondent records. Use it to resolve relative dates, times, and locations such as \"tomorrow\", \"tonight\", \"my time\", or \"near me\". Do not recite it back unless it matters to the answer, and if the email states a different location or time zone, follow the email."}
+	if c.DisplayName != "" {
+		lines = append(lines, "- Name: "+c.DisplayName)
+	}
+	if location, ok := correspondentLocation(c.TimeZone); ok {
+		local := now.In(location)
+		zone := formatUTCOffset(utcOffset(local))
+		if c.TimeZone != zone {
+			zone = c.TimeZone + ", " + zone
+		}
+		lines = append(lines, fmt.Sprintf("- Local date and time: %s (%s)", local.Format("Monday, 2 January 2006, 15:04"), zone))
+	} else {
+		lines = append(lines, fmt.Sprintf("- Local time zone: unknown; current UTC time is %s", now.UTC().Format("Monday, 2 January 2006, 15:04")))
+	}
+	switch {
+	case c.ZipCode != "":
+		lines = append(lines, "- Location: "+postalCodeLocation(c.Country, c.ZipCode))
+	case c.Country != "":
+		lines = append(lines, "- Location: "+countryName(c.Country))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func joinPromptSections(sections ...string) string {
+	var nonEmpty []string
+	for _, section := range sections {
+		if section = strings.TrimSpace(section); section != "" {
+			nonEmpty = append(nonEmpty, section)
+		}
+	}
+	return strings.Join(nonEmpty, "\n\n")
+}
+
+func zipCodeLocation(zip string) string {
+	location, ok := lookupZipCode(zip)
+	if !ok {
+		return "US ZIP code " + zip
+	}
+	return fmt.Sprintf("US ZIP code %s, approximately %s (ZIP prefix center near %.2f, %.2f)", zip, location, location.Latitude, location.Longitude)
+}
+
+func correspondentLocation(zone string) (*time.Location, bool) {
+	zone = strings.TrimSpace(zone)
+	if zone == "" {
+		return nil, false
+	}
+	if offset, ok := strings.CutPrefix(zone, "UTC"); ok && len(offset) == 6 && offset[3] == ':' {
+		hours, err := strconv.Atoi(offset[1:3])
+		if err != nil {
+			return nil, false
+		}
+		minutes, err := strconv.Atoi(offset[4:])
+		if err != nil {
+			return nil, false
+		}
+		seconds := hours*3600 + minutes*60
+		switch offset[0] {
+		case '-':
+			seconds = -seconds
+		case '+':
+		default:
+			return nil, false
+		}
+		return time.FixedZone(zone, seconds), true
+	}
+	location, err := time.LoadLocation(zone)
+	if err != nil {
+		return nil, false
+	}
+	return location, true
+}
+
+func utcOffset(t time.Time) int {
+	_, offset := t.Zone()
+	return offset
+}
@@ -0,0 +1,280 @@
+package email
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	appconfig "ai-over-email/pkg/config"
+)
+
+const (
+	SenderPGPRequired = "required"
+	SenderPGPOptional = "optional"
+)
+
+// A SenderPolicy applies to one address or, with a pattern such as
+// *@example.com or *@*.example.com, to a domain. Empty fields and a zero
+// DailyLimit fall back to config.json and the credentials allowlist; a
+// negative DailyLimit means unlimited.
+type SenderPolicy struct {
+	Pattern    string `json:"pattern"`
+	PGP        string `json:"pgp,omitempty"`
+	ModelTier  string `json:"modelTier,omitempty"`
+	DailyLimit int    `json:"dailyLimit,omitempty"`
+	Blocked    bool   `json:"blocked"`
+	Notes      string `json:"notes,omitempty"`
+	CreatedAt  string `json:"createdAt"`
+	UpdatedAt  string `json:"updatedAt"`
+}
+
+// SenderPolicyUpdate changes only the fields that are not nil.
+type SenderPolicyUpdate struct {
+	PGP        *string
+	ModelTier  *string
+	DailyLimit *int
+	Blocked    *bool
+	Notes      *string
+}
+
+const senderPolicyColumns = `pattern, pgp, model_tier, daily_limit, blocked, notes, created_at, updated_at`
+
+func scanSenderPolicy(row rowScanner) (SenderPolicy, error) {
+	var p SenderPolicy
+	err := row.Scan(&p.Pattern, &p.PGP, &p.ModelTier, &p.DailyLimit, &p.Blocked, &p.Notes, &p.CreatedAt, &p.UpdatedAt)
+	return p, err
+}
+
+func normalizeSenderPattern(pattern string) (string, error) {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if strings.HasPrefix(pattern, "@") {
+		pattern = "*" + pattern
+	}
+	if domain, ok := strings.CutPrefix(pattern, "*@"); ok {
+		domain = strings.TrimPrefix(domain, "*.")
+		if domain == "" || strings.ContainsAny(domain, "@* \t") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+			return "", fmt.Errorf("invalid sender pattern %q: use an address, *@domain or *@*.domain", pattern)
+		}
+		return pattern, nil
+	}
+	address, err := mail.ParseAddress(pattern)
+	if err != nil || address.Address != pattern {
+		return "", fmt.Errorf("invalid sender pattern %q: use an address, *@domain or *@*.domain", pattern)
+	}
+	return pattern, nil
+}
+
+// senderPolicyPatterns lists the patterns that can match email, most specific
+// first.
+func senderPolicyPatterns(email string) []string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return nil
+	}
+	domain := email[at+1:]
+	patterns := []string{email, "*@" + domain}
+	for rest := domain; strings.Contains(rest, "."); {
+		rest = rest[strings.Index(rest, ".")+1:]
+		patterns = append(patterns, "*@*."+rest)
+	}
+	return patterns
+}
+
+func (s *correspondentStore) SenderPolicyFor(ctx context.Context, email string) (SenderPolicy, bool, error) {
+	patterns := senderPolicyPatterns(email)
+	if len(patterns) == 0 {
+		return SenderPolicy{}, false, nil
+	}
+	args := make([]any, len(patterns))
+	for i, pattern := range patterns {
+		args[i] = pattern
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT `+senderPolicyColumns+` FROM sender_policies WHERE pattern IN (?`+strings.Repeat(", ?", len(patterns)-1)+`)`, args...)
+	if err != nil {
+		return SenderPolicy{}, false, err
+	}
+	defer rows.Close()
+	found := map[string]SenderPolicy{}
+	for rows.Next() {
+		policy, err := scanSenderPolicy(rows)
+		if err != nil {
+			return SenderPolicy{}, false, err
+		}
+		found[policy.Pattern] = policy
+	}
+	if err := rows.Err(); err != nil {
+		return SenderPolicy{}, false, err
+	}
+	for _, pattern := range patterns {
+		if policy, ok := found[pattern]; ok {
+			return policy, true, nil
+		}
+	}
+	return SenderPolicy{}, false, nil
+}
+
+func (s *correspondentStore) ListSenderPolicies(ctx context.Context) ([]SenderPolicy, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+senderPolicyColumns+` FROM sender_policies ORDER BY pattern`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	policies := []SenderPolicy{}
+	for rows.Next() {
+		policy, err := scanSenderPolicy(rows)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	return policies, rows.Err()
+}
+
+func (s *correspondentStore) SetSenderPolicy(ctx context.Context, pattern string, update SenderPolicyUpdate) (SenderPolicy, error) {
+	pattern, err := normalizeSenderPattern(pattern)
+	if err != nil {
+		return SenderPolicy{}, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return SenderPolicy{}, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	policy, err := scanSenderPolicy(tx.QueryRowContext(ctx, `SELECT `+senderPolicyColumns+` FROM sender_policies WHERE pattern = ?`, pattern))
+	if err == sql.ErrNoRows {
+		policy, err = SenderPolicy{Pattern: pattern, CreatedAt: now}, nil
+	}
+	if err != nil {
+		return SenderPolicy{}, err
+	}
+	if update.PGP != nil {
+		policy.PGP = strings.ToLower(strings.TrimSpace(*update.PGP))
+	}
+	if update.ModelTier != nil {
+		policy.ModelTier = strings.ToLower(strings.TrimSpace(*update.ModelTier))
+	}
+	if update.DailyLimit != nil {
+		policy.DailyLimit = max(*update.DailyLimit, -1)
+	}
+	if update.Blocked != nil {
+		policy.Blocked = *update.Blocked
+	}
+	if update.Notes != nil {
+		policy.Notes = strings.TrimSpace(*update.Notes)
+	}
+	switch policy.PGP {
+	case "", SenderPGPRequired, SenderPGPOptional:
+	default:
+		return SenderPolicy{}, fmt.Errorf("pgp must be required, optional or empty, got %q", policy.PGP)
+	}
+	switch policy.ModelTier {
+	case "", appconfig.ModelTierDefault, appconfig.ModelTierPowerful:
+	default:
+		return SenderPolicy{}, fmt.Errorf("model tier must be default, powerful or empty, got %q", policy.ModelTier)
+	}
+	policy.UpdatedAt = now
+
+	if _, err := tx.ExecContext(ctx, `INSERT INTO sender_policies (`+senderPolicyColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (pattern) DO UPDATE SET
+			pgp = excluded.pgp,
+			model_tier = excluded.model_tier,
+			daily_limit = excluded.daily_limit,
+			blocked = excluded.blocked,
+			notes = excluded.notes,
+			updated_at = excluded.updated_at`,
+		policy.Pattern, policy.PGP, policy.ModelTier, policy.DailyLimit, policy.Blocked, policy.Notes, policy.CreatedAt, policy.UpdatedAt); err != nil {
+		return SenderPolicy{}, err
+	}
+	return policy, tx.Commit()
+}
+
+func (s *correspondentStore) DeleteSenderPolicy(ctx context.Context, pattern string) error {
+	pattern, err := normalizeSenderPattern(pattern)
+	if err != nil {
+		return err
+	}
+	result, err := s.db.ExecContext(ctx, `DELETE FROM sender_policies WHERE pattern = ?`, pattern)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("sender policy %s not found", pattern)
+	}
+	return nil
+}
+
+// senderPolicy is read for every message, so changes made through the CLI or
+// MCP tools apply without restarting the watcher.
+func (w *Watcher) senderPolicy(ctx context.Context, email string) (SenderPolicy, bool, error) {
+	if w.store == nil {
+		return SenderPolicy{}, false, nil
+	}
+	return w.store.SenderPolicyFor(ctx, email)
+}
+
+// plaintextAllowed also returns the matching policy pattern, which is empty
+// when the credentials allowlist let the sender through.
+func (w *Watcher) plaintextAllowed(ctx context.Context, addresses []emailAddress) (bool, string, error) {
+	for _, email := range senderEmails(addresses) {
+		policy, ok, err := w.senderPolicy(ctx, email)
+		if err != nil {
+			return false, "", err
+		}
+		if ok && policy.PGP != "" {
+			if policy.PGP == SenderPGPOptional {
+				return true, policy.Pattern, nil
+			}
+			continue
+		}
+		if plaintextSenderAllowed([]emailAddress{{Email: email}}, w.creds.PlaintextAllowlist) {
+			return true, "", nil
+		}
+	}
+	return false, "", nil
+}
+
+// modelSettings picks the powerful tier when any sender's policy, or
+// openai.powerful_senders for senders whose policy sets no tier, asks for it.
+func (w *Watcher) modelSettings(ctx context.Context, msg emailMessage) (appconfig.OpenAIModelSettings, error) {
+	var unset []string
+	for _, email := range senderEmails(msg.From) {
+		policy, ok, err := w.senderPolicy(ctx, email)
+		if err != nil {
+			return appconfig.OpenAIModelSettings{}, err
+		}
+		if !ok || policy.ModelTier == "" {
+			unset = append(unset, email)
+			continue
+		}
+		if policy.ModelTier == appconfig.ModelTierPowerful {
+			return w.appConfig.OpenAITierSettings(appconfig.ModelTierPowerful), nil
+		}
+	}
+	return w.appConfig.OpenAISettingsForSenders(unset), nil
+}
+
+func (w *Watcher) rateLimitFor(ctx context.Context, email string) (appconfig.RateLimit, string, error) {
+	policy, ok, err := w.senderPolicy(ctx, email)
+	if err != nil {
+		return appconfig.RateLimit{}, "", err
+	}
+	switch {
+	case ok && policy.DailyLimit > 0:
+		return appconfig.RateLimit{Messages: policy.DailyLimit, Window: "day", Algorithm: appconfig.RateLimitFixed}, "policy:" + policy.Pattern, nil
+	case ok && policy.DailyLimit < 0:
+		return appconfig.RateLimit{Unlimited: true}, "policy:" + policy.Pattern, nil
+	}
+	limit, source := w.appConfig.RateLimitFor(email)
+	return limit, source, nil
+}
@@ -0,0 +1,137 @@
+package email
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	appconfig "ai-over-email/pkg/config"
+)
+
+func TestCorrespondentStoreSenderPolicies(t *testing.T) {
+	ctx := context.Background()
+	store := openTestCorrespondentStore(t)
+	optional, powerful, limit, blocked, notes := SenderPGPOptional, appconfig.ModelTierPowerful, 25, true, "partner org"
+
+	if _, err := store.SetSenderPolicy(ctx, "@Example.com", SenderPolicyUpdate{PGP: &optional, Notes: &notes}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.SetSenderPolicy(ctx, "*@*.example.com", SenderPolicyUpdate{Blocked: &blocked}); err != nil {
+		t.Fatal(err)
+	}
+	exact := testAddress("boss", "example.com")
+	if _, err := store.SetSenderPolicy(ctx, exact, SenderPolicyUpdate{ModelTier: &powerful}); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := store.SetSenderPolicy(ctx, exact, SenderPolicyUpdate{DailyLimit: &limit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.ModelTier != powerful || policy.DailyLimit != 25 || policy.PGP != "" {
+		t.Fatalf("partial update = %+v, want the tier kept", policy)
+	}
+
+	for _, tc := range []struct {
+		email   string
+		pattern string
+	}{
+		{exact, exact},
+		{"Someone@EXAMPLE.com", "*@example.com"},
+		{"someone@lists.example.com", "*@*.example.com"},
+		{"someone@a.b.example.com", "*@*.example.com"},
+		{"someone@example.org", ""},
+	} {
+		policy, ok, err := store.SenderPolicyFor(ctx, tc.email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if policy.Pattern != tc.pattern || ok != (tc.pattern != "") {
+			t.Fatalf("SenderPolicyFor(%s) = %q, %t, want %q", tc.email, policy.Pattern, ok, tc.pattern)
+		}
+	}
+
+	policies, err := store.ListSenderPolicies(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policies) != 3 || policies[0].Pattern != "*@*.example.com" || policies[1].Notes != notes {
+		t.Fatalf("policies = %+v", policies)
+	}
+
+	bad := "sometimes"
+	if _, err := store.SetSenderPolicy(ctx, exact, SenderPolicyUpdate{PGP: &bad}); err == nil {
+		t.Fatal("invalid pgp level accepted")
+	}
+	for _, pattern := range []string{"", "*@", "nobody", "*@exa*mple.com", "a@b@c"} {
+		if _, err := store.SetSenderPolicy(ctx, pattern, SenderPolicyUpdate{}); err == nil {
+			t.Fatalf("pattern %q accepted", pattern)
+		}
+	}
+	if err := store.DeleteSenderPolicy(ctx, "*@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteSenderPolicy(ctx, "*@example.com"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("second delete err = %v", err)
+	}
+}
+
+func TestWatcherAppliesSenderPolicies(t *testing.T) {
+	ctx := context.Background()
+	alice, bob := testAddress("alice", "mail.test"), testAddress("bob", "mail.test")
+	w := &Watcher{
+		store: openTestCorrespondentStore(t),
+		creds: Credentials{PlaintextAllowlist: []string{alice}},
+		appConfig: appconfig.ConfigStruct{OpenAI: appconfig.OpenAIConfig{
+			DefaultModel:    "small",
+			PowerfulModel:   "large",
+			PowerfulSenders: []string{alice},
+		}},
+	}
+	msg := func(email string) emailMessage {
+		return emailMessage{ID: "m1", From: []emailAddress{{Email: email}}}
+	}
+	check := func(email string, plaintext bool, model string, limit int, blocked bool) {
+		t.Helper()
+		allowed, _, err := w.plaintextAllowed(ctx, msg(email).From)
+		if err != nil {
+			t.Fatal(err)
+		}
+		settings, err := w.modelSettings(ctx, msg(email))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rateLimit, _, err := w.rateLimitFor(ctx, email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		isBlocked, err := w.senderBlocked(ctx, msg(email))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed != plaintext || settings.Model != model || rateLimit.Messages != limit || isBlocked != blocked {
+			t.Fatalf("%s: plaintext=%t model=%s limit=%d blocked=%t", email, allowed, settings.Model, rateLimit.Messages, isBlocked)
+		}
+	}
+
+	check(alice, true, "large", appconfig.DefaultRateLimitMessages, false)
+	check(bob, false, "small", appconfig.DefaultRateLimitMessages, false)
+
+	required, optional, defaultTier, powerful, limit, blocked := SenderPGPRequired, SenderPGPOptional, appconfig.ModelTierDefault, appconfig.ModelTierPowerful, 3, true
+	if _, err := w.store.SetSenderPolicy(ctx, alice, SenderPolicyUpdate{PGP: &required, ModelTier: &defaultTier}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.store.SetSenderPolicy(ctx, "*@mail.test", SenderPolicyUpdate{PGP: &optional, ModelTier: &powerful, DailyLimit: &limit}); err != nil {
+		t.Fatal(err)
+	}
+	check(alice, false, "small", appconfig.DefaultRateLimitMessages, false)
+	check(bob, true, "large", 3, false)
+
+	unlimited := -1
+	if _, err := w.store.SetSenderPolicy(ctx, "*@mail.test", SenderPolicyUpdate{DailyLimit: &unlimited, Blocked: &blocked}); err != nil {
+		t.Fatal(err)
+	}
+	if rateLimit, rule, err := w.rateLimitFor(ctx, bob); err != nil || !rateLimit.Unlimited || rule != "policy:*@mail.test" {
+		t.Fatalf("unlimited policy = %+v, %s, %v", rateLimit, rule, err)
+	}
+	check(bob, true, "large", 0, true)
+}
@@ -0,0 +1,86 @@
+package email
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	appconfig "ai-over-email/pkg/config"
+)
+
+const smtpTimeout = 60 * time.Second
+
+func sendSMTP(cfg appconfig.SMTPConfig, username, password string, recipients []string, raw []byte) error {
+	if len(recipients) == 0 {
+		return fmt.Errorf("SMTP: message has no recipients")
+	}
+	client, err := dialSMTP(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if username != "" {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return fmt.Errorf("SMTP server %s does not offer AUTH", cfg.Host)
+		}
+		if err := client.Auth(smtp.PlainAuth("", username, password, cfg.Host)); err != nil {
+			return fmt.Errorf("SMTP AUTH: %w", err)
+		}
+	}
+	if err := client.Mail(cfg.FromAddress); err != nil {
+		return fmt.Errorf("SMTP MAIL FROM: %w", err)
+	}
+	for _, recipient := range recipients {
+		if err := client.Rcpt(recipient); err != nil {
+			return fmt.Errorf("SMTP RCPT TO %s: %w", recipient, err)
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP DATA: %w", err)
+	}
+	if _, err := writer.Write(raw); err != nil {
+		return fmt.Errorf("SMTP DATA: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("SMTP DATA: %w", err)
+	}
+	return client.Quit()
+}
+
+func dialSMTP(cfg appconfig.SMTPConfig) (*smtp.Client, error) {
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	tlsConfig := &tls.Config{ServerName: cfg.TLSServerName, MinVersion: tls.VersionTLS12}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = cfg.Host
+	}
+
+	var conn net.Conn
+	var err error
+	if cfg.Security == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connect SMTP: %w", err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(smtpTimeout))
+	client, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SMTP greeting: %w", err)
+	}
+	if cfg.Security == "starttls" {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("SMTP STARTTLS: %w", err)
+		}
+	}
+	return client, nil
+}
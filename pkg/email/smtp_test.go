@@ -0,0 +1,83 @@
+package email
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	appconfig "ai-over-email/pkg/config"
+)
+
+func TestSendSMTPAuthenticatesAndDelivers(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	transcript := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		write := func(line string) { fmt.Fprint(conn, line+"\r\n") }
+		var log strings.Builder
+		write("220 fake ESMTP")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			log.WriteString(line + "\n")
+			switch command := strings.ToUpper(line); {
+			case strings.HasPrefix(command, "EHLO"):
+				write("250-fake")
+				write("250 AUTH PLAIN")
+			case strings.HasPrefix(command, "AUTH PLAIN"):
+				write("235 authenticated")
+			case strings.HasPrefix(command, "MAIL FROM"), strings.HasPrefix(command, "RCPT TO"):
+				write("250 ok")
+			case command == "DATA":
+				write("354 go ahead")
+				for {
+					data, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if data == ".\r\n" {
+						break
+					}
+					log.WriteString(data)
+				}
+				write("250 queued")
+			case command == "QUIT":
+				write("221 bye")
+				transcript <- log.String()
+				return
+			default:
+				write("502 unsupported")
+			}
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	cfg := appconfig.SMTPConfig{Host: host, Port: portNumber, Security: "none", FromAddress: testAddress("assistant", "mail.test")}
+	if err := sendSMTP(cfg, "assistant", "secret", []string{testAddress("sender", "mail.test")}, []byte("Subject: hi\r\n\r\nhello\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-transcript
+	for _, want := range []string{"AUTH PLAIN", "MAIL FROM:<assistant@mail.test>", "RCPT TO:<sender@mail.test>", "Subject: hi", "hello"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("SMTP transcript missing %q:\n%s", want, got)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	appconfig "ai-over-email/pkg/config"
+)
+
+const (
+	usageKindReply   = "reply"
+	usageKindProfile = "profile_extraction"
+	usageKindNotes   = "notes"
+)
+
+var tokenUsageGroups = map[string]string{
+	"day":           `substr(created_at, 1, 10)`,
+	"month":         `substr(created_at, 1, 7)`,
+	"correspondent": `email`,
+	"model":         `model`,
+	"kind":          `kind`,
+}
+
+type messageUsage struct {
+	Email     string
+	MessageID string
+	Kind      string
+	Tier      string
+	Model     string
+	Usage     openAIUsage
+	ToolCalls int
+	Latency   time.Duration
+	CostUSD   float64
+	Priced    bool
+	CreatedAt time.Time
+}
+
+type TokenUsageReportOptions struct {
+	GroupBy string
+	Since   string
+	Until   string
+	Email   string
+}
+
+type TokenUsageReport struct {
+	GroupBy string          `json:"groupBy"`
+	Since   string          `json:"since,omitempty"`
+	Until   string          `json:"until,omitempty"`
+	Rows    []TokenUsageRow `json:"rows"`
+	Total   TokenUsageRow   `json:"total"`
+}
+
+type TokenUsageRow struct {
+	Key               string  `json:"key"`
+	Calls             int     `json:"calls"`
+	InputTokens       int64   `json:"inputTokens"`
+	CachedInputTokens int64   `json:"cachedInputTokens"`
+	OutputTokens      int64   `json:"outputTokens"`
+	ReasoningTokens   int64   `json:"reasoningTokens"`
+	TotalTokens       int64   `json:"totalTokens"`
+	ToolCalls         int64   `json:"toolCalls"`
+	AvgLatencyMs      int64   `json:"avgLatencyMs"`
+	CostUSD           float64 `json:"costUsd"`
+	UnpricedCalls     int     `json:"unpricedCalls"`
+}
+
+func (s *correspondentStore) RecordMessageUsage(ctx context.Context, record messageUsage) error {
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now()
+	}
+	_, err := s.db.ExecContext(ctx, `INSERT INTO message_usage (
+			email, message_id, kind, tier, model, input_tokens, cached_input_tokens, output_tokens, reasoning_tokens, total_tokens,
+			tool_calls, latency_ms, cost_usd, priced, created_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		strings.ToLower(strings.TrimSpace(record.Email)), record.MessageID, record.Kind, record.Tier, record.Model,
+		record.Usage.InputTokens, record.Usage.CachedInputTokens, record.Usage.OutputTokens, record.Usage.ReasoningTokens, record.Usage.TotalTokens,
+		record.ToolCalls, record.Latency.Milliseconds(), record.CostUSD, record.Priced, record.CreatedAt.UTC().Format(time.RFC3339Nano))
+	return err
+}
+
+func (s *correspondentStore) TokenUsageReport(ctx context.Context, opts TokenUsageReportOptions) (TokenUsageReport, error) {
+	if opts.GroupBy == "" {
+		opts.GroupBy = "day"
+	}
+	key, ok := tokenUsageGroups[opts.GroupBy]
+	if !ok {
+		return TokenUsageReport{}, fmt.Errorf("unknown usage grouping %q: use day, month, correspondent, model or kind", opts.GroupBy)
+	}
+	report := TokenUsageReport{GroupBy: opts.GroupBy, Since: opts.Since, Until: opts.Until, Rows: []TokenUsageRow{}}
+
+	where, args := ` WHERE 1 = 1`, []any{}
+	if opts.Since != "" {
+		if _, err := time.Parse("2006-01-02", opts.Since); err != nil {
+			return TokenUsageReport{}, fmt.Errorf("since must be YYYY-MM-DD: %w", err)
+		}
+		where += ` AND created_at >= ?`
+		args = append(args, opts.Since)
+	}
+	if opts.Until != "" {
+		until, err := time.Parse("2006-01-02", opts.Until)
+		if err != nil {
+			return TokenUsageReport{}, fmt.Errorf("until must be YYYY-MM-DD: %w", err)
+		}
+		where += ` AND created_at < ?`
+		args = append(args, until.AddDate(0, 0, 1).Format("2006-01-02"))
+	}
+	if email := strings.ToLower(strings.TrimSpace(opts.Email)); email != "" {
+		where += ` AND email = ?`
+		args = append(args, email)
+	}
+	columns := `COUNT(*), COALESCE(SUM(input_tokens), 0), COALESCE(SUM(cached_input_tokens), 0), COALESCE(SUM(output_tokens), 0),
+		COALESCE(SUM(reasoning_tokens), 0), COALESCE(SUM(total_tokens), 0), COALESCE(SUM(tool_calls), 0),
+		CAST(COALESCE(AVG(latency_ms), 0) AS INTEGER), COALESCE(SUM(cost_usd), 0), COALESCE(SUM(1 - priced), 0)`
+	order := `key`
+	if opts.GroupBy == "correspondent" || opts.GroupBy == "model" {
+		order = `SUM(cost_usd) DESC, SUM(total_tokens) DESC, key`
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT `+key+` AS key, `+columns+` FROM message_usage`+where+` GROUP BY key ORDER BY `+order, args...)
+	if err != nil {
+		return TokenUsageReport{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var row TokenUsageRow
+		if err := scanTokenUsageRow(rows, &row.Key, &row); err != nil {
+			return TokenUsageReport{}, err
+		}
+		report.Rows = append(report.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return TokenUsageReport{}, err
+	}
+	report.Total.Key = "total"
+	err = scanTokenUsageRow(s.db.QueryRowContext(ctx, `SELECT `+columns+` FROM message_usage`+where, args...), nil, &report.Total)
+	return report, err
+}
+
+func scanTokenUsageRow(row rowScanner, key *string, dest *TokenUsageRow) error {
+	fields := []any{&dest.Calls, &dest.InputTokens, &dest.CachedInputTokens, &dest.OutputTokens, &dest.ReasoningTokens,
+		&dest.TotalTokens, &dest.ToolCalls, &dest.AvgLatencyMs, &dest.CostUSD, &dest.UnpricedCalls}
+	if key != nil {
+		fields = append([]any{key}, fields...)
+	}
+	return row.Scan(fields...)
+}
+
+func (w *Watcher) recordUsage(ctx context.Context, msg emailMessage, kind string, settings appconfig.OpenAIModelSettings, usage openAIUsage, toolCalls int, latency time.Duration) {
+	if w.store == nil || usage.TotalTokens == 0 {
+		return
+	}
+	record := messageUsage{MessageID: msg.ID, Kind: kind, Tier: w.settingsTier(settings), Model: settings.Model, Usage: usage, ToolCalls: toolCalls, Latency: latency}
+	if len(msg.From) > 0 {
+		record.Email = msg.From[0].Email
+	}
+	if price, ok := w.appConfig.OpenAI.Price(settings.Model); ok {
+		record.CostUSD, record.Priced = price.Cost(usage.InputTokens, usage.CachedInputTokens, usage.OutputTokens), true
+	}
+	if err := w.store.RecordMessageUsage(ctx, record); err != nil {
+		w.logf("message usage not recorded: id=%s kind=%s err=%v", msg.ID, kind, err)
+	}
+}
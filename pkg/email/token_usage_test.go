@@ -0,0 +1,86 @@
+package email
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	appconfig "ai-over-email/pkg/config"
+)
+
+func TestCorrespondentStoreTokenUsageReport(t *testing.T) {
+	ctx := context.Background()
+	store := openTestCorrespondentStore(t)
+	alice, bob := testAddress("alice", "mail.test"), testAddress("bob", "mail.test")
+	day := time.Date(2026, 3, 30, 12, 0, 0, 0, time.UTC)
+	for _, record := range []messageUsage{
+		{Email: alice, Kind: usageKindReply, Model: "gpt-5", Usage: openAIUsage{InputTokens: 100, CachedInputTokens: 20, OutputTokens: 50, ReasoningTokens: 10, TotalTokens: 150}, ToolCalls: 2, Latency: 300 * time.Millisecond, CostUSD: 0.5, Priced: true, CreatedAt: day},
+		{Email: alice, Kind: usageKindNotes, Model: "gpt-5-mini", Usage: openAIUsage{InputTokens: 10, OutputTokens: 5, TotalTokens: 15}, Latency: 100 * time.Millisecond, CreatedAt: day},
+		{Email: bob, Kind: usageKindReply, Model: "gpt-5", Usage: openAIUsage{InputTokens: 200, OutputTokens: 100, TotalTokens: 300}, Latency: 500 * time.Millisecond, CostUSD: 1.25, Priced: true, CreatedAt: day.AddDate(0, 0, 2)},
+	} {
+		if err := store.RecordMessageUsage(ctx, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := store.TokenUsageReport(ctx, TokenUsageReportOptions{GroupBy: "model"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Rows) != 2 || report.Rows[0].Key != "gpt-5" || report.Rows[0].Calls != 2 || report.Rows[0].TotalTokens != 450 || report.Rows[0].AvgLatencyMs != 400 {
+		t.Fatalf("model rows = %+v", report.Rows)
+	}
+	if report.Rows[1].UnpricedCalls != 1 || report.Rows[1].CostUSD != 0 {
+		t.Fatalf("unpriced row = %+v", report.Rows[1])
+	}
+	total := report.Total
+	if total.Calls != 3 || total.CachedInputTokens != 20 || total.ReasoningTokens != 10 || total.ToolCalls != 2 || math.Abs(total.CostUSD-1.75) > 1e-9 || total.UnpricedCalls != 1 {
+		t.Fatalf("total = %+v", total)
+	}
+
+	report, err = store.TokenUsageReport(ctx, TokenUsageReportOptions{GroupBy: "day", Since: "2026-03-30", Until: "2026-03-31"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Rows) != 1 || report.Rows[0].Key != "2026-03-30" || report.Total.Calls != 2 {
+		t.Fatalf("day report = %+v", report)
+	}
+
+	report, err = store.TokenUsageReport(ctx, TokenUsageReportOptions{GroupBy: "month", Email: bob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Rows) != 1 || report.Rows[0].Key != "2026-04" || report.Total.TotalTokens != 300 {
+		t.Fatalf("month report for bob = %+v", report)
+	}
+
+	for _, opts := range []TokenUsageReportOptions{{GroupBy: "week"}, {Since: "yesterday"}} {
+		if _, err := store.TokenUsageReport(ctx, opts); err == nil {
+			t.Fatalf("TokenUsageReport(%+v) succeeded", opts)
+		}
+	}
+}
+
+func TestWatcherRecordUsagePricesCalls(t *testing.T) {
+	ctx := context.Background()
+	w := &Watcher{store: openTestCorrespondentStore(t), appConfig: appconfig.ConfigStruct{OpenAI: appconfig.OpenAIConfig{
+		Prices: map[string]appconfig.ModelPrice{"gpt-5": {InputPerMillion: 1, OutputPerMillion: 10}},
+	}}}
+	msg := emailMessage{ID: "m1", From: []emailAddress{{Email: "Sender@Mail.Test"}}}
+
+	w.recordUsage(ctx, msg, usageKindReply, appconfig.OpenAIModelSettings{Model: "gpt-5-2025-08-07"}, openAIUsage{InputTokens: 1000, OutputTokens: 100, TotalTokens: 1100}, 1, time.Second)
+	w.recordUsage(ctx, msg, usageKindProfile, appconfig.OpenAIModelSettings{Model: "other-model"}, openAIUsage{InputTokens: 10, OutputTokens: 1, TotalTokens: 11}, 0, time.Second)
+	w.recordUsage(ctx, msg, usageKindNotes, appconfig.OpenAIModelSettings{Model: "gpt-5"}, openAIUsage{}, 0, time.Second)
+
+	report, err := w.store.TokenUsageReport(ctx, TokenUsageReportOptions{GroupBy: "kind", Email: "sender@mail.test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Rows) != 2 || report.Total.Calls != 2 || report.Total.UnpricedCalls != 1 || report.Total.ToolCalls != 1 {
+		t.Fatalf("report = %+v", report)
+	}
+	if want := 0.002; math.Abs(report.Total.CostUSD-want) > 1e-12 {
+		t.Fatalf("cost = %v, want %v", report.Total.CostUSD, want)
+	}
+}
@@ -0,0 +1,69 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	appconfig "ai-over-email/pkg/config"
+)
+
+type mailTransport interface {
+	Connect(ctx context.Context) error
+	Watch(ctx context.Context, deliver func(context.Context, []emailMessage)) error
+	Scan(ctx context.Context, limit int) ([]emailMessage, error)
+	Fetch(ctx context.Context, id string) (emailMessage, error)
+	Attachments(ctx context.Context, msg emailMessage) ([]emailAttachment, error)
+	Submit(ctx context.Context, msg outgoingEmail) error
+	Dispose(ctx context.Context, id string) error
+}
+
+// readOnlyConnector is implemented by transports whose Connect writes local
+// state or directories. Previews connect through it so a running watcher's
+// state is left alone.
+type readOnlyConnector interface {
+	ConnectReadOnly(ctx context.Context) error
+}
+
+type outgoingEmail struct {
+	To          []emailAddress
+	Subject     string
+	TextBody    string
+	HTMLBody    string
+	Attachments []emailAttachment
+	InReplyTo   []string
+	References  []string
+}
+
+func newMailTransport(appConfig appconfig.ConfigStruct, creds Credentials, logOutput io.Writer) (mailTransport, error) {
+	switch appConfig.TransportType() {
+	case appconfig.TransportJMAP:
+		return newJMAPTransport(appConfig, creds, logOutput), nil
+	case appconfig.TransportMaildir:
+		return newMaildirTransport(appConfig.Transport.Maildir.Normalized(), logOutput), nil
+	case appconfig.TransportIMAP:
+		return newIMAPTransport(appConfig.Transport.IMAP.Normalized(), appConfig.Transport.SMTP.Normalized(), creds, logOutput), nil
+	default:
+		return nil, fmt.Errorf("unsupported mail transport %q", appConfig.Transport.Type)
+	}
+}
+
+func transportCredentials(creds Credentials, appConfig appconfig.ConfigStruct) Credentials {
+	switch appConfig.TransportType() {
+	case appconfig.TransportMaildir:
+		return credentialsFrom(creds, appConfig.Transport.Maildir.Normalized().FromAddress)
+	case appconfig.TransportIMAP:
+		return credentialsFrom(creds, appConfig.Transport.SMTP.Normalized().FromAddress)
+	}
+	return creds
+}
+
+func credentialsFrom(creds Credentials, fromAddress string) Credentials {
+	if creds.Username == "" {
+		creds.Username = fromAddress
+	}
+	if creds.PublicEmail == "" {
+		creds.PublicEmail = creds.Username
+	}
+	return creds
+}
@@ -0,0 +1,408 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	appconfig "ai-over-email/pkg/config"
+	"ai-over-email/pkg/mimebuild"
+)
+
+type imapTransport struct {
+	config    appconfig.IMAPConfig
+	smtp      appconfig.SMTPConfig
+	creds     Credentials
+	logOutput io.Writer
+	interval  time.Duration
+
+	mu       sync.Mutex
+	client   *imapClient
+	state    imapState
+	readOnly bool
+}
+
+type imapState struct {
+	UIDValidity uint32 `json:"uid_validity"`
+	LastUID     uint32 `json:"last_uid"`
+}
+
+func newIMAPTransport(config appconfig.IMAPConfig, smtp appconfig.SMTPConfig, creds Credentials, logOutput io.Writer) *imapTransport {
+	interval, err := time.ParseDuration(config.PollInterval)
+	if err != nil || interval <= 0 {
+		interval = time.Minute
+	}
+	return &imapTransport{config: config, smtp: smtp, creds: creds, logOutput: logOutput, interval: interval}
+}
+
+func (t *imapTransport) Connect(ctx context.Context) error {
+	return t.connect(false)
+}
+
+// ConnectReadOnly loads the state file but never writes it, so a preview does
+// not reset the baseline a running watcher depends on.
+func (t *imapTransport) ConnectReadOnly(ctx context.Context) error {
+	return t.connect(true)
+}
+
+func (t *imapTransport) connect(readOnly bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.readOnly = readOnly
+	st, err := loadIMAPState(t.config.StatePath)
+	if err != nil {
+		return err
+	}
+	t.state = st
+	client, err := t.session()
+	if err != nil {
+		return err
+	}
+	t.logf("IMAP transport ready: host=%s mailbox=%s uid_validity=%d last_uid=%d idle=%t move=%t uidplus=%t", t.config.Host, t.config.Mailbox, t.state.UIDValidity, t.state.LastUID, client.Has("IDLE"), client.Has("MOVE"), client.Has("UIDPLUS"))
+	return nil
+}
+
+func (t *imapTransport) session() (*imapClient, error) {
+	if t.client != nil {
+		return t.client, nil
+	}
+	client, status, err := t.open()
+	if err != nil {
+		return nil, err
+	}
+	if err := t.adoptStatus(status); err != nil {
+		client.Close()
+		return nil, err
+	}
+	t.client = client
+	return client, nil
+}
+
+// adoptStatus resets the baseline when the mailbox was recreated. The caller
+// holds t.mu.
+func (t *imapTransport) adoptStatus(status imapMailboxStatus) error {
+	if status.UIDValidity == t.state.UIDValidity {
+		return nil
+	}
+	t.logf("IMAP UIDVALIDITY changed; resetting baseline: old=%d new=%d uid_next=%d", t.state.UIDValidity, status.UIDValidity, status.UIDNext)
+	t.state = imapState{UIDValidity: status.UIDValidity}
+	if status.UIDNext > 0 {
+		t.state.LastUID = status.UIDNext - 1
+	}
+	return t.saveState()
+}
+
+// watchStatus applies a SELECT seen on the watch connection. A changed
+// UIDVALIDITY also drops the command connection, whose selected mailbox is
+// the old one.
+func (t *imapTransport) watchStatus(status imapMailboxStatus) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if status.UIDValidity != t.state.UIDValidity && t.client != nil {
+		t.client.Close()
+		t.client = nil
+	}
+	return t.adoptStatus(status)
+}
+
+func (t *imapTransport) open() (*imapClient, imapMailboxStatus, error) {
+	client, err := dialIMAP(t.config, imapTimeout)
+	if err != nil {
+		return nil, imapMailboxStatus{}, err
+	}
+	if err := client.Login(t.creds.IMAPUsername, t.creds.IMAPPassword); err != nil {
+		client.Close()
+		return nil, imapMailboxStatus{}, err
+	}
+	status, err := client.Select(t.config.Mailbox)
+	if err != nil {
+		client.Close()
+		return nil, imapMailboxStatus{}, err
+	}
+	return client, status, nil
+}
+
+func (t *imapTransport) do(fn func(*imapClient) error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	client, err := t.session()
+	if err != nil {
+		return err
+	}
+	if err := fn(client); err != nil {
+		var netErr interface{ Timeout() bool }
+		if errors.Is(err, io.EOF) || errors.As(err, &netErr) {
+			t.logf("IMAP command connection dropped: err=%v", err)
+			client.conn.Close()
+			t.client = nil
+		}
+		return err
+	}
+	return nil
+}
+
+func (t *imapTransport) Watch(ctx context.Context, deliver func(context.Context, []emailMessage)) error {
+	for {
+		err := t.watchOnce(ctx, deliver)
+		if ctx.Err() != nil {
+			t.logf("watcher context canceled")
+			return ctx.Err()
+		}
+		t.logf("IMAP watch connection lost: err=%v reconnect_delay=5s", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (t *imapTransport) watchOnce(ctx context.Context, deliver func(context.Context, []emailMessage)) error {
+	client, status, err := t.open()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	idle := client.Has("IDLE")
+	t.logf("IMAP watch connection open: mailbox=%s idle=%t fallback_interval=%s", t.config.Mailbox, idle, t.interval)
+
+	for {
+		if err := t.watchStatus(status); err != nil {
+			return err
+		}
+		if err := t.deliverNew(ctx, deliver); err != nil {
+			return err
+		}
+		if idle {
+			changed, err := client.Idle(ctx, t.interval)
+			if err != nil {
+				return err
+			}
+			t.logf("IMAP IDLE returned: exists_changed=%t", changed)
+		} else {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(t.interval):
+			}
+		}
+		// Select again so a mailbox recreated while waiting is noticed before
+		// the next search uses the old LastUID.
+		if status, err = client.Select(t.config.Mailbox); err != nil {
+			return err
+		}
+	}
+}
+
+func (t *imapTransport) deliverNew(ctx context.Context, deliver func(context.Context, []emailMessage)) error {
+	var messages []emailMessage
+	var highest uint32
+	err := t.do(func(client *imapClient) error {
+		uids, err := client.SearchUIDs(fmt.Sprintf("UID %d:*", t.state.LastUID+1))
+		if err != nil {
+			return err
+		}
+		fresh := uids[:0]
+		for _, uid := range uids {
+			if uid > t.state.LastUID {
+				fresh = append(fresh, uid)
+			}
+		}
+		if len(fresh) == 0 {
+			return nil
+		}
+		highest = fresh[len(fresh)-1]
+		messages, err = t.fetchHeaders(client, fresh)
+		return err
+	})
+	if err != nil || highest == 0 {
+		return err
+	}
+
+	t.logf("IMAP new messages found: count=%d highest_uid=%d", len(messages), highest)
+	deliver(ctx, messages)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if highest > t.state.LastUID {
+		t.state.LastUID = highest
+	}
+	return t.saveState()
+}
+
+func (t *imapTransport) saveState() error {
+	if t.readOnly {
+		return nil
+	}
+	return saveIMAPState(t.config.StatePath, t.state)
+}
+
+func (t *imapTransport) Scan(ctx context.Context, limit int) ([]emailMessage, error) {
+	var messages []emailMessage
+	err := t.do(func(client *imapClient) error {
+		uids, err := client.SearchUIDs("UNDELETED")
+		if err != nil {
+			return err
+		}
+		if limit > 0 && len(uids) > limit {
+			uids = uids[:limit]
+		}
+		messages, err = t.fetchHeaders(client, uids)
+		if err == nil {
+			t.logf("inbox safety scan IMAP search: found=%d returned=%d", len(uids), len(messages))
+		}
+		return err
+	})
+	return messages, err
+}
+
+func (t *imapTransport) fetchHeaders(client *imapClient, uids []uint32) ([]emailMessage, error) {
+	headers, err := client.FetchUIDs(uids, "BODY.PEEK[HEADER]")
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]emailMessage, 0, len(uids))
+	for _, uid := range uids {
+		raw, ok := headers[uid]
+		if !ok {
+			continue
+		}
+		msg, err := parseRFC822Message(raw)
+		if err != nil {
+			t.logf("IMAP message header could not be parsed: uid=%d err=%v", uid, err)
+			continue
+		}
+		msg.ID = t.messageID(uid)
+		msg.Raw = nil
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+func (t *imapTransport) Fetch(ctx context.Context, id string) (emailMessage, error) {
+	var msg emailMessage
+	err := t.do(func(client *imapClient) error {
+		uid, err := t.parseMessageID(id)
+		if err != nil {
+			return err
+		}
+		bodies, err := client.FetchUIDs([]uint32{uid}, "BODY.PEEK[]")
+		if err != nil {
+			return err
+		}
+		raw, ok := bodies[uid]
+		if !ok {
+			return fmt.Errorf("message %s not found", id)
+		}
+		msg, err = parseRFC822Message(raw)
+		msg.ID = id
+		return err
+	})
+	return msg, err
+}
+
+func (t *imapTransport) Attachments(ctx context.Context, msg emailMessage) ([]emailAttachment, error) {
+	return extractDecryptedAttachments(string(msg.Raw)), nil
+}
+
+func (t *imapTransport) Submit(ctx context.Context, msg outgoingEmail) error {
+	from := emailAddress{Name: t.smtp.FromName, Email: t.smtp.FromAddress}
+	raw, err := buildRFC822(from, msg, time.Now(), mimebuild.NewMessageID(t.smtp.FromAddress))
+	if err != nil {
+		return err
+	}
+	recipients := make([]string, 0, len(msg.To))
+	for _, address := range msg.To {
+		recipients = append(recipients, address.Email)
+	}
+	if err := sendSMTP(t.smtp, t.creds.SMTPUsername, t.creds.SMTPPassword, recipients, raw); err != nil {
+		return err
+	}
+	t.logf("reply submitted over SMTP: host=%s recipients=%d bytes=%d", t.smtp.Host, len(recipients), len(raw))
+	return nil
+}
+
+func (t *imapTransport) Dispose(ctx context.Context, id string) error {
+	return t.do(func(client *imapClient) error {
+		uid, err := t.parseMessageID(id)
+		if err != nil {
+			return err
+		}
+		if t.config.ArchiveMailbox != "" {
+			if err := client.Move(uid, t.config.ArchiveMailbox); err != nil {
+				return err
+			}
+			t.logf("IMAP message moved: uid=%d mailbox=%s", uid, t.config.ArchiveMailbox)
+			return nil
+		}
+		expunged, err := client.Delete(uid)
+		if err != nil {
+			return err
+		}
+		if !expunged {
+			t.logf("IMAP message flagged \\Deleted; server lacks UIDPLUS so it is left for the mailbox owner to expunge: uid=%d", uid)
+			return nil
+		}
+		t.logf("IMAP message expunged: uid=%d", uid)
+		return nil
+	})
+}
+
+func (t *imapTransport) messageID(uid uint32) string {
+	return fmt.Sprintf("%d.%d", t.state.UIDValidity, uid)
+}
+
+func (t *imapTransport) parseMessageID(id string) (uint32, error) {
+	validity, uid, ok := strings.Cut(strings.TrimSpace(id), ".")
+	if !ok || parseUID(uid) == 0 {
+		return 0, fmt.Errorf("invalid IMAP message id %q; expected <uidvalidity>.<uid>", id)
+	}
+	if parseUID(validity) != t.state.UIDValidity {
+		return 0, fmt.Errorf("IMAP message id %q is from UIDVALIDITY %s, mailbox is now %d", id, validity, t.state.UIDValidity)
+	}
+	return parseUID(uid), nil
+}
+
+func (t *imapTransport) logf(format string, args ...any) {
+	logf(t.logOutput, format, args...)
+}
+
+func loadIMAPState(path string) (imapState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return imapState{}, nil
+		}
+		return imapState{}, fmt.Errorf("read IMAP state: %w", err)
+	}
+	var st imapState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return imapState{}, fmt.Errorf("decode IMAP state: %w", err)
+	}
+	return st, nil
+}
+
+func saveIMAPState(path string, st imapState) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("create IMAP state directory: %w", err)
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode IMAP state: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write IMAP state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("replace IMAP state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,598 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	appconfig "ai-over-email/pkg/config"
+)
+
+type jmapTransport struct {
+	client    *jmapClient
+	creds     Credentials
+	appConfig appconfig.ConfigStruct
+	logOutput io.Writer
+
+	accountID  string
+	inboxID    string
+	draftsID   string
+	identityID string
+	emailState string
+	pushState  string
+	backoff    reconnectBackoff
+}
+
+func newJMAPTransport(appConfig appconfig.ConfigStruct, creds Credentials, logOutput io.Writer) *jmapTransport {
+	return &jmapTransport{
+		client:    newJMAPClient(creds, logOutput),
+		creds:     creds,
+		appConfig: appConfig,
+		logOutput: logOutput,
+		backoff:   reconnectBackoff{min: 500 * time.Millisecond, max: time.Minute},
+	}
+}
+
+func (t *jmapTransport) Connect(ctx context.Context) error {
+	if err := t.client.FetchSession(ctx, t.appConfig); err != nil {
+		return err
+	}
+
+	accountID, err := t.client.AccountID()
+	if err != nil {
+		return err
+	}
+	t.accountID = accountID
+	t.logf("selected JMAP account: account_id=%s", t.accountID)
+
+	return t.initialize(ctx)
+}
+
+func (t *jmapTransport) initialize(ctx context.Context) error {
+	t.logf("initializing mailbox and email state: desired_mailbox=%q", t.creds.Mailbox)
+	envelope, err := t.client.Call(ctx, []methodCall{
+		{"Mailbox/get", map[string]any{
+			"accountId":  t.accountID,
+			"properties": []string{"id", "name", "role"},
+		}, "mailboxes"},
+		{"Identity/get", map[string]any{
+			"accountId":  t.accountID,
+			"properties": []string{"id", "email", "name"},
+		}, "identities"},
+		{"Email/query", map[string]any{
+			"accountId": t.accountID,
+			"filter":    map[string]any{},
+			"sort":      []map[string]any{{"property": "receivedAt", "isAscending": false}},
+			"limit":     1,
+		}, "query"},
+		{"Email/get", map[string]any{
+			"accountId":  t.accountID,
+			"#ids":       map[string]string{"resultOf": "query", "name": "Email/query", "path": "/ids"},
+			"properties": []string{"id"},
+		}, "state"},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, response := range envelope.MethodResponses {
+		name, args, err := decodeMethodResponse(response)
+		if err != nil {
+			return err
+		}
+		t.logf("initialization response received: method=%s bytes=%d", name, len(args))
+
+		switch name {
+		case "Mailbox/get":
+			var mailboxes mailboxGetResponse
+			if err := json.Unmarshal(args, &mailboxes); err != nil {
+				return err
+			}
+			t.inboxID = selectMailboxID(mailboxes.List, t.creds.Mailbox)
+			t.draftsID = selectMailboxID(mailboxes.List, "drafts")
+			t.logf("mailboxes loaded: count=%d selected_mailbox_id=%s drafts_mailbox_id=%s", len(mailboxes.List), t.inboxID, t.draftsID)
+		case "Identity/get":
+			var identities identityGetResponse
+			if err := json.Unmarshal(args, &identities); err != nil {
+				return err
+			}
+			t.identityID = selectIdentityID(identities.List, t.creds.Username)
+			t.logf("identities loaded: count=%d selected_identity_id=%s", len(identities.List), t.identityID)
+		case "Email/get":
+			var emails emailGetResponse
+			if err := json.Unmarshal(args, &emails); err != nil {
+				return err
+			}
+			t.emailState = emails.State
+			t.logf("email state initialized: state=%s baseline_messages=%d", t.emailState, len(emails.List))
+		case "error":
+			return fmt.Errorf("JMAP initialization error: %s", string(args))
+		}
+	}
+
+	if t.inboxID == "" {
+		return fmt.Errorf("mailbox %q not found", t.creds.Mailbox)
+	}
+	if t.emailState == "" {
+		return fmt.Errorf("could not initialize email state")
+	}
+	return nil
+}
+
+func (t *jmapTransport) Watch(ctx context.Context, deliver func(context.Context, []emailMessage)) error {
+	var lastEventID string
+	webSocket, useWebSocket := t.client.WebSocketCapability()
+	if useWebSocket && !webSocket.SupportsPush {
+		t.logf("JMAP WebSocket does not support push; using EventSource")
+		useWebSocket = false
+	}
+	for {
+		started := time.Now()
+		mode := "eventsource"
+		var err error
+		if useWebSocket {
+			mode = "websocket"
+			err = t.listenSocket(ctx, webSocket.URL, deliver)
+		} else {
+			err = t.listenOnce(ctx, &lastEventID, deliver)
+		}
+		if ctx.Err() != nil {
+			t.logf("watcher context canceled")
+			return ctx.Err()
+		}
+		if errors.Is(err, errWebSocketRejected) {
+			t.logf("JMAP WebSocket unavailable; falling back to EventSource: err=%v", err)
+			useWebSocket = false
+			continue
+		}
+		delay := t.backoff.next(time.Since(started))
+		t.logf("event stream disconnected: mode=%s err=%v reconnect_delay=%s", mode, err, delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (t *jmapTransport) listenSocket(ctx context.Context, url string, deliver func(context.Context, []emailMessage)) error {
+	socket, err := t.client.OpenSocket(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer t.client.CloseSocket(socket)
+	if err := socket.EnablePush(t.pushState); err != nil {
+		return err
+	}
+	t.logf("JMAP WebSocket push enabled: push_state=%q", t.pushState)
+	if t.pushState == "" {
+		if err := t.syncEmailChanges(ctx, deliver); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-socket.done:
+			return socket.Err()
+		case change := <-socket.pushes:
+			if err := t.handleStateChange(ctx, change, deliver); err != nil {
+				return err
+			}
+			if change.PushState != "" {
+				t.pushState = change.PushState
+			}
+		}
+	}
+}
+
+func (t *jmapTransport) listenOnce(ctx context.Context, lastEventID *string, deliver func(context.Context, []emailMessage)) error {
+	req, err := t.client.NewEventSourceRequest(ctx, *lastEventID)
+	if err != nil {
+		return err
+	}
+	t.logf("connecting to JMAP EventSource: url=%s last_event_id_present=%t", req.URL.Redacted(), *lastEventID != "")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	t.logf("EventSource response received: status=%s content_type=%s", resp.Status, resp.Header.Get("Content-Type"))
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("event source: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 4096), 1024*1024)
+
+	var eventName string
+	var eventID string
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if data.Len() > 0 {
+				if eventID != "" {
+					*lastEventID = eventID
+					t.logf("EventSource event id updated: id=%s", eventID)
+				}
+				t.logf("EventSource event received: event=%q data_bytes=%d", eventName, data.Len())
+				if eventName == "state" || eventName == "" {
+					if err := t.handleState(ctx, data.String(), deliver); err != nil {
+						return err
+					}
+				}
+			}
+			eventName = ""
+			eventID = ""
+			data.Reset()
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, ok := strings.Cut(line, ":")
+		if ok {
+			value = strings.TrimPrefix(value, " ")
+		}
+		switch field {
+		case "event":
+			eventName = value
+		case "id":
+			eventID = value
+		case "data":
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+func (t *jmapTransport) handleState(ctx context.Context, raw string, deliver func(context.Context, []emailMessage)) error {
+	var change stateChange
+	if err := json.Unmarshal([]byte(raw), &change); err != nil {
+		return err
+	}
+	return t.handleStateChange(ctx, change, deliver)
+}
+
+func (t *jmapTransport) handleStateChange(ctx context.Context, change stateChange, deliver func(context.Context, []emailMessage)) error {
+	t.logf("state change received: type=%s account_count=%d", change.Type, len(change.Changed))
+
+	if accountChange := change.Changed[t.accountID]; accountChange["Email"] != "" {
+		t.logf("email state changed: old_state=%s pushed_state=%s", t.emailState, accountChange["Email"])
+		return t.syncEmailChanges(ctx, deliver)
+	}
+	t.logf("state change ignored: no Email change for selected account")
+	return nil
+}
+
+func (t *jmapTransport) syncEmailChanges(ctx context.Context, deliver func(context.Context, []emailMessage)) error {
+	for {
+		t.logf("syncing email changes: since_state=%s", t.emailState)
+		envelope, err := t.client.Call(ctx, []methodCall{
+			{"Email/changes", map[string]any{
+				"accountId":  t.accountID,
+				"sinceState": t.emailState,
+				"maxChanges": t.client.clampGetLimit(256),
+			}, "changes"},
+			{"Email/get", map[string]any{
+				"accountId":  t.accountID,
+				"#ids":       map[string]string{"resultOf": "changes", "name": "Email/changes", "path": "/created"},
+				"properties": []string{"id", "from", "to", "subject", "mailboxIds"},
+			}, "created"},
+		})
+		if err != nil {
+			return err
+		}
+
+		var changes emailChangesResponse
+		var created emailGetResponse
+		for _, response := range envelope.MethodResponses {
+			name, args, err := decodeMethodResponse(response)
+			if err != nil {
+				return err
+			}
+			switch name {
+			case "Email/changes":
+				if err := json.Unmarshal(args, &changes); err != nil {
+					return err
+				}
+				t.logf("Email/changes response: created=%d updated=%d destroyed=%d has_more=%t new_state=%s", len(changes.Created), len(changes.Updated), len(changes.Destroyed), changes.HasMoreChanges, changes.NewState)
+			case "Email/get":
+				if err := json.Unmarshal(args, &created); err != nil {
+					return err
+				}
+				t.logf("Email/get response for created messages: fetched=%d not_found=%d", len(created.List), len(created.NotFound))
+			case "error":
+				return fmt.Errorf("JMAP sync error: %s", string(args))
+			}
+		}
+
+		watched := make([]emailMessage, 0, len(created.List))
+		for _, msg := range created.List {
+			if !msg.MailboxIDs[t.inboxID] {
+				t.logf("created message ignored outside watched mailbox: id=%s", msg.ID)
+				continue
+			}
+			watched = append(watched, msg)
+		}
+		deliver(ctx, watched)
+
+		if changes.NewState != "" {
+			t.emailState = changes.NewState
+			t.logf("email state advanced: state=%s", t.emailState)
+		}
+		if !changes.HasMoreChanges {
+			t.logf("email sync complete")
+			return nil
+		}
+		t.logf("more email changes available; continuing sync")
+	}
+}
+
+func (t *jmapTransport) Scan(ctx context.Context, limit int) ([]emailMessage, error) {
+	envelope, err := t.client.Call(ctx, []methodCall{
+		{"Email/query", map[string]any{
+			"accountId": t.accountID,
+			"filter":    map[string]any{"inMailbox": t.inboxID},
+			"sort":      []map[string]any{{"property": "receivedAt", "isAscending": true}},
+			"limit":     t.client.clampGetLimit(limit),
+		}, "query"},
+		{"Email/get", map[string]any{
+			"accountId":  t.accountID,
+			"#ids":       map[string]string{"resultOf": "query", "name": "Email/query", "path": "/ids"},
+			"properties": []string{"id", "from", "to", "subject", "mailboxIds"},
+		}, "messages"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var query emailQueryResponse
+	var messages emailGetResponse
+	for _, response := range envelope.MethodResponses {
+		name, args, err := decodeMethodResponse(response)
+		if err != nil {
+			return nil, err
+		}
+		switch name {
+		case "Email/query":
+			if err := json.Unmarshal(args, &query); err != nil {
+				return nil, err
+			}
+			t.logf("inbox safety scan query response: ids=%d query_state=%s", len(query.IDs), query.QueryState)
+		case "Email/get":
+			if err := json.Unmarshal(args, &messages); err != nil {
+				return nil, err
+			}
+			t.logf("inbox safety scan get response: fetched=%d not_found=%d", len(messages.List), len(messages.NotFound))
+		case "error":
+			return nil, fmt.Errorf("JMAP inbox safety scan error: %s", string(args))
+		}
+	}
+
+	watched := make([]emailMessage, 0, len(messages.List))
+	for _, msg := range messages.List {
+		if !msg.MailboxIDs[t.inboxID] {
+			t.logf("inbox safety scan ignored message outside watched mailbox: id=%s", msg.ID)
+			continue
+		}
+		watched = append(watched, msg)
+	}
+	return watched, nil
+}
+
+func (t *jmapTransport) Fetch(ctx context.Context, id string) (emailMessage, error) {
+	envelope, err := t.client.Call(ctx, []methodCall{
+		{"Email/get", map[string]any{
+			"accountId":           t.accountID,
+			"ids":                 []string{id},
+			"properties":          []string{"id", "blobId", "from", "to", "subject", "sentAt", "receivedAt", "textBody", "htmlBody", "attachments", "bodyValues", "messageId", "references"},
+			"fetchTextBodyValues": true,
+			"fetchHTMLBodyValues": false,
+			"maxBodyValueBytes":   200000,
+		}, "message"},
+	})
+	if err != nil {
+		return emailMessage{}, err
+	}
+
+	for _, response := range envelope.MethodResponses {
+		name, args, err := decodeMethodResponse(response)
+		if err != nil {
+			return emailMessage{}, err
+		}
+		switch name {
+		case "Email/get":
+			var got emailGetResponse
+			if err := json.Unmarshal(args, &got); err != nil {
+				return emailMessage{}, err
+			}
+			if len(got.List) == 0 {
+				return emailMessage{}, fmt.Errorf("message %s not found", id)
+			}
+			msg := got.List[0]
+			if msg.BlobID != "" {
+				raw, err := t.client.Download(ctx, t.accountID, msg.BlobID, "message.eml", "message/rfc822")
+				if err != nil {
+					return emailMessage{}, err
+				}
+				msg.Raw = raw
+			}
+			return msg, nil
+		case "error":
+			return emailMessage{}, fmt.Errorf("JMAP fetch email error: %s", string(args))
+		}
+	}
+
+	return emailMessage{}, fmt.Errorf("JMAP fetch email returned no Email/get response")
+}
+
+func (t *jmapTransport) Attachments(ctx context.Context, msg emailMessage) ([]emailAttachment, error) {
+	attachments := make([]emailAttachment, 0, len(msg.Attachments))
+	for _, part := range msg.Attachments {
+		blobID := strings.TrimSpace(part.BlobID)
+		if blobID == "" {
+			continue
+		}
+		attachment := emailAttachment{
+			Name:   part.Name,
+			Type:   part.Type,
+			BlobID: blobID,
+			Size:   part.Size,
+		}
+		data, err := t.client.Download(ctx, t.accountID, blobID, attachmentName(attachment), attachmentType(attachment))
+		if err != nil {
+			return nil, err
+		}
+		attachment.Data = data
+		if attachment.Size == 0 {
+			attachment.Size = len(data)
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, nil
+}
+
+func (t *jmapTransport) Submit(ctx context.Context, msg outgoingEmail) error {
+	if t.draftsID == "" {
+		return fmt.Errorf("drafts mailbox not found")
+	}
+	if t.identityID == "" {
+		return fmt.Errorf("identity for %s not found", t.creds.Username)
+	}
+	attachments, err := t.uploadAttachments(ctx, msg.Attachments)
+	if err != nil {
+		return err
+	}
+
+	createEmail := map[string]any{
+		"from":     []emailAddress{{Email: t.creds.Username}},
+		"to":       msg.To,
+		"subject":  msg.Subject,
+		"textBody": []map[string]any{{"partId": "text", "type": "text/plain"}},
+		"htmlBody": []map[string]any{{"partId": "html", "type": "text/html"}},
+		"bodyValues": map[string]any{
+			"text": map[string]any{"charset": "utf-8", "value": msg.TextBody},
+			"html": map[string]any{"charset": "utf-8", "value": msg.HTMLBody},
+		},
+		"mailboxIds": map[string]bool{t.draftsID: true},
+		"keywords":   map[string]bool{"$draft": true},
+	}
+	if len(attachments) > 0 {
+		createEmail["attachments"] = attachments
+	}
+	if len(msg.InReplyTo) > 0 {
+		createEmail["header:In-Reply-To:asMessageIds"] = msg.InReplyTo
+	}
+	if len(msg.References) > 0 {
+		createEmail["header:References:asMessageIds"] = msg.References
+	}
+
+	envelope, err := t.client.Call(ctx, []methodCall{
+		{"Email/set", map[string]any{
+			"accountId": t.accountID,
+			"create":    map[string]any{"reply": createEmail},
+		}, "emailSet"},
+		{"EmailSubmission/set", map[string]any{
+			"accountId":             t.accountID,
+			"onSuccessDestroyEmail": []string{"#submission"},
+			"create": map[string]any{
+				"submission": map[string]any{
+					"emailId":    "#reply",
+					"identityId": t.identityID,
+				},
+			},
+		}, "submissionSet"},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, response := range envelope.MethodResponses {
+		name, args, err := decodeMethodResponse(response)
+		if err != nil {
+			return err
+		}
+		if name == "error" {
+			return fmt.Errorf("JMAP send reply error: %s", string(args))
+		}
+		t.logf("auto-reply JMAP response: method=%s bytes=%d", name, len(args))
+	}
+	return nil
+}
+
+func (t *jmapTransport) uploadAttachments(ctx context.Context, attachments []emailAttachment) ([]map[string]any, error) {
+	if len(attachments) == 0 {
+		return nil, nil
+	}
+
+	parts := make([]map[string]any, 0, len(attachments))
+	for _, attachment := range attachments {
+		name := attachmentName(attachment)
+		contentType := attachmentType(attachment)
+		blobID := strings.TrimSpace(attachment.BlobID)
+		if blobID == "" {
+			uploaded, err := t.client.Upload(ctx, t.accountID, name, contentType, attachment.Data)
+			if err != nil {
+				return nil, err
+			}
+			blobID = uploaded.BlobID
+		}
+		if blobID == "" {
+			return nil, fmt.Errorf("attachment %q has no blobId after upload", name)
+		}
+		parts = append(parts, map[string]any{
+			"blobId":      blobID,
+			"type":        contentType,
+			"name":        name,
+			"disposition": "attachment",
+		})
+	}
+	return parts, nil
+}
+
+func (t *jmapTransport) Dispose(ctx context.Context, id string) error {
+	envelope, err := t.client.Call(ctx, []methodCall{
+		{"Email/set", map[string]any{
+			"accountId": t.accountID,
+			"destroy":   []string{id},
+		}, "deleteOriginal"},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, response := range envelope.MethodResponses {
+		name, args, err := decodeMethodResponse(response)
+		if err != nil {
+			return err
+		}
+		if name == "error" {
+			return fmt.Errorf("JMAP delete original email error: %s", string(args))
+		}
+		t.logf("original email delete response: method=%s bytes=%d", name, len(args))
+	}
+	return nil
+}
+
+func (t *jmapTransport) logf(format string, args ...any) {
+	logf(t.logOutput, format, args...)
+}
@@ -0,0 +1,366 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	appconfig "ai-over-email/pkg/config"
+	"ai-over-email/pkg/mimebuild"
+)
+
+var maildirCounter atomic.Uint64
+
+type maildirTransport struct {
+	config    appconfig.MaildirConfig
+	logOutput io.Writer
+	interval  time.Duration
+}
+
+func newMaildirTransport(config appconfig.MaildirConfig, logOutput io.Writer) *maildirTransport {
+	interval, err := time.ParseDuration(config.PollInterval)
+	if err != nil || interval <= 0 {
+		interval = 5 * time.Second
+	}
+	return &maildirTransport{config: config, logOutput: logOutput, interval: interval}
+}
+
+func (t *maildirTransport) Connect(ctx context.Context) error {
+	if err := t.ConnectReadOnly(ctx); err != nil {
+		return err
+	}
+	if t.config.Outbox != "" {
+		if err := ensureMaildir(t.config.Outbox); err != nil {
+			return err
+		}
+	}
+	if t.config.ArchivePath != "" {
+		if err := ensureMaildir(t.config.ArchivePath); err != nil {
+			return err
+		}
+	}
+	t.logf("maildir transport ready: path=%s outbox=%s sendmail_configured=%t archive=%s poll_interval=%s", t.config.Path, t.config.Outbox, t.config.Sendmail != "", t.config.ArchivePath, t.interval)
+	return nil
+}
+
+// ConnectReadOnly only checks the maildir; it does not create the outbox or
+// archive directories.
+func (t *maildirTransport) ConnectReadOnly(ctx context.Context) error {
+	for _, dir := range []string{"new", "cur"} {
+		info, err := os.Stat(filepath.Join(t.config.Path, dir))
+		if err != nil {
+			return fmt.Errorf("maildir %s: %w", t.config.Path, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("maildir %s: %s is not a directory", t.config.Path, dir)
+		}
+	}
+	return nil
+}
+
+func (t *maildirTransport) Watch(ctx context.Context, deliver func(context.Context, []emailMessage)) error {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			t.logf("watcher context canceled")
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		messages, err := t.collectNew()
+		if err != nil {
+			t.logf("maildir poll failed: err=%v", err)
+			continue
+		}
+		if len(messages) > 0 {
+			t.logf("maildir poll found new messages: count=%d", len(messages))
+			deliver(ctx, messages)
+		}
+	}
+}
+
+func (t *maildirTransport) collectNew() ([]emailMessage, error) {
+	entries, err := t.entries("new")
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]emailMessage, 0, len(entries))
+	for _, entry := range entries {
+		id := maildirID(entry.name)
+		target := filepath.Join(t.config.Path, "cur", id+":2,")
+		if err := os.Rename(entry.path, target); err != nil {
+			t.logf("maildir message could not be moved to cur: id=%s err=%v", id, err)
+			continue
+		}
+		msg, err := readMaildirMessage(id, target)
+		if err != nil {
+			t.logf("maildir message could not be parsed: id=%s err=%v", id, err)
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+func (t *maildirTransport) Scan(ctx context.Context, limit int) ([]emailMessage, error) {
+	var entries []maildirEntry
+	for _, dir := range []string{"new", "cur"} {
+		found, err := t.entries(dir)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, found...)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime.Before(entries[j].modTime) })
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	messages := make([]emailMessage, 0, len(entries))
+	for _, entry := range entries {
+		msg, err := readMaildirMessage(maildirID(entry.name), entry.path)
+		if err != nil {
+			t.logf("inbox safety scan could not parse maildir message: file=%s err=%v", entry.name, err)
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	t.logf("inbox safety scan maildir listing: found=%d returned=%d", len(entries), len(messages))
+	return messages, nil
+}
+
+func (t *maildirTransport) Fetch(ctx context.Context, id string) (emailMessage, error) {
+	path, err := t.locate(id)
+	if err != nil {
+		return emailMessage{}, err
+	}
+	return readMaildirMessage(id, path)
+}
+
+func (t *maildirTransport) Attachments(ctx context.Context, msg emailMessage) ([]emailAttachment, error) {
+	return extractDecryptedAttachments(string(msg.Raw)), nil
+}
+
+func (t *maildirTransport) Submit(ctx context.Context, msg outgoingEmail) error {
+	from := emailAddress{Name: t.config.FromName, Email: t.config.FromAddress}
+	raw, err := buildRFC822(from, msg, time.Now(), mimebuild.NewMessageID(t.config.FromAddress))
+	if err != nil {
+		return err
+	}
+
+	if t.config.Sendmail != "" {
+		args := strings.Fields(t.config.Sendmail)
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Stdin = bytes.NewReader(raw)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("sendmail: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		t.logf("reply piped to sendmail: command=%s bytes=%d", args[0], len(raw))
+		return nil
+	}
+
+	name := maildirFilename()
+	tmp := filepath.Join(t.config.Outbox, "tmp", name)
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return fmt.Errorf("write outbox message: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(t.config.Outbox, "new", name)); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("deliver outbox message: %w", err)
+	}
+	t.logf("reply written to outbox maildir: file=%s bytes=%d", name, len(raw))
+	return nil
+}
+
+func (t *maildirTransport) Dispose(ctx context.Context, id string) error {
+	path, err := t.locate(id)
+	if err != nil {
+		return err
+	}
+	if t.config.ArchivePath == "" {
+		return os.Remove(path)
+	}
+	name := filepath.Base(path)
+	if !strings.Contains(name, ":2,") {
+		name += ":2,"
+	}
+	if !strings.Contains(name[strings.Index(name, ":2,"):], "S") {
+		name += "S"
+	}
+	if err := os.Rename(path, filepath.Join(t.config.ArchivePath, "cur", name)); err != nil {
+		return fmt.Errorf("archive maildir message: %w", err)
+	}
+	t.logf("maildir message archived: id=%s archive=%s", id, t.config.ArchivePath)
+	return nil
+}
+
+type maildirEntry struct {
+	name    string
+	path    string
+	modTime time.Time
+}
+
+func (t *maildirTransport) entries(dir string) ([]maildirEntry, error) {
+	items, err := os.ReadDir(filepath.Join(t.config.Path, dir))
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]maildirEntry, 0, len(items))
+	for _, item := range items {
+		if item.IsDir() || strings.HasPrefix(item.Name(), ".") {
+			continue
+		}
+		info, err := item.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, maildirEntry{
+			name:    item.Name(),
+			path:    filepath.Join(t.config.Path, dir, item.Name()),
+			modTime: info.ModTime(),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime.Before(entries[j].modTime) })
+	return entries, nil
+}
+
+func (t *maildirTransport) locate(id string) (string, error) {
+	id = strings.TrimSpace(id)
+	if id == "" || strings.ContainsAny(id, `/\`) {
+		return "", fmt.Errorf("invalid maildir message id %q", id)
+	}
+	for _, dir := range []string{"cur", "new"} {
+		items, err := os.ReadDir(filepath.Join(t.config.Path, dir))
+		if err != nil {
+			return "", err
+		}
+		for _, item := range items {
+			if maildirID(item.Name()) == id {
+				return filepath.Join(t.config.Path, dir, item.Name()), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("message %s not found", id)
+}
+
+func (t *maildirTransport) logf(format string, args ...any) {
+	logf(t.logOutput, format, args...)
+}
+
+func readMaildirMessage(id, path string) (emailMessage, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return emailMessage{}, err
+	}
+	msg, err := parseRFC822Message(raw)
+	if err != nil {
+		return emailMessage{}, err
+	}
+	msg.ID = id
+	if msg.ReceivedAt == "" {
+		if info, err := os.Stat(path); err == nil {
+			msg.ReceivedAt = info.ModTime().UTC().Format(time.RFC3339)
+		}
+	}
+	return msg, nil
+}
+
+func parseRFC822Message(raw []byte) (emailMessage, error) {
+	parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return emailMessage{}, err
+	}
+	header := parsed.Header
+	msg := emailMessage{
+		From:       headerAddresses(header, "From"),
+		To:         headerAddresses(header, "To"),
+		Subject:    decodeHeaderValue(header.Get("Subject")),
+		MessageID:  headerMessageIDs(header.Get("Message-Id")),
+		References: headerMessageIDs(header.Get("References")),
+		Raw:        raw,
+	}
+	if date, err := header.Date(); err == nil {
+		msg.SentAt = date.UTC().Format(time.RFC3339)
+	}
+	if text, ok := extractTextMIMEPart(header, parsed.Body); ok {
+		msg.TextBody = []emailBodyPart{{PartID: "text", Type: "text/plain"}}
+		msg.BodyValues = map[string]emailBodyValue{"text": {Value: text}}
+	}
+	for index, attachment := range extractDecryptedAttachments(string(raw)) {
+		msg.Attachments = append(msg.Attachments, emailBodyPart{
+			PartID:      fmt.Sprintf("attachment-%d", index+1),
+			Type:        attachment.Type,
+			Name:        attachment.Name,
+			Size:        attachment.Size,
+			Disposition: "attachment",
+		})
+	}
+	return msg, nil
+}
+
+func headerAddresses(header mail.Header, name string) []emailAddress {
+	list, err := header.AddressList(name)
+	if err != nil {
+		return nil
+	}
+	addresses := make([]emailAddress, 0, len(list))
+	for _, address := range list {
+		addresses = append(addresses, emailAddress{Name: address.Name, Email: address.Address})
+	}
+	return addresses
+}
+
+func headerMessageIDs(value string) []string {
+	var ids []string
+	for _, field := range strings.Fields(value) {
+		if id := strings.Trim(field, "<>,"); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func decodeHeaderValue(value string) string {
+	decoded, err := new(mime.WordDecoder).DecodeHeader(value)
+	if err != nil {
+		return strings.TrimSpace(value)
+	}
+	return strings.TrimSpace(decoded)
+}
+
+func maildirID(name string) string {
+	id, _, _ := strings.Cut(name, ":2,")
+	return id
+}
+
+func maildirFilename() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	host = strings.NewReplacer("/", `\057`, ":", `\072`).Replace(host)
+	now := time.Now()
+	return fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(), maildirCounter.Add(1), host)
+}
+
+func ensureMaildir(path string) error {
+	for _, dir := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(path, dir), 0o700); err != nil {
+			return fmt.Errorf("create maildir %s: %w", path, err)
+		}
+	}
+	return nil
+}
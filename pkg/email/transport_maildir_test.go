@@ -0,0 +1,167 @@
+package email
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	appconfig "ai-over-email/pkg/config"
+)
+
+func newTestMaildir(t *testing.T) (*maildirTransport, string) {
+	t.Helper()
+	root := t.TempDir()
+	inbox := filepath.Join(root, "inbox")
+	if err := ensureMaildir(inbox); err != nil {
+		t.Fatal(err)
+	}
+	transport := newMaildirTransport(appconfig.MaildirConfig{
+		Path:         inbox,
+		Outbox:       filepath.Join(root, "outbox"),
+		ArchivePath:  filepath.Join(root, "archive"),
+		PollInterval: "5s",
+		FromName:     "Assistant",
+		FromAddress:  testAddress("assistant", "mail.test"),
+	}, nil)
+	if err := transport.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return transport, root
+}
+
+func deliverTestMessage(t *testing.T, inbox, name, raw string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(inbox, "new", name), []byte(strings.ReplaceAll(raw, "\n", "\r\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMaildirTransportReceivesAndArchivesMessages(t *testing.T) {
+	transport, root := newTestMaildir(t)
+	sender := testAddress("sender", "mail.test")
+	deliverTestMessage(t, transport.config.Path, "1700000000.M1P1.host", `From: Sender <`+sender+`>
+To: `+testAddress("assistant", "mail.test")+`
+Subject: =?utf-8?q?Caf=C3=A9_question?=
+Date: Mon, 02 Jan 2006 15:04:05 -0700
+Message-ID: <original@mail.test>
+References: <earlier@mail.test>
+Content-Type: text/plain; charset=utf-8
+
+What time is it?
+`)
+
+	messages, err := transport.collectNew()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("collectNew returned %d messages, want 1", len(messages))
+	}
+	if _, err := os.Stat(filepath.Join(transport.config.Path, "cur", "1700000000.M1P1.host:2,")); err != nil {
+		t.Fatalf("message was not moved to cur: %v", err)
+	}
+
+	msg, err := transport.Fetch(context.Background(), messages[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID != "1700000000.M1P1.host" || msg.Subject != "Café question" {
+		t.Fatalf("message = id %q subject %q", msg.ID, msg.Subject)
+	}
+	if len(msg.From) != 1 || msg.From[0].Email != sender || msg.From[0].Name != "Sender" {
+		t.Fatalf("from = %#v", msg.From)
+	}
+	if got := strings.TrimSpace(extractEmailBody(msg)); got != "What time is it?" {
+		t.Fatalf("body = %q", got)
+	}
+	if strings.Join(msg.MessageID, ",") != "original@mail.test" || strings.Join(msg.References, ",") != "earlier@mail.test" {
+		t.Fatalf("message ids = %v references = %v", msg.MessageID, msg.References)
+	}
+	if msg.SentAt != "2006-01-02T22:04:05Z" {
+		t.Fatalf("sentAt = %q", msg.SentAt)
+	}
+
+	scanned, err := transport.Scan(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scanned) != 1 || scanned[0].ID != msg.ID {
+		t.Fatalf("Scan = %#v, want the unprocessed message", scanned)
+	}
+
+	if err := transport.Dispose(context.Background(), msg.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "archive", "cur", "1700000000.M1P1.host:2,S")); err != nil {
+		t.Fatalf("message was not archived: %v", err)
+	}
+	if _, err := transport.Fetch(context.Background(), msg.ID); err == nil {
+		t.Fatal("Fetch after Dispose succeeded, want not found")
+	}
+}
+
+func TestMaildirTransportSubmitWritesOutbox(t *testing.T) {
+	transport, root := newTestMaildir(t)
+	recipient := testAddress("sender", "mail.test")
+	err := transport.Submit(context.Background(), outgoingEmail{
+		To:          []emailAddress{{Name: "Sender", Email: recipient}},
+		Subject:     "Re: Café question",
+		TextBody:    "It is noon.",
+		HTMLBody:    "<p>It is noon.</p>",
+		Attachments: []emailAttachment{{Name: "notes.txt", Type: "text/plain", Data: []byte("attached notes")}},
+		InReplyTo:   []string{"original@mail.test"},
+		References:  []string{"earlier@mail.test", "original@mail.test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := os.ReadDir(filepath.Join(root, "outbox", "new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("outbox has %d messages, want 1", len(items))
+	}
+	raw, err := os.ReadFile(filepath.Join(root, "outbox", "new", items[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"From: \"Assistant\" <assistant@mail.test>",
+		"In-Reply-To: <original@mail.test>",
+		"References: <earlier@mail.test> <original@mail.test>",
+		"Auto-Submitted: auto-replied",
+	} {
+		if !strings.Contains(string(raw), want) {
+			t.Fatalf("outbox message missing %q:\n%s", want, raw)
+		}
+	}
+
+	sent, err := parseRFC822Message(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent.Subject != "Re: Café question" || len(sent.To) != 1 || sent.To[0].Email != recipient {
+		t.Fatalf("sent subject %q to %#v", sent.Subject, sent.To)
+	}
+	if got := extractEmailBody(sent); got != "It is noon." {
+		t.Fatalf("sent body = %q", got)
+	}
+	attachments, err := transport.Attachments(context.Background(), sent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 1 || attachments[0].Name != "notes.txt" || string(attachments[0].Data) != "attached notes" {
+		t.Fatalf("attachments = %#v", attachments)
+	}
+}
+
+func TestMaildirTransportRejectsPathIDs(t *testing.T) {
+	transport, _ := newTestMaildir(t)
+	if _, err := transport.Fetch(context.Background(), "../outbox/new/x"); err == nil {
+		t.Fatal("Fetch accepted an id containing a path separator")
+	}
+}
@@ -1,7 +1,6 @@
 package email
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -17,32 +16,35 @@ import (
 const (
 	inboxSafetyScanInterval = 5 * time.Minute
 	inboxSafetyScanLimit    = 50
-	dailyMessageLimit       = 10
 )
 
 type Config struct {
-	EnvPath      string
-	ConfigPath   string
-	DatabasePath string
-	Output       io.Writer
-	LogOutput    io.Writer
+	EnvPath        string
+	ConfigPath     string
+	DatabasePath   string
+	Output         io.Writer
+	LogOutput      io.Writer
+	HTTPTransport  http.RoundTripper
+	OpenAIURL      string
+	BraveSearchURL string
 }
 
 type Watcher struct {
 	config    Config
 	creds     Credentials
 	appConfig appconfig.ConfigStruct
-	client    *jmapClient
+	transport mailTransport
 	openai    *openAIClient
 	store     *correspondentStore
 
-	accountID  string
-	inboxID    string
-	draftsID   string
-	identityID string
-	emailState string
-	seen       map[string]struct{}
-	mu         sync.Mutex
+	// now overrides the clock in the reply prompt; fixture replay pins it so
+	// recorded request bodies stay reproducible.
+	now func() time.Time
+
+	connected         bool
+	connectedReadOnly bool
+	seen              map[string]struct{}
+	mu                sync.Mutex
 }
 
 type mailbox struct {
@@ -96,8 +98,9 @@ type emailAttachment struct {
 }
 
 type stateChange struct {
-	Type    string                       `json:"@type"`
-	Changed map[string]map[string]string `json:"changed"`
+	Type      string                       `json:"@type"`
+	Changed   map[string]map[string]string `json:"changed"`
+	PushState string                       `json:"pushState"`
 }
 
 type emailGetResponse struct {
@@ -138,128 +141,81 @@ type identityGetResponse struct {
 func NewWatcher(config Config) (*Watcher, error) {
 	config = normalizeConfig(config)
 
+	logf(config.LogOutput, "loading application config from %s", config.ConfigPath)
+	appConfig, err := appconfig.Load(config.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	logf(config.LogOutput, "application config loaded: transport=%s jmap_session_endpoint=%s legacy_basic_endpoint=%s autodiscover=%t oauth=%t", appConfig.TransportType(), appConfig.JMAP.SessionEndpoint, appConfig.JMAP.LegacyBasicAuthSessionEndpoint, appConfig.JMAP.Autodiscover, appConfig.JMAP.OAuth.TokenURL != "")
+
 	logf(config.LogOutput, "loading credentials from environment with optional env file %s", config.EnvPath)
-	creds, err := LoadCredentials(config.EnvPath)
+	creds, err := loadCredentials(config.EnvPath, appConfig.TransportType())
 	if err != nil {
 		return nil, err
 	}
+	creds = transportCredentials(creds, appConfig)
 	logf(config.LogOutput, "credentials loaded: username_present=%t token_present=%t password_present=%t openai_token_present=%t brave_search_token_present=%t mailbox=%q", creds.Username != "", creds.Token != "", creds.Password != "", creds.OpenAIAPIToken != "", creds.BraveSearchAPIToken != "", creds.Mailbox)
 
-	logf(config.LogOutput, "loading application config from %s", config.ConfigPath)
-	appConfig, err := appconfig.Load(config.ConfigPath)
+	transport, err := newMailTransport(appConfig, creds, config.LogOutput)
 	if err != nil {
 		return nil, err
 	}
-	logf(config.LogOutput, "application config loaded: jmap_session_endpoint=%s legacy_basic_endpoint=%s", appConfig.JMAP.SessionEndpoint, appConfig.JMAP.LegacyBasicAuthSessionEndpoint)
+	return openWatcher(config, appConfig, creds, transport)
+}
 
+func openWatcher(config Config, appConfig appconfig.ConfigStruct, creds Credentials, transport mailTransport) (*Watcher, error) {
 	store, err := openCorrespondentStore(config.DatabasePath)
 	if err != nil {
 		return nil, err
 	}
 	logf(config.LogOutput, "correspondent database opened: path=%s", config.DatabasePath)
 
+	openai := newOpenAIClient(creds.OpenAIAPIToken, creds.PublicEmail, creds.BraveSearchAPIToken, config.LogOutput)
+	openai.UseEndpoints(config.OpenAIURL, config.BraveSearchURL, config.HTTPTransport)
+	if jmap, ok := transport.(*jmapTransport); ok {
+		jmap.client.useTransport(config.HTTPTransport)
+	}
+
 	return &Watcher{
 		config:    config,
 		creds:     creds,
 		appConfig: appConfig,
-		client:    newJMAPClient(creds, config.LogOutput),
-		openai:    newOpenAIClient(creds.OpenAIAPIToken, creds.PublicEmail, creds.BraveSearchAPIToken, config.LogOutput),
+		transport: transport,
+		openai:    openai,
 		store:     store,
 		seen:      make(map[string]struct{}),
 	}, nil
 }
 
 func (w *Watcher) Run(ctx context.Context) error {
-	w.logf("starting JMAP mailbox watcher")
-	if err := w.client.FetchSession(ctx, w.appConfig); err != nil {
-		return err
-	}
-
-	accountID, err := w.client.AccountID()
-	if err != nil {
-		return err
-	}
-	w.accountID = accountID
-	w.logf("selected JMAP account: account_id=%s", w.accountID)
-
-	if err := w.initialize(ctx); err != nil {
+	w.logf("starting mailbox watcher: transport=%s", w.appConfig.TransportType())
+	if err := w.connect(ctx); err != nil {
 		return err
 	}
 
 	go w.runInboxSafetyScanner(ctx)
 
 	w.logf("initialization complete; listening for mailbox changes")
-	return w.listen(ctx)
+	return w.transport.Watch(ctx, w.deliver)
 }
 
-func (w *Watcher) initialize(ctx context.Context) error {
-	w.logf("initializing mailbox and email state: desired_mailbox=%q", w.creds.Mailbox)
-	envelope, err := w.client.Call(ctx, []methodCall{
-		{"Mailbox/get", map[string]any{
-			"accountId":  w.accountID,
-			"properties": []string{"id", "name", "role"},
-		}, "mailboxes"},
-		{"Identity/get", map[string]any{
-			"accountId":  w.accountID,
-			"properties": []string{"id", "email", "name"},
-		}, "identities"},
-		{"Email/query", map[string]any{
-			"accountId": w.accountID,
-			"filter":    map[string]any{},
-			"sort":      []map[string]any{{"property": "receivedAt", "isAscending": false}},
-			"limit":     1,
-		}, "query"},
-		{"Email/get", map[string]any{
-			"accountId":  w.accountID,
-			"#ids":       map[string]string{"resultOf": "query", "name": "Email/query", "path": "/ids"},
-			"properties": []string{"id"},
-		}, "state"},
-	})
-	if err != nil {
+func (w *Watcher) connect(ctx context.Context) error {
+	if err := w.transport.Connect(ctx); err != nil {
 		return err
 	}
+	w.connected = true
+	return nil
+}
 
-	for _, response := range envelope.MethodResponses {
-		name, args, err := decodeMethodResponse(response)
-		if err != nil {
-			return err
-		}
-		w.logf("initialization response received: method=%s bytes=%d", name, len(args))
-
-		switch name {
-		case "Mailbox/get":
-			var mailboxes mailboxGetResponse
-			if err := json.Unmarshal(args, &mailboxes); err != nil {
-				return err
-			}
-			w.inboxID = selectMailboxID(mailboxes.List, w.creds.Mailbox)
-			w.draftsID = selectMailboxID(mailboxes.List, "drafts")
-			w.logf("mailboxes loaded: count=%d selected_mailbox_id=%s drafts_mailbox_id=%s", len(mailboxes.List), w.inboxID, w.draftsID)
-		case "Identity/get":
-			var identities identityGetResponse
-			if err := json.Unmarshal(args, &identities); err != nil {
-				return err
-			}
-			w.identityID = selectIdentityID(identities.List, w.creds.Username)
-			w.logf("identities loaded: count=%d selected_identity_id=%s", len(identities.List), w.identityID)
-		case "Email/get":
-			var emails emailGetResponse
-			if err := json.Unmarshal(args, &emails); err != nil {
-				return err
-			}
-			w.emailState = emails.State
-			w.logf("email state initialized: state=%s baseline_messages=%d", w.emailState, len(emails.List))
-		case "error":
-			return fmt.Errorf("JMAP initialization error: %s", string(args))
-		}
-	}
-
-	if w.inboxID == "" {
-		return fmt.Errorf("mailbox %q not found", w.creds.Mailbox)
+func (w *Watcher) connectReadOnly(ctx context.Context) error {
+	connector, ok := w.transport.(readOnlyConnector)
+	if !ok {
+		return w.connect(ctx)
 	}
-	if w.emailState == "" {
-		return fmt.Errorf("could not initialize email state")
+	if err := connector.ConnectReadOnly(ctx); err != nil {
+		return err
 	}
+	w.connectedReadOnly = true
 	return nil
 }
 
@@ -285,260 +241,46 @@ func (w *Watcher) runInboxSafetyScanner(ctx context.Context) {
 	}
 }
 
-func (w *Watcher) listen(ctx context.Context) error {
-	var lastEventID string
-	for {
-		if err := w.listenOnce(ctx, &lastEventID); err != nil {
-			if ctx.Err() != nil {
-				w.logf("watcher context canceled")
-				return ctx.Err()
-			}
-			w.logf("event stream disconnected: err=%v reconnect_delay=500ms", err)
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			case <-time.After(500 * time.Millisecond):
-			}
-		}
-	}
-}
-
-func (w *Watcher) listenOnce(ctx context.Context, lastEventID *string) error {
-	req, err := w.client.NewEventSourceRequest(ctx, *lastEventID)
-	if err != nil {
-		return err
-	}
-	w.logf("connecting to JMAP EventSource: url=%s last_event_id_present=%t", req.URL.Redacted(), *lastEventID != "")
-
-	resp, err := w.client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	w.logf("EventSource response received: status=%s content_type=%s", resp.Status, resp.Header.Get("Content-Type"))
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-		return fmt.Errorf("event source: %s: %s", resp.Status, strings.TrimSpace(string(body)))
-	}
-
-	scanner := bufio.NewScanner(resp.Body)
-	scanner.Buffer(make([]byte, 0, 4096), 1024*1024)
-
-	var eventName string
-	var eventID string
-	var data strings.Builder
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			if data.Len() > 0 {
-				if eventID != "" {
-					*lastEventID = eventID
-					w.logf("EventSource event id updated: id=%s", eventID)
-				}
-				w.logf("EventSource event received: event=%q data_bytes=%d", eventName, data.Len())
-				if eventName == "state" || eventName == "" {
-					if err := w.handleState(ctx, data.String()); err != nil {
-						return err
-					}
-				}
-			}
-			eventName = ""
-			eventID = ""
-			data.Reset()
-			continue
-		}
-		if strings.HasPrefix(line, ":") {
-			continue
-		}
-		field, value, ok := strings.Cut(line, ":")
-		if ok {
-			value = strings.TrimPrefix(value, " ")
-		}
-		switch field {
-		case "event":
-			eventName = value
-		case "id":
-			eventID = value
-		case "data":
-			if data.Len() > 0 {
-				data.WriteByte('\n')
-			}
-			data.WriteString(value)
-		}
-	}
-	if err := scanner.Err(); err != nil {
-		return err
-	}
-	return io.EOF
-}
-
-func (w *Watcher) handleState(ctx context.Context, raw string) error {
-	var change stateChange
-	if err := json.Unmarshal([]byte(raw), &change); err != nil {
-		return err
-	}
-	w.logf("state change received: type=%s account_count=%d", change.Type, len(change.Changed))
-
-	if accountChange := change.Changed[w.accountID]; accountChange["Email"] != "" {
-		w.logf("email state changed: old_state=%s pushed_state=%s", w.emailState, accountChange["Email"])
-		return w.syncEmailChanges(ctx)
-	}
-	w.logf("state change ignored: no Email change for selected account")
-	return nil
-}
-
-func (w *Watcher) syncEmailChanges(ctx context.Context) error {
+func (w *Watcher) deliver(ctx context.Context, messages []emailMessage) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	return w.syncEmailChangesLocked(ctx)
-}
-
-func (w *Watcher) syncEmailChangesLocked(ctx context.Context) error {
-	for {
-		w.logf("syncing email changes: since_state=%s", w.emailState)
-		envelope, err := w.client.Call(ctx, []methodCall{
-			{"Email/changes", map[string]any{
-				"accountId":  w.accountID,
-				"sinceState": w.emailState,
-				"maxChanges": 256,
-			}, "changes"},
-			{"Email/get", map[string]any{
-				"accountId":  w.accountID,
-				"#ids":       map[string]string{"resultOf": "changes", "name": "Email/changes", "path": "/created"},
-				"properties": []string{"id", "from", "to", "subject", "mailboxIds"},
-			}, "created"},
-		})
-		if err != nil {
-			return err
-		}
-
-		var changes emailChangesResponse
-		var created emailGetResponse
-		for _, response := range envelope.MethodResponses {
-			name, args, err := decodeMethodResponse(response)
-			if err != nil {
-				return err
-			}
-			switch name {
-			case "Email/changes":
-				if err := json.Unmarshal(args, &changes); err != nil {
-					return err
-				}
-				w.logf("Email/changes response: created=%d updated=%d destroyed=%d has_more=%t new_state=%s", len(changes.Created), len(changes.Updated), len(changes.Destroyed), changes.HasMoreChanges, changes.NewState)
-			case "Email/get":
-				if err := json.Unmarshal(args, &created); err != nil {
-					return err
-				}
-				w.logf("Email/get response for created messages: fetched=%d not_found=%d", len(created.List), len(created.NotFound))
-			case "error":
-				return fmt.Errorf("JMAP sync error: %s", string(args))
-			}
-		}
-
-		for _, msg := range created.List {
-			if !msg.MailboxIDs[w.inboxID] {
-				w.logf("created message ignored outside watched mailbox: id=%s", msg.ID)
-				continue
-			}
-			if _, ok := w.seen[msg.ID]; ok {
-				w.logf("created message already seen: id=%s", msg.ID)
-				continue
-			}
-			w.seen[msg.ID] = struct{}{}
-			if reason := w.skipAutoReplyReason(msg); reason != "" {
-				w.handleAutoReplyGuard(ctx, msg, reason, "event")
-				continue
-			}
-			w.logf("new watched message: id=%s from=%q subject=%q", msg.ID, formatFrom(msg.From), msg.Subject)
-			fmt.Fprintf(w.config.Output, "FROM: %s\tSUBJECT: %s\n", formatFrom(msg.From), msg.Subject)
-			if err := w.maybeAutoReply(ctx, msg); err != nil {
-				w.logf("auto-reply failed: id=%s err=%v", msg.ID, err)
-			}
-		}
-
-		if changes.NewState != "" {
-			w.emailState = changes.NewState
-			w.logf("email state advanced: state=%s", w.emailState)
-		}
-		if !changes.HasMoreChanges {
-			w.logf("email sync complete")
-			return nil
-		}
-		w.logf("more email changes available; continuing sync")
-	}
+	w.process(ctx, messages, "event")
 }
 
 func (w *Watcher) scanInbox(ctx context.Context, reason string) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	w.logf("running inbox safety scan: reason=%s mailbox_id=%s limit=%d", reason, w.inboxID, inboxSafetyScanLimit)
-	envelope, err := w.client.Call(ctx, []methodCall{
-		{"Email/query", map[string]any{
-			"accountId": w.accountID,
-			"filter":    map[string]any{"inMailbox": w.inboxID},
-			"sort":      []map[string]any{{"property": "receivedAt", "isAscending": true}},
-			"limit":     inboxSafetyScanLimit,
-		}, "query"},
-		{"Email/get", map[string]any{
-			"accountId":  w.accountID,
-			"#ids":       map[string]string{"resultOf": "query", "name": "Email/query", "path": "/ids"},
-			"properties": []string{"id", "from", "to", "subject", "mailboxIds"},
-		}, "messages"},
-	})
+	w.logf("running inbox safety scan: reason=%s limit=%d", reason, inboxSafetyScanLimit)
+	messages, err := w.transport.Scan(ctx, inboxSafetyScanLimit)
 	if err != nil {
 		return err
 	}
+	attempted := w.process(ctx, messages, "safety_scan")
+	w.logf("inbox safety scan complete: reason=%s fetched=%d attempted=%d", reason, len(messages), attempted)
+	return nil
+}
 
-	var query emailQueryResponse
-	var messages emailGetResponse
-	for _, response := range envelope.MethodResponses {
-		name, args, err := decodeMethodResponse(response)
-		if err != nil {
-			return err
-		}
-		switch name {
-		case "Email/query":
-			if err := json.Unmarshal(args, &query); err != nil {
-				return err
-			}
-			w.logf("inbox safety scan query response: ids=%d query_state=%s", len(query.IDs), query.QueryState)
-		case "Email/get":
-			if err := json.Unmarshal(args, &messages); err != nil {
-				return err
-			}
-			w.logf("inbox safety scan get response: fetched=%d not_found=%d", len(messages.List), len(messages.NotFound))
-		case "error":
-			return fmt.Errorf("JMAP inbox safety scan error: %s", string(args))
-		}
-	}
-
+func (w *Watcher) process(ctx context.Context, messages []emailMessage, source string) int {
 	attempted := 0
-	for _, msg := range messages.List {
-		if !msg.MailboxIDs[w.inboxID] {
-			w.logf("inbox safety scan ignored message outside watched mailbox: id=%s", msg.ID)
-			continue
-		}
+	for _, msg := range messages {
 		if _, ok := w.seen[msg.ID]; ok {
-			w.logf("inbox safety scan skipped already attempted message: id=%s", msg.ID)
+			w.logf("%s skipped already attempted message: id=%s", source, msg.ID)
 			continue
 		}
 		w.seen[msg.ID] = struct{}{}
 		if reason := w.skipAutoReplyReason(msg); reason != "" {
-			w.handleAutoReplyGuard(ctx, msg, reason, "safety_scan")
+			w.handleAutoReplyGuard(ctx, msg, reason, source)
 			continue
 		}
 		attempted++
-		w.logf("inbox safety scan found unprocessed message: id=%s from=%q subject=%q", msg.ID, formatFrom(msg.From), msg.Subject)
+		w.logf("%s found new message: id=%s from=%q subject=%q", source, msg.ID, formatFrom(msg.From), msg.Subject)
 		fmt.Fprintf(w.config.Output, "FROM: %s\tSUBJECT: %s\n", formatFrom(msg.From), msg.Subject)
 		if err := w.maybeAutoReply(ctx, msg); err != nil {
-			w.logf("auto-reply failed from inbox safety scan: id=%s err=%v", msg.ID, err)
+			w.logf("auto-reply failed: source=%s id=%s err=%v", source, msg.ID, err)
 		}
 	}
-
-	w.logf("inbox safety scan complete: reason=%s fetched=%d attempted=%d", reason, len(messages.List), attempted)
-	return nil
+	return attempted
 }
 
 func (w *Watcher) skipAutoReplyReason(msg emailMessage) string {
@@ -593,25 +335,28 @@ func (w *Watcher) maybeAutoReply(ctx context.Context, msg emailMessage) error {
 	if w.creds.OpenAIAPIToken == "" {
 		return fmt.Errorf("OPENAI_API_TOKEN is missing from credentials")
 	}
-	if w.draftsID == "" {
-		return fmt.Errorf("drafts mailbox not found")
-	}
-	if w.identityID == "" {
-		return fmt.Errorf("identity for %s not found", w.creds.Username)
-	}
 
-	full, err := w.fetchEmailForReply(ctx, msg.ID)
+	full, err := w.transport.Fetch(ctx, msg.ID)
 	if err != nil {
 		return err
 	}
-	usage, limited, err := w.enforceDailyMessageLimit(ctx, full)
+	blocked, err := w.senderBlocked(ctx, full)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		w.logf("auto-reply skipped for blocked sender: id=%s from=%q", full.ID, formatFrom(full.From))
+		return w.deleteEmail(ctx, full.ID)
+	}
+	usage, limited, err := w.enforceRateLimit(ctx, full)
 	if err != nil {
 		return err
 	}
 	if limited {
 		return w.deleteEmail(ctx, full.ID)
 	}
-	if err := w.registerCorrespondents(ctx, full, usage); err != nil {
+	profilePending, err := w.registerCorrespondents(ctx, full, usage)
+	if err != nil {
 		return err
 	}
 	body, protectedSubject, attachments, rejectReason, err := w.decryptVerifiedEmail(ctx, full)
@@ -624,85 +369,77 @@ func (w *Watcher) maybeAutoReply(ctx context.Context, msg emailMessage) error {
 	}
 	if rejectReason != "" {
 		w.logf("auto-reply rejected by PGP policy: id=%s reason=%s", msg.ID, rejectReason)
-		if err := w.sendReply(ctx, full, pgpRequiredReply(rejectReason, w.creds.PublicEmail), "", nil, emailFooterStats{RemainingToday: usage.remaining(), DailyMessageLimit: dailyMessageLimit}); err != nil {
+		if err := w.sendReply(ctx, full, pgpRequiredReply(rejectReason, w.creds.PublicEmail), "", nil, usage.footer()); err != nil {
 			return err
 		}
 		return w.deleteEmail(ctx, full.ID)
 	}
-	if err := w.updateCorrespondentProfiles(ctx, full, body); err != nil {
+	if command := memoryCommand(full.Subject); command != "" && len(full.From) > 0 {
+		if err := w.handleMemoryCommand(ctx, full, command, usage.footer()); err != nil {
+			return err
+		}
+		return w.deleteEmail(ctx, full.ID)
+	}
+	if err := w.updateCorrespondentProfiles(ctx, full, body, profilePending); err != nil {
 		return err
 	}
 
-	modelSettings := w.appConfig.OpenAISettingsForSenders(senderEmails(full.From))
-	w.logf("auto-reply calling OpenAI: id=%s model=%s reasoning_effort=%s body_bytes=%d attachments=%d", msg.ID, modelSettings.Model, modelSettings.ReasoningEffort, len(body), len(attachments))
-	reply, err := w.openai.AnswerEmail(ctx, full.Subject, body, attachments, modelSettings)
+	senderContext, err := w.senderContextPrompt(ctx, full)
 	if err != nil {
 		return err
 	}
-	w.logf("auto-reply model response received: id=%s response_bytes=%d total_tokens=%d", msg.ID, len(reply.Text), reply.Usage.TotalTokens)
-
-	if err := w.sendReply(ctx, full, reply.Text, body, attachments, emailFooterStats{
-		TokensUsed:        reply.Usage.TotalTokens,
-		Model:             reply.Model,
-		ToolsUsed:         reply.ToolsUsed,
-		RemainingToday:    usage.remaining(),
-		DailyMessageLimit: dailyMessageLimit,
-	}); err != nil {
+	notes, err := w.notesPrompt(ctx, full)
+	if err != nil {
 		return err
 	}
-	return w.deleteEmail(ctx, full.ID)
-}
-
-func (w *Watcher) fetchEmailForReply(ctx context.Context, id string) (emailMessage, error) {
-	envelope, err := w.client.Call(ctx, []methodCall{
-		{"Email/get", map[string]any{
-			"accountId":           w.accountID,
-			"ids":                 []string{id},
-			"properties":          []string{"id", "blobId", "from", "to", "subject", "sentAt", "receivedAt", "textBody", "htmlBody", "attachments", "bodyValues", "messageId", "references"},
-			"fetchTextBodyValues": true,
-			"fetchHTMLBodyValues": false,
-			"maxBodyValueBytes":   200000,
-		}, "message"},
-	})
+	modelSettings, err := w.modelSettings(ctx, full)
 	if err != nil {
-		return emailMessage{}, err
+		return err
 	}
-
-	for _, response := range envelope.MethodResponses {
-		name, args, err := decodeMethodResponse(response)
-		if err != nil {
-			return emailMessage{}, err
-		}
-		switch name {
-		case "Email/get":
-			var got emailGetResponse
-			if err := json.Unmarshal(args, &got); err != nil {
-				return emailMessage{}, err
-			}
-			if len(got.List) == 0 {
-				return emailMessage{}, fmt.Errorf("message %s not found", id)
-			}
-			msg := got.List[0]
-			if msg.BlobID != "" {
-				raw, err := w.client.Download(ctx, w.accountID, msg.BlobID, "message.eml", "message/rfc822")
-				if err != nil {
-					return emailMessage{}, err
-				}
-				msg.Raw = raw
-			}
-			return msg, nil
-		case "error":
-			return emailMessage{}, fmt.Errorf("JMAP fetch email error: %s", string(args))
+	budget, err := w.checkBudgets(ctx, full, modelSettings, time.Now())
+	if err != nil {
+		return err
+	}
+	if budget.Exhausted != "" {
+		w.logf("auto-reply budget exhausted: id=%s from=%q budget=%s", msg.ID, formatFrom(full.From), budget.Exhausted)
+		footer := usage.footer()
+		footer.BudgetRemaining = w.budgetRemaining(ctx, full, time.Now())
+		if err := w.sendBudgetReply(ctx, full.From[0], budget.Exhausted, time.Now(), footer); err != nil {
+			return err
 		}
+		return w.deleteEmail(ctx, full.ID)
+	}
+	if budget.Downgraded {
+		w.logf("auto-reply model downgraded by budget: id=%s from_model=%s to_model=%s", msg.ID, modelSettings.Model, budget.Settings.Model)
+		modelSettings = budget.Settings
+	}
+	w.logf("auto-reply calling OpenAI: id=%s model=%s reasoning_effort=%s body_bytes=%d attachments=%d sender_context=%t notes=%t", msg.ID, modelSettings.Model, modelSettings.ReasoningEffort, len(body), len(attachments), senderContext != "", notes != "")
+	started := time.Now()
+	reply, err := w.openai.AnswerEmail(ctx, full.Subject, body, attachments, joinPromptSections(senderContext, notes), modelSettings)
+	if err != nil {
+		return err
 	}
+	w.recordUsage(ctx, full, usageKindReply, modelSettings, reply.Usage, reply.ToolCalls, time.Since(started))
+	w.logf("auto-reply model response received: id=%s response_bytes=%d total_tokens=%d", msg.ID, len(reply.Text), reply.Usage.TotalTokens)
 
-	return emailMessage{}, fmt.Errorf("JMAP fetch email returned no Email/get response")
+	footer := usage.footer()
+	footer.TokensUsed = reply.Usage.TotalTokens
+	footer.Model = reply.Model
+	footer.ToolsUsed = reply.ToolsUsed
+	footer.BudgetRemaining = w.budgetRemaining(ctx, full, time.Now())
+	if err := w.sendReply(ctx, full, reply.Text, body, attachments, footer); err != nil {
+		return err
+	}
+	w.archiveExchange(ctx, full, body, reply)
+	w.updateNotes(ctx, full, body, reply.Text)
+	return w.deleteEmail(ctx, full.ID)
 }
 
-func (w *Watcher) registerCorrespondents(ctx context.Context, msg emailMessage, usage correspondentDailyUsage) error {
+func (w *Watcher) registerCorrespondents(ctx context.Context, msg emailMessage, usage inboundUsage) (map[string]bool, error) {
 	if w.store == nil {
-		return nil
+		return nil, nil
 	}
+	pending := map[string]bool{}
 	timezone := deriveTimezoneFromEmailHeaders(msg.Raw)
 	for _, from := range msg.From {
 		email := strings.ToLower(strings.TrimSpace(from.Email))
@@ -711,74 +448,126 @@ func (w *Watcher) registerCorrespondents(ctx context.Context, msg emailMessage,
 		}
 		registered, err := w.store.Register(ctx, email, strings.TrimSpace(from.Name), timezone)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		pending[email] = registered.ProfilePending
 		w.logf("correspondent registered: email=%s new=%t zip_present=%t timezone_present=%t profile_request_needed=%t", email, registered.New, registered.ZipPresent, registered.TimezonePresent, registered.ProfileRequestNeeded)
 		if registered.ProfileRequestNeeded {
-			if err := w.sendProfileRequest(ctx, from, emailFooterStats{RemainingToday: usage.remaining(), DailyMessageLimit: dailyMessageLimit}); err != nil {
-				return err
+			if err := w.sendProfileRequest(ctx, from, registered, usage.footer()); err != nil {
+				return nil, err
 			}
 			if err := w.store.MarkProfileRequestSent(ctx, email); err != nil {
-				return err
+				return nil, err
 			}
 			w.logf("correspondent profile request sent: email=%s", email)
 		}
 	}
-	return nil
+	return pending, nil
 }
 
-func (w *Watcher) enforceDailyMessageLimit(ctx context.Context, msg emailMessage) (correspondentDailyUsage, bool, error) {
+func (w *Watcher) senderBlocked(ctx context.Context, msg emailMessage) (bool, error) {
 	if w.store == nil {
-		return correspondentDailyUsage{Count: 0, Allowed: true}, false, nil
+		return false, nil
+	}
+	for _, email := range senderEmails(msg.From) {
+		blocked, err := w.store.IsBlocked(ctx, email)
+		if err != nil {
+			return false, err
+		}
+		policy, ok, err := w.senderPolicy(ctx, email)
+		if err != nil {
+			return false, err
+		}
+		if blocked || ok && policy.Blocked {
+			return true, nil
+		}
 	}
+	return false, nil
+}
+
+func (w *Watcher) enforceRateLimit(ctx context.Context, msg emailMessage) (inboundUsage, bool, error) {
 	for _, from := range msg.From {
 		email := strings.ToLower(strings.TrimSpace(from.Email))
 		if email == "" {
 			continue
 		}
-		usage, err := w.store.CountInboundMessage(ctx, email, dailyMessageLimit, time.Now())
+		if w.store == nil {
+			return w.untrackedUsage(email), false, nil
+		}
+		limit, rule, err := w.rateLimitFor(ctx, email)
 		if err != nil {
-			return correspondentDailyUsage{}, false, err
+			return inboundUsage{}, false, err
 		}
-		w.logf("correspondent daily usage counted: email=%s day=%s count=%d limit=%d allowed=%t", email, usage.Day, usage.Count, dailyMessageLimit, usage.Allowed)
-		if !usage.Allowed {
-			if err := w.sendRateLimitReply(ctx, from, usage, emailFooterStats{RemainingToday: usage.remaining(), DailyMessageLimit: dailyMessageLimit}); err != nil {
-				return correspondentDailyUsage{}, false, err
-			}
-			w.logf("correspondent daily limit reply sent: email=%s day=%s count=%d limit=%d", email, usage.Day, usage.Count, dailyMessageLimit)
+		usage, err := w.store.CountInboundMessage(ctx, email, limit, w.appConfig.RateLimits.LocalDay, time.Now())
+		if err != nil {
+			return inboundUsage{}, false, err
+		}
+		w.logf("correspondent rate limit counted: email=%s rule=%s algorithm=%s window=%s count=%d limit=%d unlimited=%t allowed=%t", email, rule, limit.Algorithm, limit.Window, usage.Count, limit.Messages, limit.Unlimited, usage.Allowed)
+		if usage.Allowed {
+			return usage, false, nil
+		}
+		if !usage.NoticeDue {
+			w.logf("correspondent rate limit notice already sent this window: email=%s reset_at=%s", email, usage.ResetAt.Format(time.RFC3339))
 			return usage, true, nil
 		}
-		return usage, false, nil
+		if err := w.sendRateLimitReply(ctx, from, usage, usage.footer()); err != nil {
+			return inboundUsage{}, false, err
+		}
+		if err := w.store.MarkRateLimitNotice(ctx, email, time.Now()); err != nil {
+			return inboundUsage{}, false, err
+		}
+		w.logf("correspondent rate limit reply sent: email=%s day=%s count=%d limit=%d reset_at=%s", email, usage.Day, usage.Count, limit.Messages, usage.ResetAt.Format(time.RFC3339))
+		return usage, true, nil
 	}
-	return correspondentDailyUsage{Count: 0, Allowed: true}, false, nil
+	return w.untrackedUsage(""), false, nil
 }
 
-func (w *Watcher) sendRateLimitReply(ctx context.Context, to emailAddress, usage correspondentDailyUsage, footer emailFooterStats) error {
-	body := fmt.Sprintf(strings.TrimSpace(`Hello,
-
-This address accepts up to %d messages per sender per UTC day.
-
-You have reached that limit for %s. Please try again tomorrow.
+func (w *Watcher) untrackedUsage(email string) inboundUsage {
+	limit, _ := w.appConfig.RateLimitFor(email)
+	return inboundUsage{Email: email, Limit: limit, Remaining: limit.Messages, Allowed: true}
+}
 
-Thanks.`), dailyMessageLimit, usage.Day)
+func (w *Watcher) sendRateLimitReply(ctx context.Context, to emailAddress, usage inboundUsage, footer emailFooterStats) error {
+	body := rateLimitReplyBody(usage)
 	htmlBody, err := formatReplyHTMLBody(body, emailMessage{}, "")
 	if err != nil {
 		return err
 	}
-	return w.sendEmail(ctx, []emailAddress{to}, "Daily message limit reached", body, htmlBody, nil, emailMessage{}, footer)
+	return w.sendEmail(ctx, []emailAddress{to}, rateLimitReplySubject(usage), body, htmlBody, nil, emailMessage{}, footer)
 }
 
-func (w *Watcher) sendProfileRequest(ctx context.Context, to emailAddress, footer emailFooterStats) error {
-	body := strings.TrimSpace(`Hello,
+func rateLimitReplySubject(usage inboundUsage) string {
+	if usage.Limit.CalendarDay() {
+		return "Daily message limit reached"
+	}
+	return "Message limit reached"
+}
 
-I keep a small local profile for people who email this address so replies can handle local context correctly.
+func rateLimitReplyBody(usage inboundUsage) string {
+	if usage.Limit.CalendarDay() {
+		day := "UTC day"
+		if usage.Zone != "UTC" {
+			day = "day in your time zone (" + usage.Zone + ")"
+		}
+		return fmt.Sprintf(strings.TrimSpace(`Hello,
 
-Could you reply with:
+This address accepts up to %d messages per sender per %s.
 
-- Your ZIP code
-- Your time zone, for example America/New_York or UTC-05:00
+You have reached that limit for %s. Please try again tomorrow.
+
+Thanks.`), usage.Limit.Messages, day, usage.Day)
+	}
+	return fmt.Sprintf(strings.TrimSpace(`Hello,
 
-Thanks.`)
+This address accepts up to %d messages per sender per %s.
+
+You have reached that limit. You can write again after %s.
+
+Thanks.`), usage.Limit.Messages, windowText(usage.Limit.WindowDuration()), usage.ResetAt.UTC().Format("2006-01-02 15:04 UTC"))
+}
+
+func (w *Watcher) sendProfileRequest(ctx context.Context, to emailAddress, registered correspondentRegistration, footer emailFooterStats) error {
+	body := profileRequestBody(registered)
 	htmlBody, err := formatReplyHTMLBody(body, emailMessage{}, "")
 	if err != nil {
 		return err
@@ -786,27 +575,82 @@ Thanks.`)
 	return w.sendEmail(ctx, []emailAddress{to}, "A quick setup question", body, htmlBody, nil, emailMessage{}, footer)
 }
 
-func (w *Watcher) updateCorrespondentProfiles(ctx context.Context, msg emailMessage, body string) error {
-	if w.store == nil {
-		return nil
+func profileRequestBody(registered correspondentRegistration) string {
+	intro := "Hello,\n\nI keep a small local profile for people who email this address so replies can handle local context correctly.\n\n"
+	if location, ok := lookupZipCode(registered.ZipCode); ok && registered.TimeZoneSource == "zip_lookup" {
+		return intro + fmt.Sprintf(`From your ZIP code %s I have you in %s, in the %s time zone.
+
+If that is right, there is nothing to do. If not, reply with your correct ZIP code or time zone, for example America/New_York or UTC-05:00.
+
+Thanks.`, location.ZipCode, location, registered.TimeZone)
+	}
+	if registered.TimeZone != "" && registered.TimeZoneSource == "email_header" {
+		return intro + fmt.Sprintf(`Your mail suggests you are at %s, but a UTC offset cannot follow daylight saving time changes.
+
+Could you reply with your ZIP code? I will look up your city and time zone from it and use that instead. If you are outside the US, reply with your postal code and country, or just your city, for example "Toronto, Eastern time".
+
+Thanks.`, registered.TimeZone)
 	}
-	update := extractCorrespondentProfileUpdate(body)
-	if update.ZipCode == "" && update.TimeZone == "" {
+	return intro + `Could you reply with:
+
+- Your ZIP code, or your postal code and country if you are outside the US
+- Your time zone, for example America/New_York or UTC-05:00, or just your city
+
+Thanks.`
+}
+
+func (w *Watcher) updateCorrespondentProfiles(ctx context.Context, msg emailMessage, body string, pending map[string]bool) error {
+	if w.store == nil {
 		return nil
 	}
+	fallback := extractCorrespondentProfileUpdate(body)
+	var extracted correspondentProfileUpdate
+	extractedOnce := false
 	for _, from := range msg.From {
 		email := strings.ToLower(strings.TrimSpace(from.Email))
 		if email == "" {
 			continue
 		}
+		update, source := fallback, "pattern"
+		if pending[email] {
+			if !extractedOnce {
+				extracted, extractedOnce = w.extractProfile(ctx, msg, body), true
+			}
+			if extracted != (correspondentProfileUpdate{}) {
+				update, source = extracted, "model"
+			}
+		}
+		if update == (correspondentProfileUpdate{}) {
+			continue
+		}
 		if err := w.store.UpdateProfile(ctx, email, update); err != nil {
 			return err
 		}
-		w.logf("correspondent profile updated from email body: email=%s zip_present=%t timezone_present=%t", email, update.ZipCode != "", update.TimeZone != "")
+		w.logf("correspondent profile updated from email body: email=%s source=%s country=%s zip_present=%t timezone_present=%t", email, source, update.Country, update.ZipCode != "", update.TimeZone != "")
 	}
 	return nil
 }
 
+func (w *Watcher) extractProfile(ctx context.Context, msg emailMessage, body string) correspondentProfileUpdate {
+	if !w.sideCallWithinBudget(ctx, msg, usageKindProfile) {
+		return correspondentProfileUpdate{}
+	}
+	settings := w.appConfig.OpenAIExtractionSettings()
+	started := time.Now()
+	update, usage, err := w.openai.ExtractProfile(ctx, body, settings)
+	w.recordUsage(ctx, msg, usageKindProfile, settings, usage, 0, time.Since(started))
+	if usage.TotalTokens > 0 {
+		if _, err := w.store.RecordAccountTokenUsage(ctx, usage.TotalTokens); err != nil {
+			w.logf("correspondent profile extraction usage not recorded: id=%s err=%v", msg.ID, err)
+		}
+	}
+	if err != nil {
+		w.logf("correspondent profile extraction failed, using pattern fallback: id=%s err=%v", msg.ID, err)
+		return correspondentProfileUpdate{}
+	}
+	return update
+}
+
 func (w *Watcher) sendReply(ctx context.Context, original emailMessage, body string, originalBody string, attachments []emailAttachment, footer emailFooterStats) error {
 	to := original.From
 	if len(to) == 0 {
@@ -819,14 +663,10 @@ func (w *Watcher) sendReply(ctx context.Context, original emailMessage, body str
 	if err != nil {
 		return err
 	}
-	replyAttachments, err := w.replyAttachments(ctx, attachments)
-	if err != nil {
-		return err
-	}
-	return w.sendEmail(ctx, to, subject, replyBody, replyHTMLBody, replyAttachments, original, footer)
+	return w.sendEmail(ctx, to, subject, replyBody, replyHTMLBody, attachments, original, footer)
 }
 
-func (w *Watcher) sendEmail(ctx context.Context, to []emailAddress, subject string, textBody string, htmlBody string, attachments []map[string]any, original emailMessage, footer emailFooterStats) error {
+func (w *Watcher) sendEmail(ctx context.Context, to []emailAddress, subject string, textBody string, htmlBody string, attachments []emailAttachment, original emailMessage, footer emailFooterStats) error {
 	if w.store != nil {
 		totalTokens, err := w.store.RecordAccountTokenUsage(ctx, footer.TokensUsed)
 		if err != nil {
@@ -839,99 +679,23 @@ func (w *Watcher) sendEmail(ctx context.Context, to []emailAddress, subject stri
 		}
 		footer.TotalEmailsEver = total
 	}
-	if footer.DailyMessageLimit == 0 {
-		footer.DailyMessageLimit = dailyMessageLimit
-	}
-	textBody = appendResponseFooterText(textBody, footer)
-	htmlBody = appendResponseFooterHTML(htmlBody, footer)
-
-	createEmail := map[string]any{
-		"from":     []emailAddress{{Email: w.creds.Username}},
-		"to":       to,
-		"subject":  subject,
-		"textBody": []map[string]any{{"partId": "text", "type": "text/plain"}},
-		"htmlBody": []map[string]any{{"partId": "html", "type": "text/html"}},
-		"bodyValues": map[string]any{
-			"text": map[string]any{"charset": "utf-8", "value": textBody},
-			"html": map[string]any{"charset": "utf-8", "value": htmlBody},
-		},
-		"mailboxIds": map[string]bool{w.draftsID: true},
-		"keywords":   map[string]bool{"$draft": true},
-	}
-	if len(attachments) > 0 {
-		createEmail["attachments"] = attachments
-	}
-	if len(original.MessageID) > 0 {
-		createEmail["header:In-Reply-To:asMessageIds"] = original.MessageID
-	}
-	if references := replyReferences(original.References, original.MessageID); len(references) > 0 {
-		createEmail["header:References:asMessageIds"] = references
-	}
-
-	envelope, err := w.client.Call(ctx, []methodCall{
-		{"Email/set", map[string]any{
-			"accountId": w.accountID,
-			"create":    map[string]any{"reply": createEmail},
-		}, "emailSet"},
-		{"EmailSubmission/set", map[string]any{
-			"accountId":             w.accountID,
-			"onSuccessDestroyEmail": []string{"#submission"},
-			"create": map[string]any{
-				"submission": map[string]any{
-					"emailId":    "#reply",
-					"identityId": w.identityID,
-				},
-			},
-		}, "submissionSet"},
-	})
-	if err != nil {
-		return err
-	}
+	textBody, htmlBody = appendResponseFooter(textBody, htmlBody, footer)
 
-	for _, response := range envelope.MethodResponses {
-		name, args, err := decodeMethodResponse(response)
-		if err != nil {
-			return err
-		}
-		if name == "error" {
-			return fmt.Errorf("JMAP send reply error: %s", string(args))
-		}
-		w.logf("auto-reply JMAP response: method=%s bytes=%d", name, len(args))
+	if err := w.transport.Submit(ctx, outgoingEmail{
+		To:          to,
+		Subject:     subject,
+		TextBody:    textBody,
+		HTMLBody:    htmlBody,
+		Attachments: attachments,
+		InReplyTo:   original.MessageID,
+		References:  replyReferences(original.References, original.MessageID),
+	}); err != nil {
+		return err
 	}
 	w.logf("auto-reply sent: original_id=%s to=%q subject=%q", original.ID, formatFrom(to), subject)
 	return nil
 }
 
-func (w *Watcher) replyAttachments(ctx context.Context, attachments []emailAttachment) ([]map[string]any, error) {
-	if len(attachments) == 0 {
-		return nil, nil
-	}
-
-	parts := make([]map[string]any, 0, len(attachments))
-	for _, attachment := range attachments {
-		name := attachmentName(attachment)
-		contentType := attachmentType(attachment)
-		blobID := strings.TrimSpace(attachment.BlobID)
-		if blobID == "" {
-			uploaded, err := w.client.Upload(ctx, w.accountID, name, contentType, attachment.Data)
-			if err != nil {
-				return nil, err
-			}
-			blobID = uploaded.BlobID
-		}
-		if blobID == "" {
-			return nil, fmt.Errorf("attachment %q has no blobId after upload", name)
-		}
-		parts = append(parts, map[string]any{
-			"blobId":      blobID,
-			"type":        contentType,
-			"name":        name,
-			"disposition": "attachment",
-		})
-	}
-	return parts, nil
-}
-
 func replySubject(subject string) string {
 	subject = strings.TrimSpace(subject)
 	if strings.HasPrefix(strings.ToLower(subject), "re:") {
@@ -958,26 +722,9 @@ func replyReferences(existing []string, messageIDs []string) []string {
 }
 
 func (w *Watcher) deleteEmail(ctx context.Context, id string) error {
-	envelope, err := w.client.Call(ctx, []methodCall{
-		{"Email/set", map[string]any{
-			"accountId": w.accountID,
-			"destroy":   []string{id},
-		}, "deleteOriginal"},
-	})
-	if err != nil {
+	if err := w.transport.Dispose(ctx, id); err != nil {
 		return err
 	}
-
-	for _, response := range envelope.MethodResponses {
-		name, args, err := decodeMethodResponse(response)
-		if err != nil {
-			return err
-		}
-		if name == "error" {
-			return fmt.Errorf("JMAP delete original email error: %s", string(args))
-		}
-		w.logf("original email delete response: method=%s bytes=%d", name, len(args))
-	}
 	w.logf("original email deleted after auto-reply: id=%s", id)
 	return nil
 }
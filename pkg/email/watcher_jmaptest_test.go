@@ -0,0 +1,319 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ai-over-email/pkg/jmaptest"
+)
+
+type fakeJMAPWatcher struct {
+	server      *jmaptest.Server
+	openAICalls atomic.Int32
+	notesCalls  atomic.Int32
+	lastPrompt  atomic.Value
+	cancel      context.CancelFunc
+	done        chan error
+}
+
+func startFakeJMAPWatcher(t *testing.T, sender string) *fakeJMAPWatcher {
+	t.Helper()
+	return startFakeJMAPWatcherWithConfig(t, sender, "")
+}
+
+// configFields are extra top-level config.json fields, such as `"rate_limits": {...}`.
+func startFakeJMAPWatcherWithConfig(t *testing.T, sender string, configFields string) *fakeJMAPWatcher {
+	t.Helper()
+	f, watcher := newFakeJMAPWatcher(t, sender, configFields)
+	watcher.transport.(*jmapTransport).backoff = reconnectBackoff{min: 10 * time.Millisecond, max: 100 * time.Millisecond}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f.cancel = cancel
+	go func() { f.done <- watcher.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-f.done
+	})
+	if err := f.server.WaitForPushClients(1, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// newFakeJMAPWatcher builds a watcher against a jmaptest server and a stub
+// model without starting it.
+func newFakeJMAPWatcher(t *testing.T, sender string, configFields string) (*fakeJMAPWatcher, *Watcher) {
+	t.Helper()
+	clearCredentialEnv(t)
+
+	f := &fakeJMAPWatcher{server: jmaptest.NewServer(), done: make(chan error, 1)}
+	t.Cleanup(f.server.Close)
+	openAI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer openai-test" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var request struct {
+			Input []map[string]any `json:"input"`
+			Text  map[string]any   `json:"text"`
+		}
+		decodeErr := json.NewDecoder(r.Body).Decode(&request)
+		if decodeErr == nil && request.Text != nil {
+			f.notesCalls.Add(1)
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(openAIResponse{
+				ID:     "resp_notes",
+				Output: []openAIOutputItem{{Type: "message", Content: []openAIOutputContent{{Type: "output_text", Text: `{"notes":["Asked what the answer is."]}`}}}},
+				Usage:  openAIUsage{InputTokens: 3, OutputTokens: 1, TotalTokens: 4},
+			})
+			return
+		}
+		f.openAICalls.Add(1)
+		if decodeErr == nil && len(request.Input) > 0 {
+			if prompt, ok := request.Input[0]["content"].(string); ok {
+				f.lastPrompt.Store(prompt)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(openAIResponse{
+			ID:     "resp_test",
+			Output: []openAIOutputItem{{Type: "message", Content: []openAIOutputContent{{Type: "output_text", Text: "The answer is 42."}}}},
+			Usage:  openAIUsage{InputTokens: 5, OutputTokens: 2, TotalTokens: 7},
+		})
+	}))
+	t.Cleanup(openAI.Close)
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.json")
+	config := `{"jmap": {"session_endpoint": "` + f.server.SessionURL() + `"}`
+	if configFields != "" {
+		config += ", " + configFields
+	}
+	if err := os.WriteFile(configPath, []byte(config+"}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	envPath := writeTempFile(t, strings.Join([]string{
+		"AI_OVER_EMAIL_JMAP_TOKEN=" + f.server.Token,
+		"AI_OVER_EMAIL_USERNAME=" + f.server.Username,
+		"AI_OVER_EMAIL_OPENAI_API_KEY=openai-test",
+		"AI_OVER_EMAIL_PLAINTEXT_ALLOWLIST=" + sender,
+	}, "\n"))
+
+	watcher, err := NewWatcher(Config{
+		EnvPath:       envPath,
+		ConfigPath:    configPath,
+		DatabasePath:  filepath.Join(dir, "correspondents.sqlite3"),
+		Output:        io.Discard,
+		LogOutput:     io.Discard,
+		HTTPTransport: f.server.Client().Transport,
+		OpenAIURL:     openAI.URL,
+	})
+	if err != nil {
+		t.Fatalf("NewWatcher: %v", err)
+	}
+	return f, watcher
+}
+
+func (f *fakeJMAPWatcher) waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s; calls=%v", what, f.server.Calls())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatcherRepliesThroughFakeJMAPServer(t *testing.T) {
+	sender := testAddress("sender", "mail.test")
+	f := startFakeJMAPWatcher(t, sender)
+
+	id, err := f.server.Deliver(jmaptest.Message{
+		From:      []jmaptest.Address{{Name: "Sender", Email: sender}},
+		To:        []jmaptest.Address{{Email: f.server.Username}},
+		Subject:   "Question",
+		Text:      "What is the answer?",
+		MessageID: "question@mail.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	submissions, err := f.server.WaitForSubmissions(2, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if submissions[0].Email.Subject != "A quick setup question" {
+		t.Fatalf("first submission = %q, want the profile request", submissions[0].Email.Subject)
+	}
+	reply := submissions[1].Email
+	if reply.Subject != "Re: Question" || len(reply.To) != 1 || reply.To[0].Email != sender {
+		t.Fatalf("reply = %q to %v", reply.Subject, reply.To)
+	}
+	if !strings.Contains(reply.TextBody, "The answer is 42.") || !strings.Contains(reply.HTMLBody, "The answer is 42.") {
+		t.Fatalf("reply body does not contain the model answer:\n%s", reply.TextBody)
+	}
+	if !slices.Equal(reply.InReplyTo, []string{"question@mail.test"}) {
+		t.Fatalf("In-Reply-To = %v", reply.InReplyTo)
+	}
+
+	f.waitFor(t, "original deletion", func() bool {
+		_, ok := f.server.Email(id)
+		return !ok
+	})
+	if drafts := f.server.Emails("drafts"); len(drafts) != 0 {
+		t.Fatalf("submitted drafts were not destroyed: %d left", len(drafts))
+	}
+	if calls := f.openAICalls.Load(); calls != 1 {
+		t.Fatalf("OpenAI calls = %d, want 1", calls)
+	}
+	if calls := f.notesCalls.Load(); calls != 1 {
+		t.Fatalf("notes extraction calls = %d, want 1", calls)
+	}
+	if prompt, _ := f.lastPrompt.Load().(string); !strings.Contains(prompt, "Sender context from the assistant's correspondent records") || !strings.Contains(prompt, "- Name: Sender") {
+		t.Fatalf("system prompt has no sender context:\n%s", prompt)
+	}
+}
+
+func TestWatcherRecoversFromTransientJMAPFailure(t *testing.T) {
+	sender := testAddress("sender", "mail.test")
+	f := startFakeJMAPWatcher(t, sender)
+	f.waitFor(t, "the startup scan and initial sync", func() bool {
+		calls := f.server.Calls()
+		return countCalls(calls, "Email/query") >= 2 && countCalls(calls, "Email/changes") >= 1
+	})
+	before := countCalls(f.server.Calls(), "Email/changes")
+
+	f.server.FailRequests(http.StatusServiceUnavailable, 1)
+	if _, err := f.server.Deliver(jmaptest.Message{
+		From:    []jmaptest.Address{{Email: sender}},
+		To:      []jmaptest.Address{{Email: f.server.Username}},
+		Subject: "Retry me",
+		Text:    "Hello?",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.server.WaitForSubmissions(2, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if changes := countCalls(f.server.Calls(), "Email/changes") - before; changes < 2 {
+		t.Fatalf("Email/changes calls after the failure = %d, want a resync", changes)
+	}
+}
+
+func countCalls(calls []string, method string) int {
+	count := 0
+	for _, call := range calls {
+		if call == method {
+			count++
+		}
+	}
+	return count
+}
+
+func TestWatcherKeepsOriginalWhenSubmissionFails(t *testing.T) {
+	sender := testAddress("sender", "mail.test")
+	f := startFakeJMAPWatcher(t, sender)
+
+	f.server.FailMethod("EmailSubmission/set", "forbiddenFrom")
+	id, err := f.server.Deliver(jmaptest.Message{
+		From:    []jmaptest.Address{{Email: sender}},
+		To:      []jmaptest.Address{{Email: f.server.Username}},
+		Subject: "Will fail",
+		Text:    "Hello?",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.waitFor(t, "the failed submission", func() bool {
+		return slices.Contains(f.server.Calls(), "EmailSubmission/set")
+	})
+	f.cancel()
+	<-f.done
+	f.done <- nil
+
+	if _, ok := f.server.Email(id); !ok {
+		t.Fatal("original was deleted although the reply failed")
+	}
+	if submissions := f.server.Submissions(); len(submissions) != 0 {
+		t.Fatalf("submissions = %d, want none", len(submissions))
+	}
+	if calls := f.openAICalls.Load(); calls != 0 {
+		t.Fatalf("OpenAI calls = %d, want none after the failed profile request", calls)
+	}
+}
+
+func TestPreviewReplyThroughFakeJMAPServerSendsAndCountsNothing(t *testing.T) {
+	sender := testAddress("sender", "mail.test")
+	f, watcher := newFakeJMAPWatcher(t, sender, "")
+	t.Cleanup(func() { watcher.store.Close() })
+	id, err := f.server.Deliver(jmaptest.Message{
+		From:      []jmaptest.Address{{Name: "Sender", Email: sender}},
+		To:        []jmaptest.Address{{Email: f.server.Username}},
+		Subject:   "Question",
+		Text:      "What is the answer?",
+		MessageID: "question@mail.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	counts := func() [4]int64 {
+		var c [4]int64
+		for i, table := range []string{"correspondent_daily_usage", "message_usage"} {
+			if err := watcher.store.db.QueryRowContext(ctx, `SELECT count(*) FROM `+table).Scan(&c[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tokens, sent, err := watcher.store.Totals(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c[2], c[3] = tokens, sent
+		return c
+	}
+	before := counts()
+
+	preview, err := watcher.PreviewReply(ctx, id)
+	if err != nil {
+		t.Fatalf("PreviewReply: %v", err)
+	}
+	if preview.Decision != "answered" || !strings.Contains(preview.TextBody, "The answer is 42.") || preview.Usage.TotalTokens != 7 {
+		t.Fatalf("preview = %+v", preview)
+	}
+	if f.openAICalls.Load() != 1 {
+		t.Fatalf("OpenAI calls = %d, want the one previewed reply", f.openAICalls.Load())
+	}
+
+	if submissions := f.server.Submissions(); len(submissions) != 0 {
+		t.Fatalf("preview submitted %d emails", len(submissions))
+	}
+	if drafts := f.server.Emails("drafts"); len(drafts) != 0 {
+		t.Fatalf("preview created %d drafts", len(drafts))
+	}
+	if inbox := f.server.Emails("inbox"); len(inbox) != 1 || inbox[0].ID != id {
+		t.Fatalf("inbox after preview = %+v, want the original left in place", inbox)
+	}
+	if after := counts(); after != before {
+		t.Fatalf("daily usage, message usage, total tokens, total sent = %v, want unchanged %v", after, before)
+	}
+	for _, call := range f.server.Calls() {
+		if strings.HasSuffix(call, "/set") {
+			t.Fatalf("preview made a JMAP write: %v", f.server.Calls())
+		}
+	}
+}
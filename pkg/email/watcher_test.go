@@ -171,8 +171,8 @@ func TestAppendResponseFooterText(t *testing.T) {
 		TotalEmailsEver:   45,
 		Model:             "gpt-test",
 		ToolsUsed:         []string{"web_search"},
-		RemainingToday:    6,
-		DailyMessageLimit: 10,
+		MessagesRemaining: 6,
+		MessageLimit:      10,
 	})
 
 	for _, want := range []string{
@@ -199,8 +199,8 @@ func TestAppendResponseFooterHTML(t *testing.T) {
 		TotalEmailsEver:   45,
 		Model:             "gpt-test",
 		ToolsUsed:         []string{"web_search"},
-		RemainingToday:    6,
-		DailyMessageLimit: 10,
+		MessagesRemaining: 6,
+		MessageLimit:      10,
 	})
 
 	for _, want := range []string{
@@ -0,0 +1,408 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+
+	wsAcceptGUID      = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsMaxMessageBytes = 16 << 20
+)
+
+var errWebSocketRejected = errors.New("WebSocket upgrade rejected")
+
+type wsConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	mask   bool
+
+	writeMu sync.Mutex
+}
+
+// dialWebSocket opens a client WebSocket. When transport is an
+// *http.Transport, its dialers, TLS settings and proxy are used, so the
+// socket leaves the host the same way the JMAP HTTP calls do.
+func dialWebSocket(ctx context.Context, transport http.RoundTripper, rawURL string, header http.Header, protocol string) (*wsConn, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse WebSocket URL: %w", err)
+	}
+	var secure bool
+	switch target.Scheme {
+	case "wss":
+		secure = true
+	case "ws":
+	default:
+		return nil, fmt.Errorf("unsupported WebSocket URL scheme %q", target.Scheme)
+	}
+	conn, err := dialWebSocketConn(ctx, webSocketTransport(transport), target, secure)
+	if err != nil {
+		return nil, fmt.Errorf("connect WebSocket: %w", err)
+	}
+
+	var nonce [16]byte
+	_, _ = rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        target,
+		Host:       target.Host,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header.Clone(),
+	}
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if protocol != "" {
+		req.Header.Set("Sec-WebSocket-Protocol", protocol)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else {
+		_ = conn.SetDeadline(time.Now().Add(20 * time.Second))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("write WebSocket handshake: %w", err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("read WebSocket handshake: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, fmt.Errorf("%w: %s", errWebSocketRejected, resp.Status)
+	}
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") || resp.Header.Get("Sec-WebSocket-Accept") != webSocketAccept(key) {
+		conn.Close()
+		return nil, fmt.Errorf("%w: invalid upgrade response", errWebSocketRejected)
+	}
+	if protocol != "" && resp.Header.Get("Sec-WebSocket-Protocol") != protocol {
+		conn.Close()
+		return nil, fmt.Errorf("%w: server did not select subprotocol %q", errWebSocketRejected, protocol)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return &wsConn{conn: conn, reader: reader, mask: true}, nil
+}
+
+func webSocketTransport(transport http.RoundTripper) *http.Transport {
+	if t, ok := transport.(*http.Transport); ok {
+		return t
+	}
+	return &http.Transport{}
+}
+
+func dialWebSocketConn(ctx context.Context, t *http.Transport, target *url.URL, secure bool) (net.Conn, error) {
+	host := target.Host
+	httpURL := *target
+	httpURL.Scheme = "http"
+	if secure {
+		httpURL.Scheme = "https"
+	}
+	if target.Port() == "" {
+		port := "80"
+		if secure {
+			port = "443"
+		}
+		host = net.JoinHostPort(target.Hostname(), port)
+	}
+
+	var proxyURL *url.URL
+	if t.Proxy != nil {
+		var err error
+		if proxyURL, err = t.Proxy(&http.Request{Method: http.MethodGet, URL: &httpURL, Header: http.Header{}}); err != nil {
+			return nil, fmt.Errorf("proxy for %s: %w", target.Host, err)
+		}
+	}
+	if proxyURL == nil && secure && t.DialTLSContext != nil {
+		return t.DialTLSContext(ctx, "tcp", host)
+	}
+
+	var conn net.Conn
+	var err error
+	if proxyURL != nil {
+		conn, err = dialWebSocketProxy(ctx, t, proxyURL, host)
+	} else {
+		conn, err = webSocketDial(ctx, t, host)
+	}
+	if err != nil || !secure {
+		return conn, err
+	}
+	tlsConn := tls.Client(conn, webSocketTLSConfig(t, target.Hostname()))
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+func webSocketDial(ctx context.Context, t *http.Transport, address string) (net.Conn, error) {
+	if t.DialContext != nil {
+		return t.DialContext(ctx, "tcp", address)
+	}
+	return (&net.Dialer{Timeout: 20 * time.Second}).DialContext(ctx, "tcp", address)
+}
+
+func webSocketTLSConfig(t *http.Transport, serverName string) *tls.Config {
+	config := &tls.Config{}
+	if t.TLSClientConfig != nil {
+		config = t.TLSClientConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = serverName
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	// The upgrade is an HTTP/1.1 request; never let ALPN pick h2.
+	config.NextProtos = []string{"http/1.1"}
+	return config
+}
+
+// dialWebSocketProxy tunnels to address through an HTTP or HTTPS proxy with
+// CONNECT, the way http.Transport reaches HTTPS origins.
+func dialWebSocketProxy(ctx context.Context, t *http.Transport, proxyURL *url.URL, address string) (net.Conn, error) {
+	proxyHost := proxyURL.Host
+	switch proxyURL.Scheme {
+	case "http":
+		if proxyURL.Port() == "" {
+			proxyHost = net.JoinHostPort(proxyURL.Hostname(), "80")
+		}
+	case "https":
+		if proxyURL.Port() == "" {
+			proxyHost = net.JoinHostPort(proxyURL.Hostname(), "443")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q for WebSocket", proxyURL.Scheme)
+	}
+	conn, err := webSocketDial(ctx, t, proxyHost)
+	if err != nil {
+		return nil, fmt.Errorf("connect proxy %s: %w", proxyURL.Redacted(), err)
+	}
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, webSocketTLSConfig(t, proxyURL.Hostname()))
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("connect proxy %s: %w", proxyURL.Redacted(), err)
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: t.ProxyConnectHeader.Clone(),
+	}
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password)))
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else {
+		_ = conn.SetDeadline(time.Now().Add(20 * time.Second))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT: %w", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT to %s: %s", address, resp.Status)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func webSocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + wsAcceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	var message []byte
+	started := false
+	for {
+		fin, opcode, payload, err := readWebSocketFrame(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			_ = c.writeFrame(wsOpClose, payload)
+			code := 1005
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			return nil, fmt.Errorf("WebSocket closed by server: code=%d: %w", code, io.EOF)
+		case wsOpText, wsOpBinary:
+			if started {
+				return nil, fmt.Errorf("WebSocket protocol error: new message inside fragmented message")
+			}
+			started = true
+			message = append(message[:0], payload...)
+		case wsOpContinuation:
+			if !started {
+				return nil, fmt.Errorf("WebSocket protocol error: unexpected continuation frame")
+			}
+			message = append(message, payload...)
+		default:
+			return nil, fmt.Errorf("WebSocket protocol error: unknown opcode %d", opcode)
+		}
+		if len(message) > wsMaxMessageBytes {
+			return nil, fmt.Errorf("WebSocket message exceeds %d bytes", wsMaxMessageBytes)
+		}
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+func (c *wsConn) WriteMessage(data []byte) error {
+	return c.writeFrame(wsOpText, data)
+}
+
+func (c *wsConn) Ping() error {
+	return c.writeFrame(wsOpPing, nil)
+}
+
+func (c *wsConn) SetReadDeadline(deadline time.Time) error {
+	return c.conn.SetReadDeadline(deadline)
+}
+
+func (c *wsConn) Close() error {
+	_ = c.writeFrame(wsOpClose, []byte{0x03, 0xE8})
+	return c.conn.Close()
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(20 * time.Second))
+	return writeWebSocketFrame(c.conn, opcode, payload, c.mask)
+}
+
+func writeWebSocketFrame(w io.Writer, opcode byte, payload []byte, mask bool) error {
+	header := []byte{0x80 | opcode, 0}
+	var maskBit byte
+	if mask {
+		maskBit = 0x80
+	}
+	switch length := len(payload); {
+	case length < 126:
+		header[1] = maskBit | byte(length)
+	case length <= 0xFFFF:
+		header[1] = maskBit | 126
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header[1] = maskBit | 127
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+	frame := payload
+	if mask {
+		var key [4]byte
+		_, _ = rand.Read(key[:])
+		header = append(header, key[:]...)
+		frame = make([]byte, len(payload))
+		for i, b := range payload {
+			frame[i] = b ^ key[i%4]
+		}
+	}
+	if _, err := w.Write(append(header, frame...)); err != nil {
+		return fmt.Errorf("write WebSocket frame: %w", err)
+	}
+	return nil
+}
+
+func readWebSocketFrame(r *bufio.Reader) (bool, byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := head[0]&0x80 != 0
+	opcode := head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > wsMaxMessageBytes {
+		return false, 0, nil, fmt.Errorf("WebSocket frame exceeds %d bytes", wsMaxMessageBytes)
+	}
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, key[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= key[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
@@ -0,0 +1,112 @@
+package email
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	_ "time/tzdata"
+)
+
+// zipdata/zip3.csv maps USPS three-digit ZIP prefixes (sectional centers) to the
+// center's city, state, coordinates and IANA zone. A prefix only locates a ZIP
+// to within its sectional center, so every lookup is approximate. Prefixes whose
+// area crosses a time zone line, such as 324 around Panama City where Gulf County
+// keeps Eastern time, have no zone, so the correspondent is asked for one rather
+// than given a wrong guess. Five-digit rows override their prefix where a few
+// ZIPs are known to differ, such as Adak and Atka on Aleutian time.
+//
+//go:embed zipdata/zip3.csv
+var zipDataCSV []byte
+
+// zipLocation describes the sectional center a ZIP belongs to; City is often a
+// nearby city rather than the ZIP's own.
+type zipLocation struct {
+	ZipCode   string
+	City      string
+	State     string
+	Latitude  float64
+	Longitude float64
+	TimeZone  string
+}
+
+var zipTable = sync.OnceValues(func() (map[string]zipLocation, error) {
+	return parseZipData(zipDataCSV)
+})
+
+func lookupZipCode(zip string) (zipLocation, bool) {
+	zip = strings.TrimSpace(zip)
+	if len(zip) < 5 || zipCodePattern.FindString(zip) != zip {
+		return zipLocation{}, false
+	}
+	table, err := zipTable()
+	if err != nil {
+		return zipLocation{}, false
+	}
+	location, ok := table[zip[:5]]
+	if !ok {
+		location, ok = table[zip[:3]]
+	}
+	if !ok {
+		return zipLocation{}, false
+	}
+	location.ZipCode = zip[:5]
+	return location, true
+}
+
+func zipTimeZone(zip string) string {
+	location, ok := lookupZipCode(zip)
+	if !ok {
+		return ""
+	}
+	return location.TimeZone
+}
+
+func (l zipLocation) String() string {
+	return fmt.Sprintf("the %s, %s area", l.City, l.State)
+}
+
+func parseZipData(data []byte) (map[string]zipLocation, error) {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("parse ZIP data: %w", err)
+	}
+	table := map[string]zipLocation{}
+	for i, record := range records {
+		if i == 0 {
+			continue
+		}
+		if len(record) != 6 {
+			return nil, fmt.Errorf("parse ZIP data line %d: want 6 fields, got %d", i+1, len(record))
+		}
+		latitude, err := strconv.ParseFloat(record[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse ZIP data line %d: %w", i+1, err)
+		}
+		longitude, err := strconv.ParseFloat(record[4], 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse ZIP data line %d: %w", i+1, err)
+		}
+		location := zipLocation{City: record[1], State: record[2], Latitude: latitude, Longitude: longitude, TimeZone: record[5]}
+		first, last, isRange := strings.Cut(record[0], "-")
+		if !isRange && (len(first) == 3 || len(first) == 5) {
+			table[first] = location
+			continue
+		}
+		start, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("parse ZIP data line %d: %w", i+1, err)
+		}
+		end, err := strconv.Atoi(last)
+		if err != nil || len(first) != 3 || len(last) != 3 || end < start {
+			return nil, fmt.Errorf("parse ZIP data line %d: invalid prefix range %q", i+1, record[0])
+		}
+		for prefix := start; prefix <= end; prefix++ {
+			table[fmt.Sprintf("%03d", prefix)] = location
+		}
+	}
+	return table, nil
+}
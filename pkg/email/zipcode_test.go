@@ -0,0 +1,168 @@
+package email
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLookupZipCode(t *testing.T) {
+	for _, tc := range []struct {
+		zip, city, state, zone string
+	}{
+		{"80202", "Denver", "CO", "America/Denver"},
+		{"10001-1234", "New York", "NY", "America/New_York"},
+		{"32501", "Pensacola", "FL", "America/Chicago"},
+		{"37902", "Knoxville", "TN", "America/New_York"},
+		{"83814", "Coeur d'Alene", "ID", "America/Los_Angeles"},
+		{"85004", "Phoenix", "AZ", "America/Phoenix"},
+		{"96813", "Honolulu", "HI", "Pacific/Honolulu"},
+		{"99501", "Anchorage", "AK", "America/Anchorage"},
+		{"99546", "Adak", "AK", "America/Adak"},
+	} {
+		location, ok := lookupZipCode(tc.zip)
+		if !ok || location.City != tc.city || location.State != tc.state || location.TimeZone != tc.zone || location.ZipCode != tc.zip[:5] {
+			t.Errorf("lookupZipCode(%q) = %+v, %t", tc.zip, location, ok)
+		}
+	}
+	for _, zip := range []string{"32456", "79855", "58854"} {
+		if location, ok := lookupZipCode(zip); !ok || location.TimeZone != "" {
+			t.Errorf("lookupZipCode(%q) = %+v, %t; want a location without a zone for a prefix that crosses a zone line", zip, location, ok)
+		}
+	}
+	for _, zip := range []string{"", "8020", "abcde", "00001", "96201"} {
+		if location, ok := lookupZipCode(zip); ok {
+			t.Errorf("lookupZipCode(%q) = %+v, want no match", zip, location)
+		}
+	}
+}
+
+func TestZipDataZonesLoad(t *testing.T) {
+	table, err := zipTable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(table) < 800 {
+		t.Fatalf("ZIP table has %d prefixes", len(table))
+	}
+	zoneless := 0
+	for prefix, location := range table {
+		if location.TimeZone == "" {
+			zoneless++
+		} else if _, err := time.LoadLocation(location.TimeZone); err != nil {
+			t.Errorf("prefix %s: %v", prefix, err)
+		}
+		if location.City == "" || len(location.State) != 2 || location.Latitude < -90 || location.Latitude > 90 || location.Longitude < -180 || location.Longitude > 180 {
+			t.Errorf("prefix %s: %+v", prefix, location)
+		}
+	}
+	if zoneless == 0 || zoneless > 40 {
+		t.Errorf("%d prefixes without a zone; only prefixes that cross a zone line should have none", zoneless)
+	}
+	if _, err := parseZipData([]byte("zip,city,state,latitude,longitude,time_zone\n802-800,Denver,CO,39.74,-104.99,America/Denver\n")); err == nil {
+		t.Fatal("parseZipData accepted a reversed prefix range")
+	}
+}
+
+func TestCorrespondentStoreFillsTimeZoneFromZipCode(t *testing.T) {
+	ctx := context.Background()
+	store := openTestCorrespondentStore(t)
+	email := testAddress("sender", "mail.test")
+	if _, err := store.Register(ctx, email, "", "UTC-06:00"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.UpdateProfile(ctx, email, extractCorrespondentProfileUpdate("I'm in 80202.")); err != nil {
+		t.Fatal(err)
+	}
+	assertTimeZone := func(wantZone, wantSource string) {
+		t.Helper()
+		detail, err := store.GetCorrespondent(ctx, email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if detail.TimeZone != wantZone || detail.TimeZoneSource != wantSource {
+			t.Fatalf("time zone = %q (%s), want %q (%s)", detail.TimeZone, detail.TimeZoneSource, wantZone, wantSource)
+		}
+	}
+	assertTimeZone("America/Denver", "zip_lookup")
+
+	if err := store.UpdateProfile(ctx, email, correspondentProfileUpdate{TimeZone: "America/Phoenix"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UpdateProfile(ctx, email, correspondentProfileUpdate{ZipCode: "10001"}); err != nil {
+		t.Fatal(err)
+	}
+	assertTimeZone("America/Phoenix", "email_body")
+
+	if err := store.SetProfile(ctx, email, CorrespondentProfileUpdate{ZipCode: "32501"}); err != nil {
+		t.Fatal(err)
+	}
+	assertTimeZone("America/Phoenix", "email_body")
+}
+
+func TestCorrespondentStoreRegisterResolvesStoredZipCode(t *testing.T) {
+	ctx := context.Background()
+	store := openTestCorrespondentStore(t)
+	email := testAddress("sender", "mail.test")
+	if _, err := store.Register(ctx, email, "", "UTC-05:00"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.db.ExecContext(ctx, `UPDATE correspondents SET zip_code = '60601' WHERE email = ?`, email); err != nil {
+		t.Fatal(err)
+	}
+
+	registered, err := store.Register(ctx, email, "", "UTC-05:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registered.TimeZone != "America/Chicago" || registered.TimeZoneSource != "zip_lookup" || !registered.ProfileRequestNeeded {
+		t.Fatalf("registration = %+v", registered)
+	}
+	body := profileRequestBody(registered)
+	if !strings.Contains(body, "From your ZIP code 60601 I have you in the Chicago, IL area, in the America/Chicago time zone.") {
+		t.Fatalf("confirmation request:\n%s", body)
+	}
+
+	if err := store.MarkProfileRequestSent(ctx, email); err != nil {
+		t.Fatal(err)
+	}
+	if registered, err = store.Register(ctx, email, "", ""); err != nil || registered.ProfileRequestNeeded {
+		t.Fatalf("registration after request = %+v, %v", registered, err)
+	}
+}
+
+func TestProfileRequestBody(t *testing.T) {
+	body := profileRequestBody(correspondentRegistration{New: true, TimeZone: "UTC-05:00", TimeZoneSource: "email_header"})
+	if !strings.Contains(body, "Your mail suggests you are at UTC-05:00") || !strings.Contains(body, "reply with your ZIP code") {
+		t.Fatalf("header request:\n%s", body)
+	}
+	body = profileRequestBody(correspondentRegistration{New: true})
+	if !strings.Contains(body, "- Your ZIP code, or your postal code and country") || !strings.Contains(body, "- Your time zone") {
+		t.Fatalf("default request:\n%s", body)
+	}
+}
+
+func TestCorrespondentStoreDropsZoneForZipAcrossZoneLine(t *testing.T) {
+	ctx := context.Background()
+	store := openTestCorrespondentStore(t)
+	email := testAddress("sender", "mail.test")
+	if _, err := store.Register(ctx, email, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.db.ExecContext(ctx, `UPDATE correspondents SET zip_code = '32456', time_zone = 'America/Chicago', time_zone_source = 'zip_lookup' WHERE email = ?`, email); err != nil {
+		t.Fatal(err)
+	}
+
+	registered, err := store.Register(ctx, email, "", "UTC-04:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registered.TimeZone != "UTC-04:00" || registered.TimeZoneSource != "email_header" || !registered.ProfileRequestNeeded {
+		t.Fatalf("registration = %+v, want the guessed zone replaced and the profile requested", registered)
+	}
+	if body := profileRequestBody(registered); strings.Contains(body, "America/Chicago") {
+		t.Fatalf("profile request still offers the guessed zone:\n%s", body)
+	}
+}
@@ -0,0 +1,864 @@
+package jmaptest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type request struct {
+	Using       []string            `json:"using"`
+	MethodCalls [][]json.RawMessage `json:"methodCalls"`
+}
+
+type requestContext struct {
+	responses []response
+	created   map[string]string
+}
+
+type response struct {
+	name string
+	args map[string]any
+	id   string
+}
+
+func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, s.Limits.MaxSizeRequest+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if int64(len(data)) > s.Limits.MaxSizeRequest {
+		writeJSON(w, http.StatusBadRequest, problem("urn:ietf:params:jmap:error:limit", "maxSizeRequest"))
+		return
+	}
+	var req request
+	if err := json.Unmarshal(data, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, problem("urn:ietf:params:jmap:error:notJSON", ""))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := Request{Using: req.Using}
+	for _, raw := range req.MethodCalls {
+		var call Call
+		if len(raw) > 0 && json.Unmarshal(raw[0], &call.Name) == nil {
+			s.calls = append(s.calls, call.Name)
+		}
+		if len(raw) > 1 {
+			_ = json.Unmarshal(raw[1], &call.Args)
+		}
+		record.Calls = append(record.Calls, call)
+	}
+	s.requests = append(s.requests, record)
+	if len(s.failHTTP) > 0 {
+		status := s.failHTTP[0]
+		s.failHTTP = s.failHTTP[1:]
+		http.Error(w, "jmaptest: simulated failure", status)
+		return
+	}
+	for _, capability := range req.Using {
+		if capability != CapabilityCore && !slices.Contains(s.Capabilities, capability) {
+			writeJSON(w, http.StatusBadRequest, problem("urn:ietf:params:jmap:error:unknownCapability", ""))
+			return
+		}
+	}
+	if len(req.MethodCalls) > s.Limits.MaxCallsInRequest {
+		writeJSON(w, http.StatusBadRequest, problem("urn:ietf:params:jmap:error:limit", "maxCallsInRequest"))
+		return
+	}
+
+	rc := &requestContext{created: make(map[string]string)}
+	for _, raw := range req.MethodCalls {
+		var name, id string
+		var args map[string]any
+		if len(raw) != 3 || json.Unmarshal(raw[0], &name) != nil || json.Unmarshal(raw[1], &args) != nil || json.Unmarshal(raw[2], &id) != nil {
+			writeJSON(w, http.StatusBadRequest, problem("urn:ietf:params:jmap:error:notRequest", ""))
+			return
+		}
+		s.dispatch(rc, name, args, id)
+	}
+
+	responses := make([]any, 0, len(rc.responses))
+	for _, resp := range rc.responses {
+		responses = append(responses, []any{resp.name, resp.args, resp.id})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"methodResponses": responses,
+		"createdIds":      rc.created,
+		"sessionState":    "0",
+	})
+}
+
+func (s *Server) dispatch(rc *requestContext, name string, args map[string]any, id string) {
+	if failures := s.failMethods[name]; len(failures) > 0 {
+		s.failMethods[name] = failures[1:]
+		rc.fail(id, failures[0], "simulated by jmaptest")
+		return
+	}
+	if err := rc.resolveReferences(args); err != "" {
+		rc.fail(id, "invalidResultReference", err)
+		return
+	}
+	if name != "Core/echo" && args["accountId"] != s.AccountID {
+		rc.fail(id, "accountNotFound", "")
+		return
+	}
+
+	switch name {
+	case "Core/echo":
+		rc.reply(name, args, id)
+	case "Mailbox/get":
+		s.mailboxGet(rc, args, id)
+	case "Identity/get":
+		s.identityGet(rc, args, id)
+	case "Email/get":
+		s.emailGet(rc, args, id)
+	case "Email/query":
+		s.emailQuery(rc, args, id)
+	case "Email/changes":
+		s.emailChanges(rc, args, id)
+	case "Email/set":
+		s.emailSet(rc, args, id)
+	case "EmailSubmission/set":
+		s.submissionSet(rc, args, id)
+	default:
+		rc.fail(id, "unknownMethod", name)
+	}
+}
+
+func (s *Server) mailboxGet(rc *requestContext, args map[string]any, id string) {
+	var list []any
+	var notFound []string
+	ids, filtered := stringList(args["ids"])
+	for _, mailbox := range s.mailboxes {
+		if !filtered || slices.Contains(ids, mailbox.ID) {
+			list = append(list, map[string]any{"id": mailbox.ID, "name": mailbox.Name, "role": mailbox.Role})
+		}
+	}
+	for _, want := range ids {
+		if s.mailboxIDLocked(want) != want {
+			notFound = append(notFound, want)
+		}
+	}
+	rc.reply("Mailbox/get", map[string]any{"accountId": s.AccountID, "state": "0", "list": emptyIfNil(list), "notFound": emptyIfNilStrings(notFound)}, id)
+}
+
+func (s *Server) identityGet(rc *requestContext, args map[string]any, id string) {
+	var list []any
+	for _, identity := range s.identities {
+		list = append(list, map[string]any{"id": identity.ID, "name": identity.Name, "email": identity.Email})
+	}
+	rc.reply("Identity/get", map[string]any{"accountId": s.AccountID, "state": "0", "list": emptyIfNil(list), "notFound": []string{}}, id)
+}
+
+func (s *Server) emailGet(rc *requestContext, args map[string]any, id string) {
+	ids, ok := stringList(args["ids"])
+	if !ok {
+		for _, email := range s.sortedEmailsLocked(true) {
+			ids = append(ids, email.ID)
+		}
+	}
+	if len(ids) > s.Limits.MaxObjectsInGet {
+		rc.fail(id, "requestTooLarge", "")
+		return
+	}
+	properties, _ := stringList(args["properties"])
+	options := bodyOptions{
+		text:     args["fetchTextBodyValues"] == true || args["fetchAllBodyValues"] == true,
+		html:     args["fetchHTMLBodyValues"] == true || args["fetchAllBodyValues"] == true,
+		maxBytes: intArg(args["maxBodyValueBytes"], 0),
+	}
+	var list []any
+	var notFound []string
+	for _, emailID := range ids {
+		email, ok := s.emails[emailID]
+		if !ok {
+			notFound = append(notFound, emailID)
+			continue
+		}
+		list = append(list, emailProperties(email, properties, options))
+	}
+	rc.reply("Email/get", map[string]any{"accountId": s.AccountID, "state": strconv.Itoa(s.state), "list": emptyIfNil(list), "notFound": emptyIfNilStrings(notFound)}, id)
+}
+
+func (s *Server) emailQuery(rc *requestContext, args map[string]any, id string) {
+	ascending := false
+	if sorts, ok := args["sort"].([]any); ok && len(sorts) > 0 {
+		comparator, _ := sorts[0].(map[string]any)
+		if comparator["property"] != "receivedAt" {
+			rc.fail(id, "unsupportedSort", "")
+			return
+		}
+		ascending = comparator["isAscending"] == true
+	}
+	var ids []string
+	for _, email := range s.sortedEmailsLocked(ascending) {
+		matched, errorType := s.matches(email, args["filter"])
+		if errorType != "" {
+			rc.fail(id, errorType, "")
+			return
+		}
+		if matched {
+			ids = append(ids, email.ID)
+		}
+	}
+
+	total := len(ids)
+	position := min(max(intArg(args["position"], 0), 0), total)
+	limit := intArg(args["limit"], s.Limits.MaxObjectsInGet)
+	result := map[string]any{"accountId": s.AccountID, "queryState": strconv.Itoa(s.state), "canCalculateChanges": false, "position": position}
+	if limit > s.Limits.MaxObjectsInGet {
+		limit = s.Limits.MaxObjectsInGet
+		result["limit"] = limit
+	}
+	ids = ids[position:min(position+max(limit, 0), total)]
+	result["ids"] = emptyIfNilStrings(ids)
+	if args["calculateTotal"] == true {
+		result["total"] = total
+	}
+	rc.reply("Email/query", result, id)
+}
+
+func (s *Server) matches(email *Email, filter any) (bool, string) {
+	condition, ok := filter.(map[string]any)
+	if !ok || len(condition) == 0 {
+		return true, ""
+	}
+	if operator, ok := condition["operator"].(string); ok {
+		conditions, _ := condition["conditions"].([]any)
+		for _, nested := range conditions {
+			matched, errorType := s.matches(email, nested)
+			if errorType != "" {
+				return false, errorType
+			}
+			switch {
+			case operator == "AND" && !matched:
+				return false, ""
+			case operator == "OR" && matched:
+				return true, ""
+			case operator == "NOT" && matched:
+				return false, ""
+			}
+		}
+		return operator != "OR", ""
+	}
+
+	for key, value := range condition {
+		text, _ := value.(string)
+		var matched bool
+		switch key {
+		case "inMailbox":
+			matched = email.MailboxIDs[text]
+		case "inMailboxOtherThan":
+			others, _ := stringList(value)
+			matched = false
+			for mailboxID := range email.MailboxIDs {
+				if !slices.Contains(others, mailboxID) {
+					matched = true
+				}
+			}
+		case "from":
+			matched = addressesContain(email.From, text)
+		case "to":
+			matched = addressesContain(email.To, text)
+		case "subject":
+			matched = containsFold(email.Subject, text)
+		case "body":
+			matched = containsFold(email.TextBody, text) || containsFold(email.HTMLBody, text)
+		case "text":
+			matched = containsFold(email.Subject, text) || containsFold(email.TextBody, text) || containsFold(email.HTMLBody, text) || addressesContain(email.From, text) || addressesContain(email.To, text)
+		case "after":
+			at, err := time.Parse(time.RFC3339, text)
+			matched = err == nil && !email.ReceivedAt.Before(at)
+		case "before":
+			at, err := time.Parse(time.RFC3339, text)
+			matched = err == nil && email.ReceivedAt.Before(at)
+		case "hasKeyword":
+			matched = email.Keywords[text]
+		case "notKeyword":
+			matched = !email.Keywords[text]
+		case "hasAttachment":
+			matched = (len(email.Attachments) > 0) == (value == true)
+		default:
+			return false, "unsupportedFilter"
+		}
+		if !matched {
+			return false, ""
+		}
+	}
+	return true, ""
+}
+
+func (s *Server) emailChanges(rc *requestContext, args map[string]any, id string) {
+	since, err := strconv.Atoi(stringArg(args["sinceState"]))
+	if err != nil || since < 0 || since > s.state {
+		rc.fail(id, "cannotCalculateChanges", "")
+		return
+	}
+	maxChanges := intArg(args["maxChanges"], 0)
+	if _, set := args["maxChanges"]; set && args["maxChanges"] != nil && maxChanges <= 0 {
+		rc.fail(id, "invalidArguments", "maxChanges must be positive")
+		return
+	}
+
+	var pending []change
+	for _, c := range s.changes {
+		if c.State > since {
+			pending = append(pending, c)
+		}
+	}
+	hasMore := false
+	newState := s.state
+	if maxChanges > 0 && len(pending) > maxChanges {
+		pending = pending[:maxChanges]
+		newState = pending[len(pending)-1].State
+		hasMore = true
+	}
+
+	kinds := map[string]string{}
+	var order []string
+	for _, c := range pending {
+		previous, seen := kinds[c.ID]
+		if !seen {
+			order = append(order, c.ID)
+		}
+		switch {
+		case previous == "created" && c.Kind == "destroyed":
+			kinds[c.ID] = "gone"
+		case previous == "created" || previous == "gone":
+		default:
+			kinds[c.ID] = c.Kind
+		}
+	}
+	created, updated, destroyed := []string{}, []string{}, []string{}
+	for _, emailID := range order {
+		switch kinds[emailID] {
+		case "created":
+			created = append(created, emailID)
+		case "updated":
+			updated = append(updated, emailID)
+		case "destroyed":
+			destroyed = append(destroyed, emailID)
+		}
+	}
+	rc.reply("Email/changes", map[string]any{
+		"accountId":      s.AccountID,
+		"oldState":       strconv.Itoa(since),
+		"newState":       strconv.Itoa(newState),
+		"hasMoreChanges": hasMore,
+		"created":        created,
+		"updated":        updated,
+		"destroyed":      destroyed,
+	}, id)
+}
+
+func (s *Server) emailSet(rc *requestContext, args map[string]any, id string) {
+	if ifInState, ok := args["ifInState"].(string); ok && ifInState != strconv.Itoa(s.state) {
+		rc.fail(id, "stateMismatch", "")
+		return
+	}
+	creates, _ := args["create"].(map[string]any)
+	updates, _ := args["update"].(map[string]any)
+	destroys, _ := stringList(args["destroy"])
+	if len(creates)+len(updates)+len(destroys) > s.Limits.MaxObjectsInSet {
+		rc.fail(id, "requestTooLarge", "")
+		return
+	}
+
+	oldState := strconv.Itoa(s.state)
+	result := map[string]any{"accountId": s.AccountID, "oldState": oldState}
+	created, notCreated := map[string]any{}, map[string]any{}
+	for creationID, value := range creates {
+		props, _ := value.(map[string]any)
+		email, setErr := s.createEmailLocked(props)
+		if setErr != nil {
+			notCreated[creationID] = setErr
+			continue
+		}
+		rc.created[creationID] = email.ID
+		created[creationID] = map[string]any{"id": email.ID, "blobId": email.BlobID, "threadId": email.ThreadID, "size": email.Size}
+	}
+	updated, notUpdated := map[string]any{}, map[string]any{}
+	for emailID, value := range updates {
+		patch, _ := value.(map[string]any)
+		if setErr := s.updateEmailLocked(emailID, patch); setErr != nil {
+			notUpdated[emailID] = setErr
+			continue
+		}
+		updated[emailID] = nil
+	}
+	destroyed, notDestroyed := []string{}, map[string]any{}
+	for _, emailID := range destroys {
+		if !s.destroyEmailLocked(emailID) {
+			notDestroyed[emailID] = setError("notFound", "")
+			continue
+		}
+		destroyed = append(destroyed, emailID)
+	}
+
+	result["newState"] = strconv.Itoa(s.state)
+	for key, value := range map[string]map[string]any{"created": created, "notCreated": notCreated, "updated": updated, "notUpdated": notUpdated, "notDestroyed": notDestroyed} {
+		if len(value) > 0 {
+			result[key] = value
+		}
+	}
+	if len(destroyed) > 0 {
+		result["destroyed"] = destroyed
+	}
+	rc.reply("Email/set", result, id)
+}
+
+func (s *Server) createEmailLocked(props map[string]any) (*Email, map[string]any) {
+	mailboxIDs := boolMap(props["mailboxIds"])
+	if len(mailboxIDs) == 0 {
+		return nil, setError("invalidProperties", "mailboxIds is required", "mailboxIds")
+	}
+	for mailboxID := range mailboxIDs {
+		if s.mailboxIDLocked(mailboxID) != mailboxID {
+			return nil, setError("invalidProperties", "unknown mailbox "+mailboxID, "mailboxIds")
+		}
+	}
+	bodyValues, _ := props["bodyValues"].(map[string]any)
+	now := time.Now().UTC().Truncate(time.Second)
+	email := &Email{
+		ID:         s.newID("M"),
+		ThreadID:   s.newID("T"),
+		MailboxIDs: mailboxIDs,
+		Keywords:   boolMap(props["keywords"]),
+		From:       addressList(props["from"]),
+		To:         addressList(props["to"]),
+		Subject:    stringArg(props["subject"]),
+		SentAt:     now,
+		ReceivedAt: now,
+		MessageID:  []string{s.newID("reply") + "@jmap.test"},
+		TextBody:   bodyValue(props["textBody"], bodyValues),
+		HTMLBody:   bodyValue(props["htmlBody"], bodyValues),
+	}
+	for _, key := range []string{"inReplyTo", "header:In-Reply-To:asMessageIds"} {
+		if ids, ok := stringList(props[key]); ok {
+			email.InReplyTo = trimIDs(ids)
+		}
+	}
+	for _, key := range []string{"references", "header:References:asMessageIds"} {
+		if ids, ok := stringList(props[key]); ok {
+			email.References = trimIDs(ids)
+		}
+	}
+	if ids, ok := stringList(props["messageId"]); ok && len(ids) > 0 {
+		email.MessageID = trimIDs(ids)
+	}
+	attachments, _ := props["attachments"].([]any)
+	for _, value := range attachments {
+		part, _ := value.(map[string]any)
+		blobID := stringArg(part["blobId"])
+		b, ok := s.blobs[blobID]
+		if !ok {
+			return nil, setError("blobNotFound", "unknown blob "+blobID, "attachments")
+		}
+		contentType := stringArg(part["type"])
+		if contentType == "" {
+			contentType = b.Type
+		}
+		email.Attachments = append(email.Attachments, Part{BlobID: blobID, Name: stringArg(part["name"]), Type: contentType, Size: len(b.Data)})
+	}
+	raw, err := s.buildRawLocked(email)
+	if err != nil {
+		return nil, setError("invalidEmail", err.Error())
+	}
+	email.BlobID = s.storeBlobLocked("", "message/rfc822", raw).BlobID
+	email.Size = len(raw)
+	s.emails[email.ID] = email
+	s.recordChangeLocked(email.ID, "created")
+	return email, nil
+}
+
+func (s *Server) updateEmailLocked(emailID string, patch map[string]any) map[string]any {
+	email, ok := s.emails[emailID]
+	if !ok {
+		return setError("notFound", "")
+	}
+	for path, value := range patch {
+		property, key, nested := strings.Cut(path, "/")
+		var target map[string]bool
+		switch property {
+		case "keywords":
+			target = email.Keywords
+		case "mailboxIds":
+			target = email.MailboxIDs
+		default:
+			return setError("invalidProperties", "", path)
+		}
+		if !nested {
+			replacement := boolMap(value)
+			if property == "keywords" {
+				email.Keywords = replacement
+			} else {
+				email.MailboxIDs = replacement
+			}
+			continue
+		}
+		if value == true {
+			target[key] = true
+		} else {
+			delete(target, key)
+		}
+	}
+	s.recordChangeLocked(emailID, "updated")
+	return nil
+}
+
+func (s *Server) destroyEmailLocked(emailID string) bool {
+	if _, ok := s.emails[emailID]; !ok {
+		return false
+	}
+	delete(s.emails, emailID)
+	s.recordChangeLocked(emailID, "destroyed")
+	return true
+}
+
+func (s *Server) submissionSet(rc *requestContext, args map[string]any, id string) {
+	creates, _ := args["create"].(map[string]any)
+	created, notCreated := map[string]any{}, map[string]any{}
+	submittedEmails := map[string]string{}
+	for creationID, value := range creates {
+		props, _ := value.(map[string]any)
+		emailID := rc.creationRef(stringArg(props["emailId"]))
+		email, ok := s.emails[emailID]
+		if !ok {
+			notCreated[creationID] = setError("invalidProperties", "unknown email "+emailID, "emailId")
+			continue
+		}
+		identity, ok := s.identityLocked(stringArg(props["identityId"]))
+		if !ok {
+			notCreated[creationID] = setError("invalidProperties", "unknown identity", "identityId")
+			continue
+		}
+		if len(email.From) == 0 || !strings.EqualFold(email.From[0].Email, identity.Email) {
+			notCreated[creationID] = setError("forbiddenFrom", "From does not match the identity")
+			continue
+		}
+		if len(email.To) == 0 {
+			notCreated[creationID] = setError("noRecipients", "")
+			continue
+		}
+		submission := Submission{ID: s.newID("S"), IdentityID: identity.ID, EmailID: email.ID, Email: *email}
+		s.submissions = append(s.submissions, submission)
+		close(s.submitted)
+		s.submitted = make(chan struct{})
+		submittedEmails["#"+creationID] = email.ID
+		submittedEmails[submission.ID] = email.ID
+		created[creationID] = map[string]any{"id": submission.ID, "undoStatus": "final"}
+	}
+	result := map[string]any{"accountId": s.AccountID, "oldState": "0", "newState": "0"}
+	if len(created) > 0 {
+		result["created"] = created
+	}
+	if len(notCreated) > 0 {
+		result["notCreated"] = notCreated
+	}
+	rc.reply("EmailSubmission/set", result, id)
+
+	implicit := map[string]any{}
+	if updates, ok := args["onSuccessUpdateEmail"].(map[string]any); ok {
+		update := map[string]any{}
+		for ref, patch := range updates {
+			if emailID, ok := submittedEmails[ref]; ok {
+				update[emailID] = patch
+			}
+		}
+		if len(update) > 0 {
+			implicit["update"] = update
+		}
+	}
+	if refs, ok := stringList(args["onSuccessDestroyEmail"]); ok {
+		var destroy []any
+		for _, ref := range refs {
+			if emailID, ok := submittedEmails[ref]; ok {
+				destroy = append(destroy, emailID)
+			}
+		}
+		if len(destroy) > 0 {
+			implicit["destroy"] = destroy
+		}
+	}
+	if len(implicit) > 0 {
+		implicit["accountId"] = s.AccountID
+		s.emailSet(rc, implicit, id)
+	}
+}
+
+func (s *Server) identityLocked(id string) (Identity, bool) {
+	for _, identity := range s.identities {
+		if identity.ID == id {
+			return identity, true
+		}
+	}
+	return Identity{}, false
+}
+
+func (rc *requestContext) reply(name string, args map[string]any, id string) {
+	data, _ := json.Marshal(args)
+	var normalized map[string]any
+	_ = json.Unmarshal(data, &normalized)
+	rc.responses = append(rc.responses, response{name: name, args: normalized, id: id})
+}
+
+func (rc *requestContext) fail(id string, errorType string, description string) {
+	args := map[string]any{"type": errorType}
+	if description != "" {
+		args["description"] = description
+	}
+	rc.responses = append(rc.responses, response{name: "error", args: args, id: id})
+}
+
+func (rc *requestContext) creationRef(id string) string {
+	if ref, ok := strings.CutPrefix(id, "#"); ok {
+		return rc.created[ref]
+	}
+	return id
+}
+
+func (rc *requestContext) resolveReferences(args map[string]any) string {
+	for key, value := range args {
+		property, ok := strings.CutPrefix(key, "#")
+		if !ok {
+			continue
+		}
+		ref, _ := value.(map[string]any)
+		resultOf, name, path := stringArg(ref["resultOf"]), stringArg(ref["name"]), stringArg(ref["path"])
+		var found *response
+		for i := range rc.responses {
+			if rc.responses[i].id == resultOf && rc.responses[i].name == name {
+				found = &rc.responses[i]
+			}
+		}
+		if found == nil {
+			return "no " + name + " response for " + resultOf
+		}
+		resolved, ok := evaluatePointer(found.args, strings.Split(strings.TrimPrefix(path, "/"), "/"))
+		if !ok {
+			return "path " + path + " not found"
+		}
+		delete(args, key)
+		args[property] = resolved
+	}
+	return ""
+}
+
+func evaluatePointer(value any, tokens []string) (any, bool) {
+	if len(tokens) == 0 || (len(tokens) == 1 && tokens[0] == "") {
+		return value, true
+	}
+	token := strings.NewReplacer("~1", "/", "~0", "~").Replace(tokens[0])
+	switch current := value.(type) {
+	case map[string]any:
+		next, ok := current[token]
+		if !ok {
+			return nil, false
+		}
+		return evaluatePointer(next, tokens[1:])
+	case []any:
+		if token == "*" {
+			var flattened []any
+			for _, item := range current {
+				result, ok := evaluatePointer(item, tokens[1:])
+				if !ok {
+					return nil, false
+				}
+				if list, ok := result.([]any); ok {
+					flattened = append(flattened, list...)
+				} else {
+					flattened = append(flattened, result)
+				}
+			}
+			return emptyIfNil(flattened), true
+		}
+		index, err := strconv.Atoi(token)
+		if err != nil || index < 0 || index >= len(current) {
+			return nil, false
+		}
+		return evaluatePointer(current[index], tokens[1:])
+	default:
+		return nil, false
+	}
+}
+
+type bodyOptions struct {
+	text     bool
+	html     bool
+	maxBytes int
+}
+
+func emailProperties(email *Email, properties []string, options bodyOptions) map[string]any {
+	textPart := map[string]any{"partId": "text", "type": "text/plain", "size": len(email.TextBody)}
+	htmlParts := []any{textPart}
+	if email.HTMLBody != "" {
+		htmlParts = []any{map[string]any{"partId": "html", "type": "text/html", "size": len(email.HTMLBody)}}
+	}
+	attachments := []any{}
+	for i, part := range email.Attachments {
+		attachments = append(attachments, map[string]any{
+			"partId":      "attachment-" + strconv.Itoa(i+1),
+			"blobId":      part.BlobID,
+			"name":        part.Name,
+			"type":        part.Type,
+			"size":        part.Size,
+			"disposition": "attachment",
+		})
+	}
+	bodyValues := map[string]any{}
+	if options.text {
+		bodyValues["text"] = truncatedValue(email.TextBody, options.maxBytes)
+	}
+	if options.html && email.HTMLBody != "" {
+		bodyValues["html"] = truncatedValue(email.HTMLBody, options.maxBytes)
+	}
+
+	all := map[string]any{
+		"id":            email.ID,
+		"blobId":        email.BlobID,
+		"threadId":      email.ThreadID,
+		"mailboxIds":    email.MailboxIDs,
+		"keywords":      email.Keywords,
+		"size":          email.Size,
+		"receivedAt":    email.ReceivedAt.UTC().Format(time.RFC3339),
+		"sentAt":        email.SentAt.UTC().Format(time.RFC3339),
+		"messageId":     email.MessageID,
+		"inReplyTo":     email.InReplyTo,
+		"references":    email.References,
+		"from":          email.From,
+		"to":            email.To,
+		"subject":       email.Subject,
+		"preview":       truncatedValue(email.TextBody, 256)["value"],
+		"hasAttachment": len(email.Attachments) > 0,
+		"textBody":      []any{textPart},
+		"htmlBody":      htmlParts,
+		"attachments":   attachments,
+		"bodyValues":    bodyValues,
+	}
+	if len(properties) == 0 {
+		return all
+	}
+	selected := map[string]any{"id": email.ID}
+	for _, property := range properties {
+		if value, ok := all[property]; ok {
+			selected[property] = value
+		}
+	}
+	return selected
+}
+
+func truncatedValue(value string, maxBytes int) map[string]any {
+	truncated := false
+	if maxBytes > 0 && len(value) > maxBytes {
+		value = strings.ToValidUTF8(value[:maxBytes], "")
+		truncated = true
+	}
+	return map[string]any{"value": value, "isTruncated": truncated, "isEncodingProblem": false}
+}
+
+func bodyValue(parts any, values map[string]any) string {
+	list, _ := parts.([]any)
+	var body strings.Builder
+	for _, value := range list {
+		part, _ := value.(map[string]any)
+		entry, _ := values[stringArg(part["partId"])].(map[string]any)
+		body.WriteString(stringArg(entry["value"]))
+	}
+	return body.String()
+}
+
+func setError(errorType string, description string, properties ...string) map[string]any {
+	err := map[string]any{"type": errorType}
+	if description != "" {
+		err["description"] = description
+	}
+	if len(properties) > 0 {
+		err["properties"] = properties
+	}
+	return err
+}
+
+func stringArg(value any) string {
+	text, _ := value.(string)
+	return text
+}
+
+func intArg(value any, fallback int) int {
+	if number, ok := value.(float64); ok {
+		return int(number)
+	}
+	return fallback
+}
+
+func stringList(value any) ([]string, bool) {
+	list, ok := value.([]any)
+	if !ok {
+		return nil, false
+	}
+	result := make([]string, 0, len(list))
+	for _, item := range list {
+		if text, ok := item.(string); ok {
+			result = append(result, text)
+		}
+	}
+	return result, true
+}
+
+func boolMap(value any) map[string]bool {
+	result := map[string]bool{}
+	values, _ := value.(map[string]any)
+	for key, enabled := range values {
+		if enabled == true {
+			result[key] = true
+		}
+	}
+	return result
+}
+
+func addressList(value any) []Address {
+	list, _ := value.([]any)
+	var addresses []Address
+	for _, item := range list {
+		entry, _ := item.(map[string]any)
+		addresses = append(addresses, Address{Name: stringArg(entry["name"]), Email: stringArg(entry["email"])})
+	}
+	return addresses
+}
+
+func addressesContain(addresses []Address, text string) bool {
+	for _, address := range addresses {
+		if containsFold(address.Email, text) || containsFold(address.Name, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(value string, text string) bool {
+	return strings.Contains(strings.ToLower(value), strings.ToLower(text))
+}
+
+func emptyIfNil(list []any) []any {
+	if list == nil {
+		return []any{}
+	}
+	return list
+}
+
+func emptyIfNilStrings(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
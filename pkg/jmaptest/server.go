@@ -0,0 +1,712 @@
+package jmaptest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ai-over-email/pkg/mimebuild"
+)
+
+const (
+	CapabilityCore       = "urn:ietf:params:jmap:core"
+	CapabilityMail       = "urn:ietf:params:jmap:mail"
+	CapabilitySubmission = "urn:ietf:params:jmap:submission"
+
+	DefaultAccountID = "account-1"
+	DefaultUsername  = "assistant@jmap.test"
+	DefaultPassword  = "jmaptest-password"
+	DefaultToken     = "jmaptest-token"
+)
+
+type Limits struct {
+	MaxSizeUpload         int64 `json:"maxSizeUpload"`
+	MaxConcurrentUpload   int   `json:"maxConcurrentUpload"`
+	MaxSizeRequest        int64 `json:"maxSizeRequest"`
+	MaxConcurrentRequests int   `json:"maxConcurrentRequests"`
+	MaxCallsInRequest     int   `json:"maxCallsInRequest"`
+	MaxObjectsInGet       int   `json:"maxObjectsInGet"`
+	MaxObjectsInSet       int   `json:"maxObjectsInSet"`
+}
+
+// Request is one API request as received, for tests that check how a client
+// batches calls and which capabilities it uses.
+type Request struct {
+	Using []string
+	Calls []Call
+}
+
+type Call struct {
+	Name string
+	Args map[string]any
+}
+
+type Address struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type Mailbox struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+type Identity struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type Attachment struct {
+	Name string
+	Type string
+	Data []byte
+}
+
+type Message struct {
+	From        []Address
+	To          []Address
+	Subject     string
+	Text        string
+	HTML        string
+	MessageID   string
+	InReplyTo   []string
+	References  []string
+	ReceivedAt  time.Time
+	Mailbox     string
+	Attachments []Attachment
+	Raw         []byte
+}
+
+type Part struct {
+	BlobID string
+	Name   string
+	Type   string
+	Size   int
+}
+
+type Email struct {
+	ID          string
+	BlobID      string
+	ThreadID    string
+	MailboxIDs  map[string]bool
+	Keywords    map[string]bool
+	From        []Address
+	To          []Address
+	Subject     string
+	SentAt      time.Time
+	ReceivedAt  time.Time
+	MessageID   []string
+	InReplyTo   []string
+	References  []string
+	TextBody    string
+	HTMLBody    string
+	Attachments []Part
+	Size        int
+}
+
+type Submission struct {
+	ID         string
+	IdentityID string
+	EmailID    string
+	Email      Email
+}
+
+type Server struct {
+	*httptest.Server
+
+	AccountID string
+	Username  string
+	Password  string
+	Token     string
+	Limits    Limits
+	// Capabilities are advertised in the session and are the only ones
+	// accepted in a request's using list. Core is always advertised.
+	Capabilities []string
+	// OAuthClientID enables the /oauth/token refresh grant for that client.
+	OAuthClientID string
+
+	mu          sync.Mutex
+	mailboxes   []Mailbox
+	identities  []Identity
+	emails      map[string]*Email
+	blobs       map[string]blob
+	changes     []change
+	state       int
+	nextID      int
+	submissions []Submission
+	submitted   chan struct{}
+	calls       []string
+	requests    []Request
+	refreshes   []string
+	failMethods map[string][]string
+	failHTTP    []int
+	streams     map[chan string]struct{}
+}
+
+type blob struct {
+	Type string
+	Data []byte
+}
+
+type change struct {
+	State int
+	ID    string
+	Kind  string
+}
+
+func NewServer() *Server {
+	s := newServer()
+	s.Server = httptest.NewTLSServer(s.handler())
+	return s
+}
+
+func newServer() *Server {
+	return &Server{
+		AccountID: DefaultAccountID,
+		Username:  DefaultUsername,
+		Password:  DefaultPassword,
+		Token:     DefaultToken,
+		Limits: Limits{
+			MaxSizeUpload:         50 << 20,
+			MaxConcurrentUpload:   4,
+			MaxSizeRequest:        10 << 20,
+			MaxConcurrentRequests: 4,
+			MaxCallsInRequest:     16,
+			MaxObjectsInGet:       500,
+			MaxObjectsInSet:       500,
+		},
+		Capabilities: []string{CapabilityCore, CapabilityMail, CapabilitySubmission},
+		mailboxes: []Mailbox{
+			{ID: "mb-inbox", Name: "Inbox", Role: "inbox"},
+			{ID: "mb-drafts", Name: "Drafts", Role: "drafts"},
+			{ID: "mb-sent", Name: "Sent", Role: "sent"},
+			{ID: "mb-trash", Name: "Trash", Role: "trash"},
+		},
+		identities:  []Identity{{ID: "identity-1", Name: "Assistant", Email: DefaultUsername}},
+		emails:      make(map[string]*Email),
+		blobs:       make(map[string]blob),
+		submitted:   make(chan struct{}),
+		failMethods: make(map[string][]string),
+		streams:     make(map[chan string]struct{}),
+	}
+}
+
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/jmap", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, s.SessionURL(), http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/oauth/token", s.serveToken)
+	mux.HandleFunc("/jmap/session", s.authenticated(s.serveSession))
+	mux.HandleFunc("/jmap/api", s.authenticated(s.serveAPI))
+	mux.HandleFunc("/jmap/upload/{accountId}/", s.authenticated(s.serveUpload))
+	mux.HandleFunc("/jmap/download/{accountId}/{blobId}/{name}", s.authenticated(s.serveDownload))
+	mux.HandleFunc("/jmap/eventsource/", s.authenticated(s.serveEventSource))
+	return mux
+}
+
+func (s *Server) SessionURL() string {
+	return s.URL + "/jmap/session"
+}
+
+func (s *Server) AddMailbox(mailbox Mailbox) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mailboxes = append(s.mailboxes, mailbox)
+}
+
+func (s *Server) SetIdentities(identities ...Identity) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.identities = append([]Identity(nil), identities...)
+}
+
+func (s *Server) MailboxID(role string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mailboxIDLocked(role)
+}
+
+func (s *Server) Deliver(msg Message) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	role := msg.Mailbox
+	if role == "" {
+		role = "inbox"
+	}
+	mailboxID := s.mailboxIDLocked(role)
+	if mailboxID == "" {
+		return "", fmt.Errorf("jmaptest: mailbox %q not found", role)
+	}
+	received := msg.ReceivedAt
+	if received.IsZero() {
+		received = time.Now().UTC().Truncate(time.Second)
+	}
+	messageID := strings.Trim(msg.MessageID, "<>")
+	if messageID == "" {
+		messageID = s.newID("msg") + "@jmap.test"
+	}
+
+	email := &Email{
+		ID:         s.newID("M"),
+		ThreadID:   s.newID("T"),
+		MailboxIDs: map[string]bool{mailboxID: true},
+		Keywords:   map[string]bool{},
+		From:       msg.From,
+		To:         msg.To,
+		Subject:    msg.Subject,
+		SentAt:     received,
+		ReceivedAt: received,
+		MessageID:  []string{messageID},
+		InReplyTo:  trimIDs(msg.InReplyTo),
+		References: trimIDs(msg.References),
+		TextBody:   msg.Text,
+		HTMLBody:   msg.HTML,
+	}
+	for _, attachment := range msg.Attachments {
+		email.Attachments = append(email.Attachments, s.storeBlobLocked(attachment.Name, attachment.Type, attachment.Data))
+	}
+	raw := msg.Raw
+	if raw == nil {
+		var err error
+		raw, err = s.buildRawLocked(email)
+		if err != nil {
+			return "", err
+		}
+	}
+	email.BlobID = s.storeBlobLocked("", "message/rfc822", raw).BlobID
+	email.Size = len(raw)
+	s.emails[email.ID] = email
+	s.recordChangeLocked(email.ID, "created")
+	return email.ID, nil
+}
+
+func (s *Server) Email(id string) (Email, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	email, ok := s.emails[id]
+	if !ok {
+		return Email{}, false
+	}
+	return *email, true
+}
+
+func (s *Server) Emails(role string) []Email {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mailboxID := s.mailboxIDLocked(role)
+	var emails []Email
+	for _, email := range s.sortedEmailsLocked(true) {
+		if role == "" || email.MailboxIDs[mailboxID] {
+			emails = append(emails, *email)
+		}
+	}
+	return emails
+}
+
+func (s *Server) Blob(id string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.blobs[id]
+	return b.Data, ok
+}
+
+func (s *Server) State() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return strconv.Itoa(s.state)
+}
+
+func (s *Server) Submissions() []Submission {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Submission(nil), s.submissions...)
+}
+
+func (s *Server) WaitForSubmissions(n int, timeout time.Duration) ([]Submission, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		s.mu.Lock()
+		if len(s.submissions) >= n {
+			submissions := append([]Submission(nil), s.submissions...)
+			s.mu.Unlock()
+			return submissions, nil
+		}
+		wait := s.submitted
+		count := len(s.submissions)
+		s.mu.Unlock()
+		select {
+		case <-wait:
+		case <-deadline.C:
+			return nil, fmt.Errorf("jmaptest: %d of %d submissions after %s", count, n, timeout)
+		}
+	}
+}
+
+func (s *Server) WaitForPushClients(n int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		s.mu.Lock()
+		count := len(s.streams)
+		s.mu.Unlock()
+		if count >= n {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("jmaptest: %d of %d push clients after %s", count, n, timeout)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (s *Server) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.calls...)
+}
+
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Refreshes returns the refresh tokens presented to /oauth/token, in order.
+func (s *Server) Refreshes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.refreshes...)
+}
+
+// RevokeToken invalidates the current bearer token, as an expired OAuth
+// access token would be.
+func (s *Server) RevokeToken() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Token = ""
+}
+
+func (s *Server) FailMethod(method string, errorType string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failMethods[method] = append(s.failMethods[method], errorType)
+}
+
+func (s *Server) FailRequests(status int, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for range count {
+		s.failHTTP = append(s.failHTTP, status)
+	}
+}
+
+func (s *Server) DisconnectPush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for stream := range s.streams {
+		close(stream)
+		delete(s.streams, stream)
+	}
+}
+
+func (s *Server) authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		current := s.Token
+		s.mu.Unlock()
+		auth := r.Header.Get("Authorization")
+		if token, ok := strings.CutPrefix(auth, "Bearer "); ok && token == current && current != "" {
+			next(w, r)
+			return
+		}
+		if username, password, ok := r.BasicAuth(); ok && username == s.Username && password == s.Password && s.Password != "" {
+			next(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="jmaptest"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}
+}
+
+func (s *Server) serveSession(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	state := strconv.Itoa(s.state)
+	s.mu.Unlock()
+	capabilities := map[string]any{CapabilityCore: s.Limits}
+	accountCapabilities := map[string]any{}
+	primaryAccounts := map[string]string{}
+	for _, capability := range s.Capabilities {
+		if capability == CapabilityCore {
+			continue
+		}
+		capabilities[capability] = map[string]any{}
+		accountCapabilities[capability] = map[string]any{}
+		primaryAccounts[capability] = s.AccountID
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"capabilities": capabilities,
+		"accounts": map[string]any{
+			s.AccountID: map[string]any{
+				"name":                s.Username,
+				"isPersonal":          true,
+				"accountCapabilities": accountCapabilities,
+			},
+		},
+		"primaryAccounts": primaryAccounts,
+		"username":        s.Username,
+		"apiUrl":          s.URL + "/jmap/api",
+		"downloadUrl":     s.URL + "/jmap/download/{accountId}/{blobId}/{name}?type={type}",
+		"uploadUrl":       s.URL + "/jmap/upload/{accountId}/",
+		"eventSourceUrl":  s.URL + "/jmap/eventsource/?types={types}&closeafter={closeafter}&ping={ping}",
+		"state":           state,
+	})
+}
+
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.Method != http.MethodPost || r.Form.Get("grant_type") != "refresh_token" ||
+		s.OAuthClientID == "" || r.Form.Get("client_id") != s.OAuthClientID || r.Form.Get("refresh_token") == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshes = append(s.refreshes, r.Form.Get("refresh_token"))
+	n := strconv.Itoa(len(s.refreshes))
+	s.Token = "access-" + n
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token":  s.Token,
+		"token_type":    "Bearer",
+		"expires_in":    3600,
+		"refresh_token": "refresh-" + n,
+	})
+}
+
+func (s *Server) serveUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.PathValue("accountId") != s.AccountID {
+		http.Error(w, "account not found", http.StatusNotFound)
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, s.Limits.MaxSizeUpload+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if int64(len(data)) > s.Limits.MaxSizeUpload {
+		writeJSON(w, http.StatusRequestEntityTooLarge, problem("urn:ietf:params:jmap:error:limit", "maxSizeUpload"))
+		return
+	}
+	s.mu.Lock()
+	part := s.storeBlobLocked("", r.Header.Get("Content-Type"), data)
+	s.mu.Unlock()
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"accountId": s.AccountID,
+		"blobId":    part.BlobID,
+		"type":      part.Type,
+		"size":      part.Size,
+	})
+}
+
+func (s *Server) serveDownload(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	b, ok := s.blobs[r.PathValue("blobId")]
+	s.mu.Unlock()
+	if r.PathValue("accountId") != s.AccountID || !ok {
+		http.NotFound(w, r)
+		return
+	}
+	contentType := r.URL.Query().Get("type")
+	if contentType == "" {
+		contentType = b.Type
+	}
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write(b.Data)
+}
+
+func (s *Server) serveEventSource(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	closeAfterState := r.URL.Query().Get("closeafter") == "state"
+	var ping time.Duration
+	if seconds, err := strconv.Atoi(r.URL.Query().Get("ping")); err == nil && seconds > 0 {
+		ping = time.Duration(seconds) * time.Second
+	}
+
+	stream := make(chan string, 16)
+	s.mu.Lock()
+	s.streams[stream] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		if _, ok := s.streams[stream]; ok {
+			delete(s.streams, stream)
+			close(stream)
+		}
+		s.mu.Unlock()
+	}()
+
+	s.mu.Lock()
+	initial := s.stateEventLocked()
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, initial)
+	flusher.Flush()
+
+	var ticker <-chan time.Time
+	if ping > 0 {
+		t := time.NewTicker(ping)
+		defer t.Stop()
+		ticker = t.C
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker:
+			fmt.Fprintf(w, "event: ping\ndata: {\"interval\":%d}\n\n", int(ping/time.Second))
+			flusher.Flush()
+		case event, ok := <-stream:
+			if !ok {
+				return
+			}
+			fmt.Fprint(w, event)
+			flusher.Flush()
+			if closeAfterState {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) recordChangeLocked(id string, kind string) {
+	s.state++
+	s.changes = append(s.changes, change{State: s.state, ID: id, Kind: kind})
+	event := s.stateEventLocked()
+	for stream := range s.streams {
+		select {
+		case stream <- event:
+		default:
+		}
+	}
+}
+
+func (s *Server) stateEventLocked() string {
+	data, _ := json.Marshal(map[string]any{
+		"@type":   "StateChange",
+		"changed": map[string]map[string]string{s.AccountID: {"Email": strconv.Itoa(s.state)}},
+	})
+	return fmt.Sprintf("event: state\nid: %d\ndata: %s\n\n", s.state, data)
+}
+
+func (s *Server) storeBlobLocked(name string, contentType string, data []byte) Part {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	id := s.newID("B")
+	s.blobs[id] = blob{Type: contentType, Data: append([]byte(nil), data...)}
+	return Part{BlobID: id, Name: name, Type: contentType, Size: len(data)}
+}
+
+func (s *Server) buildRawLocked(email *Email) ([]byte, error) {
+	msg := mimebuild.Message{
+		Subject:    email.Subject,
+		Date:       email.SentAt,
+		MessageID:  firstOrEmpty(email.MessageID),
+		InReplyTo:  email.InReplyTo,
+		References: email.References,
+		Text:       email.TextBody,
+		HTML:       email.HTMLBody,
+	}
+	if len(email.From) > 0 {
+		msg.From = mimebuild.Address{Name: email.From[0].Name, Email: email.From[0].Email}
+	}
+	for _, to := range email.To {
+		msg.To = append(msg.To, mimebuild.Address{Name: to.Name, Email: to.Email})
+	}
+	for _, part := range email.Attachments {
+		msg.Attachments = append(msg.Attachments, mimebuild.Part{Name: part.Name, ContentType: part.Type, Data: s.blobs[part.BlobID].Data})
+	}
+	raw, err := mimebuild.Build(msg)
+	if err != nil {
+		return nil, fmt.Errorf("jmaptest: build raw message: %w", err)
+	}
+	return raw, nil
+}
+
+func (s *Server) mailboxIDLocked(role string) string {
+	for _, mailbox := range s.mailboxes {
+		if strings.EqualFold(mailbox.Role, role) || strings.EqualFold(mailbox.Name, role) || mailbox.ID == role {
+			return mailbox.ID
+		}
+	}
+	return ""
+}
+
+func (s *Server) sortedEmailsLocked(ascending bool) []*Email {
+	emails := make([]*Email, 0, len(s.emails))
+	for _, email := range s.emails {
+		emails = append(emails, email)
+	}
+	sort.Slice(emails, func(i, j int) bool {
+		if !emails[i].ReceivedAt.Equal(emails[j].ReceivedAt) {
+			return emails[i].ReceivedAt.Before(emails[j].ReceivedAt) == ascending
+		}
+		return (emails[i].ID < emails[j].ID) == ascending
+	})
+	return emails
+}
+
+func (s *Server) newID(prefix string) string {
+	s.nextID++
+	return prefix + strconv.Itoa(s.nextID)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func problem(errorType string, limit string) map[string]any {
+	body := map[string]any{"type": errorType, "status": http.StatusBadRequest}
+	if limit != "" {
+		body["limit"] = limit
+	}
+	return body
+}
+
+func trimIDs(ids []string) []string {
+	var trimmed []string
+	for _, id := range ids {
+		if id = strings.Trim(strings.TrimSpace(id), "<>"); id != "" {
+			trimmed = append(trimmed, id)
+		}
+	}
+	return trimmed
+}
+
+func firstOrEmpty(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
@@ -0,0 +1,111 @@
+package jmaptest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func call(t *testing.T, s *Server, calls ...[]any) []any {
+	t.Helper()
+	body, err := json.Marshal(map[string]any{
+		"using":       []string{CapabilityCore, CapabilityMail},
+		"methodCalls": calls,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, s.URL+"/jmap/api", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+s.Token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %s", resp.Status)
+	}
+	var decoded struct {
+		MethodResponses []any `json:"methodResponses"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	return decoded.MethodResponses
+}
+
+func responseArgs(t *testing.T, responses []any, index int, name string) map[string]any {
+	t.Helper()
+	response := responses[index].([]any)
+	if response[0] != name {
+		t.Fatalf("response %d = %v, want %s", index, response, name)
+	}
+	return response[1].(map[string]any)
+}
+
+func TestServerChangesPagesAndBackReferences(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	start := s.State()
+	for _, subject := range []string{"one", "two", "three"} {
+		if _, err := s.Deliver(Message{From: []Address{{Email: "sender@mail.test"}}, To: []Address{{Email: s.Username}}, Subject: subject, Text: subject}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	responses := call(t, s, []any{"Email/changes", map[string]any{"accountId": s.AccountID, "sinceState": start, "maxChanges": 2}, "c"})
+	changes := responseArgs(t, responses, 0, "Email/changes")
+	if changes["hasMoreChanges"] != true || len(changes["created"].([]any)) != 2 {
+		t.Fatalf("first page = %v", changes)
+	}
+	responses = call(t, s, []any{"Email/changes", map[string]any{"accountId": s.AccountID, "sinceState": changes["newState"]}, "c"})
+	changes = responseArgs(t, responses, 0, "Email/changes")
+	if changes["hasMoreChanges"] != false || len(changes["created"].([]any)) != 1 || changes["newState"] != s.State() {
+		t.Fatalf("second page = %v", changes)
+	}
+
+	responses = call(t, s,
+		[]any{"Email/query", map[string]any{"accountId": s.AccountID, "filter": map[string]any{"subject": "two"}}, "q"},
+		[]any{"Email/get", map[string]any{
+			"accountId":  s.AccountID,
+			"#ids":       map[string]any{"resultOf": "q", "name": "Email/query", "path": "/ids"},
+			"properties": []string{"subject"},
+		}, "g"},
+	)
+	list := responseArgs(t, responses, 1, "Email/get")["list"].([]any)
+	if len(list) != 1 || list[0].(map[string]any)["subject"] != "two" {
+		t.Fatalf("Email/get via back-reference = %v", list)
+	}
+}
+
+func TestServerFailures(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.FailMethod("Mailbox/get", "serverFail")
+	responses := call(t, s, []any{"Mailbox/get", map[string]any{"accountId": s.AccountID}, "m"})
+	if args := responseArgs(t, responses, 0, "error"); args["type"] != "serverFail" {
+		t.Fatalf("error = %v", args)
+	}
+	responses = call(t, s, []any{"Mailbox/get", map[string]any{"accountId": s.AccountID}, "m"})
+	if list := responseArgs(t, responses, 0, "Mailbox/get")["list"].([]any); len(list) != 4 {
+		t.Fatalf("mailboxes = %v", list)
+	}
+
+	s.FailRequests(http.StatusServiceUnavailable, 1)
+	req, _ := http.NewRequest(http.MethodPost, s.URL+"/jmap/api", bytes.NewReader([]byte(`{"using":[],"methodCalls":[]}`)))
+	req.Header.Set("Authorization", "Bearer "+s.Token)
+	resp, err := s.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("status = %s, want the scripted failure", resp.Status)
+	}
+}
@@ -0,0 +1,82 @@
+package mcpserver
+
+import (
+	"context"
+
+	"ai-over-email/pkg/email"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func addArchiveTools(s *server.MCPServer, store *email.CorrespondentStore) {
+	s.AddTool(
+		mcp.NewTool("search_archive",
+			mcp.WithDescription("Full-text search over archived exchanges: the inbound message, the reply sent, and the subject. Without a query, list the most recent exchanges. Requires archive.enabled in the watcher config."),
+			mcp.WithReadOnlyHintAnnotation(true),
+			mcp.WithString("query", mcp.Description("Words to search for, for example `boiling point`. Each word is matched literally, punctuation included, unless raw is set.")),
+			mcp.WithBoolean("raw", mcp.Description("Treat query as SQLite FTS5 syntax, for example `\"exact phrase\"`, `refinanc*` or `reply_body:mortgage`.")),
+			mcp.WithString("email", mcp.Description("Only search exchanges with this correspondent.")),
+			mcp.WithString("since", mcp.Description("Only search exchanges archived on or after this UTC day, as YYYY-MM-DD.")),
+			mcp.WithNumber("limit", mcp.Description("Maximum number of results. Defaults to 20.")),
+		),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			results, err := store.SearchArchive(ctx, email.ArchiveSearchOptions{
+				Query: req.GetString("query", ""),
+				Raw:   req.GetBool("raw", false),
+				Email: req.GetString("email", ""),
+				Since: req.GetString("since", ""),
+				Limit: req.GetInt("limit", 20),
+			})
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			return jsonResult(results)
+		},
+	)
+
+	s.AddTool(
+		mcp.NewTool("get_archived_message",
+			mcp.WithDescription("Fetch one archived exchange with the full inbound body, reply, model, tools used and token usage."),
+			mcp.WithReadOnlyHintAnnotation(true),
+			mcp.WithNumber("id", mcp.Required(), mcp.Description("Archive id from search_archive.")),
+		),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			id, err := req.RequireInt("id")
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			message, err := store.GetArchivedMessage(ctx, int64(id))
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			return jsonResult(message)
+		},
+	)
+}
+
+func addArchiveAdminTools(s *server.MCPServer, store *email.CorrespondentStore) {
+	s.AddTool(
+		mcp.NewTool("set_archive_policy",
+			mcp.WithDescription("Turn the conversation archive on or off for one correspondent, or give them their own retention. Exchanges of correspondents turned off are deleted at the next prune."),
+			mcp.WithDestructiveHintAnnotation(true),
+			mcp.WithString("email", mcp.Required(), mcp.Description("The correspondent email address.")),
+			mcp.WithBoolean("enabled", mcp.Description("Whether to archive this correspondent's exchanges. Defaults to true.")),
+			mcp.WithNumber("retention_days", mcp.Description("Days to keep this correspondent's exchanges. 0 or unset uses archive.retention_days.")),
+		),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			address, err := req.RequireString("email")
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			if err := store.SetArchivePolicy(ctx, address, req.GetBool("enabled", true), req.GetInt("retention_days", 0)); err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			detail, err := store.GetCorrespondent(ctx, address)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			return jsonResult(detail)
+		},
+	)
+}
@@ -0,0 +1,175 @@
+package mcpserver
+
+import (
+	"context"
+	"time"
+
+	"ai-over-email/pkg/email"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func addCorrespondentTools(s *server.MCPServer, store *email.CorrespondentStore) {
+	s.AddTool(
+		mcp.NewTool("list_correspondents",
+			mcp.WithDescription("List correspondents from the local SQLite profile database, most recently seen first."),
+			mcp.WithReadOnlyHintAnnotation(true),
+			mcp.WithString("query", mcp.Description("Filter by email address or display name substring.")),
+			mcp.WithBoolean("blocked_only", mcp.Description("When true, only return blocked senders.")),
+			mcp.WithNumber("limit", mcp.Description("Maximum number of correspondents to return. Defaults to 100.")),
+		),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			correspondents, err := store.ListCorrespondents(ctx, email.CorrespondentListOptions{
+				Query:       req.GetString("query", ""),
+				BlockedOnly: req.GetBool("blocked_only", false),
+				Limit:       req.GetInt("limit", 100),
+			})
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			if correspondents == nil {
+				correspondents = []email.Correspondent{}
+			}
+			return jsonResult(correspondents)
+		},
+	)
+
+	s.AddTool(
+		mcp.NewTool("get_correspondent",
+			mcp.WithDescription("Fetch one correspondent profile with the last 30 days of daily message counts and the memory notes kept about them."),
+			mcp.WithReadOnlyHintAnnotation(true),
+			mcp.WithString("email", mcp.Required(), mcp.Description("The correspondent email address.")),
+		),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			address, err := req.RequireString("email")
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			detail, err := store.GetCorrespondent(ctx, address)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			return jsonResult(detail)
+		},
+	)
+
+	s.AddTool(
+		mcp.NewTool("usage_report",
+			mcp.WithDescription("Summarize inbound message counts per UTC day, top senders, and the account token and outbound email totals."),
+			mcp.WithReadOnlyHintAnnotation(true),
+			mcp.WithNumber("days", mcp.Description("Number of UTC days to include, ending today. Defaults to 7.")),
+		),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			report, err := store.UsageReport(ctx, req.GetInt("days", 7), time.Now())
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			return jsonResult(report)
+		},
+	)
+}
+
+func addCorrespondentAdminTools(s *server.MCPServer, store *email.CorrespondentStore) {
+	s.AddTool(
+		mcp.NewTool("set_profile",
+			mcp.WithDescription("Set a correspondent's country, postal code and/or time zone, or turn the sender context in the model prompt on or off. The time zone source is recorded as admin."),
+			mcp.WithDestructiveHintAnnotation(false),
+			mcp.WithString("email", mcp.Required(), mcp.Description("The correspondent email address.")),
+			mcp.WithString("country", mcp.Description("Two-letter country code, for example US, CA or GB.")),
+			mcp.WithString("zip_code", mcp.Description("ZIP or postal code, for example 10001, M5V 2T6 or SW1A 1AA. Validated for the given or stored country, US by default.")),
+			mcp.WithString("time_zone", mcp.Description("IANA time zone such as America/New_York or an offset such as UTC-05:00.")),
+			mcp.WithBoolean("sender_context", mcp.Description("Whether replies may use the sender's name, local time and location. Leave unset to keep the current setting.")),
+		),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			address, err := req.RequireString("email")
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			update := email.CorrespondentProfileUpdate{
+				Country:  req.GetString("country", ""),
+				ZipCode:  req.GetString("zip_code", ""),
+				TimeZone: req.GetString("time_zone", ""),
+			}
+			enabled, setContext := req.GetArguments()["sender_context"].(bool)
+			if update != (email.CorrespondentProfileUpdate{}) || !setContext {
+				if err := store.SetProfile(ctx, address, update); err != nil {
+					return mcp.NewToolResultError(err.Error()), nil
+				}
+			}
+			if setContext {
+				if err := store.SetSenderContext(ctx, address, enabled); err != nil {
+					return mcp.NewToolResultError(err.Error()), nil
+				}
+			}
+			detail, err := store.GetCorrespondent(ctx, address)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			return jsonResult(detail)
+		},
+	)
+
+	s.AddTool(
+		mcp.NewTool("reset_daily_usage",
+			mcp.WithDescription("Delete a correspondent's inbound message count for one UTC day so the daily limit starts over."),
+			mcp.WithDestructiveHintAnnotation(true),
+			mcp.WithString("email", mcp.Required(), mcp.Description("The correspondent email address.")),
+			mcp.WithString("day", mcp.Description("UTC day as YYYY-MM-DD. Defaults to today.")),
+		),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			address, err := req.RequireString("email")
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			removed, err := store.ResetDailyUsage(ctx, address, req.GetString("day", ""))
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			return jsonResult(map[string]any{"email": address, "rowsRemoved": removed})
+		},
+	)
+
+	s.AddTool(
+		mcp.NewTool("clear_notes",
+			mcp.WithDescription("Delete the memory notes kept about a correspondent. New notes are only extracted from later exchanges."),
+			mcp.WithDestructiveHintAnnotation(true),
+			mcp.WithString("email", mcp.Required(), mcp.Description("The correspondent email address.")),
+		),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			address, err := req.RequireString("email")
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			removed, err := store.ClearNotes(ctx, address)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			return jsonResult(map[string]any{"email": address, "notesRemoved": removed})
+		},
+	)
+
+	s.AddTool(
+		mcp.NewTool("block_sender",
+			mcp.WithDescription("Block or unblock a sender. Mail from blocked senders is deleted without a reply."),
+			mcp.WithDestructiveHintAnnotation(true),
+			mcp.WithString("email", mcp.Required(), mcp.Description("The sender email address.")),
+			mcp.WithBoolean("blocked", mcp.Description("Set to false to unblock. Defaults to true.")),
+			mcp.WithString("reason", mcp.Description("Operator note stored with the block.")),
+		),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			address, err := req.RequireString("email")
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			if err := store.BlockSender(ctx, address, req.GetBool("blocked", true), req.GetString("reason", "")); err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			detail, err := store.GetCorrespondent(ctx, address)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			return jsonResult(detail)
+		},
+	)
+}
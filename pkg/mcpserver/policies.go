@@ -0,0 +1,88 @@
+package mcpserver
+
+import (
+	"context"
+
+	"ai-over-email/pkg/email"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func addSenderPolicyTools(s *server.MCPServer, store *email.CorrespondentStore) {
+	s.AddTool(
+		mcp.NewTool("list_sender_policies",
+			mcp.WithDescription("List sender policies: the PGP requirement, model tier, daily message limit, block flag and notes kept per address or domain pattern."),
+			mcp.WithReadOnlyHintAnnotation(true),
+		),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			policies, err := store.ListSenderPolicies(ctx)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			return jsonResult(policies)
+		},
+	)
+}
+
+func addSenderPolicyAdminTools(s *server.MCPServer, store *email.CorrespondentStore) {
+	s.AddTool(
+		mcp.NewTool("set_sender_policy",
+			mcp.WithDescription("Create or update the sender policy for an address or domain pattern. Only the fields given change. The watcher applies the change to the next message without a restart."),
+			mcp.WithDestructiveHintAnnotation(false),
+			mcp.WithString("pattern", mcp.Required(), mcp.Description("An address, *@domain for one domain, or *@*.domain for its subdomains. The most specific matching pattern applies.")),
+			mcp.WithString("pgp", mcp.Description("required, optional, or empty to follow the credentials allowlist.")),
+			mcp.WithString("model_tier", mcp.Description("default, powerful, or empty to follow openai.powerful_senders.")),
+			mcp.WithNumber("daily_limit", mcp.Description("Inbound messages per day. 0 follows rate_limits in config.json and -1 removes the limit.")),
+			mcp.WithBoolean("blocked", mcp.Description("Whether mail from matching senders is deleted without a reply.")),
+			mcp.WithString("notes", mcp.Description("Operator note stored with the policy.")),
+		),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			pattern, err := req.RequireString("pattern")
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			args := req.GetArguments()
+			var update email.SenderPolicyUpdate
+			if value, ok := args["pgp"].(string); ok {
+				update.PGP = &value
+			}
+			if value, ok := args["model_tier"].(string); ok {
+				update.ModelTier = &value
+			}
+			if _, ok := args["daily_limit"]; ok {
+				limit := req.GetInt("daily_limit", 0)
+				update.DailyLimit = &limit
+			}
+			if value, ok := args["blocked"].(bool); ok {
+				update.Blocked = &value
+			}
+			if value, ok := args["notes"].(string); ok {
+				update.Notes = &value
+			}
+			policy, err := store.SetSenderPolicy(ctx, pattern, update)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			return jsonResult(policy)
+		},
+	)
+
+	s.AddTool(
+		mcp.NewTool("delete_sender_policy",
+			mcp.WithDescription("Delete the sender policy for an address or domain pattern, so matching senders fall back to broader policies and config.json."),
+			mcp.WithDestructiveHintAnnotation(true),
+			mcp.WithString("pattern", mcp.Required(), mcp.Description("The exact pattern from list_sender_policies.")),
+		),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			pattern, err := req.RequireString("pattern")
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			if err := store.DeleteSenderPolicy(ctx, pattern); err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			return jsonResult(map[string]any{"pattern": pattern, "deleted": true})
+		},
+	)
+}
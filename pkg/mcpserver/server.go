@@ -3,10 +3,14 @@ package mcpserver
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"os"
+	"strconv"
 	"strings"
 
 	"ai-over-email/pkg/email"
+	"ai-over-email/pkg/usenet"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
@@ -17,7 +21,22 @@ const (
 	serverVersion = "0.1.0"
 )
 
-func New(inspector *email.Inspector) *server.MCPServer {
+const (
+	allowWritesEnv     = "AI_OVER_EMAIL_MCP_ALLOW_WRITES"
+	allowUsenetPostEnv = "AI_OVER_EMAIL_MCP_ALLOW_USENET_POST"
+)
+
+type Options struct {
+	Inspector       *email.Inspector
+	Previewer       *email.Watcher
+	Correspondents  *email.CorrespondentStore
+	AllowWrites     bool
+	Usenet          *usenet.Reader
+	AllowUsenetPost bool
+}
+
+func New(opts Options) *server.MCPServer {
+	inspector := opts.Inspector
 	s := server.NewMCPServer(
 		serverName,
 		serverVersion,
@@ -90,27 +109,140 @@ func New(inspector *email.Inspector) *server.MCPServer {
 		},
 	)
 
+	if opts.Previewer != nil {
+		addPreviewTools(s, opts.Previewer)
+	}
+	if opts.Correspondents != nil {
+		addCorrespondentTools(s, opts.Correspondents)
+		addArchiveTools(s, opts.Correspondents)
+		addSenderPolicyTools(s, opts.Correspondents)
+		if opts.AllowWrites {
+			addCorrespondentAdminTools(s, opts.Correspondents)
+			addArchiveAdminTools(s, opts.Correspondents)
+			addSenderPolicyAdminTools(s, opts.Correspondents)
+		}
+	}
+	if opts.Usenet != nil {
+		addUsenetTools(s, opts.Usenet)
+		if opts.AllowUsenetPost {
+			addUsenetPostTools(s, opts.Usenet)
+		}
+	}
+
 	return s
 }
 
-func RunStdio(ctx context.Context) error {
-	inspector, err := email.NewInspector(email.Config{
-		EnvPath:      ".env",
-		ConfigPath:   "config.json",
-		DatabasePath: ".tmp/correspondents.sqlite3",
-		Output:       os.Stdout,
-		LogOutput:    os.Stderr,
+func addPreviewTools(s *server.MCPServer, previewer *email.Watcher) {
+	s.AddTool(
+		mcp.NewTool("preview_reply",
+			mcp.WithDescription("Run the full auto-reply pipeline for one message without sending, deleting, or counting it. Returns the reply text, HTML, decision, and token usage."),
+			mcp.WithReadOnlyHintAnnotation(true),
+			mcp.WithString("id", mcp.Required(), mcp.Description("The JMAP message id to preview a reply for.")),
+		),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			id, err := req.RequireString("id")
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			preview, err := previewer.PreviewReply(ctx, id)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			return jsonResult(preview)
+		},
+	)
+}
+
+type Config struct {
+	EnvPath         string
+	ConfigPath      string
+	DatabasePath    string
+	LogOutput       io.Writer
+	AllowWrites     bool
+	AllowUsenetPost bool
+}
+
+type Server struct {
+	mcp            *server.MCPServer
+	correspondents *email.CorrespondentStore
+}
+
+func DefaultConfig() Config {
+	allowWrites, _ := strconv.ParseBool(strings.TrimSpace(os.Getenv(allowWritesEnv)))
+	allowUsenetPost, _ := strconv.ParseBool(strings.TrimSpace(os.Getenv(allowUsenetPostEnv)))
+	return Config{
+		EnvPath:         ".env",
+		ConfigPath:      "config.json",
+		DatabasePath:    ".tmp/correspondents.sqlite3",
+		LogOutput:       os.Stderr,
+		AllowWrites:     allowWrites,
+		AllowUsenetPost: allowUsenetPost,
+	}
+}
+
+func Open(cfg Config) (*Server, error) {
+	if cfg.LogOutput == nil {
+		cfg.LogOutput = os.Stderr
+	}
+	config := email.Config{
+		EnvPath:      cfg.EnvPath,
+		ConfigPath:   cfg.ConfigPath,
+		DatabasePath: cfg.DatabasePath,
+		Output:       cfg.LogOutput,
+		LogOutput:    cfg.LogOutput,
+	}
+	inspector, err := email.NewInspector(config)
+	if err != nil {
+		return nil, err
+	}
+	previewer, err := email.NewWatcher(config)
+	if err != nil {
+		return nil, err
+	}
+	correspondents, err := email.OpenCorrespondentStore(config.DatabasePath)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := usenet.NewReader(usenet.Config{
+		EnvPath:    config.EnvPath,
+		ConfigPath: config.ConfigPath,
+		LogOutput:  cfg.LogOutput,
 	})
 	if err != nil {
-		return err
+		fmt.Fprintf(cfg.LogOutput, "usenet tools disabled: %v\n", err)
+		reader = nil
 	}
 
-	s := New(inspector)
-	return server.ServeStdio(s, server.WithStdioContextFunc(func(context.Context) context.Context {
+	s := New(Options{
+		Inspector:       inspector,
+		Previewer:       previewer,
+		Correspondents:  correspondents,
+		AllowWrites:     cfg.AllowWrites,
+		Usenet:          reader,
+		AllowUsenetPost: cfg.AllowUsenetPost,
+	})
+	return &Server{mcp: s, correspondents: correspondents}, nil
+}
+
+func (s *Server) ServeStdio(ctx context.Context) error {
+	return server.ServeStdio(s.mcp, server.WithStdioContextFunc(func(context.Context) context.Context {
 		return ctx
 	}))
 }
 
+func (s *Server) Close() error {
+	return s.correspondents.Close()
+}
+
+func RunStdio(ctx context.Context, cfg Config) error {
+	s, err := Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return s.ServeStdio(ctx)
+}
+
 func jsonResult(value any) (*mcp.CallToolResult, error) {
 	payload, err := json.MarshalIndent(value, "", "  ")
 	if err != nil {
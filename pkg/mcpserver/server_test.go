@@ -0,0 +1,65 @@
+package mcpserver
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"ai-over-email/pkg/email"
+	"ai-over-email/pkg/usenet"
+
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func toolNames(s *server.MCPServer) []string {
+	var names []string
+	for name := range s.ListTools() {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestNewGatesCorrespondentWriteTools(t *testing.T) {
+	store, err := email.OpenCorrespondentStore(filepath.Join(t.TempDir(), "correspondents.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	readOnly := []string{"list_correspondents", "get_correspondent", "usage_report", "search_archive", "get_archived_message", "list_sender_policies"}
+	writes := []string{"set_profile", "reset_daily_usage", "clear_notes", "block_sender", "set_archive_policy", "set_sender_policy", "delete_sender_policy"}
+
+	names := toolNames(New(Options{Correspondents: store}))
+	for _, name := range readOnly {
+		if !slices.Contains(names, name) {
+			t.Fatalf("tools without writes = %q, missing %s", names, name)
+		}
+	}
+	for _, name := range writes {
+		if slices.Contains(names, name) {
+			t.Fatalf("tools without writes = %q, include %s", names, name)
+		}
+	}
+
+	names = toolNames(New(Options{Correspondents: store, AllowWrites: true}))
+	for _, name := range append(readOnly, writes...) {
+		if !slices.Contains(names, name) {
+			t.Fatalf("tools with writes = %q, missing %s", names, name)
+		}
+	}
+}
+
+func TestNewGatesUsenetPostTool(t *testing.T) {
+	reader := &usenet.Reader{}
+	names := toolNames(New(Options{Usenet: reader}))
+	if !slices.Contains(names, "get_thread") || slices.Contains(names, "post_followup") {
+		t.Fatalf("tools without usenet posting = %q", names)
+	}
+	if names = toolNames(New(Options{Usenet: reader, AllowWrites: true})); slices.Contains(names, "post_followup") {
+		t.Fatalf("AllowWrites registered post_followup: %q", names)
+	}
+	if names = toolNames(New(Options{Usenet: reader, AllowUsenetPost: true})); !slices.Contains(names, "post_followup") {
+		t.Fatalf("tools with usenet posting = %q, missing post_followup", names)
+	}
+}
@@ -0,0 +1,127 @@
+package mcpserver
+
+import (
+	"context"
+	"fmt"
+
+	"ai-over-email/pkg/usenet"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func addUsenetTools(s *server.MCPServer, reader *usenet.Reader) {
+	s.AddTool(
+		mcp.NewTool("list_newsgroups",
+			mcp.WithDescription("List the configured Usenet newsgroups with their current article counts and number ranges."),
+			mcp.WithReadOnlyHintAnnotation(true),
+		),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			groups, err := reader.Groups(ctx)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			return jsonResult(groups)
+		},
+	)
+
+	s.AddTool(
+		mcp.NewTool("get_article",
+			mcp.WithDescription("Fetch one Usenet article from the configured group by article number or Message-ID."),
+			mcp.WithReadOnlyHintAnnotation(true),
+			mcp.WithNumber("number", mcp.Description("Article number in the configured group.")),
+			mcp.WithString("message_id", mcp.Description("Message-ID, with or without angle brackets.")),
+		),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			messageID := req.GetString("message_id", "")
+			number := req.GetInt("number", 0)
+			var (
+				result usenet.Article
+				err    error
+			)
+			switch {
+			case messageID != "":
+				result, err = reader.ArticleByMessageID(ctx, messageID)
+			case number > 0:
+				result, err = reader.ArticleByNumber(ctx, number)
+			default:
+				err = fmt.Errorf("number or message_id is required")
+			}
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			return jsonResult(result)
+		},
+	)
+
+	s.AddTool(
+		mcp.NewTool("get_thread",
+			mcp.WithDescription("Reconstruct the thread around a Usenet article from its References and nearby articles, oldest first. This is the same context the Usenet watcher sends to the model."),
+			mcp.WithReadOnlyHintAnnotation(true),
+			mcp.WithString("message_id", mcp.Required(), mcp.Description("Message-ID of any article in the thread.")),
+		),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			messageID, err := req.RequireString("message_id")
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			thread, err := reader.Thread(ctx, messageID)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			return jsonResult(thread)
+		},
+	)
+
+	s.AddTool(
+		mcp.NewTool("search_articles",
+			mcp.WithDescription("Search recent overview data (subject and author) in the configured group, newest first."),
+			mcp.WithReadOnlyHintAnnotation(true),
+			mcp.WithString("query", mcp.Description("Match against subject or author.")),
+			mcp.WithString("from", mcp.Description("Filter by author text.")),
+			mcp.WithString("subject", mcp.Description("Filter by subject text.")),
+			mcp.WithNumber("scan", mcp.Description("Number of most recent articles to scan. Defaults to 500.")),
+			mcp.WithNumber("limit", mcp.Description("Maximum number of results. Defaults to 25.")),
+		),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			entries, err := reader.Search(ctx, usenet.SearchOptions{
+				Query:   req.GetString("query", ""),
+				From:    req.GetString("from", ""),
+				Subject: req.GetString("subject", ""),
+				Scan:    req.GetInt("scan", 0),
+				Limit:   req.GetInt("limit", 0),
+			})
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			return jsonResult(entries)
+		},
+	)
+}
+
+func addUsenetPostTools(s *server.MCPServer, reader *usenet.Reader) {
+	s.AddTool(
+		mcp.NewTool("post_followup",
+			mcp.WithDescription("Post a follow-up to a Usenet article using the same From, References, and X-AI-Over-Usenet headers as the Usenet watcher. The post is recorded in the watcher state file."),
+			mcp.WithDestructiveHintAnnotation(false),
+			mcp.WithOpenWorldHintAnnotation(true),
+			mcp.WithString("message_id", mcp.Required(), mcp.Description("Message-ID of the article to follow up.")),
+			mcp.WithString("body", mcp.Required(), mcp.Description("Plain-text body of the follow-up.")),
+		),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			messageID, err := req.RequireString("message_id")
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			body, err := req.RequireString("body")
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			followup, err := reader.PostFollowup(ctx, messageID, body)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			return jsonResult(followup)
+		},
+	)
+}
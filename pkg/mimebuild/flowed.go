@@ -0,0 +1,52 @@
+package mimebuild
+
+import "strings"
+
+const flowedLineWidth = 76
+
+func FormatFlowed(text string) string {
+	text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\r", "\n")
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line == "-- " {
+			lines = append(lines, line)
+			continue
+		}
+		depth := 0
+		for depth < len(line) && line[depth] == '>' {
+			depth++
+		}
+		prefix, content := "", line[depth:]
+		if depth > 0 {
+			prefix = strings.Repeat(">", depth) + " "
+			content = strings.TrimPrefix(content, " ")
+		}
+		for _, chunk := range flowedChunks(strings.TrimRight(content, " "), flowedLineWidth-len(prefix)) {
+			if prefix == "" && (strings.HasPrefix(chunk, " ") || strings.HasPrefix(chunk, ">") || strings.HasPrefix(chunk, "From ")) {
+				chunk = " " + chunk
+			}
+			lines = append(lines, prefix+chunk)
+		}
+	}
+	return strings.Join(lines, "\r\n")
+}
+
+func flowedChunks(content string, width int) []string {
+	if width < 20 {
+		width = 20
+	}
+	var chunks []string
+	for len(content) > width {
+		cut := strings.LastIndexByte(content[:width], ' ')
+		if cut <= 0 {
+			next := strings.IndexByte(content[width:], ' ')
+			if next < 0 {
+				break
+			}
+			cut = width + next
+		}
+		chunks = append(chunks, content[:cut+1])
+		content = content[cut+1:]
+	}
+	return append(chunks, content)
+}
@@ -0,0 +1,302 @@
+package mimebuild
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	headerLineLimit = 78
+	base64LineLimit = 76
+)
+
+type Address struct {
+	Name  string
+	Email string
+}
+
+type Header struct {
+	Name  string
+	Value string
+}
+
+type Part struct {
+	Name        string
+	ContentType string
+	ContentID   string
+	Data        []byte
+}
+
+type Message struct {
+	From        Address
+	To          []Address
+	Cc          []Address
+	ReplyTo     []Address
+	Subject     string
+	Date        time.Time
+	MessageID   string
+	InReplyTo   []string
+	References  []string
+	Headers     []Header
+	Text        string
+	HTML        string
+	Flowed      bool
+	Inline      []Part
+	Attachments []Part
+}
+
+type entity struct {
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+func Build(msg Message) ([]byte, error) {
+	if strings.TrimSpace(msg.From.Email) == "" {
+		return nil, fmt.Errorf("message has no From address")
+	}
+	if len(msg.To)+len(msg.Cc) == 0 {
+		return nil, fmt.Errorf("message has no recipients")
+	}
+	if msg.Date.IsZero() {
+		msg.Date = time.Now()
+	}
+	if msg.MessageID == "" {
+		msg.MessageID = NewMessageID(msg.From.Email)
+	}
+	body, err := bodyEntity(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	writeHeader(&out, "From", FormatAddress(msg.From))
+	writeHeader(&out, "To", formatAddressList(msg.To))
+	writeHeader(&out, "Cc", formatAddressList(msg.Cc))
+	writeHeader(&out, "Reply-To", formatAddressList(msg.ReplyTo))
+	writeHeader(&out, "Subject", EncodeHeader(msg.Subject))
+	writeHeader(&out, "Date", msg.Date.Format(time.RFC1123Z))
+	writeHeader(&out, "Message-ID", AngleIDs([]string{msg.MessageID}))
+	writeHeader(&out, "In-Reply-To", AngleIDs(msg.InReplyTo))
+	writeHeader(&out, "References", AngleIDs(msg.References))
+	for _, header := range msg.Headers {
+		writeHeader(&out, header.Name, header.Value)
+	}
+	writeHeader(&out, "MIME-Version", "1.0")
+	writeEntity(&out, body)
+	return out.Bytes(), nil
+}
+
+func BuildBody(msg Message) ([]byte, error) {
+	body, err := bodyEntity(msg)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	writeEntity(&out, body)
+	return out.Bytes(), nil
+}
+
+func bodyEntity(msg Message) (entity, error) {
+	text := msg.Text
+	textType := "text/plain; charset=utf-8"
+	if msg.Flowed {
+		text = FormatFlowed(text)
+		textType += "; format=flowed"
+	}
+	content, err := textEntity(textType, text)
+	if err != nil {
+		return entity{}, err
+	}
+
+	var related []Part
+	if msg.HTML != "" {
+		html, err := textEntity("text/html; charset=utf-8", msg.HTML)
+		if err != nil {
+			return entity{}, err
+		}
+		if len(msg.Inline) > 0 {
+			parts := []entity{html}
+			for _, part := range msg.Inline {
+				if strings.TrimSpace(part.ContentID) == "" {
+					return entity{}, fmt.Errorf("inline part %q has no Content-ID", part.Name)
+				}
+				parts = append(parts, binaryEntity(part, "inline"))
+			}
+			html, err = multipartEntity("related", parts, map[string]string{"type": "text/html"})
+			if err != nil {
+				return entity{}, err
+			}
+		}
+		content, err = multipartEntity("alternative", []entity{content, html}, nil)
+		if err != nil {
+			return entity{}, err
+		}
+	} else {
+		related = msg.Inline
+	}
+
+	if len(msg.Attachments) == 0 && len(related) == 0 {
+		return content, nil
+	}
+	parts := []entity{content}
+	for _, part := range related {
+		parts = append(parts, binaryEntity(part, "inline"))
+	}
+	for _, part := range msg.Attachments {
+		parts = append(parts, binaryEntity(part, "attachment"))
+	}
+	return multipartEntity("mixed", parts, nil)
+}
+
+func textEntity(contentType, text string) (entity, error) {
+	var body bytes.Buffer
+	writer := quotedprintable.NewWriter(&body)
+	if _, err := writer.Write([]byte(text)); err != nil {
+		return entity{}, err
+	}
+	if err := writer.Close(); err != nil {
+		return entity{}, err
+	}
+	return entity{
+		header: textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		},
+		body: body.Bytes(),
+	}, nil
+}
+
+func binaryEntity(part Part, disposition string) entity {
+	name := part.Name
+	if strings.TrimSpace(name) == "" {
+		name = "attachment"
+	}
+	contentType := part.ContentType
+	if strings.TrimSpace(contentType) == "" {
+		contentType = "application/octet-stream"
+	}
+	if mediaType, params, err := mime.ParseMediaType(contentType); err == nil {
+		params["name"] = name
+		contentType = mime.FormatMediaType(mediaType, params)
+	}
+	header := textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Disposition":       {mime.FormatMediaType(disposition, map[string]string{"filename": name})},
+		"Content-Transfer-Encoding": {"base64"},
+	}
+	if part.ContentID != "" {
+		header.Set("Content-ID", AngleIDs([]string{part.ContentID}))
+	}
+
+	var body bytes.Buffer
+	encoded := base64.StdEncoding.EncodeToString(part.Data)
+	for len(encoded) > base64LineLimit {
+		body.WriteString(encoded[:base64LineLimit] + "\r\n")
+		encoded = encoded[base64LineLimit:]
+	}
+	body.WriteString(encoded + "\r\n")
+	return entity{header: header, body: body.Bytes()}
+}
+
+func multipartEntity(subtype string, parts []entity, params map[string]string) (entity, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, part := range parts {
+		partWriter, err := writer.CreatePart(part.header)
+		if err != nil {
+			return entity{}, err
+		}
+		if _, err := partWriter.Write(part.body); err != nil {
+			return entity{}, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return entity{}, err
+	}
+	contentParams := map[string]string{"boundary": writer.Boundary()}
+	for key, value := range params {
+		contentParams[key] = value
+	}
+	return entity{
+		header: textproto.MIMEHeader{"Content-Type": {mime.FormatMediaType("multipart/"+subtype, contentParams)}},
+		body:   body.Bytes(),
+	}, nil
+}
+
+func writeEntity(out *bytes.Buffer, body entity) {
+	keys := make([]string, 0, len(body.header))
+	for key := range body.header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range body.header[key] {
+			writeHeader(out, key, value)
+		}
+	}
+	out.WriteString("\r\n")
+	out.Write(body.body)
+}
+
+func writeHeader(out *bytes.Buffer, name, value string) {
+	if value == "" {
+		return
+	}
+	line := name + ":"
+	for _, word := range strings.Split(value, " ") {
+		if word != "" && len(line)+1+len(word) > headerLineLimit && strings.TrimSpace(line) != name+":" {
+			out.WriteString(line + "\r\n")
+			line = ""
+		}
+		line += " " + word
+	}
+	out.WriteString(line + "\r\n")
+}
+
+func EncodeHeader(value string) string {
+	return mime.QEncoding.Encode("utf-8", strings.Join(strings.Fields(value), " "))
+}
+
+func FormatAddress(address Address) string {
+	return (&mail.Address{Name: address.Name, Address: address.Email}).String()
+}
+
+func formatAddressList(addresses []Address) string {
+	formatted := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		formatted = append(formatted, FormatAddress(address))
+	}
+	return strings.Join(formatted, ", ")
+}
+
+func AngleIDs(ids []string) string {
+	formatted := make([]string, 0, len(ids))
+	for _, id := range ids {
+		id = strings.Trim(strings.TrimSpace(id), "<>")
+		if id != "" {
+			formatted = append(formatted, "<"+id+">")
+		}
+	}
+	return strings.Join(formatted, " ")
+}
+
+func NewMessageID(fromAddress string) string {
+	_, domain, ok := strings.Cut(fromAddress, "@")
+	if !ok || domain == "" {
+		domain = "localhost"
+	}
+	var random [12]byte
+	_, _ = rand.Read(random[:])
+	return fmt.Sprintf("%d.%s@%s", time.Now().UnixNano(), hex.EncodeToString(random[:]), domain)
+}
@@ -0,0 +1,185 @@
+package mimebuild
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testMessage() Message {
+	return Message{
+		From:       Address{Name: "Assistant", Email: "assistant@mail.test"},
+		To:         []Address{{Name: "Zoë Sender", Email: "sender@mail.test"}},
+		Subject:    "Re: Café menu for the long weekend, with a subject that needs folding across lines",
+		Date:       time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC),
+		MessageID:  "reply@mail.test",
+		InReplyTo:  []string{"<question@mail.test>"},
+		References: []string{"earlier@mail.test", "question@mail.test"},
+		Headers:    []Header{{Name: "Auto-Submitted", Value: "auto-replied"}},
+		Text:       "Here is the menu.",
+		HTML:       `<p>Here is the menu.</p><img src="cid:logo@mail.test">`,
+		Inline:     []Part{{Name: "logo.png", ContentType: "image/png", ContentID: "logo@mail.test", Data: []byte("png")}},
+		Attachments: []Part{
+			{Name: "menu.txt", ContentType: "text/plain", Data: []byte("soup\n")},
+		},
+	}
+}
+
+func TestBuildProducesNestedMultipartTree(t *testing.T) {
+	raw, err := Build(testMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(string(raw), "\r\n") {
+		if len(line) > 998 {
+			t.Fatalf("line exceeds RFC 5322 limit: %q", line)
+		}
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != testMessage().Subject {
+		t.Fatalf("Subject = %q", subject)
+	}
+	for header, want := range map[string]string{
+		"Message-ID":     "<reply@mail.test>",
+		"In-Reply-To":    "<question@mail.test>",
+		"References":     "<earlier@mail.test> <question@mail.test>",
+		"Auto-Submitted": "auto-replied",
+		"Date":           "Fri, 02 Jan 2026 15:04:05 +0000",
+	} {
+		if got := msg.Header.Get(header); got != want {
+			t.Fatalf("%s = %q, want %q", header, got, want)
+		}
+	}
+	to, err := msg.Header.AddressList("To")
+	if err != nil || len(to) != 1 || to[0].Name != "Zoë Sender" {
+		t.Fatalf("To = %#v, %v", to, err)
+	}
+
+	mixed := readParts(t, msg.Header.Get("Content-Type"), msg.Body)
+	if len(mixed) != 2 || !strings.HasPrefix(mixed[0].contentType, "multipart/alternative") {
+		t.Fatalf("mixed parts = %#v", mixed)
+	}
+	if mixed[1].disposition != "attachment" || mixed[1].body != "soup\n" {
+		t.Fatalf("attachment part = %#v", mixed[1])
+	}
+	alternative := readParts(t, mixed[0].contentType, strings.NewReader(mixed[0].body))
+	if len(alternative) != 2 || alternative[0].body != "Here is the menu." {
+		t.Fatalf("alternative parts = %#v", alternative)
+	}
+	if mediaType, params, _ := mime.ParseMediaType(alternative[1].contentType); mediaType != "multipart/related" || params["type"] != "text/html" {
+		t.Fatalf("html part type = %q", alternative[1].contentType)
+	}
+	related := readParts(t, alternative[1].contentType, strings.NewReader(alternative[1].body))
+	if len(related) != 2 || related[1].contentID != "<logo@mail.test>" || related[1].body != "png" {
+		t.Fatalf("related parts = %#v", related)
+	}
+}
+
+func TestBuildPlainMessageIsSinglePart(t *testing.T) {
+	raw, err := Build(Message{
+		From: Address{Email: "assistant@mail.test"},
+		To:   []Address{{Email: "sender@mail.test"}},
+		Text: "hello",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.Header.Get("Content-Type"); got != "text/plain; charset=utf-8" {
+		t.Fatalf("Content-Type = %q", got)
+	}
+	if !strings.HasSuffix(msg.Header.Get("Message-ID"), "@mail.test>") || msg.Header.Get("Date") == "" {
+		t.Fatalf("generated headers missing: %v", msg.Header)
+	}
+	if _, err := Build(Message{From: Address{Email: "assistant@mail.test"}}); err == nil {
+		t.Fatal("Build without recipients succeeded")
+	}
+}
+
+func TestFormatFlowed(t *testing.T) {
+	long := strings.Repeat("word ", 30) + "end"
+	got := FormatFlowed(long + "\n> quoted text\nFrom the start\n-- \nsig  ")
+	lines := strings.Split(got, "\r\n")
+	var joined strings.Builder
+	for i, line := range lines {
+		if len(line) > flowedLineWidth+1 {
+			t.Fatalf("line %d too long: %q", i, line)
+		}
+		joined.WriteString(line)
+		if !strings.HasSuffix(line, " ") {
+			break
+		}
+	}
+	if joined.String() != long {
+		t.Fatalf("unflowed paragraph = %q", joined.String())
+	}
+	for _, want := range []string{"\r\n> quoted text\r\n", "\r\n From the start\r\n", "\r\n-- \r\nsig"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("FormatFlowed missing %q:\n%q", want, got)
+		}
+	}
+	if strings.HasSuffix(got, " ") {
+		t.Fatalf("hard line break kept trailing space: %q", got)
+	}
+}
+
+type testPart struct {
+	contentType string
+	disposition string
+	contentID   string
+	body        string
+}
+
+func readParts(t *testing.T, contentType string, body io.Reader) []testPart {
+	t.Helper()
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := multipart.NewReader(body, params["boundary"])
+	var parts []testPart
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return parts
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if part.Header.Get("Content-Transfer-Encoding") == "base64" {
+			decoded, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, bytes.NewReader(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data = decoded
+		}
+		disposition, _, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+		parts = append(parts, testPart{
+			contentType: part.Header.Get("Content-Type"),
+			disposition: disposition,
+			contentID:   part.Header.Get("Content-ID"),
+			body:        string(data),
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package usenet
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	appconfig "ai-over-email/pkg/config"
+	"ai-over-email/pkg/diag"
+)
+
+func Diagnose(ctx context.Context, config Config) diag.Report {
+	if config.EnvPath == "" {
+		config.EnvPath = ".env"
+	}
+	if config.ConfigPath == "" {
+		config.ConfigPath = "config.json"
+	}
+	appCfg, err := appconfig.Load(config.ConfigPath)
+	if err != nil {
+		return diag.Report{diag.Skipped("usenet", "needs a valid config")}
+	}
+	if appCfg.Usenet.Host == "" && appCfg.Usenet.Group == "" {
+		return diag.Report{diag.Skipped("usenet", "usenet section is not configured")}
+	}
+	cfg := appCfg.Usenet.Normalized()
+
+	creds, err := LoadNNTPCredentials(config.EnvPath)
+	if err != nil {
+		return diag.Report{diag.Failed("usenet credentials", err, "set AI_OVER_USENET_USERNAME and AI_OVER_USENET_PASSWORD")}
+	}
+	report := diag.Report{diag.Passed("usenet credentials", "username=%s", creds.Username)}
+	if err := ctx.Err(); err != nil {
+		return append(report, diag.Failed("nntp connect", err, ""))
+	}
+
+	client, err := dialNNTP(cfg.Host, cfg.Port, cfg.Security, cfg.TLSServerName, cfg.TLSCertSHA256, 15*time.Second)
+	if err != nil {
+		return append(report, diag.Failed("nntp connect", err, connectHint(cfg, err)))
+	}
+	defer client.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = client.conn.SetDeadline(deadline)
+	}
+	report = append(report, diag.Passed("nntp connect", "%s:%d security=%s", cfg.Host, cfg.Port, cfg.Security))
+
+	if err := client.Auth(creds.Username, creds.Password); err != nil {
+		return append(report, diag.Failed("nntp auth", err, "check AI_OVER_USENET_USERNAME and AI_OVER_USENET_PASSWORD against the server account"))
+	}
+	report = append(report, diag.Passed("nntp auth", "%s", creds.Username))
+
+	status, err := client.Group(cfg.Group)
+	if err != nil {
+		return append(report, diag.Failed("nntp group", err, "check usenet.group and that the account may read it"))
+	}
+	return append(report, diag.Passed("nntp group", "%s count=%d low=%d high=%d", status.Name, status.Count, status.Low, status.High))
+}
+
+func connectHint(cfg appconfig.UsenetConfig, err error) string {
+	message := err.Error()
+	switch {
+	case strings.Contains(message, "fingerprint mismatch"):
+		return "the server certificate changed or usenet.tls_cert_sha256 is wrong; confirm the new certificate out of band and update the fingerprint"
+	case strings.Contains(message, "certificate"):
+		return "set usenet.tls_server_name to the name on the certificate, or pin a self-signed certificate with usenet.tls_cert_sha256"
+	case cfg.Security == "tls" && cfg.Port == 119:
+		return "port 119 is usually plaintext; set usenet.security to none or use port 563 for TLS"
+	default:
+		return fmt.Sprintf("check that %s:%d is reachable from this host", cfg.Host, cfg.Port)
+	}
+}
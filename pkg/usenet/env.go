@@ -16,6 +16,17 @@ type Credentials struct {
 }
 
 func LoadCredentials(envPath string) (Credentials, error) {
+	creds, err := LoadNNTPCredentials(envPath)
+	if err != nil {
+		return Credentials{}, err
+	}
+	if creds.OpenAIAPIToken == "" {
+		return Credentials{}, errors.New("credentials must include AI_OVER_EMAIL_OPENAI_API_KEY")
+	}
+	return creds, nil
+}
+
+func LoadNNTPCredentials(envPath string) (Credentials, error) {
 	values, err := loadEnvironment(envPath)
 	if err != nil {
 		return Credentials{}, err
@@ -32,9 +43,6 @@ func LoadCredentials(envPath string) (Credentials, error) {
 	if creds.Password == "" {
 		return Credentials{}, errors.New("credentials must include AI_OVER_USENET_PASSWORD")
 	}
-	if creds.OpenAIAPIToken == "" {
-		return Credentials{}, errors.New("credentials must include AI_OVER_EMAIL_OPENAI_API_KEY")
-	}
 	return creds, nil
 }
 
@@ -34,6 +34,17 @@ type article struct {
 	RawHeader  mail.Header
 }
 
+type overview struct {
+	Number     int
+	Subject    string
+	From       string
+	Date       string
+	MessageID  string
+	References []string
+	Bytes      int
+	Lines      int
+}
+
 type groupStatus struct {
 	Count int
 	Low   int
@@ -78,7 +89,7 @@ func dialTLSNNTP(host string, port int, serverName string, certSHA256 string, ti
 			sum := sha256.Sum256(rawCerts[0])
 			got := hex.EncodeToString(sum[:])
 			if got != want {
-				return fmt.Errorf("server certificate fingerprint mismatch")
+				return fmt.Errorf("server certificate fingerprint mismatch: got %s", got)
 			}
 			return nil
 		}
@@ -196,6 +207,37 @@ func (c *nntpClient) ArticleByMessageID(messageID string) (article, error) {
 	return parseArticle(number, strings.Join(lines, "\r\n"))
 }
 
+func (c *nntpClient) Overview(low int, high int) ([]overview, error) {
+	code, line, err := c.command("OVER %d-%d", low, high)
+	if err != nil {
+		return nil, err
+	}
+	if code == 500 {
+		code, line, err = c.command("XOVER %d-%d", low, high)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if code == 420 || code == 423 {
+		return nil, nil
+	}
+	if code != 224 {
+		return nil, fmt.Errorf("OVER %d-%d: %d %s", low, high, code, line)
+	}
+	lines, err := c.text.ReadDotLines()
+	if err != nil {
+		return nil, fmt.Errorf("read OVER %d-%d: %w", low, high, err)
+	}
+	result := make([]overview, 0, len(lines))
+	for _, line := range lines {
+		entry, ok := parseOverviewLine(line)
+		if ok {
+			result = append(result, entry)
+		}
+	}
+	return result, nil
+}
+
 func (c *nntpClient) HasMessageID(messageID string) (bool, error) {
 	code, line, err := c.command("STAT %s", messageID)
 	if err != nil {
@@ -286,6 +328,29 @@ func parseArticle(number int, raw string) (article, error) {
 	}, nil
 }
 
+func parseOverviewLine(line string) (overview, bool) {
+	fields := strings.Split(line, "\t")
+	if len(fields) < 8 {
+		return overview{}, false
+	}
+	number, err := strconv.Atoi(strings.TrimSpace(fields[0]))
+	if err != nil {
+		return overview{}, false
+	}
+	bytes, _ := strconv.Atoi(strings.TrimSpace(fields[6]))
+	lines, _ := strconv.Atoi(strings.TrimSpace(fields[7]))
+	return overview{
+		Number:     number,
+		Subject:    strings.TrimSpace(fields[1]),
+		From:       strings.TrimSpace(fields[2]),
+		Date:       strings.TrimSpace(fields[3]),
+		MessageID:  strings.TrimSpace(fields[4]),
+		References: parseReferences(fields[5]),
+		Bytes:      bytes,
+		Lines:      lines,
+	}, true
+}
+
 func parseReferences(value string) []string {
 	fields := strings.Fields(value)
 	result := make([]string, 0, len(fields))
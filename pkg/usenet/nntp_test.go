@@ -30,3 +30,21 @@ func TestParseReferencesDedupes(t *testing.T) {
 		t.Fatalf("parseReferences = %#v, want %s", got, want)
 	}
 }
+
+func TestParseOverviewLine(t *testing.T) {
+	line := "12\tRe: Question\tSender <sender@example.com>\tSat, 04 Jul 2026 03:00:00 +0000\t<two@example.com>\t<one@example.com>\t1234\t20"
+
+	entry, ok := parseOverviewLine(line)
+	if !ok {
+		t.Fatal("parseOverviewLine rejected a valid line")
+	}
+	if entry.Number != 12 || entry.Subject != "Re: Question" || entry.MessageID != "<two@example.com>" || entry.Bytes != 1234 || entry.Lines != 20 {
+		t.Fatalf("entry = %#v", entry)
+	}
+	if strings.Join(entry.References, " ") != "<one@example.com>" {
+		t.Fatalf("References = %#v", entry.References)
+	}
+	if _, ok := parseOverviewLine("not\tenough\tfields"); ok {
+		t.Fatal("parseOverviewLine accepted a short line")
+	}
+}
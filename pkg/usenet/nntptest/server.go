@@ -0,0 +1,713 @@
+package nntptest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultUsername   = "reader"
+	DefaultPassword   = "nntptest-password"
+	DefaultServerName = "nntp.test"
+)
+
+type Article struct {
+	MessageID  string
+	From       string
+	Subject    string
+	Date       time.Time
+	References []string
+	Headers    map[string]string
+	Body       string
+}
+
+type Posting struct {
+	MessageID  string
+	Newsgroups []string
+	Raw        string
+	Header     mail.Header
+	Body       string
+}
+
+type Server struct {
+	Addr       string
+	Host       string
+	Port       int
+	Username   string
+	Password   string
+	TLS        bool
+	CertSHA256 string
+
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu          sync.Mutex
+	groups      map[string]*group
+	byID        map[string]stored
+	posts       []Posting
+	posted      chan struct{}
+	commands    []string
+	failures    map[string][]failure
+	conns       map[net.Conn]struct{}
+	requireAuth bool
+	closed      bool
+}
+
+type group struct {
+	name     string
+	low      int
+	high     int
+	articles map[int]string
+}
+
+type stored struct {
+	group  string
+	number int
+	raw    string
+}
+
+type failure struct {
+	code       int
+	text       string
+	disconnect bool
+}
+
+func NewServer() *Server {
+	s := newServer()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("nntptest: listen: %v", err))
+	}
+	s.start(listener)
+	return s
+}
+
+func NewTLSServer() *Server {
+	s := newServer()
+	cert, fingerprint, err := selfSignedCertificate(DefaultServerName)
+	if err != nil {
+		panic(fmt.Sprintf("nntptest: certificate: %v", err))
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	})
+	if err != nil {
+		panic(fmt.Sprintf("nntptest: listen: %v", err))
+	}
+	s.TLS = true
+	s.CertSHA256 = fingerprint
+	s.start(listener)
+	return s
+}
+
+func newServer() *Server {
+	return &Server{
+		Username:    DefaultUsername,
+		Password:    DefaultPassword,
+		groups:      make(map[string]*group),
+		byID:        make(map[string]stored),
+		posted:      make(chan struct{}),
+		failures:    make(map[string][]failure),
+		conns:       make(map[net.Conn]struct{}),
+		requireAuth: true,
+	}
+}
+
+func (s *Server) start(listener net.Listener) {
+	s.listener = listener
+	addr := listener.Addr().(*net.TCPAddr)
+	s.Addr = addr.String()
+	s.Host = addr.IP.String()
+	s.Port = addr.Port
+	s.wg.Add(1)
+	go s.accept()
+}
+
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) AllowAnonymous() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requireAuth = false
+}
+
+func (s *Server) AddGroup(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.groupLocked(name)
+}
+
+func (s *Server) Add(groupName string, a Article) (int, error) {
+	if a.MessageID == "" {
+		return 0, errors.New("nntptest: article needs a Message-ID")
+	}
+	if a.Date.IsZero() {
+		a.Date = time.Now()
+	}
+	var raw strings.Builder
+	header := func(key, value string) {
+		if value != "" {
+			fmt.Fprintf(&raw, "%s: %s\r\n", key, value)
+		}
+	}
+	header("Message-ID", a.MessageID)
+	header("From", a.From)
+	header("Newsgroups", groupName)
+	header("Subject", a.Subject)
+	header("Date", a.Date.UTC().Format(time.RFC1123Z))
+	header("References", strings.Join(a.References, " "))
+	keys := make([]string, 0, len(a.Headers))
+	for key := range a.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		header(key, a.Headers[key])
+	}
+	raw.WriteString("\r\n")
+	raw.WriteString(strings.ReplaceAll(strings.ReplaceAll(a.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return s.AddRaw(groupName, raw.String())
+}
+
+func (s *Server) AddRaw(groupName string, raw string) (int, error) {
+	msg, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		return 0, fmt.Errorf("nntptest: parse article: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.storeLocked(groupName, msg.Header.Get("Message-ID"), raw)
+}
+
+func (s *Server) Remove(messageID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.byID[messageID]
+	if !ok {
+		return
+	}
+	delete(s.byID, messageID)
+	delete(s.groups[entry.group].articles, entry.number)
+}
+
+func (s *Server) Posts() []Posting {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Posting(nil), s.posts...)
+}
+
+func (s *Server) WaitForPosts(n int, timeout time.Duration) ([]Posting, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		s.mu.Lock()
+		if len(s.posts) >= n {
+			posts := append([]Posting(nil), s.posts...)
+			s.mu.Unlock()
+			return posts, nil
+		}
+		posted := s.posted
+		count := len(s.posts)
+		s.mu.Unlock()
+		select {
+		case <-posted:
+		case <-deadline.C:
+			return nil, fmt.Errorf("nntptest: %d posts after %s, want %d", count, timeout, n)
+		}
+	}
+}
+
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+func (s *Server) Fail(command string, code int, text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	command = strings.ToUpper(command)
+	s.failures[command] = append(s.failures[command], failure{code: code, text: text})
+}
+
+func (s *Server) Disconnect(command string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	command = strings.ToUpper(command)
+	s.failures[command] = append(s.failures[command], failure{disconnect: true})
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serve(conn)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+type session struct {
+	text          *textproto.Conn
+	user          string
+	authenticated bool
+	group         string
+	current       int
+}
+
+func (s *Server) serve(conn net.Conn) {
+	sess := &session{text: textproto.NewConn(conn)}
+	if err := sess.text.PrintfLine("200 nntptest ready, posting allowed"); err != nil {
+		return
+	}
+	for {
+		line, err := sess.text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+		verb = strings.ToUpper(verb)
+		args = strings.TrimSpace(args)
+
+		s.mu.Lock()
+		s.commands = append(s.commands, verb)
+		scripted, ok := s.nextFailureLocked(verb, args)
+		s.mu.Unlock()
+		if ok {
+			if scripted.disconnect {
+				return
+			}
+			if verb == "POST" {
+				if sess.text.PrintfLine("340 send article") != nil {
+					return
+				}
+				if _, err := sess.text.ReadDotBytes(); err != nil {
+					return
+				}
+			}
+			if err := sess.text.PrintfLine("%d %s", scripted.code, scripted.text); err != nil {
+				return
+			}
+			continue
+		}
+		if !s.handle(sess, verb, args) {
+			return
+		}
+	}
+}
+
+func (s *Server) nextFailureLocked(verb string, args string) (failure, bool) {
+	key := verb
+	if verb == "AUTHINFO" {
+		if sub, _, _ := strings.Cut(args, " "); sub != "" {
+			if queue := s.failures["AUTHINFO "+strings.ToUpper(sub)]; len(queue) > 0 {
+				key = "AUTHINFO " + strings.ToUpper(sub)
+			}
+		}
+	}
+	queue := s.failures[key]
+	if len(queue) == 0 {
+		return failure{}, false
+	}
+	s.failures[key] = queue[1:]
+	return queue[0], true
+}
+
+func (s *Server) handle(sess *session, verb string, args string) bool {
+	reply := func(format string, values ...any) bool {
+		return sess.text.PrintfLine(format, values...) == nil
+	}
+	switch verb {
+	case "QUIT":
+		reply("205 bye")
+		return false
+	case "CAPABILITIES":
+		return s.writeBlock(sess, "101 capability list follows", []string{
+			"VERSION 2", "READER", "POST", "OVER", "HDR", "AUTHINFO USER", "LIST ACTIVE",
+		})
+	case "MODE":
+		return reply("200 reader mode, posting allowed")
+	case "AUTHINFO":
+		return s.authinfo(sess, args)
+	}
+
+	s.mu.Lock()
+	needAuth := s.requireAuth && !sess.authenticated
+	s.mu.Unlock()
+	if needAuth {
+		return reply("480 authentication required")
+	}
+	switch verb {
+	case "GROUP":
+		return s.selectGroup(sess, args)
+	case "ARTICLE", "HEAD", "BODY", "STAT":
+		return s.article(sess, verb, args)
+	case "OVER", "XOVER":
+		return s.over(sess, args)
+	case "HDR", "XHDR":
+		return s.hdr(sess, args)
+	case "POST":
+		return s.post(sess)
+	default:
+		return reply("500 unknown command")
+	}
+}
+
+func (s *Server) authinfo(sess *session, args string) bool {
+	sub, value, _ := strings.Cut(args, " ")
+	switch strings.ToUpper(sub) {
+	case "USER":
+		sess.user = strings.TrimSpace(value)
+		return sess.text.PrintfLine("381 password required") == nil
+	case "PASS":
+		if sess.user == "" {
+			return sess.text.PrintfLine("482 send AUTHINFO USER first") == nil
+		}
+		s.mu.Lock()
+		ok := sess.user == s.Username && strings.TrimSpace(value) == s.Password
+		s.mu.Unlock()
+		if !ok {
+			return sess.text.PrintfLine("481 authentication failed") == nil
+		}
+		sess.authenticated = true
+		return sess.text.PrintfLine("281 authentication accepted") == nil
+	default:
+		return sess.text.PrintfLine("501 unknown AUTHINFO subcommand") == nil
+	}
+}
+
+func (s *Server) selectGroup(sess *session, name string) bool {
+	s.mu.Lock()
+	g, ok := s.groups[name]
+	var count, low, high int
+	if ok {
+		count, low, high = len(g.articles), g.low, g.high
+	}
+	s.mu.Unlock()
+	if !ok {
+		return sess.text.PrintfLine("411 no such group") == nil
+	}
+	sess.group = name
+	sess.current = low
+	return sess.text.PrintfLine("211 %d %d %d %s", count, low, high, name) == nil
+}
+
+func (s *Server) article(sess *session, verb string, args string) bool {
+	s.mu.Lock()
+	number, raw, code, text := s.lookupLocked(sess, args)
+	s.mu.Unlock()
+	if code != 0 {
+		return sess.text.PrintfLine("%d %s", code, text) == nil
+	}
+	messageID := args
+	if !strings.HasPrefix(args, "<") {
+		sess.current = number
+		if msg, err := mail.ReadMessage(strings.NewReader(raw)); err == nil {
+			messageID = msg.Header.Get("Message-ID")
+		}
+	}
+	header, body, _ := strings.Cut(raw, "\r\n\r\n")
+	switch verb {
+	case "STAT":
+		return sess.text.PrintfLine("223 %d %s", number, messageID) == nil
+	case "HEAD":
+		return s.writeBlock(sess, fmt.Sprintf("221 %d %s", number, messageID), strings.Split(header, "\r\n"))
+	case "BODY":
+		return s.writeBlock(sess, fmt.Sprintf("222 %d %s", number, messageID), strings.Split(body, "\r\n"))
+	default:
+		return s.writeBlock(sess, fmt.Sprintf("220 %d %s", number, messageID), strings.Split(raw, "\r\n"))
+	}
+}
+
+func (s *Server) lookupLocked(sess *session, args string) (int, string, int, string) {
+	if strings.HasPrefix(args, "<") {
+		entry, ok := s.byID[args]
+		if !ok {
+			return 0, "", 430, "no article with that message-id"
+		}
+		number := 0
+		if entry.group == sess.group {
+			number = entry.number
+		}
+		return number, entry.raw, 0, ""
+	}
+	g, ok := s.groups[sess.group]
+	if !ok {
+		return 0, "", 412, "no newsgroup selected"
+	}
+	number := sess.current
+	if args != "" {
+		parsed, err := strconv.Atoi(args)
+		if err != nil {
+			return 0, "", 501, "bad article number"
+		}
+		number = parsed
+	}
+	raw, ok := g.articles[number]
+	if !ok {
+		return 0, "", 423, "no article with that number"
+	}
+	return number, raw, 0, ""
+}
+
+func (s *Server) over(sess *session, args string) bool {
+	s.mu.Lock()
+	entries, code, text := s.rangeLocked(sess, args)
+	lines := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		lines = append(lines, overviewLine(entry.number, entry.raw))
+	}
+	s.mu.Unlock()
+	if code != 0 {
+		return sess.text.PrintfLine("%d %s", code, text) == nil
+	}
+	return s.writeBlock(sess, "224 overview information follows", lines)
+}
+
+func (s *Server) hdr(sess *session, args string) bool {
+	field, rangeArgs, _ := strings.Cut(args, " ")
+	if field == "" {
+		return sess.text.PrintfLine("501 HDR needs a header name") == nil
+	}
+	s.mu.Lock()
+	entries, code, text := s.rangeLocked(sess, strings.TrimSpace(rangeArgs))
+	lines := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		value := ""
+		if msg, err := mail.ReadMessage(strings.NewReader(entry.raw)); err == nil {
+			value = msg.Header.Get(field)
+		}
+		lines = append(lines, fmt.Sprintf("%d %s", entry.number, value))
+	}
+	s.mu.Unlock()
+	if code != 0 {
+		return sess.text.PrintfLine("%d %s", code, text) == nil
+	}
+	return s.writeBlock(sess, "225 headers follow", lines)
+}
+
+func (s *Server) rangeLocked(sess *session, args string) ([]stored, int, string) {
+	if strings.HasPrefix(args, "<") {
+		entry, ok := s.byID[args]
+		if !ok {
+			return nil, 430, "no article with that message-id"
+		}
+		return []stored{entry}, 0, ""
+	}
+	g, ok := s.groups[sess.group]
+	if !ok {
+		return nil, 412, "no newsgroup selected"
+	}
+	low, high := sess.current, sess.current
+	if args != "" {
+		first, last, isRange := strings.Cut(args, "-")
+		var err error
+		if low, err = strconv.Atoi(first); err != nil {
+			return nil, 501, "bad range"
+		}
+		high = low
+		if isRange {
+			high = g.high
+			if last != "" {
+				if high, err = strconv.Atoi(last); err != nil {
+					return nil, 501, "bad range"
+				}
+			}
+		}
+	}
+	var entries []stored
+	for number := low; number <= high; number++ {
+		if raw, ok := g.articles[number]; ok {
+			entries = append(entries, stored{group: g.name, number: number, raw: raw})
+		}
+	}
+	if len(entries) == 0 {
+		return nil, 423, "no articles in that range"
+	}
+	return entries, 0, ""
+}
+
+func (s *Server) post(sess *session) bool {
+	if err := sess.text.PrintfLine("340 send article"); err != nil {
+		return false
+	}
+	data, err := sess.text.ReadDotBytes()
+	if err != nil {
+		return false
+	}
+	raw := strings.ReplaceAll(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n", "\r\n")
+	msg, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		return sess.text.PrintfLine("441 malformed article") == nil
+	}
+	body, _ := io.ReadAll(msg.Body)
+	messageID := strings.TrimSpace(msg.Header.Get("Message-ID"))
+	var newsgroups []string
+	for _, name := range strings.Split(msg.Header.Get("Newsgroups"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			newsgroups = append(newsgroups, name)
+		}
+	}
+	if messageID == "" || len(newsgroups) == 0 {
+		return sess.text.PrintfLine("441 article needs Message-ID and Newsgroups") == nil
+	}
+
+	s.mu.Lock()
+	if _, exists := s.byID[messageID]; exists {
+		s.mu.Unlock()
+		return sess.text.PrintfLine("441 duplicate message-id") == nil
+	}
+	for _, name := range newsgroups {
+		if _, err := s.storeLocked(name, messageID, raw); err != nil {
+			s.mu.Unlock()
+			return sess.text.PrintfLine("441 %s", err) == nil
+		}
+	}
+	s.posts = append(s.posts, Posting{
+		MessageID:  messageID,
+		Newsgroups: newsgroups,
+		Raw:        raw,
+		Header:     msg.Header,
+		Body:       string(bytes.TrimRight(body, "\r\n")),
+	})
+	close(s.posted)
+	s.posted = make(chan struct{})
+	s.mu.Unlock()
+	return sess.text.PrintfLine("240 article received") == nil
+}
+
+func (s *Server) writeBlock(sess *session, status string, lines []string) bool {
+	if err := sess.text.PrintfLine("%s", status); err != nil {
+		return false
+	}
+	writer := sess.text.DotWriter()
+	buffered := bufio.NewWriter(writer)
+	for _, line := range lines {
+		buffered.WriteString(line)
+		buffered.WriteString("\n")
+	}
+	if err := buffered.Flush(); err != nil {
+		return false
+	}
+	return writer.Close() == nil
+}
+
+func (s *Server) groupLocked(name string) *group {
+	g, ok := s.groups[name]
+	if !ok {
+		g = &group{name: name, low: 1, articles: make(map[int]string)}
+		s.groups[name] = g
+	}
+	return g
+}
+
+func (s *Server) storeLocked(groupName string, messageID string, raw string) (int, error) {
+	messageID = strings.TrimSpace(messageID)
+	if messageID != "" {
+		if _, exists := s.byID[messageID]; exists {
+			return 0, fmt.Errorf("nntptest: duplicate Message-ID %s", messageID)
+		}
+	}
+	g := s.groupLocked(groupName)
+	g.high++
+	g.articles[g.high] = raw
+	if messageID != "" {
+		s.byID[messageID] = stored{group: groupName, number: g.high, raw: raw}
+	}
+	return g.high, nil
+}
+
+func overviewLine(number int, raw string) string {
+	msg, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		return strconv.Itoa(number) + strings.Repeat("\t", 7)
+	}
+	clean := func(value string) string {
+		return strings.NewReplacer("\t", " ", "\r", " ", "\n", " ").Replace(value)
+	}
+	body, _ := io.ReadAll(msg.Body)
+	return strings.Join([]string{
+		strconv.Itoa(number),
+		clean(msg.Header.Get("Subject")),
+		clean(msg.Header.Get("From")),
+		clean(msg.Header.Get("Date")),
+		clean(msg.Header.Get("Message-ID")),
+		clean(msg.Header.Get("References")),
+		strconv.Itoa(len(raw)),
+		strconv.Itoa(bytes.Count(body, []byte("\n"))),
+	}, "\t")
+}
+
+func selfSignedCertificate(name string) (tls.Certificate, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+	sum := sha256.Sum256(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,88 @@
+package nntptest
+
+import (
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func dial(t *testing.T, s *Server) *textproto.Conn {
+	t.Helper()
+	conn, err := textproto.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if _, _, err := conn.ReadCodeLine(200); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func command(t *testing.T, conn *textproto.Conn, expect int, format string, args ...any) string {
+	t.Helper()
+	if err := conn.PrintfLine(format, args...); err != nil {
+		t.Fatal(err)
+	}
+	_, message, err := conn.ReadCodeLine(expect)
+	if err != nil {
+		t.Fatalf("%s: %v", format, err)
+	}
+	return message
+}
+
+func TestServerReaderCommands(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	if _, err := s.Add("misc.test", Article{MessageID: "<one@example.com>", From: "One <one@example.com>", Subject: "First", Body: "Hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Add("misc.test", Article{MessageID: "<two@example.com>", Subject: "Re: First", References: []string{"<one@example.com>"}, Body: "Hi"}); err != nil {
+		t.Fatal(err)
+	}
+	conn := dial(t, s)
+
+	command(t, conn, 101, "CAPABILITIES")
+	capabilities, err := conn.ReadDotLines()
+	if err != nil || !strings.Contains(strings.Join(capabilities, "\n"), "HDR") {
+		t.Fatalf("capabilities = %v, %v", capabilities, err)
+	}
+	command(t, conn, 480, "GROUP misc.test")
+	command(t, conn, 381, "AUTHINFO USER %s", s.Username)
+	command(t, conn, 281, "AUTHINFO PASS %s", s.Password)
+	if got := command(t, conn, 211, "GROUP misc.test"); got != "2 1 2 misc.test" {
+		t.Fatalf("GROUP = %q", got)
+	}
+
+	command(t, conn, 225, "HDR References 1-")
+	refs, err := conn.ReadDotLines()
+	if err != nil || len(refs) != 2 || refs[1] != "2 <one@example.com>" {
+		t.Fatalf("HDR = %q, %v", refs, err)
+	}
+	command(t, conn, 224, "OVER 2")
+	over, err := conn.ReadDotLines()
+	if err != nil || len(over) != 1 || !strings.HasPrefix(over[0], "2\tRe: First\t") {
+		t.Fatalf("OVER = %q, %v", over, err)
+	}
+	command(t, conn, 430, "ARTICLE <missing@example.com>")
+	command(t, conn, 423, "ARTICLE 9")
+
+	s.Fail("ARTICLE", 430, "expired")
+	command(t, conn, 430, "ARTICLE 1")
+	command(t, conn, 220, "ARTICLE 1")
+	if _, err := conn.ReadDotLines(); err != nil {
+		t.Fatal(err)
+	}
+
+	command(t, conn, 340, "POST")
+	writer := conn.DotWriter()
+	writer.Write([]byte("Message-ID: <three@example.com>\r\nNewsgroups: misc.test\r\nSubject: Third\r\n\r\nBody\r\n"))
+	writer.Close()
+	if _, _, err := conn.ReadCodeLine(240); err != nil {
+		t.Fatal(err)
+	}
+	if posts := s.Posts(); len(posts) != 1 || posts[0].Body != "Body" {
+		t.Fatalf("posts = %+v", posts)
+	}
+	command(t, conn, 223, "STAT <three@example.com>")
+}
@@ -0,0 +1,339 @@
+package usenet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	appconfig "ai-over-email/pkg/config"
+)
+
+const (
+	defaultSearchScan  = 500
+	defaultSearchLimit = 25
+)
+
+type Reader struct {
+	config Config
+	usenet appconfig.UsenetConfig
+	creds  Credentials
+	mu     sync.Mutex
+}
+
+type GroupInfo struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+	Low   int    `json:"low"`
+	High  int    `json:"high"`
+}
+
+type Article struct {
+	Number     int      `json:"number"`
+	MessageID  string   `json:"messageId"`
+	Subject    string   `json:"subject"`
+	From       string   `json:"from"`
+	Date       string   `json:"date,omitempty"`
+	References []string `json:"references,omitempty"`
+	Body       string   `json:"body"`
+}
+
+type OverviewEntry struct {
+	Number     int      `json:"number"`
+	Subject    string   `json:"subject"`
+	From       string   `json:"from"`
+	Date       string   `json:"date"`
+	MessageID  string   `json:"messageId"`
+	References []string `json:"references,omitempty"`
+	Bytes      int      `json:"bytes"`
+	Lines      int      `json:"lines"`
+}
+
+type SearchOptions struct {
+	Query   string
+	From    string
+	Subject string
+	Scan    int
+	Limit   int
+}
+
+type Followup struct {
+	SourceMessageID string `json:"sourceMessageId"`
+	MessageID       string `json:"messageId"`
+	Subject         string `json:"subject"`
+	Raw             string `json:"raw"`
+}
+
+func NewReader(config Config) (*Reader, error) {
+	if config.EnvPath == "" {
+		config.EnvPath = ".env"
+	}
+	if config.ConfigPath == "" {
+		config.ConfigPath = "config.json"
+	}
+	if config.LogOutput == nil {
+		config.LogOutput = os.Stderr
+	}
+	appCfg, err := appconfig.Load(config.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	usenetCfg := appCfg.Usenet.Normalized()
+	if usenetCfg.Host == "" || usenetCfg.Group == "" {
+		return nil, fmt.Errorf("usenet.host and usenet.group must be configured")
+	}
+	creds, err := LoadNNTPCredentials(config.EnvPath)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{config: config, usenet: usenetCfg, creds: creds}, nil
+}
+
+func (r *Reader) Groups(ctx context.Context) ([]GroupInfo, error) {
+	client, status, err := r.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	return []GroupInfo{{Name: status.Name, Count: status.Count, Low: status.Low, High: status.High}}, nil
+}
+
+func (r *Reader) ArticleByNumber(ctx context.Context, number int) (Article, error) {
+	client, _, err := r.open(ctx)
+	if err != nil {
+		return Article{}, err
+	}
+	defer client.Close()
+	item, err := client.ArticleByNumber(number)
+	if errors.Is(err, errArticleMissing) {
+		return Article{}, fmt.Errorf("article %d not found in %s", number, r.usenet.Group)
+	}
+	if err != nil {
+		return Article{}, err
+	}
+	return exportArticle(item), nil
+}
+
+func (r *Reader) ArticleByMessageID(ctx context.Context, messageID string) (Article, error) {
+	messageID, err := normalizeMessageID(messageID)
+	if err != nil {
+		return Article{}, err
+	}
+	client, status, err := r.open(ctx)
+	if err != nil {
+		return Article{}, err
+	}
+	defer client.Close()
+	item, err := r.locate(client, status, messageID)
+	if err != nil {
+		return Article{}, err
+	}
+	return exportArticle(item), nil
+}
+
+func (r *Reader) Thread(ctx context.Context, messageID string) ([]Article, error) {
+	messageID, err := normalizeMessageID(messageID)
+	if err != nil {
+		return nil, err
+	}
+	client, status, err := r.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	current, err := r.locate(client, status, messageID)
+	if err != nil {
+		return nil, err
+	}
+	thread, err := threadContext(client, r.usenet, current)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Article, 0, len(thread))
+	for _, item := range thread {
+		result = append(result, exportArticle(item))
+	}
+	return result, nil
+}
+
+func (r *Reader) Search(ctx context.Context, opts SearchOptions) ([]OverviewEntry, error) {
+	scan := opts.Scan
+	if scan <= 0 {
+		scan = defaultSearchScan
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	client, status, err := r.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	if status.High < status.Low {
+		return []OverviewEntry{}, nil
+	}
+	low := max(status.Low, status.High-scan+1)
+	entries, err := client.Overview(low, status.High)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Number > entries[j].Number
+	})
+	result := []OverviewEntry{}
+	for _, entry := range entries {
+		if !overviewMatches(entry, opts) {
+			continue
+		}
+		result = append(result, OverviewEntry(entry))
+		if len(result) >= limit {
+			break
+		}
+	}
+	return result, nil
+}
+
+func (r *Reader) PostFollowup(ctx context.Context, messageID string, body string) (Followup, error) {
+	messageID, err := normalizeMessageID(messageID)
+	if err != nil {
+		return Followup{}, err
+	}
+	if strings.TrimSpace(body) == "" {
+		return Followup{}, fmt.Errorf("follow-up body is empty")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	client, status, err := r.open(ctx)
+	if err != nil {
+		return Followup{}, err
+	}
+	defer client.Close()
+	original, err := r.locate(client, status, messageID)
+	if err != nil {
+		return Followup{}, err
+	}
+	raw, postedID, err := formatFollowup(r.usenet, original, body)
+	if err != nil {
+		return Followup{}, err
+	}
+	err = updateState(r.usenet.StatePath, func(st *state) error {
+		if prior := st.Replied[original.MessageID]; prior != "" {
+			return fmt.Errorf("article %s already has a follow-up %s", original.MessageID, prior)
+		}
+		if err := client.Post(raw); err != nil {
+			return err
+		}
+		st.Replied[original.MessageID] = postedID
+		return nil
+	})
+	if err != nil {
+		return Followup{}, err
+	}
+	r.logf("posted Usenet follow-up through MCP: source_message_id=%s reply_message_id=%s", original.MessageID, postedID)
+	return Followup{SourceMessageID: original.MessageID, MessageID: postedID, Subject: replySubject(original.Subject), Raw: raw}, nil
+}
+
+func (r *Reader) open(ctx context.Context) (*nntpClient, groupStatus, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, groupStatus{}, err
+	}
+	client, err := dialNNTP(r.usenet.Host, r.usenet.Port, r.usenet.Security, r.usenet.TLSServerName, r.usenet.TLSCertSHA256, 30*time.Second)
+	if err != nil {
+		return nil, groupStatus{}, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = client.conn.SetDeadline(deadline)
+	}
+	if err := client.Auth(r.creds.Username, r.creds.Password); err != nil {
+		client.Close()
+		return nil, groupStatus{}, err
+	}
+	status, err := client.Group(r.usenet.Group)
+	if err != nil {
+		client.Close()
+		return nil, groupStatus{}, err
+	}
+	return client, status, nil
+}
+
+func (r *Reader) locate(client *nntpClient, status groupStatus, messageID string) (article, error) {
+	item, err := client.ArticleByMessageID(messageID)
+	if errors.Is(err, errArticleMissing) {
+		return article{}, fmt.Errorf("article %s not found", messageID)
+	}
+	if err != nil {
+		return article{}, err
+	}
+	if item.Number > 0 || status.High < status.Low {
+		return item, nil
+	}
+	low := max(status.Low, status.High-defaultSearchScan+1)
+	entries, err := client.Overview(low, status.High)
+	if err != nil {
+		return article{}, err
+	}
+	for _, entry := range entries {
+		if entry.MessageID == item.MessageID {
+			item.Number = entry.Number
+			break
+		}
+	}
+	return item, nil
+}
+
+func (r *Reader) logf(format string, args ...any) {
+	if r.config.LogOutput == nil {
+		return
+	}
+	fmt.Fprintf(r.config.LogOutput, "%s usenet: %s\n", time.Now().UTC().Format(time.RFC3339Nano), fmt.Sprintf(format, args...))
+}
+
+func exportArticle(item article) Article {
+	date := ""
+	if item.RawHeader != nil {
+		date = strings.TrimSpace(item.RawHeader.Get("Date"))
+	}
+	return Article{
+		Number:     item.Number,
+		MessageID:  item.MessageID,
+		Subject:    item.Subject,
+		From:       item.From,
+		Date:       date,
+		References: item.References,
+		Body:       item.Body,
+	}
+}
+
+func overviewMatches(entry overview, opts SearchOptions) bool {
+	if from := strings.ToLower(strings.TrimSpace(opts.From)); from != "" && !strings.Contains(strings.ToLower(entry.From), from) {
+		return false
+	}
+	if subject := strings.ToLower(strings.TrimSpace(opts.Subject)); subject != "" && !strings.Contains(strings.ToLower(entry.Subject), subject) {
+		return false
+	}
+	query := strings.ToLower(strings.TrimSpace(opts.Query))
+	if query == "" {
+		return true
+	}
+	return strings.Contains(strings.ToLower(entry.Subject), query) || strings.Contains(strings.ToLower(entry.From), query)
+}
+
+func normalizeMessageID(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", fmt.Errorf("message id is required")
+	}
+	if strings.ContainsAny(value, " \t\r\n") {
+		return "", fmt.Errorf("invalid message id %q", value)
+	}
+	if !strings.HasPrefix(value, "<") {
+		value = "<" + value + ">"
+	}
+	return value, nil
+}
@@ -0,0 +1,68 @@
+package usenet
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOverviewMatches(t *testing.T) {
+	entry := overview{Subject: "Question about Go", From: "Sender <sender@example.com>"}
+
+	for _, opts := range []SearchOptions{
+		{},
+		{Query: "go"},
+		{Query: "SENDER@"},
+		{From: "example.com", Subject: "question"},
+	} {
+		if !overviewMatches(entry, opts) {
+			t.Fatalf("overviewMatches(%#v) = false, want true", opts)
+		}
+	}
+	for _, opts := range []SearchOptions{
+		{Query: "rust"},
+		{From: "other@example.com"},
+		{Subject: "question", From: "nobody"},
+	} {
+		if overviewMatches(entry, opts) {
+			t.Fatalf("overviewMatches(%#v) = true, want false", opts)
+		}
+	}
+}
+
+func TestNormalizeMessageID(t *testing.T) {
+	got, err := normalizeMessageID(" one@example.com ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "<one@example.com>" {
+		t.Fatalf("normalizeMessageID = %q", got)
+	}
+	if _, err := normalizeMessageID(""); err == nil {
+		t.Fatal("empty message id accepted")
+	}
+	if _, err := normalizeMessageID("<one@example.com> QUIT"); err == nil {
+		t.Fatal("message id with whitespace accepted")
+	}
+}
+
+func TestLoadNNTPCredentialsDoesNotRequireOpenAIKey(t *testing.T) {
+	for _, key := range []string{"AI_OVER_USENET_USERNAME", "AI_OVER_EMAIL_USENET_USERNAME", "AI_OVER_USENET_PASSWORD", "AI_OVER_EMAIL_USENET_PASSWORD", "AI_OVER_EMAIL_OPENAI_API_KEY", "AI_OVER_EMAIL_BRAVE_API_KEY"} {
+		t.Setenv(key, "")
+	}
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte("AI_OVER_USENET_USERNAME=reader\nAI_OVER_USENET_PASSWORD=secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := LoadNNTPCredentials(path)
+	if err != nil {
+		t.Fatalf("LoadNNTPCredentials returned error: %v", err)
+	}
+	if creds.Username != "reader" || creds.Password != "secret" {
+		t.Fatalf("creds = %#v", creds)
+	}
+	if _, err := LoadCredentials(path); err == nil {
+		t.Fatal("LoadCredentials accepted credentials without an OpenAI key")
+	}
+}
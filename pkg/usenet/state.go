@@ -65,3 +65,37 @@ func saveState(path string, st state) error {
 	}
 	return nil
 }
+
+// updateState applies update to the state at path while holding an exclusive
+// lock on path+".lock", so the watcher and the MCP server never overwrite each
+// other's Replied entries. The state is saved only when update succeeds.
+func updateState(path string, update func(*state) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("create state directory: %w", err)
+	}
+	unlock, err := lockStateFile(path + ".lock")
+	if err != nil {
+		return fmt.Errorf("lock state: %w", err)
+	}
+	defer unlock()
+	st, err := loadState(path)
+	if err != nil {
+		return err
+	}
+	if err := update(&st); err != nil {
+		return err
+	}
+	return saveState(path, st)
+}
+
+// mergeState saves the watcher's progress without dropping follow-ups another
+// process recorded since st was loaded.
+func mergeState(path string, st state) error {
+	return updateState(path, func(current *state) error {
+		current.LastSeenNumber = st.LastSeenNumber
+		for source, reply := range st.Replied {
+			current.Replied[source] = reply
+		}
+		return nil
+	})
+}
@@ -0,0 +1,9 @@
+//go:build !unix && !windows
+
+package usenet
+
+// lockStateFile falls back to an exclusively created lock file where neither
+// flock nor LockFileEx is available.
+func lockStateFile(path string) (func(), error) {
+	return lockStateFileExclusive(path, stateLockStale)
+}
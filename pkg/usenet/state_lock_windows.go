@@ -0,0 +1,28 @@
+//go:build windows
+
+package usenet
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockStateFile takes a LockFileEx lock, which Windows drops when the holder
+// exits, so a crashed process never leaves the state locked.
+func lockStateFile(path string) (func(), error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	handle := windows.Handle(file.Fd())
+	overlapped := new(windows.Overlapped)
+	if err := windows.LockFileEx(handle, windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, overlapped); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return func() {
+		_ = windows.UnlockFileEx(handle, 0, 1, 0, overlapped)
+		file.Close()
+	}, nil
+}
@@ -0,0 +1,69 @@
+package usenet
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stateLockStale is how old a lock file must be before it is taken to belong
+// to a crashed process. Holders only keep the lock for one load and save.
+const stateLockStale = 30 * time.Second
+
+// lockStateFileExclusive locks by creating path exclusively. The file records
+// the holder's PID and when it was taken; a lock older than stale is broken,
+// so a process that died while holding it does not block every later update.
+func lockStateFileExclusive(path string, stale time.Duration) (func(), error) {
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			_, werr := fmt.Fprintf(file, "%d %d\n", os.Getpid(), time.Now().UnixNano())
+			if cerr := file.Close(); werr == nil {
+				werr = cerr
+			}
+			if werr != nil {
+				_ = os.Remove(path)
+				return nil, werr
+			}
+			return func() { _ = os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		holder, taken, ok := readStateLock(path)
+		if ok && time.Since(taken) > stale {
+			// Only remove the lock we judged stale, not one a competing
+			// process took after breaking it first.
+			if current, err := os.ReadFile(path); err == nil && string(current) == holder {
+				_ = os.Remove(path)
+			}
+			continue
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func readStateLock(path string) (string, time.Time, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		// A holder may be between creating and writing the file; judge it by
+		// its modification time instead.
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", time.Time{}, false
+		}
+		return string(data), info.ModTime(), true
+	}
+	nanos, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return string(data), time.Time{}, true
+	}
+	return string(data), time.Unix(0, nanos), true
+}
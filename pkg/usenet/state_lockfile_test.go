@@ -0,0 +1,54 @@
+package usenet
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLockStateFileExclusiveBreaksStaleLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json.lock")
+	if err := os.WriteFile(path, []byte("4242 "+strings.Repeat("1", 10)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := lockStateFileExclusive(path, time.Minute)
+	if err != nil {
+		t.Fatalf("lock over a crashed holder's file: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || !strings.HasPrefix(string(data), strconv.Itoa(os.Getpid())+" ") {
+		t.Fatalf("lock file = %q, %v; want this process recorded", data, err)
+	}
+
+	acquired := make(chan func(), 1)
+	go func() {
+		next, err := lockStateFileExclusive(path, time.Minute)
+		if err != nil {
+			t.Error(err)
+			close(acquired)
+			return
+		}
+		acquired <- next
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("a fresh lock was broken")
+	case <-time.After(200 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case next := <-acquired:
+		if next != nil {
+			next()
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("lock was not acquired after release")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("lock file left behind: %v", err)
+	}
+}
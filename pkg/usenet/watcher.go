@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"net/mail"
 	"os"
 	"sort"
@@ -16,10 +17,13 @@ import (
 )
 
 type Config struct {
-	EnvPath    string
-	ConfigPath string
-	Output     io.Writer
-	LogOutput  io.Writer
+	EnvPath        string
+	ConfigPath     string
+	Output         io.Writer
+	LogOutput      io.Writer
+	HTTPTransport  http.RoundTripper
+	OpenAIURL      string
+	BraveSearchURL string
 }
 
 type Watcher struct {
@@ -55,12 +59,14 @@ func NewWatcher(config Config) (*Watcher, error) {
 	if err != nil {
 		return nil, err
 	}
+	openai := email.NewOpenAIClient(creds.OpenAIAPIToken, usenetCfg.FromAddress, creds.BraveSearchAPIToken, config.LogOutput)
+	openai.UseEndpoints(config.OpenAIURL, config.BraveSearchURL, config.HTTPTransport)
 	return &Watcher{
 		config:    config,
 		appConfig: appCfg,
 		usenet:    usenetCfg,
 		creds:     creds,
-		openai:    email.NewOpenAIClient(creds.OpenAIAPIToken, usenetCfg.FromAddress, creds.BraveSearchAPIToken, config.LogOutput),
+		openai:    openai,
 	}, nil
 }
 
@@ -161,14 +167,18 @@ func (w *Watcher) Poll(ctx context.Context) error {
 			return err
 		}
 		st.LastSeenNumber = max(st.LastSeenNumber, number)
-		if err := saveState(w.usenet.StatePath, st); err != nil {
+		if err := mergeState(w.usenet.StatePath, st); err != nil {
 			return err
 		}
 	}
 	st.LastSeenNumber = max(st.LastSeenNumber, status.High)
-	return saveState(w.usenet.StatePath, st)
+	return mergeState(w.usenet.StatePath, st)
 }
 
+// errAlreadyAnswered reports that another process recorded a follow-up to the
+// article while its answer was being generated.
+var errAlreadyAnswered = errors.New("article already answered")
+
 func (w *Watcher) answerArticle(ctx context.Context, client *nntpClient, current article, st state) error {
 	thread, err := w.threadContext(client, current)
 	if err != nil {
@@ -190,7 +200,23 @@ func (w *Watcher) answerArticle(ctx context.Context, client *nntpClient, current
 	if err != nil {
 		return err
 	}
-	if err := client.Post(raw); err != nil {
+	err = updateState(w.usenet.StatePath, func(locked *state) error {
+		if prior := locked.Replied[current.MessageID]; prior != "" {
+			postedID = prior
+			return errAlreadyAnswered
+		}
+		if err := client.Post(raw); err != nil {
+			return err
+		}
+		locked.Replied[current.MessageID] = postedID
+		return nil
+	})
+	if errors.Is(err, errAlreadyAnswered) {
+		st.Replied[current.MessageID] = postedID
+		w.logf("skipping article answered by another process: message_id=%s reply_message_id=%s", current.MessageID, postedID)
+		return nil
+	}
+	if err != nil {
 		return err
 	}
 	st.Replied[current.MessageID] = postedID
@@ -200,6 +226,10 @@ func (w *Watcher) answerArticle(ctx context.Context, client *nntpClient, current
 }
 
 func (w *Watcher) threadContext(client *nntpClient, current article) ([]article, error) {
+	return threadContext(client, w.usenet, current)
+}
+
+func threadContext(client *nntpClient, cfg appconfig.UsenetConfig, current article) ([]article, error) {
 	seen := map[string]article{}
 	for _, ref := range current.References {
 		ancestor, err := client.ArticleByMessageID(ref)
@@ -213,11 +243,11 @@ func (w *Watcher) threadContext(client *nntpClient, current article) ([]article,
 			seen[ancestor.MessageID] = ancestor
 		}
 	}
-	status, err := client.Group(w.usenet.Group)
+	status, err := client.Group(cfg.Group)
 	if err != nil {
 		return nil, err
 	}
-	start := current.Number - w.usenet.MaxThreadArticles
+	start := current.Number - cfg.MaxThreadArticles
 	if start < status.Low {
 		start = status.Low
 	}
@@ -275,8 +305,12 @@ func (w *Watcher) isOwnArticle(article article) bool {
 }
 
 func (w *Watcher) formatFollowup(original article, body string) (string, string, error) {
-	from := mail.Address{Name: w.usenet.FromName, Address: w.usenet.FromAddress}
-	messageID := newMessageID(w.usenet.FromAddress)
+	return formatFollowup(w.usenet, original, body)
+}
+
+func formatFollowup(cfg appconfig.UsenetConfig, original article, body string) (string, string, error) {
+	from := mail.Address{Name: cfg.FromName, Address: cfg.FromAddress}
+	messageID := newMessageID(cfg.FromAddress)
 	subject := replySubject(original.Subject)
 	references := append([]string{}, original.References...)
 	references = append(references, original.MessageID)
@@ -286,7 +320,7 @@ func (w *Watcher) formatFollowup(original article, body string) (string, string,
 		value string
 	}{
 		{"From", from.String()},
-		{"Newsgroups", w.usenet.Group},
+		{"Newsgroups", cfg.Group},
 		{"Subject", subject},
 		{"Message-ID", messageID},
 		{"Date", time.Now().UTC().Format(time.RFC1123Z)},
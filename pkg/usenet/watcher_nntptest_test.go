@@ -0,0 +1,255 @@
+package usenet
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"ai-over-email/pkg/usenet/nntptest"
+)
+
+type fakeNNTPWatcher struct {
+	server      *nntptest.Server
+	watcher     *Watcher
+	statePath   string
+	openAICalls atomic.Int32
+}
+
+func startFakeNNTPWatcher(t *testing.T, server *nntptest.Server, fingerprint string) *fakeNNTPWatcher {
+	t.Helper()
+	t.Cleanup(server.Close)
+	f := &fakeNNTPWatcher{server: server}
+	openAI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.openAICalls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"id":"resp_test","output":[{"type":"message","content":[{"type":"output_text","text":"Try turning it off and on again."}]}],"usage":{"total_tokens":7}}`)
+	}))
+	t.Cleanup(openAI.Close)
+
+	dir := t.TempDir()
+	f.statePath = filepath.Join(dir, "state.json")
+	usenet := map[string]any{
+		"host":         server.Host,
+		"port":         server.Port,
+		"security":     "none",
+		"group":        "misc.test",
+		"state_path":   f.statePath,
+		"from_address": "pegasus-ai@example.com",
+	}
+	if server.TLS {
+		usenet["security"] = "tls"
+		usenet["tls_cert_sha256"] = fingerprint
+	}
+	configJSON, err := json.Marshal(map[string]any{
+		"jmap":   map[string]any{"session_endpoint": "https://jmap.example.com/session"},
+		"usenet": usenet,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "config.json")
+	envPath := filepath.Join(dir, ".env")
+	env := "AI_OVER_USENET_USERNAME=" + server.Username + "\nAI_OVER_USENET_PASSWORD=" + server.Password + "\nAI_OVER_EMAIL_OPENAI_API_KEY=openai-test\n"
+	if err := os.WriteFile(configPath, configJSON, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(envPath, []byte(env), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f.watcher, err = NewWatcher(Config{
+		EnvPath:    envPath,
+		ConfigPath: configPath,
+		Output:     io.Discard,
+		LogOutput:  io.Discard,
+		OpenAIURL:  openAI.URL,
+	})
+	if err != nil {
+		t.Fatalf("NewWatcher: %v", err)
+	}
+	return f
+}
+
+func addTestArticle(t *testing.T, server *nntptest.Server, a nntptest.Article) {
+	t.Helper()
+	if _, err := server.Add("misc.test", a); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPollAnswersArticleOverPinnedTLS(t *testing.T) {
+	server := nntptest.NewTLSServer()
+	f := startFakeNNTPWatcher(t, server, server.CertSHA256)
+	addTestArticle(t, server, nntptest.Article{MessageID: "<root@example.com>", From: "Alice <alice@example.com>", Subject: "Printer", Body: "My printer is broken."})
+	addTestArticle(t, server, nntptest.Article{
+		MessageID:  "<question@example.com>",
+		From:       "Bob <bob@example.com>",
+		Subject:    "Re: Printer",
+		References: []string{"<expired@example.com>", "<root@example.com>"},
+		Body:       "Mine too. Any ideas?",
+	})
+
+	if err := f.watcher.Poll(context.Background()); err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	posts := server.Posts()
+	if len(posts) != 2 {
+		t.Fatalf("posts = %d, want a follow-up to each article", len(posts))
+	}
+	followup := posts[1]
+	if got := followup.Header.Get("References"); got != "<expired@example.com> <root@example.com> <question@example.com>" {
+		t.Fatalf("References = %q", got)
+	}
+	if followup.Header.Get("Subject") != "Re: Printer" || followup.Body != "Try turning it off and on again." {
+		t.Fatalf("follow-up = %q: %q", followup.Header.Get("Subject"), followup.Body)
+	}
+
+	st, err := loadState(f.statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.LastSeenNumber != 2 || st.Replied["<question@example.com>"] != followup.MessageID {
+		t.Fatalf("state = %+v", st)
+	}
+	if err := f.watcher.Poll(context.Background()); err != nil {
+		t.Fatalf("second Poll: %v", err)
+	}
+	if calls := f.openAICalls.Load(); calls != 2 || len(server.Posts()) != 2 {
+		t.Fatalf("second poll answered again: openai=%d posts=%d", calls, len(server.Posts()))
+	}
+}
+
+func TestPollRejectsWrongCertificateFingerprint(t *testing.T) {
+	server := nntptest.NewTLSServer()
+	f := startFakeNNTPWatcher(t, server, strings.Repeat("ab", 32))
+	err := f.watcher.Poll(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "fingerprint mismatch") {
+		t.Fatalf("Poll error = %v, want a fingerprint mismatch", err)
+	}
+	if len(server.Commands()) != 0 {
+		t.Fatalf("commands reached the server: %v", server.Commands())
+	}
+}
+
+func TestPollSkipsMissingAndOwnArticles(t *testing.T) {
+	server := nntptest.NewServer()
+	f := startFakeNNTPWatcher(t, server, "")
+	addTestArticle(t, server, nntptest.Article{MessageID: "<gone@example.com>", Subject: "Gone", Body: "x"})
+	addTestArticle(t, server, nntptest.Article{
+		MessageID: "<own@example.com>",
+		From:      "Pegasus AI <pegasus-ai@example.com>",
+		Subject:   "Re: Earlier",
+		Body:      "Earlier answer.",
+	})
+	addTestArticle(t, server, nntptest.Article{
+		MessageID: "<tagged@example.com>",
+		From:      "Relay <relay@example.com>",
+		Subject:   "Re: Earlier",
+		Headers:   map[string]string{"X-AI-Over-Usenet": "true"},
+		Body:      "Relayed answer.",
+	})
+	server.Remove("<gone@example.com>")
+
+	if err := f.watcher.Poll(context.Background()); err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	if posts := server.Posts(); len(posts) != 0 || f.openAICalls.Load() != 0 {
+		t.Fatalf("posts = %d, openai = %d; want none", len(posts), f.openAICalls.Load())
+	}
+	st, err := loadState(f.statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.LastSeenNumber != 3 {
+		t.Fatalf("last seen = %d, want 3", st.LastSeenNumber)
+	}
+}
+
+func TestPollRetriesArticleAfterServerFailures(t *testing.T) {
+	server := nntptest.NewServer()
+	f := startFakeNNTPWatcher(t, server, "")
+	addTestArticle(t, server, nntptest.Article{MessageID: "<retry@example.com>", From: "Dan <dan@example.com>", Subject: "Retry", Body: "Hello?"})
+
+	server.Fail("AUTHINFO", 480, "temporarily unavailable")
+	if err := f.watcher.Poll(context.Background()); err == nil || !strings.Contains(err.Error(), "480") {
+		t.Fatalf("Poll error = %v, want 480", err)
+	}
+	server.Disconnect("GROUP")
+	if err := f.watcher.Poll(context.Background()); err == nil {
+		t.Fatal("Poll succeeded although the server dropped the connection")
+	}
+	server.Fail("POST", 441, "posting failed")
+	if err := f.watcher.Poll(context.Background()); err == nil || !strings.Contains(err.Error(), "441") {
+		t.Fatalf("Poll error = %v, want 441", err)
+	}
+	if st, err := loadState(f.statePath); err != nil || st.LastSeenNumber != 0 {
+		t.Fatalf("state after failures = %+v, %v; want the article left unseen", st, err)
+	}
+
+	if err := f.watcher.Poll(context.Background()); err != nil {
+		t.Fatalf("Poll after recovery: %v", err)
+	}
+	if posts := server.Posts(); len(posts) != 1 || posts[0].Header.Get("In-Reply-To") != "<retry@example.com>" {
+		t.Fatalf("posts = %+v", posts)
+	}
+}
+
+func TestPostFollowupSharesStateWithWatcher(t *testing.T) {
+	server := nntptest.NewServer()
+	f := startFakeNNTPWatcher(t, server, "")
+	addTestArticle(t, server, nntptest.Article{MessageID: "<answered@example.com>", From: "Eve <eve@example.com>", Subject: "First", Body: "Hello?"})
+	if err := f.watcher.Poll(context.Background()); err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	dir := filepath.Dir(f.statePath)
+	reader, err := NewReader(Config{EnvPath: filepath.Join(dir, ".env"), ConfigPath: filepath.Join(dir, "config.json"), LogOutput: io.Discard})
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+
+	_, err = reader.PostFollowup(context.Background(), "<answered@example.com>", "A second answer.")
+	if err == nil || !strings.Contains(err.Error(), "already has a follow-up") {
+		t.Fatalf("PostFollowup error = %v, want the watcher's follow-up reported", err)
+	}
+	if posts := server.Posts(); len(posts) != 1 {
+		t.Fatalf("posts = %d, want only the watcher's follow-up", len(posts))
+	}
+
+	addTestArticle(t, server, nntptest.Article{MessageID: "<manual@example.com>", From: "Eve <eve@example.com>", Subject: "Second", Body: "Anyone?"})
+	stale, err := loadState(f.statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	followup, err := reader.PostFollowup(context.Background(), "<manual@example.com>", "Answered by hand.")
+	if err != nil {
+		t.Fatalf("PostFollowup: %v", err)
+	}
+	stale.LastSeenNumber = 2
+	if err := mergeState(f.statePath, stale); err != nil {
+		t.Fatal(err)
+	}
+	st, err := loadState(f.statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.LastSeenNumber != 2 || st.Replied["<manual@example.com>"] != followup.MessageID || st.Replied["<answered@example.com>"] == "" {
+		t.Fatalf("state = %+v, want both follow-ups kept", st)
+	}
+
+	st.LastSeenNumber = 0
+	if err := saveState(f.statePath, st); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.watcher.Poll(context.Background()); err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	if posts := server.Posts(); len(posts) != 2 || f.openAICalls.Load() != 1 {
+		t.Fatalf("posts = %d, openai = %d; want the watcher to skip the hand-answered article", len(posts), f.openAICalls.Load())
+	}
+}